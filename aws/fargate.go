@@ -3,10 +3,15 @@ package aws
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -15,24 +20,71 @@ import (
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
 )
 
+// clientTokenBytes keeps the hex encoded token within the 36 characters
+// accepted as the "startedBy" value of the ECS tasks
+const clientTokenBytes = 16
+
+// maxStopReasonLength is the limit of the reason accepted by StopTask
+const maxStopReasonLength = 255
+
+// cpuArchitectureAttribute is the attribute of the tasks holding the
+// architecture of their CPU
+const cpuArchitectureAttribute = "ecs.cpu-architecture"
+
 var (
 	defaultContainerName = "ci-coordinator"
 
 	// ErrNotInitialized is returned when the fargate methods are invoked without initialization
 	ErrNotInitialized = errors.New("fargate adapter is not initialized")
+
+	// ErrTaskNotFound is returned when the described task doesn't exist
+	ErrTaskNotFound = errors.New("fargate task not found")
 )
 
+// TaskStatusRunning is the last status of a task that runs its containers
+const TaskStatusRunning = ecs.DesiredStatusRunning
+
+// stoppingStatuses are the last statuses of a task that won't run again
+var stoppingStatuses = map[string]bool{
+	"DEACTIVATING":   true,
+	"STOPPING":       true,
+	"DEPROVISIONING": true,
+	"STOPPED":        true,
+}
+
+// IsTaskStopping reports whether the last status of a task means that it
+// was stopped or is being stopped
+func IsTaskStopping(status string) bool {
+	return stoppingStatuses[status]
+}
+
 // Fargate should be used to manage AWS Fargate Tasks (start, stop, etc)
 type Fargate interface {
 	// RunTask starts a new task in a pre configured Fargate
 	RunTask(ctx context.Context, taskSettings TaskSettings, connection ConnectionSettings) (string, error)
 
 	// WaitUntilTaskRunning blocks the request until the task is in "running"
-	// state or failed to reach this state
-	WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string) error
+	// state or failed to reach this state. The observer, when not nil,
+	// receives the last status of each check
+	WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string, observer TaskStatusObserver) error
+
+	// DescribeTask returns the placement and the resources of the task
+	DescribeTask(ctx context.Context, taskARN string, cluster string) (*TaskDescription, error)
 
-	// RunTask stops a specified Fargate task
-	StopTask(ctx context.Context, taskARN string, cluster string) error
+	// FindTask returns the ARN of a task, not yet stopped, started with the
+	// client token. An empty ARN is returned when there is no such task
+	FindTask(ctx context.Context, cluster string, clientToken string) (string, error)
+
+	// RunTask stops a specified Fargate task. The reason is shown in the
+	// details of the stopped task
+	StopTask(ctx context.Context, taskARN string, cluster string, reason string) error
+
+	// WaitUntilTaskStopped blocks the request until the task is in "stopped"
+	// state, and returns the details of its termination
+	WaitUntilTaskStopped(ctx context.Context, taskARN string, cluster string) (*StoppedTask, error)
+
+	// GetTaskStatus returns the last status of the task, as reported by ECS
+	GetTaskStatus(ctx context.Context, taskARN string, cluster string) (string, error)
 
 	// GetContainerIP returns the IP of the container related to the specified task
 	GetContainerIP(ctx context.Context, taskARN string, cluster string, usePublicIP bool) (string, error)
@@ -47,6 +99,48 @@ type TaskSettings struct {
 	TaskDefinition       string
 	PlatformVersion      string
 	EnvironmentVariables map[string]string
+
+	// CPU and Memory override the task size of the task definition when set
+	CPU    int
+	Memory int
+
+	// ClientToken is used as the "startedBy" value of the task, so the task
+	// can be found when its ARN wasn't received or recorded
+	ClientToken string
+
+	// Tags are added to the task, so it can be found when its metadata is lost
+	Tags map[string]string
+}
+
+// TaskStatusObserver receives the last status of a task while it's awaited
+type TaskStatusObserver func(status string)
+
+// TaskDescription describes the placement and the resources of a task. The
+// fields unknown to ECS are empty
+type TaskDescription struct {
+	Status           string
+	AvailabilityZone string
+	Architecture     string
+	CPU              string
+	Memory           string
+	PlatformVersion  string
+	// ImageDigest is the digest of the image of the container executing
+	// the scripts
+	ImageDigest string
+}
+
+// StoppedTask describes the termination of a task
+type StoppedTask struct {
+	StoppedReason string
+	Containers    []StoppedContainer
+}
+
+// StoppedContainer describes the termination of a container of the task.
+// ExitCode is nil when the container was not started
+type StoppedContainer struct {
+	Name     string
+	ExitCode *int64
+	Reason   string
 }
 
 // ConnectionSettings centralizes attributes related to the task's network configuration
@@ -57,8 +151,10 @@ type ConnectionSettings struct {
 }
 
 type ecsClient interface {
+	ListTasksWithContext(aws.Context, *ecs.ListTasksInput, ...request.Option) (*ecs.ListTasksOutput, error)
 	RunTaskWithContext(aws.Context, *ecs.RunTaskInput, ...request.Option) (*ecs.RunTaskOutput, error)
 	WaitUntilTasksRunningWithContext(aws.Context, *ecs.DescribeTasksInput, ...request.WaiterOption) error
+	WaitUntilTasksStoppedWithContext(aws.Context, *ecs.DescribeTasksInput, ...request.WaiterOption) error
 	StopTaskWithContext(aws.Context, *ecs.StopTaskInput, ...request.Option) (*ecs.StopTaskOutput, error)
 	DescribeTasksWithContext(aws.Context, *ecs.DescribeTasksInput, ...request.Option) (*ecs.DescribeTasksOutput, error)
 }
@@ -78,6 +174,15 @@ type awsFargate struct {
 	sessionCreator func(awsRegion string) (*session.Session, error)
 }
 
+// JobClientToken derives the token identifying the task started for a job
+// from the key of the job, so the task can be listed by its "startedBy"
+// value even when nothing was recorded about it
+func JobClientToken(jobKey string) string {
+	sum := sha256.Sum256([]byte(jobKey))
+
+	return hex.EncodeToString(sum[:clientTokenBytes])
+}
+
 // NewFargate is a constructor for the concrete type of the Fargate interface
 func NewFargate(logger logging.Logger, awsRegion string) Fargate {
 	awsFargate := new(awsFargate)
@@ -86,9 +191,10 @@ func NewFargate(logger logging.Logger, awsRegion string) Fargate {
 	awsFargate.awsRegion = awsRegion
 	awsFargate.sessionCreator = func(awsRegion string) (*session.Session, error) {
 		return session.NewSession(
-			&aws.Config{
-				Region: aws.String(awsRegion),
-			},
+			request.WithRetryer(
+				&aws.Config{Region: aws.String(awsRegion)},
+				newThrottlingRetryer(),
+			),
 		)
 	}
 
@@ -125,6 +231,11 @@ func (a *awsFargate) RunTask(ctx context.Context, taskSettings TaskSettings, con
 		platformVersion = &taskSettings.PlatformVersion
 	}
 
+	var startedBy *string
+	if taskSettings.ClientToken != "" {
+		startedBy = &taskSettings.ClientToken
+	}
+
 	taskInput := ecs.RunTaskInput{
 		TaskDefinition: &taskSettings.TaskDefinition,
 		Cluster:        &taskSettings.Cluster,
@@ -135,8 +246,10 @@ func (a *awsFargate) RunTask(ctx context.Context, taskSettings TaskSettings, con
 				AssignPublicIp: &publicIP,
 			},
 		},
-		Overrides:       a.processEnvVariablesToInject(taskSettings.EnvironmentVariables),
+		Overrides:       a.processTaskOverride(taskSettings),
 		PlatformVersion: platformVersion,
+		StartedBy:       startedBy,
+		Tags:            a.processTags(taskSettings.Tags),
 	}
 
 	taskOutput, err := a.ecsSvc.RunTaskWithContext(ctx, &taskInput)
@@ -161,6 +274,28 @@ func (a *awsFargate) errIfNotInitialized() error {
 	return ErrNotInitialized
 }
 
+func (a *awsFargate) processTaskOverride(taskSettings TaskSettings) *ecs.TaskOverride {
+	taskOverride := a.processEnvVariablesToInject(taskSettings.EnvironmentVariables)
+
+	if taskSettings.CPU == 0 && taskSettings.Memory == 0 {
+		return taskOverride
+	}
+
+	if taskOverride == nil {
+		taskOverride = new(ecs.TaskOverride)
+	}
+
+	if taskSettings.CPU != 0 {
+		taskOverride.Cpu = aws.String(strconv.Itoa(taskSettings.CPU))
+	}
+
+	if taskSettings.Memory != 0 {
+		taskOverride.Memory = aws.String(strconv.Itoa(taskSettings.Memory))
+	}
+
+	return taskOverride
+}
+
 func (a *awsFargate) processEnvVariablesToInject(envVars map[string]string) *ecs.TaskOverride {
 	if (envVars == nil) || (len(envVars) == 0) {
 		return nil
@@ -169,8 +304,8 @@ func (a *awsFargate) processEnvVariablesToInject(envVars map[string]string) *ecs
 	environmentVars := make([]*ecs.KeyValuePair, 0)
 	for key, value := range envVars {
 		environmentVars = append(environmentVars, &ecs.KeyValuePair{
-			Name:  &key,
-			Value: &value,
+			Name:  aws.String(key),
+			Value: aws.String(value),
 		})
 	}
 
@@ -188,7 +323,23 @@ func (a *awsFargate) processEnvVariablesToInject(envVars map[string]string) *ecs
 	return taskOverride
 }
 
-func (a *awsFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string) error {
+func (a *awsFargate) processTags(tags map[string]string) []*ecs.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	ecsTags := make([]*ecs.Tag, 0, len(tags))
+	for key, value := range tags {
+		ecsTags = append(ecsTags, &ecs.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	return ecsTags
+}
+
+func (a *awsFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string, observer TaskStatusObserver) error {
 	err := a.errIfNotInitialized()
 	if err != nil {
 		return fmt.Errorf("could not wait AWS Fargate task: %w", err)
@@ -203,7 +354,12 @@ func (a *awsFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, c
 		Tasks:   []*string{&taskARN},
 	}
 
-	err = a.ecsSvc.WaitUntilTasksRunningWithContext(ctx, &input)
+	var options []request.WaiterOption
+	if observer != nil {
+		options = append(options, request.WithWaiterRequestOptions(observeTaskStatus(observer)))
+	}
+
+	err = a.ecsSvc.WaitUntilTasksRunningWithContext(ctx, &input, options...)
 	if err != nil {
 		return fmt.Errorf(`error waiting AWS Fargate Task %q to be in "Running" state: %w`, taskARN, err)
 	}
@@ -215,7 +371,43 @@ func (a *awsFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, c
 	return nil
 }
 
-func (a *awsFargate) StopTask(ctx context.Context, taskARN string, cluster string) error {
+func (a *awsFargate) FindTask(ctx context.Context, cluster string, clientToken string) (string, error) {
+	err := a.errIfNotInitialized()
+	if err != nil {
+		return "", fmt.Errorf("could not find AWS Fargate Task: %w", err)
+	}
+
+	a.logger.
+		WithField("client-token", clientToken).
+		Debug("[FindTask] Will search the task started with the client token")
+
+	// Without DesiredStatus the tasks that are running or pending are listed
+	output, err := a.ecsSvc.ListTasksWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:   &cluster,
+		StartedBy: &clientToken,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing AWS Fargate Tasks started by %q: %w", clientToken, err)
+	}
+
+	if len(output.TaskArns) < 1 {
+		a.logger.
+			WithField("client-token", clientToken).
+			Debug("[FindTask] No task was found")
+
+		return "", nil
+	}
+
+	taskARN := aws.StringValue(output.TaskArns[0])
+
+	a.logger.
+		WithField("task-arn", taskARN).
+		Debug("[FindTask] Found the task started with the client token")
+
+	return taskARN, nil
+}
+
+func (a *awsFargate) StopTask(ctx context.Context, taskARN string, cluster string, reason string) error {
 	err := a.errIfNotInitialized()
 	if err != nil {
 		return fmt.Errorf("could not stop AWS Fargate Task: %w", err)
@@ -230,7 +422,19 @@ func (a *awsFargate) StopTask(ctx context.Context, taskARN string, cluster strin
 		Task:    &taskARN,
 	}
 
+	if reason != "" {
+		if len(reason) > maxStopReasonLength {
+			reason = reason[:maxStopReasonLength]
+		}
+
+		input.Reason = &reason
+	}
+
 	_, err = a.ecsSvc.StopTaskWithContext(ctx, &input)
+	if isTaskNotFoundError(err) {
+		return fmt.Errorf("%w: %q", ErrTaskNotFound, taskARN)
+	}
+
 	if err != nil {
 		return fmt.Errorf("error stopping AWS Fargate Task %q: %w", taskARN, err)
 	}
@@ -242,6 +446,150 @@ func (a *awsFargate) StopTask(ctx context.Context, taskARN string, cluster strin
 	return nil
 }
 
+// isTaskNotFoundError recognizes the error of ECS about a task that doesn't
+// exist anymore, e.g. a task stopped long ago
+func isTaskNotFoundError(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+
+	return awsErr.Code() == ecs.ErrCodeInvalidParameterException &&
+		strings.Contains(strings.ToLower(awsErr.Message()), "task was not found")
+}
+
+func (a *awsFargate) WaitUntilTaskStopped(ctx context.Context, taskARN string, cluster string) (*StoppedTask, error) {
+	err := a.errIfNotInitialized()
+	if err != nil {
+		return nil, fmt.Errorf("could not wait AWS Fargate task: %w", err)
+	}
+
+	a.logger.
+		WithField("task-arn", taskARN).
+		Debug(`[WaitUntilTaskStopped] Will wait until Fargate task is in "Stopped" state`)
+
+	input := ecs.DescribeTasksInput{
+		Cluster: &cluster,
+		Tasks:   []*string{&taskARN},
+	}
+
+	err = a.ecsSvc.WaitUntilTasksStoppedWithContext(ctx, &input)
+	if err != nil {
+		return nil, fmt.Errorf(`error waiting AWS Fargate Task %q to be in "Stopped" state: %w`, taskARN, err)
+	}
+
+	taskDetails, err := a.getTaskDetails(ctx, taskARN, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing information about the task %q: %w", taskARN, err)
+	}
+
+	if len(taskDetails.Tasks) < 1 {
+		return nil, fmt.Errorf("%w: %q", ErrTaskNotFound, taskARN)
+	}
+
+	task := taskDetails.Tasks[0]
+	stoppedTask := &StoppedTask{
+		StoppedReason: aws.StringValue(task.StoppedReason),
+	}
+
+	for _, container := range task.Containers {
+		stoppedTask.Containers = append(stoppedTask.Containers, StoppedContainer{
+			Name:     aws.StringValue(container.Name),
+			ExitCode: container.ExitCode,
+			Reason:   aws.StringValue(container.Reason),
+		})
+	}
+
+	a.logger.
+		WithField("task-arn", taskARN).
+		Debug(`[WaitUntilTaskStopped] Fargate Task in "Stopped" state`)
+
+	return stoppedTask, nil
+}
+
+func (a *awsFargate) GetTaskStatus(ctx context.Context, taskARN string, cluster string) (string, error) {
+	err := a.errIfNotInitialized()
+	if err != nil {
+		return "", fmt.Errorf("could not get AWS Fargate Task status: %w", err)
+	}
+
+	taskDetails, err := a.getTaskDetails(ctx, taskARN, cluster)
+	if err != nil {
+		return "", fmt.Errorf("error accessing information about the task %q: %w", taskARN, err)
+	}
+
+	if len(taskDetails.Tasks) < 1 {
+		return "", fmt.Errorf("%w: %q", ErrTaskNotFound, taskARN)
+	}
+
+	status := aws.StringValue(taskDetails.Tasks[0].LastStatus)
+
+	a.logger.
+		WithField("task-arn", taskARN).
+		WithField("status", status).
+		Debug("[GetTaskStatus] Fetched the task status")
+
+	return status, nil
+}
+
+// observeTaskStatus passes the last status of the task described by each
+// request of the waiter to the observer
+func observeTaskStatus(observer TaskStatusObserver) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			output, ok := r.Data.(*ecs.DescribeTasksOutput)
+			if r.Error != nil || !ok || len(output.Tasks) < 1 {
+				return
+			}
+
+			observer(aws.StringValue(output.Tasks[0].LastStatus))
+		})
+	}
+}
+
+func (a *awsFargate) DescribeTask(ctx context.Context, taskARN string, cluster string) (*TaskDescription, error) {
+	err := a.errIfNotInitialized()
+	if err != nil {
+		return nil, fmt.Errorf("could not describe AWS Fargate Task: %w", err)
+	}
+
+	taskDetails, err := a.getTaskDetails(ctx, taskARN, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing information about the task %q: %w", taskARN, err)
+	}
+
+	if len(taskDetails.Tasks) < 1 {
+		return nil, fmt.Errorf("%w: %q", ErrTaskNotFound, taskARN)
+	}
+
+	ecsTask := taskDetails.Tasks[0]
+	description := &TaskDescription{
+		Status:           aws.StringValue(ecsTask.LastStatus),
+		AvailabilityZone: aws.StringValue(ecsTask.AvailabilityZone),
+		CPU:              aws.StringValue(ecsTask.Cpu),
+		Memory:           aws.StringValue(ecsTask.Memory),
+		PlatformVersion:  aws.StringValue(ecsTask.PlatformVersion),
+	}
+
+	for _, attribute := range ecsTask.Attributes {
+		if aws.StringValue(attribute.Name) == cpuArchitectureAttribute {
+			description.Architecture = aws.StringValue(attribute.Value)
+		}
+	}
+
+	for i, container := range ecsTask.Containers {
+		if i == 0 || aws.StringValue(container.Name) == defaultContainerName {
+			description.ImageDigest = aws.StringValue(container.ImageDigest)
+		}
+	}
+
+	a.logger.
+		WithField("task-arn", taskARN).
+		Debug("[DescribeTask] Described the task")
+
+	return description, nil
+}
+
 func (a *awsFargate) GetContainerIP(ctx context.Context, taskARN string, cluster string, usePublicIP bool) (string, error) {
 	err := a.errIfNotInitialized()
 	if err != nil {
@@ -3,9 +3,14 @@ package aws
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -31,6 +36,10 @@ func TestRunTask(t *testing.T) {
 		initializeAdapter bool
 		environmentVars   map[string]string
 		platformVersion   string
+		clientToken       string
+		tags              map[string]string
+		cpu               int
+		memory            int
 		awsError          error
 		expectedARN       string
 		expectedError     error
@@ -59,6 +68,23 @@ func TestRunTask(t *testing.T) {
 			expectedARN:       taskARN,
 			expectedError:     nil,
 		},
+		"Fargate API returning success with client token": {
+			initializeAdapter: true,
+			clientToken:       "client-token",
+			expectedARN:       taskARN,
+		},
+		"Fargate API returning success with tags": {
+			initializeAdapter: true,
+			tags:              map[string]string{"job": "job-key"},
+			expectedARN:       taskARN,
+		},
+		"Fargate API returning success overriding task size": {
+			initializeAdapter: true,
+			environmentVars:   testEnvVar,
+			cpu:               1024,
+			memory:            2048,
+			expectedARN:       taskARN,
+		},
 		"Fargate API returning error": {
 			initializeAdapter: true,
 			environmentVars:   nil,
@@ -97,13 +123,29 @@ func TestRunTask(t *testing.T) {
 				TaskDefinition:       "task-def",
 				PlatformVersion:      tt.platformVersion,
 				EnvironmentVariables: tt.environmentVars,
+				ClientToken:          tt.clientToken,
+				Tags:                 tt.tags,
+				CPU:                  tt.cpu,
+				Memory:               tt.memory,
 			}
 
 			if tt.initializeAdapter {
 				mockECS.On(
 					"RunTaskWithContext",
 					testContext,
-					mock.AnythingOfType("*ecs.RunTaskInput"),
+					mock.MatchedBy(func(input *ecs.RunTaskInput) bool {
+						var tags map[string]string
+						if input.Tags != nil {
+							tags = make(map[string]string)
+							for _, tag := range input.Tags {
+								tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+							}
+						}
+
+						return aws.StringValue(input.StartedBy) == tt.clientToken &&
+							assert.ObjectsAreEqual(tt.tags, tags) &&
+							assertTaskOverride(input.Overrides, tt.environmentVars, tt.cpu, tt.memory)
+					}),
 				).
 					Return(
 						&ecs.RunTaskOutput{
@@ -133,6 +175,32 @@ func TestRunTask(t *testing.T) {
 	}
 }
 
+func assertTaskOverride(override *ecs.TaskOverride, envVars map[string]string, cpu int, memory int) bool {
+	if len(envVars) == 0 && cpu == 0 && memory == 0 {
+		return override == nil
+	}
+
+	var environment map[string]string
+	if len(override.ContainerOverrides) > 0 {
+		environment = make(map[string]string)
+		for _, variable := range override.ContainerOverrides[0].Environment {
+			environment[aws.StringValue(variable.Name)] = aws.StringValue(variable.Value)
+		}
+	}
+
+	var expectedCPU, expectedMemory string
+	if cpu != 0 {
+		expectedCPU = strconv.Itoa(cpu)
+	}
+	if memory != 0 {
+		expectedMemory = strconv.Itoa(memory)
+	}
+
+	return assert.ObjectsAreEqual(envVars, environment) &&
+		aws.StringValue(override.Cpu) == expectedCPU &&
+		aws.StringValue(override.Memory) == expectedMemory
+}
+
 func createTestLogger() logging.Logger {
 	return test.NewNullLogger()
 }
@@ -229,7 +297,7 @@ func TestWaitUntilTaskRunning(t *testing.T) {
 			if tt.initializeAdapter {
 				mockECS.On(
 					"WaitUntilTasksRunningWithContext",
-					mock.AnythingOfType("*context.emptyCtx"),
+					mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
 					mock.AnythingOfType("*ecs.DescribeTasksInput"),
 				).
 					Return(tt.awsError).
@@ -243,7 +311,7 @@ func TestWaitUntilTaskRunning(t *testing.T) {
 				fargate.(*awsFargate).ec2Svc = mockEC2
 			}
 
-			err := fargate.WaitUntilTaskRunning(context.Background(), "param1", "param2")
+			err := fargate.WaitUntilTaskRunning(context.Background(), "param1", "param2", nil)
 
 			if tt.expectedError != nil {
 				assertions.ErrorIs(t, err, tt.expectedError)
@@ -255,13 +323,235 @@ func TestWaitUntilTaskRunning(t *testing.T) {
 	}
 }
 
+func TestWaitUntilTaskRunning_Observer(t *testing.T) {
+	mockECS := new(mockEcsClient)
+	defer mockECS.AssertExpectations(t)
+
+	// Each request of the waiter is completed with the next status
+	statuses := []string{"PROVISIONING", "PENDING", "RUNNING"}
+	mockECS.On(
+		"WaitUntilTasksRunningWithContext",
+		mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
+		mock.AnythingOfType("*ecs.DescribeTasksInput"),
+		mock.AnythingOfType("request.WaiterOption"),
+	).
+		Return(func(_ aws.Context, _ *ecs.DescribeTasksInput, options ...request.WaiterOption) error {
+			waiter := new(request.Waiter)
+			for _, option := range options {
+				option(waiter)
+			}
+
+			for _, status := range statuses {
+				req := &request.Request{
+					Data: &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{{LastStatus: aws.String(status)}}},
+				}
+				req.ApplyOptions(waiter.RequestOptions...)
+				req.Handlers.Complete.Run(req)
+			}
+
+			return nil
+		}).
+		Once()
+
+	fargate := NewFargate(createTestLogger(), "us-east-1")
+	require.NoError(t, fargate.Init())
+	fargate.(*awsFargate).ecsSvc = mockECS
+
+	var observed []string
+	err := fargate.WaitUntilTaskRunning(context.Background(), "task-arn", "cluster-name", func(status string) {
+		observed = append(observed, status)
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, statuses, observed)
+}
+
+func TestJobClientToken(t *testing.T) {
+	token := JobClientToken("job-key")
+
+	assert.Len(t, token, 2*clientTokenBytes)
+	assert.LessOrEqual(t, len(token), 36, "token must be accepted as startedBy value")
+	assert.Equal(t, token, JobClientToken("job-key"), "token must be deterministic")
+	assert.NotEqual(t, token, JobClientToken("other-job-key"))
+}
+
+func TestFindTask(t *testing.T) {
+	testError := errors.New("simulated error")
+	taskARN := "my-task-arn"
+	logger := createTestLogger()
+
+	tests := map[string]struct {
+		initializeAdapter bool
+		awsOutput         *ecs.ListTasksOutput
+		awsError          error
+		expectedARN       string
+		expectedError     error
+	}{
+		"Task found": {
+			initializeAdapter: true,
+			awsOutput:         &ecs.ListTasksOutput{TaskArns: []*string{&taskARN}},
+			expectedARN:       taskARN,
+		},
+		"Task not found": {
+			initializeAdapter: true,
+			awsOutput:         &ecs.ListTasksOutput{},
+			expectedARN:       "",
+		},
+		"Fargate API returning error": {
+			initializeAdapter: true,
+			awsError:          testError,
+			expectedError:     testError,
+		},
+		"Fargate adapter not initialized error": {
+			initializeAdapter: false,
+			expectedError:     ErrNotInitialized,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockECS := new(mockEcsClient)
+			defer mockECS.AssertExpectations(t)
+
+			fargate := NewFargate(logger, "us-east-1")
+
+			if tt.initializeAdapter {
+				mockECS.On(
+					"ListTasksWithContext",
+					mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
+					&ecs.ListTasksInput{
+						Cluster:   aws.String("cluster-name"),
+						StartedBy: aws.String("client-token"),
+					},
+				).
+					Return(tt.awsOutput, tt.awsError).
+					Once()
+
+				err := fargate.Init()
+				require.NoError(t, err)
+
+				fargate.(*awsFargate).ecsSvc = mockECS
+			}
+
+			arn, err := fargate.FindTask(context.Background(), "cluster-name", "client-token")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedARN, arn)
+		})
+	}
+}
+
+func TestWaitUntilTaskStopped(t *testing.T) {
+	testError := errors.New("simulated error")
+	logger := createTestLogger()
+
+	stoppedTaskOutput := &ecs.DescribeTasksOutput{
+		Tasks: []*ecs.Task{{
+			StoppedReason: aws.String("Job finished"),
+			Containers: []*ecs.Container{
+				{Name: aws.String("ci-coordinator"), ExitCode: aws.Int64(0)},
+				{Name: aws.String("sidecar"), Reason: aws.String("CannotPullContainerError")},
+			},
+		}},
+	}
+
+	tests := map[string]struct {
+		initializeAdapter bool
+		waitError         error
+		describeOutput    *ecs.DescribeTasksOutput
+		describeError     error
+		expectedTask      *StoppedTask
+		expectedError     error
+	}{
+		"Task stopped": {
+			initializeAdapter: true,
+			describeOutput:    stoppedTaskOutput,
+			expectedTask: &StoppedTask{
+				StoppedReason: "Job finished",
+				Containers: []StoppedContainer{
+					{Name: "ci-coordinator", ExitCode: aws.Int64(0)},
+					{Name: "sidecar", Reason: "CannotPullContainerError"},
+				},
+			},
+		},
+		"Error waiting for the task": {
+			initializeAdapter: true,
+			waitError:         testError,
+			expectedError:     testError,
+		},
+		"Error describing the task": {
+			initializeAdapter: true,
+			describeError:     testError,
+			expectedError:     testError,
+		},
+		"Task not found": {
+			initializeAdapter: true,
+			describeOutput:    &ecs.DescribeTasksOutput{},
+			expectedError:     ErrTaskNotFound,
+		},
+		"Fargate adapter not initialized error": {
+			initializeAdapter: false,
+			expectedError:     ErrNotInitialized,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockECS := new(mockEcsClient)
+			defer mockECS.AssertExpectations(t)
+
+			fargate := NewFargate(logger, "us-east-1")
+
+			if tt.initializeAdapter {
+				input := &ecs.DescribeTasksInput{
+					Cluster: aws.String("cluster-name"),
+					Tasks:   []*string{aws.String("task-arn")},
+				}
+
+				mockECS.On("WaitUntilTasksStoppedWithContext", mock.Anything, input).
+					Return(tt.waitError).
+					Once()
+
+				if tt.waitError == nil {
+					mockECS.On("DescribeTasksWithContext", mock.Anything, input).
+						Return(tt.describeOutput, tt.describeError).
+						Once()
+				}
+
+				err := fargate.Init()
+				require.NoError(t, err)
+
+				fargate.(*awsFargate).ecsSvc = mockECS
+			}
+
+			stoppedTask, err := fargate.WaitUntilTaskStopped(context.Background(), "task-arn", "cluster-name")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTask, stoppedTask)
+		})
+	}
+}
+
 func TestStopTask(t *testing.T) {
 	testError := errors.New("simulated error")
+	invalidParameterError := awserr.New(ecs.ErrCodeInvalidParameterException, "Invalid reason.", nil)
 	logger := createTestLogger()
 
 	tests := map[string]struct {
 		initializeAdapter bool
 		awsTaskOutput     *ecs.StopTaskOutput
+		reason            string
+		expectedReason    *string
 		awsError          error
 		expectedError     error
 	}{
@@ -271,6 +561,18 @@ func TestStopTask(t *testing.T) {
 			awsError:          nil,
 			expectedError:     nil,
 		},
+		"Fargate API returning success with reason": {
+			initializeAdapter: true,
+			awsTaskOutput:     &ecs.StopTaskOutput{},
+			reason:            "Job finished",
+			expectedReason:    aws.String("Job finished"),
+		},
+		"Fargate API returning success with truncated reason": {
+			initializeAdapter: true,
+			awsTaskOutput:     &ecs.StopTaskOutput{},
+			reason:            strings.Repeat("a", 300),
+			expectedReason:    aws.String(strings.Repeat("a", maxStopReasonLength)),
+		},
 		"Fargate API returning error and task output": {
 			initializeAdapter: true,
 			awsTaskOutput:     &ecs.StopTaskOutput{},
@@ -283,6 +585,16 @@ func TestStopTask(t *testing.T) {
 			awsError:          testError,
 			expectedError:     testError,
 		},
+		"Fargate API returning task not found": {
+			initializeAdapter: true,
+			awsError:          awserr.New(ecs.ErrCodeInvalidParameterException, "The referenced task was not found.", nil),
+			expectedError:     ErrTaskNotFound,
+		},
+		"Fargate API returning another invalid parameter": {
+			initializeAdapter: true,
+			awsError:          invalidParameterError,
+			expectedError:     invalidParameterError,
+		},
 		"Fargate adapter not initialized error": {
 			initializeAdapter: false,
 			awsTaskOutput:     nil,
@@ -304,8 +616,12 @@ func TestStopTask(t *testing.T) {
 			if tt.initializeAdapter {
 				mockECS.On(
 					"StopTaskWithContext",
-					mock.AnythingOfType("*context.emptyCtx"),
-					mock.AnythingOfType("*ecs.StopTaskInput"),
+					mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
+					&ecs.StopTaskInput{
+						Cluster: aws.String("param2"),
+						Task:    aws.String("param1"),
+						Reason:  tt.expectedReason,
+					},
 				).
 					Return(tt.awsTaskOutput, tt.awsError).
 					Once()
@@ -318,7 +634,80 @@ func TestStopTask(t *testing.T) {
 				fargate.(*awsFargate).ec2Svc = mockEC2
 			}
 
-			err := fargate.StopTask(context.Background(), "param1", "param2")
+			err := fargate.StopTask(context.Background(), "param1", "param2", tt.reason)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGetTaskStatus(t *testing.T) {
+	testError := errors.New("simulated error")
+	logger := createTestLogger()
+
+	tests := map[string]struct {
+		initializeAdapter bool
+		awsOutput         *ecs.DescribeTasksOutput
+		awsError          error
+		expectedStatus    string
+		expectedError     error
+	}{
+		"Task status fetched": {
+			initializeAdapter: true,
+			awsOutput: &ecs.DescribeTasksOutput{
+				Tasks: []*ecs.Task{{LastStatus: aws.String(TaskStatusRunning)}},
+			},
+			expectedStatus: TaskStatusRunning,
+		},
+		"Task not found": {
+			initializeAdapter: true,
+			awsOutput: &ecs.DescribeTasksOutput{
+				Failures: []*ecs.Failure{{Reason: aws.String("MISSING")}},
+			},
+			expectedError: ErrTaskNotFound,
+		},
+		"Fargate API returning error": {
+			initializeAdapter: true,
+			awsError:          testError,
+			expectedError:     testError,
+		},
+		"Fargate adapter not initialized error": {
+			initializeAdapter: false,
+			expectedError:     ErrNotInitialized,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockECS := new(mockEcsClient)
+			defer mockECS.AssertExpectations(t)
+
+			fargate := NewFargate(logger, "us-east-1")
+
+			if tt.initializeAdapter {
+				mockECS.On(
+					"DescribeTasksWithContext",
+					mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
+					&ecs.DescribeTasksInput{
+						Cluster: aws.String("cluster-name"),
+						Tasks:   []*string{aws.String("task-arn")},
+					},
+				).
+					Return(tt.awsOutput, tt.awsError).
+					Once()
+
+				err := fargate.Init()
+				require.NoError(t, err)
+
+				fargate.(*awsFargate).ecsSvc = mockECS
+			}
+
+			status, err := fargate.GetTaskStatus(context.Background(), "task-arn", "cluster-name")
 
 			if tt.expectedError != nil {
 				assertions.ErrorIs(t, err, tt.expectedError)
@@ -326,10 +715,123 @@ func TestStopTask(t *testing.T) {
 			}
 
 			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, status)
 		})
 	}
 }
 
+func TestDescribeTask(t *testing.T) {
+	testError := errors.New("simulated error")
+	logger := createTestLogger()
+
+	tests := map[string]struct {
+		initializeAdapter   bool
+		awsOutput           *ecs.DescribeTasksOutput
+		awsError            error
+		expectedDescription *TaskDescription
+		expectedError       error
+	}{
+		"Task described": {
+			initializeAdapter: true,
+			awsOutput: &ecs.DescribeTasksOutput{
+				Tasks: []*ecs.Task{{
+					LastStatus:       aws.String(TaskStatusRunning),
+					AvailabilityZone: aws.String("us-east-1a"),
+					Cpu:              aws.String("1024"),
+					Memory:           aws.String("2048"),
+					PlatformVersion:  aws.String("1.4.0"),
+					Attributes: []*ecs.Attribute{
+						{Name: aws.String(cpuArchitectureAttribute), Value: aws.String("arm64")},
+					},
+					Containers: []*ecs.Container{
+						{Name: aws.String("sidecar"), ImageDigest: aws.String("sha256:sidecar")},
+						{Name: aws.String(defaultContainerName), ImageDigest: aws.String("sha256:coordinator")},
+					},
+				}},
+			},
+			expectedDescription: &TaskDescription{
+				Status:           TaskStatusRunning,
+				AvailabilityZone: "us-east-1a",
+				Architecture:     "arm64",
+				CPU:              "1024",
+				Memory:           "2048",
+				PlatformVersion:  "1.4.0",
+				ImageDigest:      "sha256:coordinator",
+			},
+		},
+		"Task partially described": {
+			initializeAdapter: true,
+			awsOutput: &ecs.DescribeTasksOutput{
+				Tasks: []*ecs.Task{{
+					LastStatus: aws.String("PENDING"),
+					Containers: []*ecs.Container{{Name: aws.String("build")}},
+				}},
+			},
+			expectedDescription: &TaskDescription{Status: "PENDING"},
+		},
+		"Task not found": {
+			initializeAdapter: true,
+			awsOutput: &ecs.DescribeTasksOutput{
+				Failures: []*ecs.Failure{{Reason: aws.String("MISSING")}},
+			},
+			expectedError: ErrTaskNotFound,
+		},
+		"Fargate API returning error": {
+			initializeAdapter: true,
+			awsError:          testError,
+			expectedError:     testError,
+		},
+		"Fargate adapter not initialized error": {
+			initializeAdapter: false,
+			expectedError:     ErrNotInitialized,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockECS := new(mockEcsClient)
+			defer mockECS.AssertExpectations(t)
+
+			fargate := NewFargate(logger, "us-east-1")
+
+			if tt.initializeAdapter {
+				mockECS.On(
+					"DescribeTasksWithContext",
+					mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
+					&ecs.DescribeTasksInput{
+						Cluster: aws.String("cluster-name"),
+						Tasks:   []*string{aws.String("task-arn")},
+					},
+				).
+					Return(tt.awsOutput, tt.awsError).
+					Once()
+
+				err := fargate.Init()
+				require.NoError(t, err)
+
+				fargate.(*awsFargate).ecsSvc = mockECS
+			}
+
+			description, err := fargate.DescribeTask(context.Background(), "task-arn", "cluster-name")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDescription, description)
+		})
+	}
+}
+
+func TestIsTaskStopping(t *testing.T) {
+	assert.False(t, IsTaskStopping("PROVISIONING"))
+	assert.False(t, IsTaskStopping(TaskStatusRunning))
+	assert.True(t, IsTaskStopping("STOPPING"))
+	assert.True(t, IsTaskStopping("STOPPED"))
+}
+
 func TestGetContainerIP(t *testing.T) {
 	testError := errors.New("simulated error")
 	logger := createTestLogger()
@@ -439,7 +941,7 @@ func mockFargateDescribeNetworkInterfaces(shouldBeCalled bool, errorToReturn err
 	publicIP := "172.0.0.1"
 	mockEC2.On(
 		"DescribeNetworkInterfacesWithContext",
-		mock.AnythingOfType("*context.emptyCtx"),
+		mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
 		mock.AnythingOfType("*ec2.DescribeNetworkInterfacesInput"),
 	).
 		Return(
@@ -470,7 +972,7 @@ func mockFargateDescribeTask(shouldBeCalled bool, errorToReturn error) *mockEcsC
 	expectedNetworkIDValue := "net-id"
 	mockECS.On(
 		"DescribeTasksWithContext",
-		mock.AnythingOfType("*context.emptyCtx"),
+		mock.AnythingOfType(fmt.Sprintf("%T", context.Background())),
 		mock.AnythingOfType("*ecs.DescribeTasksInput"),
 	).
 		Return(
@@ -13,6 +13,50 @@ type MockFargate struct {
 	mock.Mock
 }
 
+// DescribeTask provides a mock function with given fields: ctx, taskARN, cluster
+func (_m *MockFargate) DescribeTask(ctx context.Context, taskARN string, cluster string) (*TaskDescription, error) {
+	ret := _m.Called(ctx, taskARN, cluster)
+
+	var r0 *TaskDescription
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *TaskDescription); ok {
+		r0 = rf(ctx, taskARN, cluster)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*TaskDescription)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, taskARN, cluster)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindTask provides a mock function with given fields: ctx, cluster, clientToken
+func (_m *MockFargate) FindTask(ctx context.Context, cluster string, clientToken string) (string, error) {
+	ret := _m.Called(ctx, cluster, clientToken)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, cluster, clientToken)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, cluster, clientToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetContainerIP provides a mock function with given fields: ctx, taskARN, cluster, usePublicIP
 func (_m *MockFargate) GetContainerIP(ctx context.Context, taskARN string, cluster string, usePublicIP bool) (string, error) {
 	ret := _m.Called(ctx, taskARN, cluster, usePublicIP)
@@ -34,6 +78,27 @@ func (_m *MockFargate) GetContainerIP(ctx context.Context, taskARN string, clust
 	return r0, r1
 }
 
+// GetTaskStatus provides a mock function with given fields: ctx, taskARN, cluster
+func (_m *MockFargate) GetTaskStatus(ctx context.Context, taskARN string, cluster string) (string, error) {
+	ret := _m.Called(ctx, taskARN, cluster)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, taskARN, cluster)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, taskARN, cluster)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Init provides a mock function with given fields:
 func (_m *MockFargate) Init() error {
 	ret := _m.Called()
@@ -69,13 +134,13 @@ func (_m *MockFargate) RunTask(ctx context.Context, taskSettings TaskSettings, c
 	return r0, r1
 }
 
-// StopTask provides a mock function with given fields: ctx, taskARN, cluster
-func (_m *MockFargate) StopTask(ctx context.Context, taskARN string, cluster string) error {
-	ret := _m.Called(ctx, taskARN, cluster)
+// StopTask provides a mock function with given fields: ctx, taskARN, cluster, reason
+func (_m *MockFargate) StopTask(ctx context.Context, taskARN string, cluster string, reason string) error {
+	ret := _m.Called(ctx, taskARN, cluster, reason)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, taskARN, cluster)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, taskARN, cluster, reason)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -83,16 +148,39 @@ func (_m *MockFargate) StopTask(ctx context.Context, taskARN string, cluster str
 	return r0
 }
 
-// WaitUntilTaskRunning provides a mock function with given fields: ctx, taskARN, cluster
-func (_m *MockFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string) error {
-	ret := _m.Called(ctx, taskARN, cluster)
+// WaitUntilTaskRunning provides a mock function with given fields: ctx, taskARN, cluster, observer
+func (_m *MockFargate) WaitUntilTaskRunning(ctx context.Context, taskARN string, cluster string, observer TaskStatusObserver) error {
+	ret := _m.Called(ctx, taskARN, cluster, observer)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, taskARN, cluster)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, TaskStatusObserver) error); ok {
+		r0 = rf(ctx, taskARN, cluster, observer)
 	} else {
 		r0 = ret.Error(0)
 	}
 
 	return r0
 }
+
+// WaitUntilTaskStopped provides a mock function with given fields: ctx, taskARN, cluster
+func (_m *MockFargate) WaitUntilTaskStopped(ctx context.Context, taskARN string, cluster string) (*StoppedTask, error) {
+	ret := _m.Called(ctx, taskARN, cluster)
+
+	var r0 *StoppedTask
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *StoppedTask); ok {
+		r0 = rf(ctx, taskARN, cluster)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*StoppedTask)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, taskARN, cluster)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
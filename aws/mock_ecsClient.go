@@ -46,6 +46,36 @@ func (_m *mockEcsClient) DescribeTasksWithContext(_a0 context.Context, _a1 *ecs.
 	return r0, r1
 }
 
+// ListTasksWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *mockEcsClient) ListTasksWithContext(_a0 context.Context, _a1 *ecs.ListTasksInput, _a2 ...request.Option) (*ecs.ListTasksOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *ecs.ListTasksOutput
+	if rf, ok := ret.Get(0).(func(context.Context, *ecs.ListTasksInput, ...request.Option) *ecs.ListTasksOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ecs.ListTasksOutput)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *ecs.ListTasksInput, ...request.Option) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // RunTaskWithContext provides a mock function with given fields: _a0, _a1, _a2
 func (_m *mockEcsClient) RunTaskWithContext(_a0 context.Context, _a1 *ecs.RunTaskInput, _a2 ...request.Option) (*ecs.RunTaskOutput, error) {
 	_va := make([]interface{}, len(_a2))
@@ -126,3 +156,24 @@ func (_m *mockEcsClient) WaitUntilTasksRunningWithContext(_a0 context.Context, _
 
 	return r0
 }
+
+// WaitUntilTasksStoppedWithContext provides a mock function with given fields: _a0, _a1, _a2
+func (_m *mockEcsClient) WaitUntilTasksStoppedWithContext(_a0 context.Context, _a1 *ecs.DescribeTasksInput, _a2 ...request.WaiterOption) error {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *ecs.DescribeTasksInput, ...request.WaiterOption) error); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,47 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// maxThrottledRetries is the number of retries of a throttled request.
+	// The rate limits of the ECS API are shared by all the jobs handled by
+	// the Runner, so throttling is expected when many jobs end together
+	maxThrottledRetries = 10
+
+	minThrottleDelay = 500 * time.Millisecond
+	maxThrottleDelay = 20 * time.Second
+)
+
+// throttlingRetryer retries the throttled requests with exponential backoff
+// more times than the requests failed for other reasons, which are retried
+// as done by the default retryer of the SDK
+type throttlingRetryer struct {
+	client.DefaultRetryer
+}
+
+func newThrottlingRetryer() request.Retryer {
+	return throttlingRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    maxThrottledRetries,
+			MinThrottleDelay: minThrottleDelay,
+			MaxThrottleDelay: maxThrottleDelay,
+		},
+	}
+}
+
+func (r throttlingRetryer) ShouldRetry(req *request.Request) bool {
+	if req.IsErrorThrottle() {
+		return true
+	}
+
+	if req.RetryCount >= client.DefaultRetryerMaxNumRetries {
+		return false
+	}
+
+	return r.DefaultRetryer.ShouldRetry(req)
+}
@@ -0,0 +1,62 @@
+package aws
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottlingRetryer(t *testing.T) {
+	throttlingError := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	serverError := awserr.New("ServerException", "Internal error", nil)
+
+	tests := map[string]struct {
+		err           error
+		statusCode    int
+		retryCount    int
+		expectedRetry bool
+	}{
+		"Throttled request is retried": {
+			err:           throttlingError,
+			statusCode:    http.StatusBadRequest,
+			retryCount:    5,
+			expectedRetry: true,
+		},
+		"Server error is retried": {
+			err:           serverError,
+			statusCode:    http.StatusInternalServerError,
+			retryCount:    1,
+			expectedRetry: true,
+		},
+		"Server error is not retried after the default retries": {
+			err:           serverError,
+			statusCode:    http.StatusInternalServerError,
+			retryCount:    3,
+			expectedRetry: false,
+		},
+		"Client error is not retried": {
+			err:           awserr.New("InvalidParameterException", "Invalid cluster", nil),
+			statusCode:    http.StatusBadRequest,
+			retryCount:    0,
+			expectedRetry: false,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			retryer := newThrottlingRetryer()
+
+			req := &request.Request{
+				Error:        tt.err,
+				RetryCount:   tt.retryCount,
+				HTTPResponse: &http.Response{StatusCode: tt.statusCode},
+			}
+
+			assert.Equal(t, tt.expectedRetry, retryer.ShouldRetry(req))
+			assert.Equal(t, maxThrottledRetries, retryer.MaxRetries())
+		})
+	}
+}
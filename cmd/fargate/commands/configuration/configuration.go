@@ -0,0 +1,51 @@
+// Package configuration provides the commands checking the configuration
+// file of the driver
+package configuration
+
+import (
+	urfave "github.com/urfave/cli"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+const categoryName = "config"
+
+var categoryAliases = []string{"cfg"}
+
+// NewConfigCategory groups the commands checking the configuration file
+func NewConfigCategory() cli.Category {
+	return cli.Category{
+		Config: cli.Config{
+			Name:    categoryName,
+			Aliases: categoryAliases,
+			Usage:   "Checks of the configuration file",
+			Description: `These commands load the configuration file themselves, so they can
+report its problems instead of failing before being executed.`,
+		},
+		SubCommands: []cli.Command{
+			NewValidateCommand(),
+			NewSchemaCommand(),
+		},
+	}
+}
+
+// IsConfigurationCommand reports whether the command line arguments execute
+// a command of this category, which doesn't need the configuration loaded
+// by the application
+func IsConfigurationCommand(args urfave.Args) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	if args[0] == categoryName {
+		return true
+	}
+
+	for _, alias := range categoryAliases {
+		if args[0] == alias {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,26 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	urfave "github.com/urfave/cli"
+)
+
+func TestIsConfigurationCommand(t *testing.T) {
+	tests := map[string]struct {
+		args     urfave.Args
+		expected bool
+	}{
+		"No command":            {args: urfave.Args{}, expected: false},
+		"Configuration command": {args: urfave.Args{"config", "validate"}, expected: true},
+		"Alias of the category": {args: urfave.Args{"cfg", "schema"}, expected: true},
+		"Custom Executor stage": {args: urfave.Args{"custom", "config"}, expected: false},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsConfigurationCommand(tt.args))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package configuration
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+// NewSchemaCommand constructs the command line abstraction for the "schema" command
+func NewSchemaCommand() cli.Command {
+	cmd := new(SchemaCommand)
+
+	cmd.output = os.Stdout
+
+	return cli.Command{
+		Handler: cmd,
+		Config: cli.Config{
+			Name:  "schema",
+			Usage: "Print the JSON Schema of the configuration file",
+			Description: `
+Prints the JSON Schema describing the settings of the configuration file.
+It can be used by editors and linters to check the configuration after
+converting it to JSON.`,
+		},
+	}
+}
+
+// SchemaCommand provides data and operations related to the "schema" command
+type SchemaCommand struct {
+	output io.Writer
+}
+
+// Execute prints the JSON Schema of the configuration
+func (c *SchemaCommand) Execute(ctx *cli.Context) error {
+	schema, err := config.JSONSchema()
+	if err != nil {
+		return fmt.Errorf("generating JSON Schema: %w", err)
+	}
+
+	_, err = fmt.Fprintln(c.output, string(schema))
+	if err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+func TestSchemaCommand_Execute(t *testing.T) {
+	output := new(bytes.Buffer)
+
+	cmd := new(SchemaCommand)
+	cmd.output = output
+
+	err := cmd.Execute(new(cli.Context))
+	require.NoError(t, err)
+
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &schema))
+	assert.Contains(t, schema, "properties")
+}
@@ -0,0 +1,99 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+// NewValidateCommand constructs the command line abstraction for the "validate" command
+func NewValidateCommand() cli.Command {
+	cmd := new(ValidateCommand)
+
+	cmd.output = os.Stdout
+	cmd.loadFromFile = config.LoadFromFile
+
+	return cli.Command{
+		Handler: cmd,
+		Config: cli.Config{
+			Name:  "validate",
+			Usage: "Validate the configuration file",
+			Description: `
+Loads the configuration file like the other commands do, and lists all its
+problems: unknown settings, missing required settings and values with an
+invalid format. The problems are reported with their line in the file.
+
+When the configuration is merged from several files, the problems are
+reported with the file defining the setting, and the values replaced by the
+later files are listed as notes.
+
+The command fails when the configuration is invalid.`,
+		},
+	}
+}
+
+// ValidateCommand provides data and operations related to the "validate" command
+type ValidateCommand struct {
+	ConfigFile string `long:"config" description:"Path to the configuration file to validate. Defaults to the file of the global --config option"`
+
+	output io.Writer
+
+	// Wrapping functions to make easier mocking in the unit tests
+	loadFromFile func(file string) (config.Global, error)
+}
+
+// Execute validates the configuration file
+func (c *ValidateCommand) Execute(ctx *cli.Context) error {
+	file := c.ConfigFile
+	if file == "" {
+		file = ctx.Cli.GlobalString("config")
+	}
+
+	cfg, err := c.loadFromFile(file)
+
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		return c.reportProblems(file, validationErr.Problems)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range cfg.ShadowedSettings() {
+		_, err = fmt.Fprintf(c.output, "note: %s\n", setting)
+		if err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+	}
+
+	_, err = fmt.Fprintf(c.output, "Configuration file %q is valid\n", file)
+	if err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+
+	return nil
+}
+
+// reportProblems lists the problems one per line, as the error logged by the
+// application would escape the line breaks. The problems already naming
+// their file are not prefixed
+func (c *ValidateCommand) reportProblems(file string, problems []config.Problem) error {
+	for _, problem := range problems {
+		prefix := file + ": "
+		if problem.File != "" {
+			prefix = ""
+		}
+
+		_, err := fmt.Fprintf(c.output, "%s%s\n", prefix, problem)
+		if err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+	}
+
+	return fmt.Errorf("%w: %d problems found in %q", config.ErrInvalidConfig, len(problems), file)
+}
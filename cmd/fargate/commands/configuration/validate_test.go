@@ -0,0 +1,88 @@
+package configuration
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+)
+
+func TestNewValidateCommand(t *testing.T) {
+	cmd := NewValidateCommand()
+
+	assert.NotNil(t, cmd, "Command should be created")
+	assert.NotNil(t, cmd.Handler, "Handler should be created")
+}
+
+func TestValidateCommand_Execute(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		loadError      error
+		expectedOutput string
+		expectedError  error
+	}{
+		"Valid configuration": {
+			expectedOutput: "Configuration file \"config.toml\" is valid\n",
+		},
+		"Invalid configuration": {
+			loadError: &config.ValidationError{
+				Problems: []config.Problem{
+					{Key: "Fargate.Subnnet", Line: 5, Message: "is not a known setting"},
+					{Key: "Fargate.Subnet", Message: "is required"},
+				},
+			},
+			expectedOutput: "config.toml: line 5: Fargate.Subnnet: is not a known setting\n" +
+				"config.toml: Fargate.Subnet: is required\n",
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Invalid configuration fragments": {
+			loadError: &config.ValidationError{
+				Problems: []config.Problem{
+					{Key: "SSH.Port", File: "config.d/10-ssh.toml", Line: 4, Message: "must be at most 65535"},
+					{Key: "Lease.Enable", File: "config.d/20-lease.json", Message: "is not a known setting"},
+				},
+			},
+			expectedOutput: "config.d/10-ssh.toml: line 4: SSH.Port: must be at most 65535\n" +
+				"config.d/20-lease.json: Lease.Enable: is not a known setting\n",
+			expectedError: config.ErrInvalidConfig,
+		},
+		"Error loading the configuration": {
+			loadError:     testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			output := new(bytes.Buffer)
+
+			cmd := new(ValidateCommand)
+			cmd.ConfigFile = "config.toml"
+			cmd.output = output
+			cmd.loadFromFile = func(file string) (config.Global, error) {
+				assert.Equal(t, "config.toml", file)
+				return config.Global{}, tt.loadError
+			}
+
+			ctx := new(cli.Context)
+			ctx.SetLogger(test.NewNullLogger())
+
+			err := cmd.Execute(ctx)
+			assert.Equal(t, tt.expectedOutput, output.String())
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package custom
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// maxLinkDepth limits the symbolic links followed to resolve a path, like
+// the kernel does
+const maxLinkDepth = 40
+
+// ErrUnsafeArchive is returned when an archive of the task container has a
+// member that would be extracted out of the directory of the Runner host
+var ErrUnsafeArchive = errors.New("unsafe archive")
+
+// checkArchive copies the tar archive read from r to w, member by member,
+// once each member is checked. The members must be directories, regular
+// files or links, and can't lead out of the directory the archive is
+// extracted in, directly or through the symbolic links of the archive
+func checkArchive(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	links := newArchiveLinks()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		err = links.check(header)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnsafeArchive, err)
+		}
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return fmt.Errorf("writing archive: %w", err)
+		}
+
+		_, err = io.Copy(tw, tr)
+		if err != nil {
+			return fmt.Errorf("writing archive: %w", err)
+		}
+	}
+
+	err := links.verify()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsafeArchive, err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+
+	// The padding after the end of the archive is consumed, so the writer
+	// doesn't fail on a closed pipe
+	_, _ = io.Copy(ioutil.Discard, r)
+
+	return nil
+}
+
+// archiveLinks records the symbolic links of an archive, by the path of the
+// directory they are extracted in, once their own links are followed
+type archiveLinks struct {
+	links map[string]string
+}
+
+func newArchiveLinks() *archiveLinks {
+	return &archiveLinks{links: make(map[string]string)}
+}
+
+func (a *archiveLinks) check(header *tar.Header) error {
+	name := strings.TrimSuffix(header.Name, "/")
+	if path.IsAbs(name) || hasParentComponent(name) {
+		return fmt.Errorf("member %q is out of the directory", header.Name)
+	}
+
+	dir, ok := a.resolve(path.Dir(name), 0)
+	if !ok {
+		return fmt.Errorf("member %q is extracted through a link leading out of the directory", header.Name)
+	}
+
+	member := path.Join(dir, path.Base(name))
+
+	// The extracted member replaces the previous one, but a directory is
+	// extracted through an existing link
+	if header.Typeflag != tar.TypeDir {
+		delete(a.links, member)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeRegA:
+		return nil
+	case tar.TypeSymlink:
+		_, ok := a.resolve(dir+"/"+header.Linkname, 0)
+		if path.IsAbs(header.Linkname) || !ok {
+			return fmt.Errorf("symbolic link %q to %q leads out of the directory", header.Name, header.Linkname)
+		}
+
+		a.links[member] = header.Linkname
+
+		return nil
+	case tar.TypeLink:
+		_, ok := a.resolve(header.Linkname, 0)
+		if path.IsAbs(header.Linkname) || hasParentComponent(header.Linkname) || !ok {
+			return fmt.Errorf("hard link %q to %q leads out of the directory", header.Name, header.Linkname)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("member %q has the unsupported type %q", header.Name, header.Typeflag)
+}
+
+// verify checks the symbolic links once the archive is read, as a member can
+// change the path a previous link leads to
+func (a *archiveLinks) verify() error {
+	for member, target := range a.links {
+		_, ok := a.resolve(parentDir(member)+"/"+target, 0)
+		if !ok {
+			return fmt.Errorf("symbolic link %q to %q leads out of the directory", member, target)
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the path p, relative to the directory of the archive, once
+// the symbolic links of the archive are followed. It reports false when the
+// path leads out of the directory. The components are followed one by one,
+// like the kernel does, as ".." after a link goes up from its target
+func (a *archiveLinks) resolve(p string, depth int) (string, bool) {
+	if depth > maxLinkDepth {
+		return "", false
+	}
+
+	resolved := ""
+
+	for _, component := range strings.Split(p, "/") {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "" {
+				return "", false
+			}
+
+			resolved = parentDir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, component)
+
+		target, ok := a.links[next]
+		if !ok {
+			resolved = next
+			continue
+		}
+
+		if path.IsAbs(target) {
+			return "", false
+		}
+
+		resolved, ok = a.resolve(resolved+"/"+target, depth+1)
+		if !ok {
+			return "", false
+		}
+	}
+
+	return resolved, true
+}
+
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
+
+func hasParentComponent(p string) bool {
+	for _, component := range strings.Split(p, "/") {
+		if component == ".." {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,122 @@
+package custom
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestCheckArchive(t *testing.T) {
+	dir := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+	}
+	symlink := func(name string, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+	hardlink := func(name string, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}
+	}
+
+	tests := map[string]struct {
+		headers       []*tar.Header
+		expectedError bool
+	}{
+		"Files and links of the directory": {
+			headers: []*tar.Header{
+				dir("./"),
+				dir("./pkg/bin/"),
+				file("./pkg/bin/x"),
+				dir("./node_modules/.bin/"),
+				symlink("./node_modules/.bin/x", "../../pkg/bin/x"),
+				symlink("./bin", "pkg/bin"),
+				file("./bin/y"),
+				hardlink("./z", "./bin/y"),
+				symlink("./self", "."),
+			},
+		},
+		"Absolute member": {
+			headers:       []*tar.Header{file("/etc/passwd")},
+			expectedError: true,
+		},
+		"Member out of the directory": {
+			headers:       []*tar.Header{dir("./"), file("./a/../../passwd")},
+			expectedError: true,
+		},
+		"Absolute symbolic link": {
+			headers:       []*tar.Header{symlink("./passwd", "/etc/passwd")},
+			expectedError: true,
+		},
+		"Symbolic link out of the directory": {
+			headers:       []*tar.Header{dir("./a/"), symlink("./a/up", "../..")},
+			expectedError: true,
+		},
+		"Symbolic link out of the directory through other links": {
+			headers: []*tar.Header{
+				dir("./sub/sub/"),
+				symlink("./y", "sub/sub"),
+				symlink("./x", "y/../../.."),
+			},
+			expectedError: true,
+		},
+		"Symbolic link changed by a later member": {
+			headers: []*tar.Header{
+				dir("./a/"),
+				symlink("./x", "a/.."),
+				symlink("./a", "."),
+			},
+			expectedError: true,
+		},
+		"Member extracted through a link of the directory": {
+			headers: []*tar.Header{
+				symlink("./parent", "."),
+				symlink("./parent/up", ".."),
+			},
+			expectedError: true,
+		},
+		"Looping symbolic links": {
+			headers: []*tar.Header{
+				symlink("./a", "b"),
+				symlink("./b", "a"),
+				file("./a/x"),
+			},
+			expectedError: true,
+		},
+		"Hard link out of the directory": {
+			headers:       []*tar.Header{hardlink("./passwd", "../../etc/passwd")},
+			expectedError: true,
+		},
+		"FIFO": {
+			headers:       []*tar.Header{{Name: "./fifo", Typeflag: tar.TypeFifo, Mode: 0644}},
+			expectedError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			archive := testArchive(t, tt.headers...)
+			out := new(bytes.Buffer)
+
+			err := checkArchive(bytes.NewReader(archive), out)
+
+			if tt.expectedError {
+				assertions.ErrorIs(t, err, ErrUnsafeArchive)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			var names []string
+			for _, header := range tt.headers {
+				names = append(names, header.Name)
+			}
+			assert.Equal(t, names, archiveMembers(t, out.String()))
+		})
+	}
+}
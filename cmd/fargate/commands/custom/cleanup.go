@@ -1,15 +1,26 @@
 package custom
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	sshExecutor "gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors/ssh"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
 )
 
+// defaultStopWaitTimeout limits the time of waiting for the task to be stopped
+const defaultStopWaitTimeout = 5 * time.Minute
+
 // NewCleanupCommand constructs the command line abstraction for the "cleanup" stage
 func NewCleanupCommand() cli.Command {
 	cmd := new(CleanupCommand)
@@ -18,8 +29,10 @@ func NewCleanupCommand() cli.Command {
 	cmd.newFargate = func(logger logging.Logger, awsRegion string) aws.Fargate {
 		return aws.NewFargate(logger, awsRegion)
 	}
-	cmd.newMetadataManager = func(logger logging.Logger, directory string) task.MetadataManager {
-		return task.NewMetadataManager(logger, directory)
+	cmd.newMetadataManager = task.NewMetadataManagerForConfig
+	cmd.newStopQueue = task.NewStopQueue
+	cmd.newExecutor = func(logger logging.Logger) executors.Executor {
+		return sshExecutor.NewExecutor(logger)
 	}
 
 	return cli.Command{
@@ -32,7 +45,9 @@ func NewCleanupCommand() cli.Command {
 This is the implementation of Cleanup stage of the Custom Executor.
 
 This command is deleting the AWS Fargate Task that was dedicated for
-the job scripts execution.
+the job scripts execution. Tasks that couldn't be stopped are queued,
+and stopping them is retried by the next executions of this command
+and of the "prepare" command.
 
 Details about how this command is used can be found at
 https://docs.gitlab.com/runner/executors/custom.html#cleanup.`,
@@ -49,10 +64,15 @@ type CleanupCommand struct {
 
 	awsFargate      aws.Fargate
 	metadataManager task.MetadataManager
+	// stopQueue is nil when no directory is configured for the queue
+	stopQueue task.StopQueue
+	executor  executors.Executor
 
 	// Wrapping constructors to make easier mocking in the unit tests
 	newFargate         func(logger logging.Logger, awsRegion string) aws.Fargate
-	newMetadataManager func(logger logging.Logger, directory string) task.MetadataManager
+	newMetadataManager func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error)
+	newStopQueue       func(logger logging.Logger, directory string) (task.StopQueue, error)
+	newExecutor        func(logger logging.Logger) executors.Executor
 }
 
 // CustomExecute is the "core" of the implementation for the "cleanup" stage
@@ -66,38 +86,198 @@ func (c *CleanupCommand) CustomExecute(ctx *cli.Context) error {
 
 	c.logger.Info("Fetching task data from metadata storage")
 	taskData, err := c.metadataManager.Get()
-	if err != nil {
+	metadataMissing := errors.Is(err, os.ErrNotExist)
+	if metadataMissing {
+		c.logger.
+			WithError(err).
+			Warning("Metadata of the job doesn't exist. Will search the task by its tags")
+	} else if err != nil {
 		return fmt.Errorf("obtaining information about the running task: %w", err)
 	}
 
-	logger := c.logger.WithField("taskARN", taskData.TaskARN)
-	logger.Info("Stopping Fargate task")
-	err = c.awsFargate.StopTask(ctx.Ctx, taskData.TaskARN, c.cfg.Fargate.Cluster)
+	taskARN, err := c.findTaskARN(ctx, taskData, metadataMissing)
 	if err != nil {
-		return fmt.Errorf("stopping Fargate Task %q: %w", taskData.TaskARN, err)
+		return fmt.Errorf("searching the Fargate Task started for the job: %w", err)
+	}
+
+	logger := c.logger.WithField("taskARN", taskARN)
+	if taskARN == "" {
+		logger.Info("No Fargate task was started for the job")
+	} else {
+		c.removeSyncedFiles(ctx, logger, taskData)
+
+		// A queued stop is retried by the later executions, so the stage
+		// only fails when the task couldn't be queued
+		err = c.stopTask(ctx, logger, taskARN)
+		if err != nil && !c.queueStop(logger, taskARN) {
+			return err
+		}
+	}
+
+	retryQueuedStops(ctx.Ctx, c.logger, c.stopQueue, c.awsFargate)
+
+	if metadataMissing {
+		return nil
 	}
 
 	logger.Info("Clear metadata related to the stopped Fargate Task")
 	err = c.metadataManager.Clear()
 	if err != nil {
-		return fmt.Errorf("deleting metadata related to the stopped Fargate Task %q: %w", taskData.TaskARN, err)
+		return fmt.Errorf("deleting metadata related to the stopped Fargate Task %q: %w", taskARN, err)
+	}
+
+	return nil
+}
+
+func (c *CleanupCommand) stopReason() string {
+	return fmt.Sprintf("Job %s finished", runner.GetAdapter().JobURL())
+}
+
+// removeSyncedFiles removes the files of the file-type variables from the
+// task container. It doesn't fail the stage, as the files are lost anyway
+// once the task is stopped
+func (c *CleanupCommand) removeSyncedFiles(ctx *cli.Context, logger logging.Logger, taskData task.Data) {
+	if len(taskData.SyncedFiles) == 0 {
+		return
+	}
+
+	quoted := make([]string, 0, len(taskData.SyncedFiles))
+	for _, file := range taskData.SyncedFiles {
+		quoted = append(quoted, executors.ShellQuote(file))
+	}
+
+	script := fmt.Sprintf("rm -f %s\n", strings.Join(quoted, " "))
+
+	err := c.executor.Execute(ctx.Ctx, newConnectionSettings(taskData, c.cfg.SSH), []byte(script))
+	if err != nil {
+		logger.WithError(err).Warning("Couldn't remove the synced files from the task container")
+		return
+	}
+
+	logger.
+		WithField("files", taskData.SyncedFiles).
+		Info("Removed the synced files from the task container")
+}
+
+// stopTask doesn't fail when the task is not stopped in time, as it will be
+// stopped by ECS anyway, nor when the task doesn't exist anymore
+func (c *CleanupCommand) stopTask(ctx *cli.Context, logger logging.Logger, taskARN string) error {
+	logger.Info("Stopping Fargate task")
+
+	err := c.awsFargate.StopTask(ctx.Ctx, taskARN, c.cfg.Fargate.Cluster, c.stopReason())
+	if errors.Is(err, aws.ErrTaskNotFound) {
+		logger.Info("Fargate task doesn't exist anymore")
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("stopping Fargate Task %q: %w", taskARN, err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx.Ctx, defaultStopWaitTimeout)
+	defer cancel()
+
+	stoppedTask, err := c.awsFargate.WaitUntilTaskStopped(waitCtx, taskARN, c.cfg.Fargate.Cluster)
+	if err != nil {
+		logger.WithError(err).Warning("Fargate task was not stopped in time")
+		return nil
+	}
+
+	logger = logger.WithField("stoppedReason", stoppedTask.StoppedReason)
+	for _, container := range stoppedTask.Containers {
+		containerLogger := logger.
+			WithField("container", container.Name).
+			WithField("reason", container.Reason)
+
+		if container.ExitCode == nil {
+			containerLogger.Info("Container stopped without exit code")
+			continue
+		}
+
+		containerLogger.
+			WithField("exitCode", *container.ExitCode).
+			Info("Container stopped")
 	}
 
 	return nil
 }
 
+// queueStop records the task that couldn't be stopped, so the stop is
+// retried by a later execution. It returns false when the task wasn't queued
+func (c *CleanupCommand) queueStop(logger logging.Logger, taskARN string) bool {
+	if c.stopQueue == nil {
+		return false
+	}
+
+	err := c.stopQueue.Add(task.StopRequest{
+		TaskARN: taskARN,
+		Cluster: c.cfg.Fargate.Cluster,
+		Reason:  c.stopReason(),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Couldn't queue the Fargate task for a later stop")
+		return false
+	}
+
+	logger.Warning("Fargate task couldn't be stopped. It was queued for a later stop")
+
+	return true
+}
+
+// retryQueuedStops is called by the "prepare" and "cleanup" stages. It
+// doesn't fail the stage, as the queued tasks belong to other jobs
+func retryQueuedStops(ctx context.Context, logger logging.Logger, queue task.StopQueue, fargate aws.Fargate) {
+	if queue == nil {
+		return
+	}
+
+	_, err := queue.Drain(task.IgnoreTaskNotFound(func(request task.StopRequest) error {
+		return fargate.StopTask(ctx, request.TaskARN, request.Cluster, request.Reason)
+	}))
+	if err != nil {
+		logger.WithError(err).Warning("Retrying the queued stops of Fargate tasks")
+	}
+}
+
+// findTaskARN uses the client token when the "prepare" stage was aborted
+// before it recorded the ARN of the started task. When the metadata is
+// missing, the client token is derived from the job
+func (c *CleanupCommand) findTaskARN(ctx *cli.Context, taskData task.Data, metadataMissing bool) (string, error) {
+	if metadataMissing {
+		return c.awsFargate.FindTask(ctx.Ctx, c.cfg.Fargate.Cluster, aws.JobClientToken(task.JobKey()))
+	}
+
+	if taskData.TaskARN != "" || taskData.ClientToken == "" {
+		return taskData.TaskARN, nil
+	}
+
+	return c.awsFargate.FindTask(ctx.Ctx, c.cfg.Fargate.Cluster, taskData.ClientToken)
+}
+
 func (c *CleanupCommand) init(ctx *cli.Context) error {
 	c.cfg = ctx.Config()
 	c.logger = ctx.Logger().
 		WithField("command", "cleanup_exec")
 
+	c.executor = c.newExecutor(c.logger)
+
 	c.awsFargate = c.newFargate(c.logger, c.cfg.Fargate.Region)
 	err := c.awsFargate.Init()
 	if err != nil {
 		return fmt.Errorf("initializing Fargate adapter: %w", err)
 	}
 
-	c.metadataManager = c.newMetadataManager(c.logger, c.cfg.TaskMetadata.Directory)
+	c.metadataManager, err = c.newMetadataManager(c.logger, c.cfg.TaskMetadata, c.cfg.Fargate.Region)
+	if err != nil {
+		return fmt.Errorf("initializing metadata manager: %w", err)
+	}
+
+	c.stopQueue, err = c.newStopQueue(c.logger, task.StopQueueDirectory(c.cfg.TaskMetadata))
+	if errors.Is(err, task.ErrStopQueueNotConfigured) {
+		c.logger.Debug("[init] Stop queue is disabled, as no directory is configured")
+	} else if err != nil {
+		return fmt.Errorf("initializing stop queue: %w", err)
+	}
 
 	return nil
 }
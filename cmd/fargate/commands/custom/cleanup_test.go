@@ -3,12 +3,17 @@ package custom
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
@@ -20,11 +25,20 @@ type cleanupCommandTestCase struct {
 	context       context.Context
 	fargateConfig config.Fargate
 	taskData      task.Data
+	foundTaskARN  string
 
 	fargateInitError     error
+	metadataInitError    error
+	stopQueueInitError   error
 	obtainTaskDataError  error
+	fargateFindTaskError error
 	fargateStopTaskError error
+	fargateWaitTaskError error
 	clearMetadataError   error
+	queueStopError       error
+	drainQueueError      error
+	removeFilesError     error
+	stoppedTask          *aws.StoppedTask
 
 	expectedError error
 }
@@ -37,6 +51,8 @@ func TestNewCleanupCommand(t *testing.T) {
 }
 
 func TestCustomExecuteCleanup(t *testing.T) {
+	initializeAdapterForTesting(t)
+
 	testContext, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -54,25 +70,99 @@ func TestCustomExecuteCleanup(t *testing.T) {
 			fargateInitError: testError,
 			expectedError:    testError,
 		},
+		"Error during metadata manager init": {
+			metadataInitError: testError,
+			expectedError:     testError,
+		},
 		"Error reading task metadata": {
 			obtainTaskDataError: testError,
 			expectedError:       testError,
 		},
-		"Error stopping Fargate task": {
+		"Error during stop queue init": {
+			stopQueueInitError: testError,
+			expectedError:      testError,
+		},
+		"Execute cleanup with success when the stop of the task is queued": {
+			fargateStopTaskError: testError,
+		},
+		"Execute cleanup with success when the task doesn't exist anymore": {
+			fargateStopTaskError: fmt.Errorf("stopping task: %w", aws.ErrTaskNotFound),
+		},
+		"Error stopping Fargate task when it can't be queued": {
 			fargateStopTaskError: testError,
+			queueStopError:       testError,
 			expectedError:        testError,
 		},
+		"Error stopping Fargate task when the stop queue is disabled": {
+			stopQueueInitError:   task.ErrStopQueueNotConfigured,
+			fargateStopTaskError: testError,
+			expectedError:        testError,
+		},
+		"Execute cleanup with success when the stop queue is disabled": {
+			stopQueueInitError: task.ErrStopQueueNotConfigured,
+		},
+		"Execute cleanup with success when the queued stops fail": {
+			drainQueueError: task.ErrStopAttemptsExhausted,
+		},
 		"Error deleting task metadata": {
 			clearMetadataError: testError,
 			expectedError:      testError,
 		},
+		"Execute cleanup with success when the task ARN wasn't recorded": {
+			taskData:     task.Data{ClientToken: "client-token", Phase: task.PhaseLaunchRequested},
+			foundTaskARN: "found-task-arn",
+		},
+		"Execute cleanup with success when no task was started": {
+			taskData: task.Data{ClientToken: "client-token", Phase: task.PhaseLaunchRequested},
+		},
+		"Execute cleanup with success when no task was requested": {
+			taskData: task.Data{Phase: task.PhaseKeyGenerated},
+		},
+		"Execute cleanup with success when the metadata is missing": {
+			obtainTaskDataError: fmt.Errorf("reading file: %w", os.ErrNotExist),
+			foundTaskARN:        "found-task-arn",
+		},
+		"Execute cleanup with success when the metadata is missing and no task was found": {
+			obtainTaskDataError: fmt.Errorf("reading file: %w", os.ErrNotExist),
+		},
+		"Error searching the task by tags when the metadata is missing": {
+			obtainTaskDataError:  fmt.Errorf("reading file: %w", os.ErrNotExist),
+			fargateFindTaskError: testError,
+			expectedError:        testError,
+		},
+		"Execute cleanup with success when the task is not stopped in time": {
+			fargateWaitTaskError: context.DeadlineExceeded,
+		},
+		"Execute cleanup with success and log of the exit codes": {
+			stoppedTask: &aws.StoppedTask{
+				StoppedReason: "Job finished",
+				Containers: []aws.StoppedContainer{
+					{Name: "ci-coordinator", ExitCode: func(i int64) *int64 { return &i }(0)},
+					{Name: "sidecar", Reason: "CannotPullContainerError"},
+				},
+			},
+		},
+		"Execute cleanup with success and removal of the synced files": {
+			taskData: task.Data{TaskARN: "task-arn", Phase: task.PhaseReachable, SyncedFiles: []string{"/builds/project.tmp/KUBECONFIG", "/etc/gitlab-ca.crt"}},
+		},
+		"Execute cleanup with success when the synced files can't be removed": {
+			taskData:         task.Data{TaskARN: "task-arn", Phase: task.PhaseReachable, SyncedFiles: []string{"/etc/gitlab-ca.crt"}},
+			removeFilesError: testError,
+		},
+		"Error searching the task started for the job": {
+			taskData:             task.Data{ClientToken: "client-token", Phase: task.PhaseLaunchRequested},
+			fargateFindTaskError: testError,
+			expectedError:        testError,
+		},
 	}
 
 	for tn, tt := range tests {
 		t.Run(tn, func(t *testing.T) {
 			tt.context = testContext
 			tt.fargateConfig = testFargateConfig
-			tt.taskData = testTaskData
+			if tt.taskData.Phase == "" {
+				tt.taskData = testTaskData
+			}
 
 			mockAwsFargate := new(aws.MockFargate)
 			defer mockAwsFargate.AssertExpectations(t)
@@ -80,28 +170,78 @@ func TestCustomExecuteCleanup(t *testing.T) {
 			mockMetadataManager := new(task.MockMetadataManager)
 			defer mockMetadataManager.AssertExpectations(t)
 
+			mockStopQueue := new(task.MockStopQueue)
+			defer mockStopQueue.AssertExpectations(t)
+
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			stopQueueEnabled := tt.stopQueueInitError == nil
+			stopQueueInitFailed := !stopQueueEnabled && !errors.Is(tt.stopQueueInitError, task.ErrStopQueueNotConfigured)
+
 			mockAwsFargate.On("Init").
 				Return(tt.fargateInitError).
 				Once()
 
 			// Should call get task data if initialization was successful
-			shouldCallGetTaskData := tt.fargateInitError == nil
+			shouldCallGetTaskData := tt.fargateInitError == nil && tt.metadataInitError == nil && !stopQueueInitFailed
 			setExpectationForGetTaskData(mockMetadataManager, shouldCallGetTaskData, tt)
 
+			metadataMissing := errors.Is(tt.obtainTaskDataError, os.ErrNotExist)
+			taskDataObtained := shouldCallGetTaskData && (tt.obtainTaskDataError == nil || metadataMissing)
+
+			// Should search the task by the client token of the job if the metadata is missing
+			shouldCallFindJobTask := taskDataObtained && metadataMissing
+			setExpectationForFindJobTask(mockAwsFargate, shouldCallFindJobTask, tt)
+
+			// Should search the task if its ARN wasn't recorded
+			shouldCallFindTask := taskDataObtained && !metadataMissing &&
+				tt.taskData.TaskARN == "" && tt.taskData.ClientToken != ""
+			setExpectationForFindTask(mockAwsFargate, shouldCallFindTask, tt)
+
 			// Should call stop task if init and get task data were successful
-			shouldCallStopTask := shouldCallGetTaskData && tt.obtainTaskDataError == nil
+			// and the task is known
+			shouldCallStopTask := taskDataObtained && tt.fargateFindTaskError == nil &&
+				((tt.taskData.TaskARN != "" && !metadataMissing) || tt.foundTaskARN != "")
 			setExpectationForStopTask(mockAwsFargate, shouldCallStopTask, tt)
 
-			// Should call clear metadata if all process worked as expected
-			shouldCallClearMetadata := shouldCallStopTask && tt.fargateStopTaskError == nil
+			// Should remove the synced files before stopping the task
+			shouldCallRemoveFiles := shouldCallStopTask && len(tt.taskData.SyncedFiles) > 0
+			setExpectationForRemoveFiles(mockExecutor, shouldCallRemoveFiles, tt)
+
+			// Should queue the task if it couldn't be stopped
+			stopFailed := tt.fargateStopTaskError != nil && !errors.Is(tt.fargateStopTaskError, aws.ErrTaskNotFound)
+			shouldCallQueueStop := shouldCallStopTask && stopFailed && stopQueueEnabled
+			setExpectationForQueueStop(mockStopQueue, shouldCallQueueStop, tt)
+
+			taskHandled := taskDataObtained && tt.fargateFindTaskError == nil &&
+				(!stopFailed || (shouldCallQueueStop && tt.queueStopError == nil))
+
+			// Should retry the queued stops if the task of the job was handled
+			shouldCallDrainQueue := taskHandled && stopQueueEnabled
+			setExpectationForDrainQueue(mockStopQueue, mockAwsFargate, shouldCallDrainQueue, tt)
+
+			// Should clear metadata if the task was stopped or queued and
+			// the metadata exists
+			shouldCallClearMetadata := taskHandled && !metadataMissing
 			setExpectationForClearMetadata(mockMetadataManager, shouldCallClearMetadata, tt)
 
 			cleanup := new(CleanupCommand)
 			cleanup.newFargate = func(logger logging.Logger, awsRegion string) aws.Fargate {
 				return mockAwsFargate
 			}
-			cleanup.newMetadataManager = func(logger logging.Logger, directory string) task.MetadataManager {
-				return mockMetadataManager
+			cleanup.newMetadataManager = func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error) {
+				return mockMetadataManager, tt.metadataInitError
+			}
+			cleanup.newExecutor = func(logger logging.Logger) executors.Executor {
+				return mockExecutor
+			}
+			cleanup.newStopQueue = func(logger logging.Logger, directory string) (task.StopQueue, error) {
+				if tt.stopQueueInitError != nil {
+					return nil, tt.stopQueueInitError
+				}
+
+				return mockStopQueue, nil
 			}
 
 			err := cleanup.CustomExecute(createContextForCleanupCmdTests(tt))
@@ -118,6 +258,21 @@ func TestCustomExecuteCleanup(t *testing.T) {
 	}
 }
 
+func setExpectationForRemoveFiles(mockExecutor *executors.MockExecutor, shouldCall bool, testParams cleanupCommandTestCase) {
+	if !shouldCall {
+		return
+	}
+
+	quoted := make([]string, 0, len(testParams.taskData.SyncedFiles))
+	for _, file := range testParams.taskData.SyncedFiles {
+		quoted = append(quoted, "'"+file+"'")
+	}
+
+	mockExecutor.On("Execute", testParams.context, mock.Anything, []byte("rm -f "+strings.Join(quoted, " ")+"\n")).
+		Return(testParams.removeFilesError).
+		Once()
+}
+
 func setExpectationForGetTaskData(mockManager *task.MockMetadataManager, shouldCall bool, testParams cleanupCommandTestCase) {
 	if !shouldCall {
 		return
@@ -128,19 +283,114 @@ func setExpectationForGetTaskData(mockManager *task.MockMetadataManager, shouldC
 		Once()
 }
 
+func setExpectationForFindJobTask(mockAwsFargate *aws.MockFargate, shouldCall bool, testParams cleanupCommandTestCase) {
+	if !shouldCall {
+		return
+	}
+
+	mockAwsFargate.On(
+		"FindTask",
+		testParams.context,
+		testParams.fargateConfig.Cluster,
+		aws.JobClientToken(task.JobKey()),
+	).
+		Return(testParams.foundTaskARN, testParams.fargateFindTaskError).
+		Once()
+}
+
+func setExpectationForFindTask(mockAwsFargate *aws.MockFargate, shouldCall bool, testParams cleanupCommandTestCase) {
+	if !shouldCall {
+		return
+	}
+
+	mockAwsFargate.On(
+		"FindTask",
+		testParams.context,
+		testParams.fargateConfig.Cluster,
+		testParams.taskData.ClientToken,
+	).
+		Return(testParams.foundTaskARN, testParams.fargateFindTaskError).
+		Once()
+}
+
 func setExpectationForStopTask(mockAwsFargate *aws.MockFargate, shouldCall bool, testParams cleanupCommandTestCase) {
 	if !shouldCall {
 		return
 	}
 
+	taskARN := testParams.foundTaskARN
+	if taskARN == "" {
+		taskARN = testParams.taskData.TaskARN
+	}
+
 	mockAwsFargate.On(
 		"StopTask",
 		testParams.context,
-		testParams.taskData.TaskARN,
+		taskARN,
 		testParams.fargateConfig.Cluster,
+		mock.MatchedBy(func(reason string) bool {
+			return strings.HasPrefix(reason, "Job ") && strings.HasSuffix(reason, " finished")
+		}),
 	).
 		Return(testParams.fargateStopTaskError).
 		Once()
+
+	if testParams.fargateStopTaskError != nil {
+		return
+	}
+
+	stoppedTask := testParams.stoppedTask
+	if stoppedTask == nil && testParams.fargateWaitTaskError == nil {
+		stoppedTask = new(aws.StoppedTask)
+	}
+
+	mockAwsFargate.On(
+		"WaitUntilTaskStopped",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			_, hasDeadline := ctx.Deadline()
+			return hasDeadline
+		}),
+		taskARN,
+		testParams.fargateConfig.Cluster,
+	).
+		Return(stoppedTask, testParams.fargateWaitTaskError).
+		Once()
+}
+
+func setExpectationForQueueStop(mockQueue *task.MockStopQueue, shouldCall bool, testParams cleanupCommandTestCase) {
+	if !shouldCall {
+		return
+	}
+
+	mockQueue.On(
+		"Add",
+		mock.MatchedBy(func(request task.StopRequest) bool {
+			return request.TaskARN != "" &&
+				request.Cluster == testParams.fargateConfig.Cluster &&
+				strings.HasSuffix(request.Reason, " finished")
+		}),
+	).
+		Return(testParams.queueStopError).
+		Once()
+}
+
+func setExpectationForDrainQueue(mockQueue *task.MockStopQueue, mockAwsFargate *aws.MockFargate, shouldCall bool, testParams cleanupCommandTestCase) {
+	if !shouldCall {
+		return
+	}
+
+	queued := task.StopRequest{TaskARN: "queued-task-arn", Cluster: "other-cluster", Reason: "Job finished"}
+
+	mockAwsFargate.On("StopTask", testParams.context, queued.TaskARN, queued.Cluster, queued.Reason).
+		Return(nil).
+		Once()
+
+	mockQueue.On("Drain", mock.AnythingOfType("task.StopFunc")).
+		Run(func(args mock.Arguments) {
+			_ = args.Get(0).(task.StopFunc)(queued)
+		}).
+		Return(task.DrainResult{}, testParams.drainQueueError).
+		Once()
 }
 
 func setExpectationForClearMetadata(mockManager *task.MockMetadataManager, shouldCall bool, testParams cleanupCommandTestCase) {
@@ -76,9 +76,15 @@ func (c *ConfigCommand) CustomExecute(ctx *cli.Context) error {
 
 	c.logger.Info("Executing the command")
 
-	hostname := c.createUniqueIdentifierForHostname()
+	output := runner.ConfigOutput{
+		Hostname:          c.createUniqueIdentifierForHostname(),
+		BuildsDir:         c.cfg.ConfigStage.BuildsDir,
+		CacheDir:          c.cfg.ConfigStage.CacheDir,
+		BuildsDirIsShared: c.cfg.ConfigStage.BuildsDirIsShared,
+		JobEnv:            configJobEnv(c.cfg),
+	}
 
-	err := runner.GetAdapter().WriteCustomExecutorConfig(c.output, hostname)
+	err := runner.GetAdapter().WriteCustomExecutorConfig(c.output, output)
 	if err != nil {
 		return &errWriteConfig{inner: err}
 	}
@@ -0,0 +1,153 @@
+package custom
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// exportRx matches the variables exported by the scripts generated by the
+// Runner, like "export KUBECONFIG=$'/builds/project.tmp/KUBECONFIG'"
+var exportRx = regexp.MustCompile(`^\s*export\s+([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// tmpDirSuffix names the directory where the Runner writes the files of the
+// file-type variables, next to the project directory
+const tmpDirSuffix = ".tmp"
+
+// fileVariablePaths returns the paths of the file-type variables exported by
+// the script. The Runner writes each file in the temporary directory of the
+// project, named like the variable, so the other exported paths are ignored
+func fileVariablePaths(script []byte) []string {
+	tmpDir, ok := fileVariablesDir(script)
+	if !ok {
+		return nil
+	}
+
+	var paths []string
+
+	for _, variable := range exportedVariables(script) {
+		if isFileVariablePath(tmpDir, variable.name, variable.value) {
+			paths = append(paths, variable.value)
+		}
+	}
+
+	return paths
+}
+
+// fileVariablesDir returns the temporary directory of the project exported
+// by the script
+func fileVariablesDir(script []byte) (string, bool) {
+	projectDir, ok := exportedVariable(script, "CI_PROJECT_DIR")
+	if !ok || !path.IsAbs(projectDir) {
+		return "", false
+	}
+
+	return path.Clean(projectDir) + tmpDirSuffix, true
+}
+
+// isFileVariablePath reports whether the value is the file of the variable,
+// in the temporary directory of the project
+func isFileVariablePath(tmpDir string, name string, value string) bool {
+	return value == path.Join(tmpDir, name)
+}
+
+// exportedVariable returns the last value of the variable exported by the
+// script
+func exportedVariable(script []byte, name string) (string, bool) {
+	value, found := "", false
+
+	for _, variable := range exportedVariables(script) {
+		if variable.name == name {
+			value, found = variable.value, true
+		}
+	}
+
+	return value, found
+}
+
+type shellVariable struct {
+	name  string
+	value string
+}
+
+// exportedVariables returns the variables exported by the script, in order.
+// The variables whose values use expansions are ignored
+func exportedVariables(script []byte) []shellVariable {
+	var variables []shellVariable
+
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	scanner.Buffer(nil, len(script)+1)
+
+	for scanner.Scan() {
+		match := exportRx.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		value, ok := unquoteShellWord(strings.TrimSpace(match[2]))
+		if ok {
+			variables = append(variables, shellVariable{name: match[1], value: value})
+		}
+	}
+
+	return variables
+}
+
+// unquoteShellWord returns the value of a single shell word, bare or quoted
+// with '...', "..." or $'...'. The words using expansions are not supported
+func unquoteShellWord(word string) (string, bool) {
+	switch {
+	case strings.HasPrefix(word, "$'"):
+		return unquoteANSIC(word[2:])
+	case strings.HasPrefix(word, "'"):
+		if len(word) < 2 || !strings.HasSuffix(word, "'") || strings.Contains(word[1:len(word)-1], "'") {
+			return "", false
+		}
+
+		return word[1 : len(word)-1], true
+	case strings.HasPrefix(word, `"`):
+		if len(word) < 2 || !strings.HasSuffix(word, `"`) || strings.ContainsAny(word[1:len(word)-1], "\"\\$`") {
+			return "", false
+		}
+
+		return word[1 : len(word)-1], true
+	}
+
+	if strings.ContainsAny(word, " \t'\"\\$`;&|<>()") {
+		return "", false
+	}
+
+	return word, true
+}
+
+// unquoteANSIC unquotes the rest of a $'...' word, after the opening quote
+func unquoteANSIC(rest string) (string, bool) {
+	var value strings.Builder
+
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] {
+		case '\'':
+			return value.String(), i == len(rest)-1
+		case '\\':
+			i++
+			if i == len(rest) {
+				return "", false
+			}
+
+			switch rest[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(rest[i])
+			}
+		default:
+			value.WriteByte(rest[i])
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,77 @@
+package custom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileVariablePaths(t *testing.T) {
+	tests := map[string]struct {
+		script        string
+		expectedPaths []string
+	}{
+		"File variables": {
+			script: `#!/usr/bin/env bash
+set -eo pipefail
+export CI_PROJECT_DIR=/builds/group/project
+export CI_SERVER_TLS_CA_FILE="/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE"
+export KUBECONFIG=$'/builds/group/project.tmp/KUBECONFIG'
+  export SSH_CONFIG='/builds/group/project.tmp/SSH_CONFIG'
+export RUNNER_CONFIG=/etc/gitlab-runner/config.toml
+export RENAMED=$'/builds/group/project.tmp/KUBECONFIG'
+export TRAVERSAL=$'/builds/group/project.tmp/../other.tmp/TRAVERSAL'
+export NESTED=$'/builds/group/project.tmp/dir/NESTED'
+export CI_JOB_NAME=build
+export EXPANDED="$CI_PROJECT_DIR.tmp/EXPANDED"
+echo "export NOT_EXPORTED=/builds/group/project.tmp/NOT_EXPORTED"
+`,
+			expectedPaths: []string{
+				"/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE",
+				"/builds/group/project.tmp/KUBECONFIG",
+				"/builds/group/project.tmp/SSH_CONFIG",
+			},
+		},
+		"Project directory not exported": {
+			script: `export KUBECONFIG=$'/builds/group/project.tmp/KUBECONFIG'
+`,
+		},
+		"Relative project directory": {
+			script: `export CI_PROJECT_DIR=project
+export KUBECONFIG=$'project.tmp/KUBECONFIG'
+`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expectedPaths, fileVariablePaths([]byte(tt.script)))
+		})
+	}
+}
+
+func TestExportedVariables(t *testing.T) {
+	script := `#!/usr/bin/env bash
+set -eo pipefail
+export CI_PROJECT_DIR=/builds/group/project
+export CI_SERVER_TLS_CA_FILE="/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE"
+export KUBECONFIG=$'/builds/group/project.tmp/KUBECONFIG'
+  export SSH_CONFIG='/tmp/ssh config'
+export ESCAPED=$'/tmp/it\'s'
+export CI_JOB_NAME=build
+export EXPANDED="$HOME/file"
+export UNTERMINATED=$'/tmp/file
+echo "export NOT_EXPORTED=/tmp/file"
+`
+
+	expected := []shellVariable{
+		{name: "CI_PROJECT_DIR", value: "/builds/group/project"},
+		{name: "CI_SERVER_TLS_CA_FILE", value: "/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE"},
+		{name: "KUBECONFIG", value: "/builds/group/project.tmp/KUBECONFIG"},
+		{name: "SSH_CONFIG", value: "/tmp/ssh config"},
+		{name: "ESCAPED", value: "/tmp/it's"},
+		{name: "CI_JOB_NAME", value: "build"},
+	}
+
+	assert.Equal(t, expected, exportedVariables([]byte(script)))
+}
@@ -0,0 +1,188 @@
+package custom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+// defaultHelperPath is in the PATH of most images, so the scripts of the
+// Runner find the helper
+const defaultHelperPath = "/usr/local/bin/gitlab-runner"
+
+var (
+	// ErrHelperChecksumMismatch is returned when the helper written in the
+	// container doesn't match the binary of the cache
+	ErrHelperChecksumMismatch = errors.New("checksum of the uploaded helper doesn't match")
+
+	// ErrUnsupportedArchitecture is returned when no helper binary is built
+	// for the architecture of the container
+	ErrUnsupportedArchitecture = errors.New("unsupported architecture")
+
+	errUnknownRunnerVersion = errors.New("version of the Runner is unknown")
+	errInvalidRunnerVersion = errors.New("invalid version of the Runner")
+)
+
+// runnerVersionRx matches the versions of the Runner naming the directories
+// of the cache, like "13.1.0" or "v13.2.0-rc1"
+var runnerVersionRx = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+
+// machineArchitectures maps the machines reported by uname to the
+// architectures named like Go does, as the helper binaries, the
+// CI_RUNNER_EXECUTABLE_ARCH variable of the Runner and FARGATE_ARCH
+var machineArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"s390x":   "s390x",
+	"ppc64le": "ppc64le",
+}
+
+// provisionHelper uploads the gitlab-runner binary matching the version of
+// the Runner and the architecture of the container, unless the container
+// already has the same binary. The binary is written to a temporary file
+// renamed once its checksum is verified, so an interrupted upload never leaves
+// a broken helper
+func (c *PrepareCommand) provisionHelper(ctx *cli.Context, taskDetails task.Data) error {
+	if c.cfg.Helper.CacheDir == "" {
+		return nil
+	}
+
+	file := c.cfg.Helper.Path
+	if file == "" {
+		file = defaultHelperPath
+	}
+
+	logger := c.logger.WithField("file", file)
+	settings := newConnectionSettings(taskDetails, c.cfg.SSH)
+
+	output, err := c.executor.Output(ctx.Ctx, settings, helperProbeScript(file))
+	if err != nil {
+		return fmt.Errorf("probing the helper: %w", err)
+	}
+
+	probe := parseHelperProbe(output)
+
+	binary, err := c.helperBinary(probe.machine)
+	if err != nil {
+		return err
+	}
+
+	content, err := c.fs.ReadFile(binary)
+	if err != nil {
+		return fmt.Errorf("reading helper %q: %w", binary, err)
+	}
+
+	checksum := sha256.Sum256(content)
+
+	if probe.helper != "" {
+		helperLogger := logger.WithField("helper", probe.helper)
+
+		if probe.checksum == hex.EncodeToString(checksum[:]) {
+			helperLogger.Info("Helper already present in the task container")
+			return nil
+		}
+
+		helperLogger.Warning("Helper of the task container doesn't match the version of the Runner, replacing it")
+	}
+
+	logger.
+		WithField("binary", binary).
+		Info("Uploading the helper to the task container")
+
+	tmpFile := file + ".tmp"
+
+	err = c.executor.Upload(ctx.Ctx, settings, tmpFile, content)
+	if err != nil {
+		return fmt.Errorf("uploading helper: %w", err)
+	}
+
+	output, err = c.executor.Output(ctx.Ctx, settings, helperInstallScript(tmpFile, file, hex.EncodeToString(checksum[:])))
+	if err != nil {
+		return fmt.Errorf("installing helper: %w", err)
+	}
+
+	if strings.TrimSpace(string(output)) != "ok" {
+		return fmt.Errorf("%w: %s", ErrHelperChecksumMismatch, binary)
+	}
+
+	logger.Info("Helper provisioned")
+
+	return nil
+}
+
+// helperBinary returns the binary of the cache for the machine of the container
+func (c *PrepareCommand) helperBinary(machine string) (string, error) {
+	arch, ok := machineArchitectures[machine]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedArchitecture, machine)
+	}
+
+	if c.job.RunnerVersion == "" {
+		return "", errUnknownRunnerVersion
+	}
+
+	// The version names a directory of the Runner host
+	if !runnerVersionRx.MatchString(c.job.RunnerVersion) {
+		return "", fmt.Errorf("%w: %q", errInvalidRunnerVersion, c.job.RunnerVersion)
+	}
+
+	return filepath.Join(c.cfg.Helper.CacheDir, c.job.RunnerVersion, "gitlab-runner-linux-"+arch), nil
+}
+
+// helperProbeScript prints the machine of the container, then the helper
+// found in the PATH or at file, and its checksum, if any
+func helperProbeScript(file string) []byte {
+	quoted := executors.ShellQuote(file)
+
+	return []byte(fmt.Sprintf(
+		"uname -m\n"+
+			"helper=$(command -v gitlab-runner || { [ -x %[1]s ] && echo %[1]s; } || true)\n"+
+			"echo \"$helper\"\n"+
+			"[ -z \"$helper\" ] || sha256sum \"$helper\" | cut -d ' ' -f 1\n",
+		quoted,
+	))
+}
+
+type helperProbe struct {
+	machine  string
+	helper   string
+	checksum string
+}
+
+func parseHelperProbe(output []byte) helperProbe {
+	var probe helperProbe
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := []*string{&probe.machine, &probe.helper, &probe.checksum}
+
+	for i := 0; i < len(lines) && i < len(fields); i++ {
+		*fields[i] = strings.TrimSpace(lines[i])
+	}
+
+	return probe
+}
+
+// helperInstallScript prints "ok" once the uploaded helper is verified and
+// renamed to file. A mismatching helper is removed
+func helperInstallScript(tmpFile string, file string, checksum string) []byte {
+	return []byte(fmt.Sprintf(
+		"chmod 700 %[1]s && if [ \"$(sha256sum %[1]s | cut -d ' ' -f 1)\" = %[3]s ]; then mv -f %[1]s %[2]s && echo ok; else rm -f %[1]s; fi\n",
+		executors.ShellQuote(tmpFile),
+		executors.ShellQuote(file),
+		executors.ShellQuote(checksum),
+	))
+}
@@ -0,0 +1,220 @@
+package custom
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestPrepareCommand_provisionHelper(t *testing.T) {
+	testError := errors.New("simulated error")
+	testTask := task.Data{TaskARN: "task-arn", ContainerIP: "1.2.3.4"}
+	testBinary := []byte("helper")
+	// sha256 of testBinary
+	testChecksum := "e81d3b0e9d82feaaf5f6e55bdff24731d7eee08632ffa63801e6397290c5d20a"
+
+	tests := map[string]struct {
+		cacheDir       string
+		path           string
+		runnerVersion  string
+		probeOutput    string
+		probeError     error
+		readFileError  error
+		uploadError    error
+		installOutput  string
+		installError   error
+		expectedBinary string
+		expectedFile   string
+		expectedError  error
+	}{
+		"Provisioning disabled": {},
+		"Helper already present": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n/usr/bin/gitlab-runner\n" + testChecksum + "\n",
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+		},
+		"Helper of another version present": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "v13.2.0-rc1",
+			probeOutput:    "x86_64\n/usr/bin/gitlab-runner\n0123abcd\n",
+			installOutput:  "ok\n",
+			expectedBinary: "/var/cache/helpers/v13.2.0-rc1/gitlab-runner-linux-amd64",
+			expectedFile:   defaultHelperPath,
+		},
+		"Helper provisioned": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "aarch64\n\n",
+			installOutput:  "ok\n",
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-arm64",
+			expectedFile:   defaultHelperPath,
+		},
+		"Helper provisioned to the configured path": {
+			cacheDir:       "/var/cache/helpers",
+			path:           "/home/ci/bin/gitlab-runner",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n",
+			installOutput:  "ok\n",
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+			expectedFile:   "/home/ci/bin/gitlab-runner",
+		},
+		"Error on probing the helper": {
+			cacheDir:      "/var/cache/helpers",
+			probeError:    testError,
+			expectedError: testError,
+		},
+		"Unsupported architecture": {
+			cacheDir:      "/var/cache/helpers",
+			runnerVersion: "13.1.0",
+			probeOutput:   "mips\n",
+			expectedError: ErrUnsupportedArchitecture,
+		},
+		"Unknown Runner version": {
+			cacheDir:      "/var/cache/helpers",
+			probeOutput:   "x86_64\n",
+			expectedError: errUnknownRunnerVersion,
+		},
+		"Invalid Runner version": {
+			cacheDir:      "/var/cache/helpers",
+			runnerVersion: "../../../etc",
+			probeOutput:   "x86_64\n",
+			expectedError: errInvalidRunnerVersion,
+		},
+		"Runner version with a path": {
+			cacheDir:      "/var/cache/helpers",
+			runnerVersion: "13.1.0-rc1/../../13.0.0",
+			probeOutput:   "x86_64\n",
+			expectedError: errInvalidRunnerVersion,
+		},
+		"Error on reading the binary": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n",
+			readFileError:  testError,
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+			expectedError:  testError,
+		},
+		"Error on uploading the binary": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n",
+			uploadError:    testError,
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+			expectedFile:   defaultHelperPath,
+			expectedError:  testError,
+		},
+		"Error on installing the binary": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n",
+			installError:   testError,
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+			expectedFile:   defaultHelperPath,
+			expectedError:  testError,
+		},
+		"Checksum mismatch": {
+			cacheDir:       "/var/cache/helpers",
+			runnerVersion:  "13.1.0",
+			probeOutput:    "x86_64\n",
+			expectedBinary: "/var/cache/helpers/13.1.0/gitlab-runner-linux-amd64",
+			expectedFile:   defaultHelperPath,
+			expectedError:  ErrHelperChecksumMismatch,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ctx := new(cli.Context)
+			ctx.Ctx = context.Background()
+
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			mockFS := new(fs.MockFS)
+			defer mockFS.AssertExpectations(t)
+
+			path := tt.path
+			if path == "" {
+				path = defaultHelperPath
+			}
+
+			if tt.cacheDir != "" {
+				mockExecutor.On("Output", ctx.Ctx, mock.Anything, helperProbeScript(path)).
+					Return([]byte(tt.probeOutput), tt.probeError).
+					Once()
+			}
+
+			if tt.expectedBinary != "" {
+				mockFS.On("ReadFile", tt.expectedBinary).
+					Return(testBinary, tt.readFileError).
+					Once()
+			}
+
+			if tt.expectedFile != "" {
+				mockExecutor.On("Upload", ctx.Ctx, mock.Anything, tt.expectedFile+".tmp", testBinary).
+					Return(tt.uploadError).
+					Once()
+
+				if tt.uploadError == nil {
+					mockExecutor.On("Output", ctx.Ctx, mock.Anything, helperInstallScript(tt.expectedFile+".tmp", tt.expectedFile, testChecksum)).
+						Return([]byte(tt.installOutput), tt.installError).
+						Once()
+				}
+			}
+
+			prepare := new(PrepareCommand)
+			prepare.cfg = config.Global{Helper: config.Helper{CacheDir: tt.cacheDir, Path: tt.path}}
+			prepare.job = runner.JobContext{RunnerVersion: tt.runnerVersion}
+			prepare.logger = createTestLogger()
+			prepare.executor = mockExecutor
+			prepare.fs = mockFS
+
+			err := prepare.provisionHelper(ctx, testTask)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseHelperProbe(t *testing.T) {
+	tests := map[string]struct {
+		output        string
+		expectedProbe helperProbe
+	}{
+		"Helper missing": {
+			output:        "x86_64\n\n",
+			expectedProbe: helperProbe{machine: "x86_64"},
+		},
+		"Helper present": {
+			output: "aarch64\n/usr/local/bin/gitlab-runner\n0123abcd\n",
+			expectedProbe: helperProbe{
+				machine:  "aarch64",
+				helper:   "/usr/local/bin/gitlab-runner",
+				checksum: "0123abcd",
+			},
+		},
+		"No output": {},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			assert.Equal(t, tt.expectedProbe, parseHelperProbe([]byte(tt.output)))
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package custom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+// defaultHostShell executes the scripts of the host stages
+const defaultHostShell = "bash"
+
+// jobDirPrefix starts the name of the directories created for the jobs in
+// the builds directory of the host
+const jobDirPrefix = "fargate-job-"
+
+// hostProjectDirName is the project directory in the job directory of the
+// host
+const hostProjectDirName = "project"
+
+// hostPath is the only PATH of the scripts executed on the host, whose
+// environment is cleared
+const hostPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// unsafeHostVariables change how the shell and the programs it starts are
+// loaded, so the jobs can't export them on the host
+var unsafeHostVariables = map[string]bool{
+	"PATH":          true,
+	"ENV":           true,
+	"BASH_ENV":      true,
+	"BASHOPTS":      true,
+	"SHELLOPTS":     true,
+	"CDPATH":        true,
+	"GLOBIGNORE":    true,
+	"IFS":           true,
+	"PS4":           true,
+	"GCONV_PATH":    true,
+	"HOSTALIASES":   true,
+	"NLSPATH":       true,
+	"LOCALDOMAIN":   true,
+	"RES_OPTIONS":   true,
+	"MALLOC_CHECK_": true,
+}
+
+// unsafeHostVariablePrefixes start the names of the variables of the dynamic
+// loaders
+var unsafeHostVariablePrefixes = []string{"LD_", "DYLD_"}
+
+// ErrInvalidProjectDir is returned when the script of a host stage doesn't
+// export a project directory that can be moved to the host
+var ErrInvalidProjectDir = errors.New("invalid project directory")
+
+// isHostStage reports whether the stage is executed on the Runner host. The
+// executor of the [Stages.<name>] section takes precedence over HostStages
+func (c *RunCommand) isHostStage(stage string) bool {
+	if _, ok := config.HostStageTransfer(stage); !ok {
+		return false
+	}
+
+	switch c.cfg.Stage(stage).Executor {
+	case config.StageExecutorHost:
+		return true
+	case config.StageExecutorContainer:
+		return false
+	}
+
+	return c.cfg.HostStages.Enabled
+}
+
+// executeOnHost executes the script of the stage on the Runner host. The
+// project directory is moved between the task container and a directory
+// created for the job below the builds directory of the host, which is
+// removed once the stage is done
+func (c *RunCommand) executeOnHost(ctx context.Context, taskData task.Data, stage string, scriptPath string, script []byte) error {
+	containerDir, err := containerProjectDir(script)
+	if err != nil {
+		return err
+	}
+
+	root := hostBuildsDir(c.cfg.HostStages)
+
+	jobDir, err := c.createJobDir(root)
+	if err != nil {
+		return err
+	}
+
+	logger := c.logger.WithField("jobDir", jobDir)
+	logger.Info("Executing the stage on the Runner host")
+
+	defer func() {
+		removeErr := c.removeJobDir(root, jobDir)
+		if removeErr != nil {
+			logger.WithError(removeErr).Warning("Couldn't remove the job directory from the Runner host")
+		}
+	}()
+
+	projectDir := filepath.Join(jobDir, hostProjectDirName)
+
+	err = c.fs.MkdirAll(projectDir, 0700)
+	if err != nil {
+		return fmt.Errorf("creating directory %q: %w", projectDir, err)
+	}
+
+	// The script of the Runner refers to the project directory of the
+	// container, which is moved to the job directory of the host
+	hostScriptPath := filepath.Join(jobDir, filepath.Base(scriptPath))
+	hostScript := bytes.ReplaceAll(withoutUnsafeExports(script), []byte(containerDir), []byte(projectDir))
+
+	err = c.fs.WriteFile(hostScriptPath, hostScript, 0700)
+	if err != nil {
+		return fmt.Errorf("writing script %q: %w", hostScriptPath, err)
+	}
+
+	settings := newConnectionSettings(taskData, c.cfg.SSH)
+	transfer, _ := config.HostStageTransfer(stage)
+
+	if transfer == config.TransferFromContainer {
+		err = c.copyFromContainer(ctx, settings, containerDir, projectDir)
+		if err != nil {
+			return fmt.Errorf("copying the project directory from the task container: %w", err)
+		}
+	}
+
+	// The environment of the driver, like its AWS credentials, is not given
+	// to the script
+	args := []string{"-i", "PATH=" + hostPath, "HOME=" + jobDir}
+	args = append(args, hostInterpreter(c.cfg.Stage(stage), c.cfg.HostStages)...)
+	args = append(args, hostScriptPath)
+
+	err = c.runLocal(ctx, nil, os.Stdout, "env", args...)
+	if err != nil {
+		return fmt.Errorf("executing script on the Runner host: %w", err)
+	}
+
+	if transfer == config.TransferToContainer {
+		err = c.copyToContainer(ctx, settings, projectDir, containerDir)
+		if err != nil {
+			return fmt.Errorf("copying the project directory to the task container: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// withoutUnsafeExports removes the exports of the unsafe variables from the
+// script of the Runner, which exports the variables of the job
+func withoutUnsafeExports(script []byte) []byte {
+	buf := new(bytes.Buffer)
+
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	scanner.Buffer(nil, len(script)+1)
+
+	for scanner.Scan() {
+		match := exportRx.FindSubmatch(scanner.Bytes())
+		if match != nil && isUnsafeHostVariable(string(match[1])) {
+			continue
+		}
+
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+func isUnsafeHostVariable(name string) bool {
+	if unsafeHostVariables[name] {
+		return true
+	}
+
+	for _, prefix := range unsafeHostVariablePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containerProjectDir returns the project directory of the container, as
+// exported by the script
+func containerProjectDir(script []byte) (string, error) {
+	dir, ok := exportedVariable(script, "CI_PROJECT_DIR")
+	if !ok {
+		return "", fmt.Errorf("%w: CI_PROJECT_DIR is not exported by the script", ErrInvalidProjectDir)
+	}
+
+	dir = path.Clean(dir)
+	if !path.IsAbs(dir) || strings.Count(dir, "/") < 2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidProjectDir, dir)
+	}
+
+	return dir, nil
+}
+
+// hostBuildsDir returns the directory of the host the job directories are
+// created in
+func hostBuildsDir(hostStages config.HostStages) string {
+	if hostStages.BuildsDir != "" {
+		return filepath.Clean(hostStages.BuildsDir)
+	}
+
+	return os.TempDir()
+}
+
+// createJobDir creates a new directory for the job below the builds
+// directory, only accessible to the user of the Runner
+func (c *RunCommand) createJobDir(root string) (string, error) {
+	err := c.fs.MkdirAll(root, 0700)
+	if err != nil {
+		return "", fmt.Errorf("creating directory %q: %w", root, err)
+	}
+
+	dir, err := c.fs.TempDir(root, fmt.Sprintf("%s%d-", jobDirPrefix, c.job.JobID))
+	if err != nil {
+		return "", fmt.Errorf("creating job directory in %q: %w", root, err)
+	}
+
+	return dir, nil
+}
+
+// removeJobDir removes the job directory, which must be one created by the
+// driver directly below the builds directory
+func (c *RunCommand) removeJobDir(root string, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel != filepath.Base(dir) || !strings.HasPrefix(rel, jobDirPrefix) {
+		return fmt.Errorf("%w: %q is not a job directory of %q", ErrInvalidProjectDir, dir, root)
+	}
+
+	return c.fs.RemoveAll(dir)
+}
+
+// copyFromContainer streams the directory of the container, as a tar archive,
+// to the directory of the host. The members of the archive are checked before
+// being extracted, and keep neither their owners nor the permissions of the
+// existing directories
+func (c *RunCommand) copyFromContainer(ctx context.Context, settings executors.ConnectionSettings, containerDir string, hostDir string) error {
+	reader, writer := io.Pipe()
+	script := fmt.Sprintf("tar -C %s -cf - .\n", executors.ShellQuote(containerDir))
+
+	streamErr := make(chan error, 1)
+	go func() {
+		err := c.sshExecutor.Stream(ctx, settings, []byte(script), nil, writer)
+		_ = writer.CloseWithError(err)
+		streamErr <- err
+	}()
+
+	checkedReader, checkedWriter := io.Pipe()
+
+	checkErr := make(chan error, 1)
+	go func() {
+		err := checkArchive(reader, checkedWriter)
+		_ = checkedWriter.CloseWithError(err)
+		// Unblocks the stream when the archive is rejected
+		_ = reader.CloseWithError(err)
+		checkErr <- err
+	}()
+
+	err := c.runLocal(ctx, checkedReader, nil, "tar", "-C", hostDir, "--no-same-owner", "--no-overwrite-dir", "-xf", "-")
+	// Unblocks the check when the local tar exited early
+	_ = checkedReader.Close()
+
+	archiveErr := <-checkErr
+	remoteErr := <-streamErr
+
+	if archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return fmt.Errorf("checking the archive of the container: %w", archiveErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("extracting the archive on the host: %w", err)
+	}
+
+	if remoteErr != nil {
+		return fmt.Errorf("archiving the directory in the container: %w", remoteErr)
+	}
+
+	return nil
+}
+
+// copyToContainer streams the directory of the host, as a tar archive, to the
+// directory of the container, where it's merged with the existing files
+func (c *RunCommand) copyToContainer(ctx context.Context, settings executors.ConnectionSettings, hostDir string, containerDir string) error {
+	reader, writer := io.Pipe()
+	quoted := executors.ShellQuote(containerDir)
+	script := fmt.Sprintf("mkdir -p %s && tar -C %s -xf -\n", quoted, quoted)
+
+	localErr := make(chan error, 1)
+	go func() {
+		err := c.runLocal(ctx, nil, writer, "tar", "-C", hostDir, "-cf", "-", ".")
+		_ = writer.CloseWithError(err)
+		localErr <- err
+	}()
+
+	err := c.sshExecutor.Stream(ctx, settings, []byte(script), reader, nil)
+	// Unblocks the local tar when the stream exited early
+	_ = reader.Close()
+
+	archiveErr := <-localErr
+	if err != nil {
+		return fmt.Errorf("extracting the archive in the container: %w", err)
+	}
+
+	if archiveErr != nil {
+		return fmt.Errorf("archiving the directory on the host: %w", archiveErr)
+	}
+
+	return nil
+}
+
+// runLocalCommand runs the command on the Runner host. Its standard error is
+// the one of the driver, shown in the job log
+func runLocalCommand(ctx context.Context, stdin io.Reader, stdout io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
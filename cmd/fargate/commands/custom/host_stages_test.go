@@ -0,0 +1,418 @@
+package custom
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestRunCommand_isHostStage(t *testing.T) {
+	run := new(RunCommand)
+	assert.False(t, run.isHostStage(runner.StageUploadArtifactsOnSuccess))
+
+	run.cfg.HostStages.Enabled = true
+	assert.True(t, run.isHostStage(runner.StageUploadArtifactsOnSuccess))
+	assert.True(t, run.isHostStage(runner.StageRestoreCache))
+	assert.False(t, run.isHostStage(runner.StageBuildScript))
+
+	run.cfg.Stages = map[string]config.Stage{
+		runner.StageRestoreCache: {Executor: config.StageExecutorContainer},
+		runner.StageBuildScript:  {Executor: config.StageExecutorHost},
+		runner.StageArchiveCache: {Executor: config.StageExecutorHost},
+		runner.StageGetSources:   {Retries: 1},
+	}
+	assert.False(t, run.isHostStage(runner.StageRestoreCache))
+	assert.False(t, run.isHostStage(runner.StageBuildScript))
+	assert.True(t, run.isHostStage(runner.StageUploadArtifactsOnSuccess))
+
+	run.cfg.HostStages.Enabled = false
+	assert.True(t, run.isHostStage(runner.StageArchiveCache))
+	assert.False(t, run.isHostStage(runner.StageUploadArtifactsOnSuccess))
+}
+
+func TestRunCommand_isHostStage_HostCapableStages(t *testing.T) {
+	stages := config.HostCapableStages()
+	assert.ElementsMatch(t, []string{
+		runner.StageArchiveCache,
+		runner.StageArchiveCacheOnFailure,
+		runner.StageUploadArtifactsOnSuccess,
+		runner.StageUploadArtifactsOnFailure,
+		runner.StageRestoreCache,
+		runner.StageDownloadArtifacts,
+	}, stages)
+
+	run := new(RunCommand)
+	run.cfg.HostStages.Enabled = true
+
+	for _, stage := range stages {
+		assert.True(t, run.isHostStage(stage), "stage: %s", stage)
+	}
+}
+
+// localCommands fakes the commands of the Runner host, recording them and
+// the archives they receive
+type localCommands struct {
+	mu       sync.Mutex
+	commands []string
+	received string
+	errors   map[string]error
+}
+
+func (l *localCommands) run(ctx context.Context, stdin io.Reader, stdout io.Writer, name string, args ...string) error {
+	command := strings.Join(append([]string{name}, args...), " ")
+
+	l.mu.Lock()
+	l.commands = append(l.commands, command)
+	err := l.errors[name]
+	l.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if stdin != nil {
+		data, _ := ioutil.ReadAll(stdin)
+
+		l.mu.Lock()
+		l.received = string(data)
+		l.mu.Unlock()
+	}
+
+	if stdout != nil && name == "tar" {
+		_, _ = stdout.Write([]byte("host archive"))
+	}
+
+	return nil
+}
+
+// streamExecutor fakes the streams of the executor, as the mocks format
+// their arguments, which races with the pipes being written
+type streamExecutor struct {
+	executors.MockExecutor
+
+	script []byte
+	stream func(script []byte, stdin io.Reader, stdout io.Writer) error
+}
+
+func (s *streamExecutor) Stream(_ context.Context, _ executors.ConnectionSettings, script []byte, stdin io.Reader, stdout io.Writer) error {
+	s.script = script
+
+	return s.stream(script, stdin, stdout)
+}
+
+// testArchive builds a tar archive of the headers, with the name of each
+// regular file as content
+func testArchive(t *testing.T, headers ...*tar.Header) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	for _, header := range headers {
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = []byte(header.Name)
+			header.Size = int64(len(content))
+		}
+
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+// archiveMembers lists the names of the members of the archive
+func archiveMembers(t *testing.T, archive string) []string {
+	var names []string
+
+	tr := tar.NewReader(strings.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+}
+
+func TestRunCommand_executeOnHost(t *testing.T) {
+	testError := errors.New("simulated error")
+	testTask := task.Data{TaskARN: "task-arn", ContainerIP: "1.2.3.4"}
+	testScriptPath := "/tmp/custom-executor123/script456/script."
+	testDir := "/builds/abcd1234/0/group/project"
+	testScript := []byte("export CI_PROJECT_DIR=$'" + testDir + "'\nexport LD_PRELOAD=$'" + testDir + "/x.so'\ncd $'" + testDir + "'\n")
+	testRoot := "/var/lib/host-stages"
+	testJobDir := testRoot + "/fargate-job-34-123"
+	testProjectDir := testJobDir + "/project"
+	testHostScriptPath := testJobDir + "/script."
+	testHostScript := []byte("export CI_PROJECT_DIR=$'" + testProjectDir + "'\ncd $'" + testProjectDir + "'\n")
+	testEnv := "env -i PATH=" + hostPath + " HOME=" + testJobDir + " "
+
+	safeArchive := testArchive(t,
+		&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./out/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./out/report.xml", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "./latest", Typeflag: tar.TypeSymlink, Linkname: "out/report.xml"},
+	)
+	unsafeArchive := testArchive(t,
+		&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	)
+
+	tests := map[string]struct {
+		stage            string
+		script           []byte
+		shell            string
+		containerArchive []byte
+		tempDirError     error
+		writeError       error
+		streamError      error
+		localErrors      map[string]error
+		expectedCommands []string
+		expectedStream   string
+		expectedMembers  []string
+		expectedReceived string
+		expectedRemove   bool
+		expectedError    error
+	}{
+		"Artifacts uploaded from the host": {
+			stage:            runner.StageUploadArtifactsOnSuccess,
+			containerArchive: safeArchive,
+			expectedCommands: []string{
+				"tar -C " + testProjectDir + " --no-same-owner --no-overwrite-dir -xf -",
+				testEnv + "bash " + testHostScriptPath,
+			},
+			expectedStream:  "tar -C '" + testDir + "' -cf - .\n",
+			expectedMembers: []string{"./", "./out/", "./out/report.xml", "./latest"},
+			expectedRemove:  true,
+		},
+		"Cache restored from the host with a custom shell": {
+			stage: runner.StageRestoreCache,
+			shell: "/bin/sh",
+			expectedCommands: []string{
+				testEnv + "/bin/sh " + testHostScriptPath,
+				"tar -C " + testProjectDir + " -cf - .",
+			},
+			expectedStream:   "mkdir -p '" + testDir + "' && tar -C '" + testDir + "' -xf -\n",
+			expectedReceived: "host archive",
+			expectedRemove:   true,
+		},
+		"Project directory not exported": {
+			stage:         runner.StageArchiveCache,
+			script:        []byte("echo 1\n"),
+			expectedError: ErrInvalidProjectDir,
+		},
+		"Project directory at the root": {
+			stage:         runner.StageArchiveCache,
+			script:        []byte("export CI_PROJECT_DIR=/builds\n"),
+			expectedError: ErrInvalidProjectDir,
+		},
+		"Error on creating the job directory": {
+			stage:         runner.StageArchiveCache,
+			tempDirError:  testError,
+			expectedError: testError,
+		},
+		"Error on writing the script": {
+			stage:          runner.StageArchiveCache,
+			writeError:     testError,
+			expectedRemove: true,
+			expectedError:  testError,
+		},
+		"Unsafe archive of the container": {
+			stage:            runner.StageArchiveCache,
+			containerArchive: unsafeArchive,
+			expectedCommands: []string{
+				"tar -C " + testProjectDir + " --no-same-owner --no-overwrite-dir -xf -",
+			},
+			expectedStream: "tar -C '" + testDir + "' -cf - .\n",
+			expectedRemove: true,
+			expectedError:  ErrUnsafeArchive,
+		},
+		"Error on archiving in the container": {
+			stage:       runner.StageArchiveCache,
+			streamError: testError,
+			expectedCommands: []string{
+				"tar -C " + testProjectDir + " --no-same-owner --no-overwrite-dir -xf -",
+			},
+			expectedStream: "tar -C '" + testDir + "' -cf - .\n",
+			expectedRemove: true,
+			expectedError:  testError,
+		},
+		"Error on executing the script": {
+			stage:       runner.StageDownloadArtifacts,
+			localErrors: map[string]error{"env": testError},
+			expectedCommands: []string{
+				testEnv + "bash " + testHostScriptPath,
+			},
+			expectedRemove: true,
+			expectedError:  testError,
+		},
+		"Error on extracting in the container": {
+			stage:       runner.StageDownloadArtifacts,
+			streamError: testError,
+			expectedCommands: []string{
+				testEnv + "bash " + testHostScriptPath,
+				"tar -C " + testProjectDir + " -cf - .",
+			},
+			expectedStream: "mkdir -p '" + testDir + "' && tar -C '" + testDir + "' -xf -\n",
+			expectedRemove: true,
+			expectedError:  testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			script := tt.script
+			if script == nil {
+				script = testScript
+			}
+
+			mockFS := new(fs.MockFS)
+			mockFS.On("MkdirAll", testRoot, os.FileMode(0700)).Return(nil)
+			mockFS.On("TempDir", testRoot, "fargate-job-34-").Return(testJobDir, tt.tempDirError)
+			mockFS.On("MkdirAll", testProjectDir, os.FileMode(0700)).Return(nil)
+			mockFS.On("WriteFile", testHostScriptPath, testHostScript, os.FileMode(0700)).Return(tt.writeError)
+			mockFS.On("RemoveAll", testJobDir).Return(nil)
+
+			local := &localCommands{errors: tt.localErrors}
+
+			streamer := &streamExecutor{
+				stream: func(script []byte, stdin io.Reader, stdout io.Writer) error {
+					if stdin != nil {
+						data, _ := ioutil.ReadAll(stdin)
+						local.mu.Lock()
+						local.received = string(data)
+						local.mu.Unlock()
+					}
+
+					if stdout != nil && tt.streamError == nil {
+						_, err := stdout.Write(tt.containerArchive)
+						return err
+					}
+
+					return tt.streamError
+				},
+			}
+
+			run := new(RunCommand)
+			run.cfg = config.Global{HostStages: config.HostStages{Enabled: true, Shell: tt.shell, BuildsDir: testRoot + "/"}}
+			run.job = runner.JobContext{JobID: 34}
+			run.logger = test.NewNullLogger()
+			run.fs = mockFS
+			run.sshExecutor = streamer
+			run.runLocal = local.run
+
+			err := run.executeOnHost(context.Background(), testTask, tt.stage, testScriptPath, script)
+
+			assert.Equal(t, tt.expectedCommands, local.commands)
+			assert.Equal(t, tt.expectedStream, string(streamer.script))
+
+			if tt.expectedRemove {
+				mockFS.AssertCalled(t, "RemoveAll", testJobDir)
+			} else {
+				mockFS.AssertNotCalled(t, "RemoveAll", mock.Anything)
+			}
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			if tt.expectedMembers != nil {
+				assert.Equal(t, tt.expectedMembers, archiveMembers(t, local.received))
+			} else {
+				assert.Equal(t, tt.expectedReceived, local.received)
+			}
+		})
+	}
+}
+
+func TestRunCommand_removeJobDir(t *testing.T) {
+	tests := map[string]struct {
+		dir            string
+		expectedRemove bool
+	}{
+		"Job directory": {
+			dir:            "/var/lib/host-stages/fargate-job-34-123",
+			expectedRemove: true,
+		},
+		"Builds directory": {
+			dir: "/var/lib/host-stages",
+		},
+		"Directory out of the builds directory": {
+			dir: "/var/lib/fargate-job-34-123",
+		},
+		"Directory below a job directory": {
+			dir: "/var/lib/host-stages/fargate-job-34-123/project",
+		},
+		"Directory not created for a job": {
+			dir: "/var/lib/host-stages/cache",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockFS := new(fs.MockFS)
+			mockFS.On("RemoveAll", tt.dir).Return(nil)
+
+			run := new(RunCommand)
+			run.fs = mockFS
+
+			err := run.removeJobDir("/var/lib/host-stages", tt.dir)
+
+			if tt.expectedRemove {
+				assert.NoError(t, err)
+				mockFS.AssertCalled(t, "RemoveAll", tt.dir)
+				return
+			}
+
+			assertions.ErrorIs(t, err, ErrInvalidProjectDir)
+			mockFS.AssertNotCalled(t, "RemoveAll", tt.dir)
+		})
+	}
+}
+
+func TestWithoutUnsafeExports(t *testing.T) {
+	script := strings.Join([]string{
+		"#!/usr/bin/env bash",
+		"export CI_JOB_ID=34",
+		"export LD_PRELOAD=$'/builds/project/x.so'",
+		"  export PATH=/builds/project/bin",
+		"export BASH_ENV=/builds/project/env",
+		"export ENV=/builds/project/env",
+		"export LD_LIBRARY_PATH=/builds/project",
+		"export MY_PATH=/builds/project",
+		"echo $PATH",
+		"",
+	}, "\n")
+
+	assert.Equal(
+		t,
+		"#!/usr/bin/env bash\nexport CI_JOB_ID=34\nexport MY_PATH=/builds/project\necho $PATH\n",
+		string(withoutUnsafeExports([]byte(script))),
+	)
+}
@@ -0,0 +1,89 @@
+package custom
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+// Variables describing the task of the job, set by the driver once known
+const (
+	taskARNVariable = "FARGATE_TASK_ARN"
+	archVariable    = "FARGATE_ARCH"
+	clusterVariable = "FARGATE_CLUSTER"
+)
+
+// configJobEnv returns the variables given by the Runner to the next stages
+// of the driver. Only the cluster is known by the "config" stage, and it
+// takes precedence over the configured variables
+func configJobEnv(cfg config.Global) map[string]string {
+	env := make(map[string]string, len(cfg.ConfigStage.JobEnv)+1)
+	for name, value := range cfg.ConfigStage.JobEnv {
+		env[name] = value
+	}
+
+	env[clusterVariable] = cfg.Fargate.Cluster
+
+	return env
+}
+
+// withTaskVariables prepends the exports of the variables describing the
+// task to the script
+func withTaskVariables(taskData task.Data, cluster string, script []byte) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "export %s=%s\n", taskARNVariable, executors.ShellQuote(taskData.TaskARN))
+	fmt.Fprintf(buf, "export %s=%s\n", clusterVariable, executors.ShellQuote(cluster))
+	fmt.Fprintf(buf, "export %s=\"$(%s)\"\n", archVariable, architectureScript())
+	buf.Write(script)
+
+	return buf.Bytes()
+}
+
+// taskEnvironment returns the variables describing the task that are known by
+// the driver, set in the session environment of the interpreters of the stages
+func taskEnvironment(taskData task.Data, cluster string) map[string]string {
+	return map[string]string{
+		taskARNVariable: taskData.TaskARN,
+		clusterVariable: cluster,
+	}
+}
+
+// interpreterCommand returns the command line starting the interpreter of the
+// stage. It's run by the shell of the SSH service, which exports the
+// architecture and sources the secrets before replacing itself with the
+// interpreter, so the script piped to the interpreter is left untouched
+func interpreterCommand(secretsFile string, interpreter string) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "export %s=\"$(%s)\" && ", archVariable, architectureScript())
+	if secretsFile != "" {
+		fmt.Fprintf(buf, ". %s && ", executors.ShellQuote(secretsFile))
+	}
+	fmt.Fprintf(buf, "exec %s\n", interpreter)
+
+	return buf.Bytes()
+}
+
+// architectureScript prints the architecture of the container, named like the
+// Architecture of the templates, as "amd64" or "arm64". The unknown machines
+// are printed as reported by uname
+func architectureScript() string {
+	machines := make([]string, 0, len(machineArchitectures))
+	for machine := range machineArchitectures {
+		machines = append(machines, machine)
+	}
+	sort.Strings(machines)
+
+	buf := new(strings.Builder)
+	buf.WriteString(`m=$(uname -m); case "$m" in `)
+	for _, machine := range machines {
+		fmt.Fprintf(buf, "%s) echo %s ;; ", machine, machineArchitectures[machine])
+	}
+	buf.WriteString(`*) echo "$m" ;; esac`)
+
+	return buf.String()
+}
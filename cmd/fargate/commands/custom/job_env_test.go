@@ -0,0 +1,93 @@
+package custom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestConfigJobEnv(t *testing.T) {
+	cfg := config.Global{
+		Fargate: config.Fargate{Cluster: "cluster"},
+		ConfigStage: config.ConfigStage{
+			JobEnv: map[string]string{
+				"DEPLOY_ENV":      "staging",
+				"FARGATE_CLUSTER": "other",
+			},
+		},
+	}
+
+	assert.Equal(t, map[string]string{
+		"DEPLOY_ENV":      "staging",
+		"FARGATE_CLUSTER": "cluster",
+	}, configJobEnv(cfg))
+	assert.Equal(t, "other", cfg.ConfigStage.JobEnv["FARGATE_CLUSTER"], "The configuration should not be modified")
+}
+
+func TestWithTaskVariables(t *testing.T) {
+	script := withTaskVariables(task.Data{TaskARN: "task-arn"}, "cluster", []byte("echo 1\n"))
+
+	assert.Equal(
+		t,
+		"export FARGATE_TASK_ARN='task-arn'\nexport FARGATE_CLUSTER='cluster'\nexport FARGATE_ARCH=\"$("+architectureScript()+")\"\necho 1\n",
+		string(script),
+	)
+}
+
+func TestTaskEnvironment(t *testing.T) {
+	assert.Equal(
+		t,
+		map[string]string{"FARGATE_TASK_ARN": "task-arn", "FARGATE_CLUSTER": "cluster"},
+		taskEnvironment(task.Data{TaskARN: "task-arn"}, "cluster"),
+	)
+}
+
+func TestInterpreterCommand(t *testing.T) {
+	assert.Equal(
+		t,
+		"export FARGATE_ARCH=\"$("+architectureScript()+")\" && exec pwsh -Command -\n",
+		string(interpreterCommand("", "pwsh -Command -")),
+	)
+
+	dir, err := ioutil.TempDir("", "interpreter-command")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	secretsFile := filepath.Join(dir, "secrets.env")
+	err = ioutil.WriteFile(secretsFile, secretsEnvFile(map[string]string{"DB_PASS": "it's secret"}), 0600)
+	require.NoError(t, err)
+
+	output, err := exec.Command("sh", "-c", string(interpreterCommand(secretsFile, "env"))).Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "\nDB_PASS=it's secret\n")
+	assert.Regexp(t, "(^|\n)FARGATE_ARCH=.+\n", string(output))
+}
+
+func TestArchitectureScript(t *testing.T) {
+	tests := map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"arm64":   "arm64",
+		"armv7l":  "arm",
+		"riscv64": "riscv64",
+	}
+
+	for machine, expectedArch := range tests {
+		t.Run(machine, func(t *testing.T) {
+			script := fmt.Sprintf("uname() { echo %s; }\n%s", machine, architectureScript())
+
+			output, err := exec.Command("sh", "-c", script).Output()
+			require.NoError(t, err)
+			assert.Equal(t, expectedArch+"\n", string(output))
+		})
+	}
+}
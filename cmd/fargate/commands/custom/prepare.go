@@ -1,17 +1,70 @@
 package custom
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	sshExecutor "gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors/ssh"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/ssh"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
 )
 
-const defaultBitSize = 4096
+var (
+	// ErrUnknownPhase is returned when the recorded phase of the provisioning isn't known
+	ErrUnknownPhase = errors.New("unknown provisioning phase")
+
+	errUnhealthyTask = errors.New("task is not healthy")
+)
+
+const (
+	defaultBitSize = 4096
+
+	// defaultReadinessTimeout limits the time of waiting for the SSH service
+	// to accept connections once the task is running
+	defaultReadinessTimeout = 5 * time.Minute
+
+	// defaultReachabilityTimeout limits the time of connecting to the SSH
+	// service of a task started by a previous invocation
+	defaultReachabilityTimeout = 30 * time.Second
+
+	// stopTaskTimeout limits the time of stopping the task when the
+	// provisioning fails
+	stopTaskTimeout = 2 * time.Minute
+
+	// leaseMargin is added to the job timeout, so the lease of the SSH
+	// service doesn't expire before the Runner reaches the cleanup stage
+	leaseMargin = 10 * time.Minute
+
+	// jobTagKey is the tag identifying the job of the task, so the task can
+	// be found by the "cleanup" stage when the metadata is lost
+	jobTagKey = "gitlab-ci-job"
+
+	// defaultSecretsEnvFile is on a tmpfs, so the secrets delivered with
+	// the file method are never written to disk
+	defaultSecretsEnvFile = "/dev/shm/fargate-driver/secrets.env"
+
+	sshPublicKeyVariable         = "SSH_PUBLIC_KEY"
+	sshServiceTokenVar           = "SSH_SERVICE_TOKEN"
+	sshHostKeySeedVar            = "SSH_HOST_KEY_SEED"
+	sshServiceLeaseVar           = "SSH_SERVICE_LEASE"
+	sshServiceStartupDeadlineVar = "SSH_SERVICE_STARTUP_DEADLINE"
+)
 
 // NewPrepareCommand constructs the command line abstraction for the "prepare" stage
 func NewPrepareCommand() cli.Command {
@@ -19,8 +72,24 @@ func NewPrepareCommand() cli.Command {
 	cmd.abstractCustomCommand.customCommand = cmd
 
 	cmd.newFargate = aws.NewFargate
-	cmd.newMetadataManager = task.NewMetadataManager
+	cmd.newMetadataManager = task.NewMetadataManagerForConfig
+	cmd.newStopQueue = task.NewStopQueue
 	cmd.newKeyFactory = ssh.NewKeyFactory
+	cmd.newReadinessChecker = ssh.NewReadinessChecker
+	cmd.newServiceToken = ssh.NewServiceToken
+	cmd.newClientToken = func() (string, error) {
+		return aws.JobClientToken(task.JobKey()), nil
+	}
+	cmd.resolveSecrets = config.Global.ResolveSecrets
+	cmd.newExecutor = func(logger logging.Logger) executors.Executor {
+		return sshExecutor.NewExecutor(logger)
+	}
+	cmd.newFS = func() fs.FS {
+		return fs.NewOS()
+	}
+	cmd.output = os.Stderr
+	cmd.progressOutput = os.Stdout
+	cmd.now = time.Now
 
 	return cli.Command{
 		Handler: cmd,
@@ -45,19 +114,50 @@ type PrepareCommand struct {
 	abstractCustomCommand
 
 	cfg    config.Global
+	job    runner.JobContext
 	logger logging.Logger
 
 	awsFargate      aws.Fargate
 	metadataManager task.MetadataManager
-	keyFactory      ssh.KeyFactory
+	// stopQueue is nil when it couldn't be initialized
+	stopQueue        task.StopQueue
+	keyFactory       ssh.KeyFactory
+	readinessChecker ssh.ReadinessChecker
+	executor         executors.Executor
+	fs               fs.FS
+
+	// resumedPhase is the phase recorded by a previous invocation of the stage
+	resumedPhase task.Phase
+
+	// secrets are the values of the secrets of the job by variable. They
+	// are resolved by each invocation, as they are never recorded
+	secrets map[string]string
+
+	// output receives the messages shown to the user in the job log
+	output io.Writer
+
+	// progress reports the provisioning to progressOutput, also shown in
+	// the job log
+	progress       *progressReport
+	progressOutput io.Writer
+	now            func() time.Time
 
 	// Wrapping constructors to make easier mocking in the unit tests
-	newFargate         func(logger logging.Logger, awsRegion string) aws.Fargate
-	newMetadataManager func(logger logging.Logger, directory string) task.MetadataManager
-	newKeyFactory      func(logger logging.Logger) ssh.KeyFactory
+	newFargate          func(logger logging.Logger, awsRegion string) aws.Fargate
+	newMetadataManager  func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error)
+	newStopQueue        func(logger logging.Logger, directory string) (task.StopQueue, error)
+	newKeyFactory       func(logger logging.Logger) ssh.KeyFactory
+	newReadinessChecker func(logger logging.Logger) ssh.ReadinessChecker
+	newServiceToken     func() (string, error)
+	newClientToken      func() (string, error)
+	newExecutor         func(logger logging.Logger) executors.Executor
+	resolveSecrets      func(cfg config.Global, secrets []config.Secret) (map[string]string, error)
+	newFS               func() fs.FS
 }
 
-// CustomExecute is the "core" of the implementation for the "prepare" stage
+// CustomExecute is the "core" of the implementation for the "prepare" stage.
+// Each phase of the provisioning is persisted before moving to the next one,
+// so a re-invoked stage resumes from the last recorded phase
 func (c *PrepareCommand) CustomExecute(ctx *cli.Context) error {
 	err := c.init(ctx)
 	if err != nil {
@@ -66,37 +166,263 @@ func (c *PrepareCommand) CustomExecute(ctx *cli.Context) error {
 
 	c.logger.Info("Executing the command")
 
-	keyPair, err := c.keyFactory.Create(defaultBitSize)
+	// The stops that failed in the "cleanup" stage of other jobs are retried
+	// as soon as possible, instead of waiting for the next "cleanup" stage
+	retryQueuedStops(ctx.Ctx, c.logger, c.stopQueue, c.awsFargate)
+
+	err = c.authorize()
 	if err != nil {
-		return fmt.Errorf("generating public/private keys: %w", err)
+		return err
 	}
 
-	taskARN, err := c.startNewFargateTask(ctx, keyPair.PublicKey)
+	err = c.loadSecrets()
 	if err != nil {
-		c.stopFargateTaskOnError(ctx, taskARN, err, "Error when starting a new Fargate task. Will stop the task for cleanup")
-		return fmt.Errorf("starting new Fargate task: %w", err)
+		return err
 	}
 
-	// Persist Task ARN to be used by other commands (run / cleanup)
-	taskDetails := task.Data{TaskARN: taskARN, PrivateKey: keyPair.PrivateKey}
-	err = c.persistDataForLaterStages(taskDetails)
+	taskDetails, err := c.loadTaskDetails()
 	if err != nil {
-		c.stopFargateTaskOnError(ctx, taskARN, err, "Error when persisting the task ARN. Will stop the task for cleanup")
-		return fmt.Errorf("persisting task ARN for later stages: %w", err)
+		return fmt.Errorf("loading the provisioning state: %w", err)
 	}
 
-	containerIP, err := c.waitFargateTaskReady(ctx, taskARN)
+	taskDetails, err = c.verifyRecordedTask(ctx, taskDetails)
 	if err != nil {
-		c.stopFargateTaskOnError(ctx, taskARN, err, "Error when waiting for task initialization. Will stop the task for cleanup")
-		return fmt.Errorf("waiting Fargate task to be ready: %w", err)
+		return fmt.Errorf("verifying the task started by a previous invocation: %w", err)
+	}
+
+	for taskDetails.Phase != task.PhaseReachable {
+		err = c.advance(ctx, &taskDetails)
+		if err != nil {
+			c.rollbackOnError(taskDetails, err)
+			return err
+		}
+
+		err = c.persistDataForLaterStages(taskDetails)
+		if err != nil {
+			c.rollbackOnError(taskDetails, err)
+			return fmt.Errorf("persisting %q phase for later stages: %w", taskDetails.Phase, err)
+		}
 	}
 
-	// Update metadata with the container IP to be used by the "run" command
-	taskDetails.ContainerIP = containerIP
+	err = c.deliverSecrets(ctx, taskDetails)
+	if err != nil {
+		c.rollbackOnError(taskDetails, err)
+		return err
+	}
+
+	err = c.provisionHelper(ctx, taskDetails)
+	if err != nil {
+		c.rollbackOnError(taskDetails, err)
+		return fmt.Errorf("provisioning the helper: %w", err)
+	}
+
+	c.progress.ready()
+
+	return nil
+}
+
+// authorize checks the settings overridden by the job against the rules of
+// the configuration. A denied job fails as a build failure, explaining
+// what it isn't allowed to request in the job log
+func (c *PrepareCommand) authorize() error {
+	err := c.cfg.Authorize(c.job.AuthorizationJob())
+	if err == nil {
+		return nil
+	}
+
+	c.logger.WithError(err).Warn("Job not authorized")
+
+	_, _ = fmt.Fprintf(
+		c.output,
+		"ERROR: %v\nThe task settings requested with the FARGATE_* CI variables are not allowed for "+
+			"project %q on %q by the Runner configuration. Remove the variables, or ask the "+
+			"administrator of the Runner to allow them.\n",
+		err,
+		c.job.ProjectPath,
+		c.job.RefName,
+	)
+
+	return runner.NewBuildFailureError(err)
+}
+
+// loadSecrets resolves the secrets of the configuration and the ones
+// requested by the job. Their values are masked in the logs of the driver. A
+// job requesting secrets that are not allowed fails as a build failure
+func (c *PrepareCommand) loadSecrets() error {
+	secrets, err := c.cfg.JobSecrets(c.job.RequestedSecrets)
+	if errors.Is(err, config.ErrSecretNotAllowed) || errors.Is(err, config.ErrInvalidSecret) {
+		c.logger.WithError(err).Warn("Job secrets not allowed")
+
+		_, _ = fmt.Fprintf(
+			c.output,
+			"ERROR: %v\nThe secrets requested with the FARGATE_SECRETS CI variable are not allowed for "+
+				"project %q by the Runner configuration. Remove them, or ask the administrator of the "+
+				"Runner to allow them.\n",
+			err,
+			c.job.ProjectPath,
+		)
+
+		return runner.NewBuildFailureError(err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("loading the secrets of the job: %w", err)
+	}
+
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	values, err := c.resolveSecrets(c.cfg, secrets)
+	if err != nil {
+		return fmt.Errorf("resolving the secrets of the job: %w", err)
+	}
+
+	variables := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		variables = append(variables, secret.Variable)
+		c.logger.Mask(values[secret.Variable])
+	}
+
+	c.logger.
+		WithField("variables", variables).
+		Info("Secrets resolved")
+
+	c.secrets = values
+
+	return nil
+}
+
+// deliverSecrets writes the secrets to the env file of the container when
+// they are delivered with the file method. They are written by each
+// invocation, as a resumed provisioning doesn't know them
+func (c *PrepareCommand) deliverSecrets(ctx *cli.Context, taskDetails task.Data) error {
+	if len(c.secrets) == 0 || c.cfg.Secrets.Delivery == config.SecretsDeliveryEnvironment {
+		return nil
+	}
+
+	file := c.cfg.Secrets.EnvFile
+	if file == "" {
+		file = defaultSecretsEnvFile
+	}
+
+	c.logger.
+		WithField("file", file).
+		Info("Delivering secrets to the task container")
+
+	settings := newConnectionSettings(taskDetails, c.cfg.SSH)
+
+	err := c.executor.Upload(ctx.Ctx, settings, file, secretsEnvFile(c.secrets))
+	if err != nil {
+		return fmt.Errorf("delivering secrets: %w", err)
+	}
+
+	taskDetails.SecretsFile = file
+
 	err = c.persistDataForLaterStages(taskDetails)
 	if err != nil {
-		c.stopFargateTaskOnError(ctx, taskARN, err, "Error persisting container IP. Will stop the task for cleanup")
-		return fmt.Errorf("persisting container IP for later stages: %w", err)
+		return fmt.Errorf("recording the secrets file: %w", err)
+	}
+
+	return nil
+}
+
+// secretsEnvFile exports the variables, sorted by name, for the POSIX shells
+func secretsEnvFile(secrets map[string]string) []byte {
+	variables := make([]string, 0, len(secrets))
+	for variable := range secrets {
+		variables = append(variables, variable)
+	}
+	sort.Strings(variables)
+
+	buf := new(bytes.Buffer)
+	for _, variable := range variables {
+		fmt.Fprintf(buf, "export %s=%s\n", variable, executors.ShellQuote(secrets[variable]))
+	}
+
+	return buf.Bytes()
+}
+
+// loadTaskDetails returns the data recorded by a previous invocation of the
+// stage, or empty data when the provisioning wasn't started yet
+func (c *PrepareCommand) loadTaskDetails() (task.Data, error) {
+	taskDetails, err := c.metadataManager.Get()
+	if errors.Is(err, os.ErrNotExist) {
+		return task.Data{}, nil
+	}
+
+	if err != nil {
+		return taskDetails, fmt.Errorf("fetching metadata: %w", err)
+	}
+
+	c.resumedPhase = taskDetails.Phase
+	c.logger.
+		WithField("phase", taskDetails.Phase).
+		WithField("taskARN", taskDetails.TaskARN).
+		Info("Resuming the provisioning from the last recorded phase")
+
+	return taskDetails, nil
+}
+
+// advance executes the step following the recorded phase and updates the
+// phase of the taskDetails
+func (c *PrepareCommand) advance(ctx *cli.Context, taskDetails *task.Data) error {
+	switch taskDetails.Phase {
+	case "":
+		keyPair, err := c.keyFactory.Create(defaultBitSize)
+		if err != nil {
+			return fmt.Errorf("generating public/private keys: %w", err)
+		}
+
+		serviceToken, err := c.createServiceToken()
+		if err != nil {
+			return fmt.Errorf("generating SSH service token: %w", err)
+		}
+
+		taskDetails.PrivateKey = keyPair.PrivateKey
+		taskDetails.PublicKey = keyPair.PublicKey
+		taskDetails.ServiceToken = serviceToken
+		taskDetails.LeaseDuration = c.leaseDuration()
+		taskDetails.Phase = task.PhaseKeyGenerated
+
+	case task.PhaseKeyGenerated:
+		clientToken, err := c.newClientToken()
+		if err != nil {
+			return fmt.Errorf("generating client token: %w", err)
+		}
+
+		taskDetails.ClientToken = clientToken
+		taskDetails.Phase = task.PhaseLaunchRequested
+
+	case task.PhaseLaunchRequested:
+		taskARN, err := c.launchFargateTask(ctx, *taskDetails)
+		taskDetails.TaskARN = taskARN
+		if err != nil {
+			return fmt.Errorf("starting new Fargate task: %w", err)
+		}
+
+		taskDetails.Phase = task.PhaseLaunched
+
+	case task.PhaseLaunched:
+		containerIP, err := c.waitFargateTaskReady(ctx, taskDetails.TaskARN)
+		if err != nil {
+			return fmt.Errorf("waiting Fargate task to be ready: %w", err)
+		}
+
+		taskDetails.ContainerIP = containerIP
+		taskDetails.Phase = task.PhaseRunning
+
+	case task.PhaseRunning:
+		hostKeyFingerprint, err := c.waitSSHServiceReady(ctx, *taskDetails)
+		if err != nil {
+			return fmt.Errorf("waiting SSH service to be ready: %w", err)
+		}
+
+		taskDetails.HostKeyFingerprint = hostKeyFingerprint
+		taskDetails.Phase = task.PhaseReachable
+
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownPhase, taskDetails.Phase)
 	}
 
 	return nil
@@ -104,9 +430,11 @@ func (c *PrepareCommand) CustomExecute(ctx *cli.Context) error {
 
 func (c *PrepareCommand) init(ctx *cli.Context) error {
 	c.cfg = ctx.Config()
+	c.job = ctx.JobContext()
 	c.logger = ctx.
 		Logger().
 		WithField("command", "prepare_exec")
+	c.progress = newProgressReport(c.progressOutput, c.now)
 
 	c.awsFargate = c.newFargate(c.logger, c.cfg.Fargate.Region)
 	err := c.awsFargate.Init()
@@ -114,25 +442,126 @@ func (c *PrepareCommand) init(ctx *cli.Context) error {
 		return fmt.Errorf("initializing Fargate adapter: %w", err)
 	}
 
-	c.metadataManager = c.newMetadataManager(c.logger, c.cfg.TaskMetadata.Directory)
+	c.metadataManager, err = c.newMetadataManager(c.logger, c.cfg.TaskMetadata, c.cfg.Fargate.Region)
+	if err != nil {
+		return fmt.Errorf("initializing metadata manager: %w", err)
+	}
+
+	// The stage doesn't depend on the queue, so it's not failed by it
+	c.stopQueue, err = c.newStopQueue(c.logger, task.StopQueueDirectory(c.cfg.TaskMetadata))
+	if err != nil {
+		c.logger.WithError(err).Warning("Stop queue couldn't be initialized. Queued stops are not retried")
+	}
 
 	c.keyFactory = c.newKeyFactory(c.logger)
 
+	c.readinessChecker = c.newReadinessChecker(c.logger)
+
+	c.executor = c.newExecutor(c.logger)
+	c.fs = c.newFS()
+
 	return nil
 }
 
-func (c *PrepareCommand) startNewFargateTask(ctx *cli.Context, publicKey []byte) (string, error) {
+// createServiceToken returns an empty token when the readiness endpoint
+// of the SSH service is not used
+func (c *PrepareCommand) createServiceToken() (string, error) {
+	if c.cfg.SSH.ReadinessPort < 1 {
+		return "", nil
+	}
+
+	return c.newServiceToken()
+}
+
+// leaseDuration returns zero when the lease of the SSH service is disabled
+func (c *PrepareCommand) leaseDuration() time.Duration {
+	if !c.cfg.Lease.Enabled {
+		return 0
+	}
+
+	duration := runner.GetAdapter().JobTimeout()
+	if duration <= 0 {
+		duration = c.cfg.Lease.DefaultDuration.Duration
+	}
+
+	if duration <= 0 {
+		return 0
+	}
+
+	return duration + leaseMargin
+}
+
+// launchFargateTask adopts the task started by a previous invocation of the
+// stage, when RunTask was called but its result wasn't recorded
+func (c *PrepareCommand) launchFargateTask(ctx *cli.Context, taskDetails task.Data) (string, error) {
+	if c.resumedPhase == task.PhaseLaunchRequested {
+		taskARN, err := c.awsFargate.FindTask(ctx.Ctx, c.cfg.Fargate.Cluster, taskDetails.ClientToken)
+		if err != nil {
+			return "", fmt.Errorf("searching the task started by a previous invocation: %w", err)
+		}
+
+		if taskARN != "" {
+			c.logger.
+				WithField("taskARN", taskARN).
+				Info("Using the Fargate task started by a previous invocation")
+
+			return taskARN, nil
+		}
+	}
+
+	return c.startNewFargateTask(
+		ctx,
+		taskDetails.PublicKey,
+		taskDetails.PrivateKey,
+		taskDetails.ServiceToken,
+		taskDetails.LeaseDuration,
+		taskDetails.ClientToken,
+	)
+}
+
+func (c *PrepareCommand) startNewFargateTask(ctx *cli.Context, publicKey []byte, privateKey []byte, serviceToken string, leaseDuration time.Duration, clientToken string) (string, error) {
 	c.logger.Info("Starting new Fargate task")
+	c.progress.printf("Requesting a new AWS Fargate task on cluster %q\n", c.cfg.Fargate.Cluster)
 
 	taskSettings := aws.TaskSettings{
 		Cluster:         c.cfg.Fargate.Cluster,
 		TaskDefinition:  c.cfg.Fargate.TaskDefinition,
 		PlatformVersion: c.cfg.Fargate.PlatformVersion,
+		CPU:             c.cfg.Fargate.CPU,
+		Memory:          c.cfg.Fargate.Memory,
 		EnvironmentVariables: map[string]string{
-			"SSH_PUBLIC_KEY": string(publicKey),
+			sshPublicKeyVariable: string(publicKey),
+		},
+		ClientToken: clientToken,
+		Tags: map[string]string{
+			jobTagKey: task.JobKey(),
 		},
 	}
 
+	// The host key is only provided to the SSH service of the driver, which
+	// announces it through the readiness endpoint
+	if serviceToken != "" {
+		taskSettings.EnvironmentVariables[sshServiceTokenVar] = serviceToken
+		taskSettings.EnvironmentVariables[sshHostKeySeedVar] = base64.StdEncoding.EncodeToString(ssh.HostKeySeed(privateKey))
+	}
+
+	if leaseDuration > 0 {
+		taskSettings.EnvironmentVariables[sshServiceLeaseVar] = leaseDuration.String()
+	}
+
+	if c.cfg.Lease.Enabled && c.cfg.Lease.StartupDeadline.Duration > 0 {
+		taskSettings.EnvironmentVariables[sshServiceStartupDeadlineVar] = c.cfg.Lease.StartupDeadline.String()
+	}
+
+	// The secrets can't replace the variables of the SSH service
+	if c.cfg.Secrets.Delivery == config.SecretsDeliveryEnvironment {
+		for variable, value := range c.secrets {
+			if _, ok := taskSettings.EnvironmentVariables[variable]; !ok {
+				taskSettings.EnvironmentVariables[variable] = value
+			}
+		}
+	}
+
 	connection := aws.ConnectionSettings{
 		Subnet:         c.cfg.Fargate.Subnet,
 		SecurityGroup:  c.cfg.Fargate.SecurityGroup,
@@ -147,25 +576,139 @@ func (c *PrepareCommand) startNewFargateTask(ctx *cli.Context, publicKey []byte)
 	return taskARN, nil
 }
 
-func (c *PrepareCommand) stopFargateTaskOnError(ctx *cli.Context, taskARN string, err error, logMessage string) {
-	if taskARN == "" {
+// rollbackOnError rolls back the provisioning that failed with the cause
+func (c *PrepareCommand) rollbackOnError(taskDetails task.Data, cause error) {
+	if taskDetails.TaskARN == "" && taskDetails.ClientToken == "" {
 		return
 	}
 
-	logger := c.logger.WithField("taskARN", taskARN)
+	logger := c.logger.
+		WithField("phase", taskDetails.Phase).
+		WithField("taskARN", taskDetails.TaskARN)
+	logger.WithError(cause).
+		Error("Error during the provisioning. Will stop the task for cleanup")
+
+	_, err := c.rollback(taskDetails)
+	if err != nil {
+		logger.WithError(err).
+			Error("Error during rollback of the provisioning")
+	}
+}
+
+// rollback stops the task started for the job and records the phase of the
+// generated keys, so the provisioning starts a new task. It uses its own
+// context, as the one of the command is cancelled when the job is aborted.
+// When the task can't be stopped the record is left untouched, so the
+// "cleanup" stage can still find the task
+func (c *PrepareCommand) rollback(taskDetails task.Data) (task.Data, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTaskTimeout)
+	defer cancel()
+
+	taskARN := taskDetails.TaskARN
+	if taskARN == "" && taskDetails.ClientToken != "" {
+		var err error
+		taskARN, err = c.awsFargate.FindTask(ctx, c.cfg.Fargate.Cluster, taskDetails.ClientToken)
+		if err != nil {
+			return taskDetails, fmt.Errorf("searching the started task: %w", err)
+		}
+	}
+
+	if taskARN != "" {
+		reason := fmt.Sprintf("Provisioning for job %s was rolled back", runner.GetAdapter().JobURL())
+
+		err := c.awsFargate.StopTask(ctx, taskARN, c.cfg.Fargate.Cluster, reason)
+		if err != nil {
+			return taskDetails, fmt.Errorf("stopping task %q: %w", taskARN, err)
+		}
+	}
+
+	taskDetails.TaskARN = ""
+	taskDetails.ClientToken = ""
+	taskDetails.ContainerIP = ""
+	taskDetails.HostKeyFingerprint = ""
+	taskDetails.Phase = task.PhaseKeyGenerated
+
+	err := c.persistDataForLaterStages(taskDetails)
+	if err != nil {
+		return taskDetails, fmt.Errorf("recording the rolled back phase: %w", err)
+	}
+
+	return taskDetails, nil
+}
+
+// verifyRecordedTask reuses the task recorded by a previous invocation of
+// the stage, e.g. when the Runner retries it after a system failure, as long
+// as the task is healthy. Otherwise the task is stopped and the provisioning
+// restarts from the generated keys
+func (c *PrepareCommand) verifyRecordedTask(ctx *cli.Context, taskDetails task.Data) (task.Data, error) {
+	switch taskDetails.Phase {
+	case task.PhaseLaunched, task.PhaseRunning, task.PhaseReachable:
+	default:
+		return taskDetails, nil
+	}
+
+	logger := c.logger.
+		WithField("phase", taskDetails.Phase).
+		WithField("taskARN", taskDetails.TaskARN)
+
+	err := c.checkTaskHealth(ctx, taskDetails)
+	if err == nil {
+		logger.Info("Reusing the task started by a previous invocation")
+		c.progress.printf("Reusing the AWS Fargate task %s started by a previous attempt\n", taskDetails.TaskARN)
+
+		return taskDetails, nil
+	}
+
+	if !errors.Is(err, errUnhealthyTask) {
+		return taskDetails, err
+	}
+
 	logger.WithError(err).
-		Error(logMessage)
+		Warning("Task started by a previous invocation can't be reused. Will replace it with a new one")
+
+	return c.rollback(taskDetails)
+}
 
-	stopErr := c.awsFargate.StopTask(ctx.Ctx, taskARN, c.cfg.Fargate.Cluster)
-	if stopErr != nil {
-		logger.WithError(stopErr).
-			Error("Error during stop task")
+// checkTaskHealth returns an error wrapping errUnhealthyTask when the task
+// can't be reused. Other errors mean that the health couldn't be checked, so
+// a healthy task is not stopped because of a failing request
+func (c *PrepareCommand) checkTaskHealth(ctx *cli.Context, taskDetails task.Data) error {
+	status, err := c.awsFargate.GetTaskStatus(ctx.Ctx, taskDetails.TaskARN, c.cfg.Fargate.Cluster)
+	if errors.Is(err, aws.ErrTaskNotFound) {
+		return fmt.Errorf("%w: %v", errUnhealthyTask, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("fetching the task status: %w", err)
 	}
+
+	if aws.IsTaskStopping(status) {
+		return fmt.Errorf("%w: last status is %q", errUnhealthyTask, status)
+	}
+
+	if taskDetails.Phase != task.PhaseReachable {
+		return nil
+	}
+
+	if status != aws.TaskStatusRunning {
+		return fmt.Errorf("%w: last status is %q", errUnhealthyTask, status)
+	}
+
+	settings := newConnectionSettings(taskDetails, c.cfg.SSH)
+	settings.ConnectTimeout = defaultReachabilityTimeout
+
+	err = c.executor.CheckConnection(ctx.Ctx, settings)
+	if err != nil {
+		return fmt.Errorf("%w: checking SSH connection: %v", errUnhealthyTask, err)
+	}
+
+	return nil
 }
 
 func (c *PrepareCommand) persistDataForLaterStages(taskDetails task.Data) error {
 	c.logger.
 		WithField("taskARN", taskDetails.TaskARN).
+		WithField("phase", taskDetails.Phase).
 		Info("Persisting data that will be used by other commands")
 
 	err := c.metadataManager.Persist(taskDetails)
@@ -183,8 +726,11 @@ func (c *PrepareCommand) waitFargateTaskReady(ctx *cli.Context, taskARN string)
 
 	var containerIP string
 
-	// Wait for the task to be in "running" state
-	err := c.awsFargate.WaitUntilTaskRunning(ctx.Ctx, taskARN, c.cfg.Fargate.Cluster)
+	// Wait for the task to be in "running" state, reporting its statuses
+	c.progress.startSection("fargate_task_status", "Waiting for the AWS Fargate task", false)
+	err := c.awsFargate.WaitUntilTaskRunning(ctx.Ctx, taskARN, c.cfg.Fargate.Cluster, c.progress.taskStatus)
+	c.progress.endSection("fargate_task_status")
+
 	if err != nil {
 		return containerIP, fmt.Errorf("waiting for Fargate task to be in running state: %w", err)
 	}
@@ -200,5 +746,57 @@ func (c *PrepareCommand) waitFargateTaskReady(ctx *cli.Context, taskARN string)
 		return containerIP, fmt.Errorf("fetching the container IP: %w", err)
 	}
 
+	c.reportTaskDetails(ctx, taskARN)
+
 	return containerIP, nil
 }
+
+// reportTaskDetails prints the placement and the resources of the task. The
+// task is usable without them, so a failing description is only logged
+func (c *PrepareCommand) reportTaskDetails(ctx *cli.Context, taskARN string) {
+	description, err := c.awsFargate.DescribeTask(ctx.Ctx, taskARN, c.cfg.Fargate.Cluster)
+	if err != nil {
+		c.logger.
+			WithError(err).
+			WithField("taskARN", taskARN).
+			Warning("Couldn't describe the task")
+	}
+
+	c.progress.startSection("fargate_task_details", "AWS Fargate task details", true)
+	c.progress.taskDetails(c.cfg.Fargate.Cluster, taskARN, description)
+	c.progress.endSection("fargate_task_details")
+}
+
+// waitSSHServiceReady returns the fingerprint of the host key provided to the
+// SSH service, or an empty string when the readiness endpoint is not used.
+// The service must announce the same host key, which is the one pinned by
+// the SSH connections
+func (c *PrepareCommand) waitSSHServiceReady(ctx *cli.Context, taskDetails task.Data) (string, error) {
+	if taskDetails.ServiceToken == "" {
+		return "", nil
+	}
+
+	expectedFingerprint, err := ssh.HostKeyFingerprint(ssh.HostKeySeed(taskDetails.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("deriving the host key: %w", err)
+	}
+
+	address := net.JoinHostPort(taskDetails.ContainerIP, strconv.Itoa(c.cfg.SSH.ReadinessPort))
+	c.logger.
+		WithField("address", address).
+		Info("Waiting SSH service to be ready")
+
+	readinessCtx, cancel := context.WithTimeout(ctx.Ctx, defaultReadinessTimeout)
+	defer cancel()
+
+	info, err := c.readinessChecker.WaitUntilReady(readinessCtx, address, taskDetails.ServiceToken)
+	if err != nil {
+		return "", fmt.Errorf("checking SSH service readiness: %w", err)
+	}
+
+	if info.HostKeyFingerprint != expectedFingerprint {
+		return "", fmt.Errorf("%w: %q announced, %q expected", ssh.ErrFingerprintMismatch, info.HostKeyFingerprint, expectedFingerprint)
+	}
+
+	return expectedFingerprint, nil
+}
@@ -2,17 +2,27 @@ package custom
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/ssh"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
 )
@@ -21,28 +31,51 @@ type prepareCommandTestCase struct {
 	context        context.Context
 	fargateConfig  config.Fargate
 	metadataConfig config.TaskMetadata
+	sshConfig      config.SSH
+	leaseConfig    config.Lease
+	existingData   *task.Data
 	taskARN        *string
+	foundTaskARN   string
 	containerIP    string
 	keyPair        ssh.KeyPair
+	serviceToken   string
+	clientToken    string
+	serviceInfo    ssh.ServiceInfo
+	cancelled      bool
+	taskStatus     string
+	stoppedTaskARN string
+	replacesTask   bool
 
-	createKeyPairError      error
-	fargateInitError        error
-	fargateRunTaskError     error
-	fargateWaitTaskError    error
-	fargateContainerIPError error
-	fargateStopTaskError    error
-	persistARNError         error
-	persistIPError          error
+	getMetadataError         error
+	createKeyPairError       error
+	fargateInitError         error
+	fargateFindTaskError     error
+	fargateRunTaskError      error
+	fargateWaitTaskError     error
+	fargateContainerIPError  error
+	fargateDescribeTaskError error
+	fargateStopTaskError     error
+	serviceTokenError        error
+	clientTokenError         error
+	readinessError           error
+	getTaskStatusError       error
+	checkConnectionError     error
+	stopQueueInitError       error
+	drainQueueError          error
+	persistErrors            map[task.Phase]error
+
+	findTaskCalls int
 
 	shouldNotCallCreateKeyPair  bool
 	shouldNotCallRunTask        bool
 	shouldNotCallWaitTask       bool
 	shouldNotCallGetContainerIP bool
 	shouldNotCallStopTask       bool
-	shouldNotPersistARN         bool
-	shouldNotPersistIP          bool
+	shouldNotCallReadiness      bool
 
-	expectedError error
+	expectedPhases []task.Phase
+	expectedData   *task.Data
+	expectedError  error
 }
 
 func TestNewPrepareCommand(t *testing.T) {
@@ -53,9 +86,14 @@ func TestNewPrepareCommand(t *testing.T) {
 }
 
 func TestPrepareCommand_CustomExecute(t *testing.T) {
+	initializeAdapterForTesting(t)
+
 	testContext, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	cancelledContext, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
 	testFargateConfig := config.Fargate{
 		Cluster:        "cluster",
 		Region:         "region",
@@ -69,15 +107,37 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 	}
 	testTaskARN := "task-arn"
 	testContainerIP := "1.2.3.4"
+	testClientToken := "client-token"
 	testKeyPair := ssh.KeyPair{
 		PrivateKey: []byte("Private key"),
 		PublicKey:  []byte("Public key"),
 	}
 
+	testReadinessSSHConfig := config.SSH{
+		Username:      "root",
+		Port:          2222,
+		ReadinessPort: 8888,
+	}
+	testHostKeyFingerprint, err := ssh.HostKeyFingerprint(ssh.HostKeySeed(testKeyPair.PrivateKey))
+	require.NoError(t, err)
+
+	testServiceInfo := ssh.ServiceInfo{
+		Ready:              true,
+		HostKeyFingerprint: testHostKeyFingerprint,
+	}
+
+	noTaskARN := func(s string) *string { return &s }("")
+
 	testError := errors.New("simulated error")
 	testErrorStopTask := errors.New("simulated error 2")
 
-	t.Log(testError, testErrorStopTask)
+	allPhases := []task.Phase{
+		task.PhaseKeyGenerated,
+		task.PhaseLaunchRequested,
+		task.PhaseLaunched,
+		task.PhaseRunning,
+		task.PhaseReachable,
+	}
 
 	tests := map[string]prepareCommandTestCase{
 		"Error during Fargate Init": {
@@ -87,8 +147,25 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
 			shouldNotCallStopTask:       true,
-			shouldNotPersistARN:         true,
-			shouldNotPersistIP:          true,
+			expectedError:               testError,
+		},
+		"Execute prepare with success when the queued stops fail": {
+			drainQueueError:       task.ErrStopAttemptsExhausted,
+			shouldNotCallStopTask: true,
+			expectedPhases:        allPhases,
+		},
+		"Execute prepare with success when the stop queue can't be initialized": {
+			stopQueueInitError:    testError,
+			shouldNotCallStopTask: true,
+			expectedPhases:        allPhases,
+		},
+		"Error reading existing metadata": {
+			getMetadataError:            testError,
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
 			expectedError:               testError,
 		},
 		"Error during create Public / Private Key Pair": {
@@ -97,81 +174,379 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
 			shouldNotCallStopTask:       true,
-			shouldNotPersistARN:         true,
-			shouldNotPersistIP:          true,
 			expectedError:               testError,
 		},
-
+		"Error during generating SSH service token": {
+			sshConfig:                   testReadinessSSHConfig,
+			serviceTokenError:           testError,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
+			expectedError:               testError,
+		},
+		"Error during persisting generated keys": {
+			persistErrors:               map[task.Phase]error{task.PhaseKeyGenerated: testError},
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
+			expectedPhases:              allPhases[:1],
+			expectedError:               testError,
+		},
+		"Error during generating client token": {
+			clientTokenError:            testError,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
+			expectedPhases:              allPhases[:1],
+			expectedError:               testError,
+		},
 		"Error during Fargate Run Task": {
 			fargateRunTaskError:         testError,
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
-			shouldNotPersistARN:         true,
-			shouldNotPersistIP:          true,
+			expectedPhases:              append(allPhases[:2:2], task.PhaseKeyGenerated),
 			expectedError:               testError,
 		},
 		"Error during Fargate Run Task - when no taskARN was provided": {
-			taskARN:                     func(s string) *string { return &s }(""),
+			taskARN:                     noTaskARN,
+			fargateRunTaskError:         testError,
+			findTaskCalls:               1,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
+			expectedPhases:              append(allPhases[:2:2], task.PhaseKeyGenerated),
+			expectedError:               testError,
+		},
+		"Error during Fargate Run Task - when the task is found by client token": {
+			taskARN:                     noTaskARN,
+			foundTaskARN:                "found-task-arn",
 			fargateRunTaskError:         testError,
+			findTaskCalls:               1,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			expectedPhases:              append(allPhases[:2:2], task.PhaseKeyGenerated),
+			expectedError:               testError,
+		},
+		"Error during Fargate Run Task and searching the task": {
+			taskARN:                     noTaskARN,
+			fargateRunTaskError:         testError,
+			fargateFindTaskError:        testErrorStopTask,
+			findTaskCalls:               1,
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
 			shouldNotCallStopTask:       true,
-			shouldNotPersistARN:         true,
-			shouldNotPersistIP:          true,
+			expectedPhases:              allPhases[:2],
+			expectedError:               testError,
+		},
+		"Error during persisting task ARN": {
+			persistErrors:               map[task.Phase]error{task.PhaseLaunched: testError},
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			expectedPhases:              append(allPhases[:3:3], task.PhaseKeyGenerated),
+			expectedError:               testError,
+		},
+		"Error during persisting ARN and stop task": {
+			persistErrors:               map[task.Phase]error{task.PhaseLaunched: testError},
+			fargateStopTaskError:        testErrorStopTask,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			expectedPhases:              allPhases[:3],
 			expectedError:               testError,
 		},
 		"Error during Fargate Wait Task": {
 			fargateWaitTaskError:        testError,
 			shouldNotCallGetContainerIP: true,
-			shouldNotPersistIP:          true,
+			expectedPhases:              append(allPhases[:3:3], task.PhaseKeyGenerated),
 			expectedError:               testError,
 		},
 		"Error during Fargate Wait Task and Stop Task": {
 			fargateWaitTaskError:        testError,
 			fargateStopTaskError:        testErrorStopTask,
 			shouldNotCallGetContainerIP: true,
-			shouldNotPersistIP:          true,
+			expectedPhases:              allPhases[:3],
 			expectedError:               testError,
 		},
+		"Error during Fargate Wait Task when the job was cancelled": {
+			cancelled:                   true,
+			fargateWaitTaskError:        context.Canceled,
+			shouldNotCallGetContainerIP: true,
+			expectedPhases:              append(allPhases[:3:3], task.PhaseKeyGenerated),
+			expectedError:               context.Canceled,
+		},
 		"Error during Fargate Get Container IP": {
 			fargateContainerIPError: testError,
-			shouldNotPersistIP:      true,
+			expectedPhases:          append(allPhases[:3:3], task.PhaseKeyGenerated),
 			expectedError:           testError,
 		},
-		"Error during persisting task ARN": {
-			persistARNError:             testError,
+		"Error during waiting for SSH service readiness": {
+			sshConfig:      testReadinessSSHConfig,
+			serviceToken:   "token",
+			readinessError: testError,
+			expectedPhases: append(allPhases[:4:4], task.PhaseKeyGenerated),
+			expectedError:  testError,
+		},
+		"Host key announced by the SSH service not provided by the driver": {
+			sshConfig:      testReadinessSSHConfig,
+			serviceToken:   "token",
+			serviceInfo:    ssh.ServiceInfo{Ready: true, HostKeyFingerprint: "SHA256:other"},
+			expectedPhases: append(allPhases[:4:4], task.PhaseKeyGenerated),
+			expectedError:  ssh.ErrFingerprintMismatch,
+		},
+		"Error during persisting container IP": {
+			persistErrors:  map[task.Phase]error{task.PhaseReachable: testError},
+			expectedPhases: append(allPhases[:5:5], task.PhaseKeyGenerated),
+			expectedError:  testError,
+		},
+		"Execute prepare with success": {
+			shouldNotCallStopTask: true,
+			expectedPhases:        allPhases,
+			expectedData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     testTaskARN,
+				ClientToken: testClientToken,
+				ContainerIP: testContainerIP,
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+		},
+		"Execute prepare with success when the task can't be described": {
+			fargateDescribeTaskError: testError,
+			shouldNotCallStopTask:    true,
+			expectedPhases:           allPhases,
+		},
+		"Execute prepare with success and SSH service lease": {
+			leaseConfig: config.Lease{
+				Enabled:         true,
+				DefaultDuration: config.Duration{Duration: time.Hour},
+				StartupDeadline: config.Duration{Duration: 5 * time.Minute},
+			},
+			shouldNotCallStopTask: true,
+			expectedPhases:        allPhases,
+		},
+		"Execute prepare with success and SSH service readiness check": {
+			sshConfig:             testReadinessSSHConfig,
+			serviceToken:          "token",
+			serviceInfo:           testServiceInfo,
+			shouldNotCallStopTask: true,
+			expectedPhases:        allPhases,
+			expectedData: &task.Data{
+				Phase:              task.PhaseReachable,
+				TaskARN:            testTaskARN,
+				ClientToken:        testClientToken,
+				ContainerIP:        testContainerIP,
+				PrivateKey:         testKeyPair.PrivateKey,
+				PublicKey:          testKeyPair.PublicKey,
+				ServiceToken:       "token",
+				HostKeyFingerprint: testServiceInfo.HostKeyFingerprint,
+			},
+		},
+		"Resume from generated keys": {
+			existingData: &task.Data{
+				Phase:      task.PhaseKeyGenerated,
+				PrivateKey: testKeyPair.PrivateKey,
+				PublicKey:  testKeyPair.PublicKey,
+			},
+			shouldNotCallCreateKeyPair: true,
+			shouldNotCallStopTask:      true,
+			expectedPhases:             allPhases[1:],
+		},
+		"Resume from requested launch when the task was started": {
+			existingData: &task.Data{
+				Phase:       task.PhaseLaunchRequested,
+				ClientToken: testClientToken,
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+			foundTaskARN:               "found-task-arn",
+			findTaskCalls:              1,
+			shouldNotCallCreateKeyPair: true,
+			shouldNotCallRunTask:       true,
+			shouldNotCallStopTask:      true,
+			expectedPhases:             allPhases[2:],
+			expectedData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     "found-task-arn",
+				ClientToken: testClientToken,
+				ContainerIP: testContainerIP,
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+		},
+		"Resume from requested launch when the task wasn't started": {
+			existingData: &task.Data{
+				Phase:       task.PhaseLaunchRequested,
+				ClientToken: testClientToken,
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+			findTaskCalls:              1,
+			shouldNotCallCreateKeyPair: true,
+			shouldNotCallStopTask:      true,
+			expectedPhases:             allPhases[2:],
+		},
+		"Error during resume from requested launch when searching the task": {
+			existingData: &task.Data{
+				Phase:       task.PhaseLaunchRequested,
+				ClientToken: testClientToken,
+			},
+			fargateFindTaskError:        testError,
+			findTaskCalls:               2,
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
-			shouldNotPersistIP:          true,
+			shouldNotCallStopTask:       true,
 			expectedError:               testError,
 		},
-		"Error during persisting ARN and stop task": {
-			persistARNError:             testError,
-			fargateStopTaskError:        testErrorStopTask,
+		"Resume from launched task": {
+			existingData: &task.Data{
+				Phase:       task.PhaseLaunched,
+				TaskARN:     testTaskARN,
+				ClientToken: testClientToken,
+				PrivateKey:  testKeyPair.PrivateKey,
+			},
+			shouldNotCallCreateKeyPair: true,
+			shouldNotCallRunTask:       true,
+			shouldNotCallStopTask:      true,
+			expectedPhases:             allPhases[3:],
+		},
+		"Reuse healthy task from previous invocation": {
+			existingData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     testTaskARN,
+				ContainerIP: testContainerIP,
+				PrivateKey:  testKeyPair.PrivateKey,
+			},
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
 			shouldNotCallWaitTask:       true,
 			shouldNotCallGetContainerIP: true,
-			shouldNotPersistIP:          true,
+			shouldNotCallStopTask:       true,
+		},
+		"Replace task from previous invocation when SSH service is not reachable": {
+			existingData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     "old-task-arn",
+				ClientToken: "old-client-token",
+				ContainerIP: "4.3.2.1",
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+			checkConnectionError:       testError,
+			replacesTask:               true,
+			stoppedTaskARN:             "old-task-arn",
+			shouldNotCallCreateKeyPair: true,
+			expectedPhases:             allPhases,
+			expectedData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     testTaskARN,
+				ClientToken: testClientToken,
+				ContainerIP: testContainerIP,
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+		},
+		"Replace task from previous invocation when it's not running": {
+			existingData: &task.Data{
+				Phase:       task.PhaseReachable,
+				TaskARN:     "old-task-arn",
+				ContainerIP: "4.3.2.1",
+				PrivateKey:  testKeyPair.PrivateKey,
+				PublicKey:   testKeyPair.PublicKey,
+			},
+			taskStatus:                 "PENDING",
+			replacesTask:               true,
+			stoppedTaskARN:             "old-task-arn",
+			shouldNotCallCreateKeyPair: true,
+			expectedPhases:             allPhases,
+		},
+		"Replace launched task from previous invocation when it was stopped": {
+			existingData: &task.Data{
+				Phase:      task.PhaseLaunched,
+				TaskARN:    "old-task-arn",
+				PrivateKey: testKeyPair.PrivateKey,
+				PublicKey:  testKeyPair.PublicKey,
+			},
+			taskStatus:                 "STOPPED",
+			replacesTask:               true,
+			stoppedTaskARN:             "old-task-arn",
+			shouldNotCallCreateKeyPair: true,
+			expectedPhases:             allPhases,
+		},
+		"Replace task from previous invocation when it doesn't exist": {
+			existingData: &task.Data{
+				Phase:      task.PhaseRunning,
+				TaskARN:    "old-task-arn",
+				PrivateKey: testKeyPair.PrivateKey,
+				PublicKey:  testKeyPair.PublicKey,
+			},
+			getTaskStatusError:         fmt.Errorf("simulated: %w", aws.ErrTaskNotFound),
+			replacesTask:               true,
+			stoppedTaskARN:             "old-task-arn",
+			shouldNotCallCreateKeyPair: true,
+			expectedPhases:             allPhases,
+		},
+		"Error during checking the task from previous invocation": {
+			existingData: &task.Data{
+				Phase:   task.PhaseReachable,
+				TaskARN: testTaskARN,
+			},
+			getTaskStatusError:          testError,
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			shouldNotCallStopTask:       true,
 			expectedError:               testError,
 		},
-		"Error during persisting container IP": {
-			persistIPError: testError,
-			expectedError:  testError,
+		"Error during stopping unhealthy task from previous invocation": {
+			existingData: &task.Data{
+				Phase:   task.PhaseLaunched,
+				TaskARN: "old-task-arn",
+			},
+			taskStatus:                  "STOPPING",
+			fargateStopTaskError:        testErrorStopTask,
+			stoppedTaskARN:              "old-task-arn",
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			expectedError:               testErrorStopTask,
 		},
-		"Execute prepare with success": {
-			shouldNotCallStopTask: true,
+		"Error during resume from unknown phase": {
+			existingData: &task.Data{
+				Phase:   task.Phase("unknown"),
+				TaskARN: testTaskARN,
+			},
+			shouldNotCallCreateKeyPair:  true,
+			shouldNotCallRunTask:        true,
+			shouldNotCallWaitTask:       true,
+			shouldNotCallGetContainerIP: true,
+			expectedPhases:              []task.Phase{task.PhaseKeyGenerated},
+			expectedError:               ErrUnknownPhase,
 		},
 	}
 
 	for tn, tt := range tests {
 		t.Run(tn, func(t *testing.T) {
 			tt.context = testContext
+			if tt.cancelled {
+				tt.context = cancelledContext
+			}
 			tt.fargateConfig = testFargateConfig
 			tt.metadataConfig = testMetadataConfig
 			tt.containerIP = testContainerIP
 			tt.keyPair = testKeyPair
+			tt.clientToken = testClientToken
 			if tt.taskARN == nil {
 				tt.taskARN = &testTaskARN
 			}
+			if tt.taskStatus == "" {
+				tt.taskStatus = aws.TaskStatusRunning
+			}
 
 			mockKeyFactory := new(ssh.MockKeyFactory)
 			defer mockKeyFactory.AssertExpectations(t)
@@ -182,9 +557,26 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 			mockMetadataManager := new(task.MockMetadataManager)
 			defer mockMetadataManager.AssertExpectations(t)
 
+			mockReadinessChecker := new(ssh.MockReadinessChecker)
+			defer mockReadinessChecker.AssertExpectations(t)
+
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			mockStopQueue := new(task.MockStopQueue)
+			defer mockStopQueue.AssertExpectations(t)
+
+			if tt.fargateInitError == nil && tt.stopQueueInitError == nil {
+				mockStopQueue.On("Drain", mock.Anything).
+					Return(task.DrainResult{}, tt.drainQueueError).
+					Once()
+			}
+
 			setExpectationsForKeyFactory(mockKeyFactory, tt)
+			setExpectationsForReadinessChecker(mockReadinessChecker, tt)
 			setExpectationsForFargate(mockAwsFargate, tt)
-			setExpectationsForMetadataManager(mockMetadataManager, tt)
+			setExpectationsForExecutor(mockExecutor, tt)
+			persisted := setExpectationsForMetadataManager(mockMetadataManager, tt)
 
 			prepare := new(PrepareCommand)
 			prepare.newKeyFactory = func(logger logging.Logger) ssh.KeyFactory {
@@ -193,12 +585,42 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 			prepare.newFargate = func(logger logging.Logger, awsRegion string) aws.Fargate {
 				return mockAwsFargate
 			}
-			prepare.newMetadataManager = func(logger logging.Logger, directory string) task.MetadataManager {
-				return mockMetadataManager
+			prepare.newMetadataManager = func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error) {
+				return mockMetadataManager, nil
 			}
+			prepare.newStopQueue = func(logger logging.Logger, directory string) (task.StopQueue, error) {
+				if tt.stopQueueInitError != nil {
+					return nil, tt.stopQueueInitError
+				}
+
+				return mockStopQueue, nil
+			}
+			prepare.newReadinessChecker = func(logger logging.Logger) ssh.ReadinessChecker {
+				return mockReadinessChecker
+			}
+			prepare.newServiceToken = func() (string, error) {
+				return tt.serviceToken, tt.serviceTokenError
+			}
+			prepare.newClientToken = func() (string, error) {
+				return tt.clientToken, tt.clientTokenError
+			}
+			prepare.newExecutor = func(logger logging.Logger) executors.Executor {
+				return mockExecutor
+			}
+			prepare.newFS = func() fs.FS {
+				return new(fs.MockFS)
+			}
+			prepare.progressOutput = new(strings.Builder)
+			prepare.now = time.Now
 
 			err := prepare.CustomExecute(createCliContextForTests(tt))
 
+			var persistedPhases []task.Phase
+			for _, data := range *persisted {
+				persistedPhases = append(persistedPhases, data.Phase)
+			}
+			assert.Equal(t, tt.expectedPhases, persistedPhases, "Unexpected sequence of persisted phases")
+
 			if tt.expectedError != nil {
 				assertions.ErrorIs(t, err, tt.expectedError)
 				return
@@ -207,12 +629,242 @@ func TestPrepareCommand_CustomExecute(t *testing.T) {
 			assert.NoError(t, err, "Executing the command should not return errors")
 			assert.NotEmpty(t, prepare.awsFargate)
 			assert.NotEmpty(t, prepare.metadataManager)
+
+			if tt.expectedData != nil {
+				assert.Equal(t, *tt.expectedData, (*persisted)[len(*persisted)-1])
+			}
+		})
+	}
+}
+
+func TestPrepareCommand_authorize(t *testing.T) {
+	tests := map[string]struct {
+		rules          []config.Rule
+		taskDefinition string
+		expectedError  error
+		expectedOutput string
+	}{
+		"No rules": {
+			taskDefinition: "privileged:1",
+		},
+		"Task definition allowed for the project": {
+			rules: []config.Rule{
+				{Projects: []string{"group/*"}, Effect: config.RuleEffectAllow, TaskDefinitions: []string{"privileged:*"}},
+			},
+			taskDefinition: "privileged:1",
+		},
+		"Task definition not allowed for the project": {
+			rules: []config.Rule{
+				{Projects: []string{"other/*"}, Effect: config.RuleEffectAllow, TaskDefinitions: []string{"privileged:*"}},
+				{Effect: config.RuleEffectAllow, TaskDefinitions: []string{"build:*"}},
+			},
+			taskDefinition: "privileged:1",
+			expectedError:  config.ErrNotAuthorized,
+			expectedOutput: `ERROR: job is not authorized to use the requested task settings: ` +
+				`Fargate.TaskDefinition=privileged:1 (not allowed by any rule)` + "\n" +
+				`The task settings requested with the FARGATE_* CI variables are not allowed for project "group/project" on "main"`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg := config.Global{
+				Overrides: config.Overrides{Allowed: []string{"Fargate.TaskDefinition"}},
+				Rules:     tt.rules,
+			}
+
+			// The validation of the incomplete configuration is not relevant
+			_, _ = cfg.ApplyOverrides(func(variable string) string {
+				if variable == "FARGATE_TASK_DEFINITION" {
+					return tt.taskDefinition
+				}
+
+				return ""
+			})
+
+			output := new(strings.Builder)
+
+			prepare := new(PrepareCommand)
+			prepare.cfg = cfg
+			prepare.job = runner.JobContext{ProjectPath: "group/project", RefName: "main"}
+			prepare.logger = createTestLogger()
+			prepare.output = output
+
+			err := prepare.authorize()
+			if tt.expectedError == nil {
+				assert.NoError(t, err)
+				assert.Empty(t, output.String())
+				return
+			}
+
+			assertions.ErrorIs(t, err, tt.expectedError)
+			assertions.ErrorIs(t, err, &runner.BuildFailureError{})
+			assert.Contains(t, output.String(), tt.expectedOutput)
+		})
+	}
+}
+
+func TestPrepareCommand_loadSecrets(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		secrets         config.Secrets
+		requested       []string
+		resolveError    error
+		expectedSecrets map[string]string
+		expectedError   error
+		expectedOutput  string
+	}{
+		"No secrets": {},
+		"Secrets resolved": {
+			secrets: config.Secrets{
+				Variables: []string{"DB_PASS=ssm:/ci/db"},
+				Allowed:   []string{"secretsmanager:ci/*"},
+			},
+			requested:       []string{"TOKEN=secretsmanager:ci/token"},
+			expectedSecrets: map[string]string{"DB_PASS": "value of /ci/db", "TOKEN": "value of ci/token"},
+		},
+		"Secrets not allowed": {
+			secrets:       config.Secrets{Allowed: []string{"ssm:/ci/*"}},
+			requested:     []string{"ROOT=ssm:/admin/root"},
+			expectedError: config.ErrSecretNotAllowed,
+			expectedOutput: `ERROR: secret is not allowed: ROOT=ssm:/admin/root` + "\n" +
+				`The secrets requested with the FARGATE_SECRETS CI variable are not allowed for project "group/project"`,
+		},
+		"Error on resolving secrets": {
+			secrets:       config.Secrets{Variables: []string{"DB_PASS=ssm:/ci/db"}},
+			resolveError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			output := new(strings.Builder)
+
+			prepare := new(PrepareCommand)
+			prepare.cfg = config.Global{Secrets: tt.secrets}
+			prepare.job = runner.JobContext{ProjectPath: "group/project", RequestedSecrets: tt.requested}
+			prepare.logger = createTestLogger()
+			prepare.output = output
+			prepare.resolveSecrets = func(cfg config.Global, secrets []config.Secret) (map[string]string, error) {
+				values := make(map[string]string)
+				for _, secret := range secrets {
+					values[secret.Variable] = "value of " + secret.Name
+				}
+
+				return values, tt.resolveError
+			}
+
+			err := prepare.loadSecrets()
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				assert.Contains(t, output.String(), tt.expectedOutput)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Empty(t, output.String())
+			assert.Equal(t, tt.expectedSecrets, prepare.secrets)
 		})
 	}
 }
 
+func TestPrepareCommand_deliverSecrets(t *testing.T) {
+	testError := errors.New("simulated error")
+	testTask := task.Data{TaskARN: "task-arn", ContainerIP: "1.2.3.4", Phase: task.PhaseReachable}
+
+	tests := map[string]struct {
+		secrets       map[string]string
+		cfg           config.Secrets
+		uploadError   error
+		persistError  error
+		expectedFile  string
+		expectedError error
+	}{
+		"No secrets": {},
+		"Secrets delivered in the environment": {
+			secrets: map[string]string{"DB_PASS": "secret"},
+			cfg:     config.Secrets{Delivery: config.SecretsDeliveryEnvironment},
+		},
+		"Secrets delivered to the default file": {
+			secrets:      map[string]string{"DB_PASS": "it's secret", "API_KEY": "key"},
+			expectedFile: defaultSecretsEnvFile,
+		},
+		"Secrets delivered to the configured file": {
+			secrets:      map[string]string{"DB_PASS": "secret"},
+			cfg:          config.Secrets{Delivery: config.SecretsDeliveryFile, EnvFile: "/run/secrets.env"},
+			expectedFile: "/run/secrets.env",
+		},
+		"Error on uploading the file": {
+			secrets:       map[string]string{"DB_PASS": "secret"},
+			uploadError:   testError,
+			expectedFile:  defaultSecretsEnvFile,
+			expectedError: testError,
+		},
+		"Error on recording the file": {
+			secrets:       map[string]string{"DB_PASS": "secret"},
+			persistError:  testError,
+			expectedFile:  defaultSecretsEnvFile,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			mockMetadataManager := new(task.MockMetadataManager)
+			defer mockMetadataManager.AssertExpectations(t)
+
+			if tt.expectedFile != "" {
+				mockExecutor.On("Upload", mock.Anything, mock.Anything, tt.expectedFile, secretsEnvFile(tt.secrets)).
+					Return(tt.uploadError).
+					Once()
+
+				if tt.uploadError == nil {
+					expectedData := testTask
+					expectedData.SecretsFile = tt.expectedFile
+
+					mockMetadataManager.On("Persist", expectedData).
+						Return(tt.persistError).
+						Once()
+				}
+			}
+
+			prepare := new(PrepareCommand)
+			prepare.cfg = config.Global{Secrets: tt.cfg}
+			prepare.logger = createTestLogger()
+			prepare.executor = mockExecutor
+			prepare.metadataManager = mockMetadataManager
+			prepare.secrets = tt.secrets
+
+			ctx := new(cli.Context)
+			ctx.Ctx = context.Background()
+
+			err := prepare.deliverSecrets(ctx, testTask)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSecretsEnvFile(t *testing.T) {
+	content := secretsEnvFile(map[string]string{
+		"TOKEN":   "abc",
+		"DB_PASS": "it's $ecret",
+	})
+
+	assert.Equal(t, "export DB_PASS='it'\\''s $ecret'\nexport TOKEN='abc'\n", string(content))
+}
+
 func setExpectationsForKeyFactory(mockKeyFactory *ssh.MockKeyFactory, testParams prepareCommandTestCase) {
-	if testParams.shouldNotCallCreateKeyPair {
+	if testParams.shouldNotCallCreateKeyPair || testParams.fargateInitError != nil || testParams.getMetadataError != nil {
 		return
 	}
 
@@ -221,19 +873,113 @@ func setExpectationsForKeyFactory(mockKeyFactory *ssh.MockKeyFactory, testParams
 		Once()
 }
 
+func setExpectationsForReadinessChecker(mockChecker *ssh.MockReadinessChecker, testParams prepareCommandTestCase) {
+	if testParams.sshConfig.ReadinessPort < 1 || testParams.shouldNotCallGetContainerIP || testParams.fargateContainerIPError != nil {
+		return
+	}
+
+	expectedAddress := fmt.Sprintf("%s:%d", testParams.containerIP, testParams.sshConfig.ReadinessPort)
+
+	mockChecker.On("WaitUntilReady", mock.Anything, expectedAddress, testParams.serviceToken).
+		Return(&testParams.serviceInfo, testParams.readinessError).
+		Once()
+}
+
 func setExpectationsForFargate(mock *aws.MockFargate, testParams prepareCommandTestCase) {
 	mock.On("Init").
 		Return(testParams.fargateInitError).
 		Once()
 
+	setExpectationForFargateGetTaskStatus(mock, testParams)
+	setExpectationForFargateFindTask(mock, testParams)
 	setExpectationForFargateRunTask(mock, testParams)
 	setExpectationForFargateWaitTask(mock, testParams)
 	setExpectationForFargateGetIP(mock, testParams)
+	setExpectationForFargateDescribeTask(mock, testParams)
 	setExpectationForFargateStopTask(mock, testParams)
 }
 
+// startedTaskARN is the ARN of the task found by the client token, or
+// returned by RunTask
+func startedTaskARN(testParams prepareCommandTestCase) string {
+	if testParams.foundTaskARN != "" {
+		return testParams.foundTaskARN
+	}
+
+	if testParams.existingData != nil && testParams.existingData.TaskARN != "" && !testParams.replacesTask {
+		return testParams.existingData.TaskARN
+	}
+
+	return *testParams.taskARN
+}
+
+// recordedTaskPhase returns the phase of the recorded task that is checked
+// before the provisioning is resumed, or an empty phase
+func recordedTaskPhase(testParams prepareCommandTestCase) task.Phase {
+	if testParams.existingData == nil || testParams.getMetadataError != nil || testParams.fargateInitError != nil {
+		return ""
+	}
+
+	switch testParams.existingData.Phase {
+	case task.PhaseLaunched, task.PhaseRunning, task.PhaseReachable:
+		return testParams.existingData.Phase
+	}
+
+	return ""
+}
+
+func setExpectationForFargateGetTaskStatus(mockAwsFargate *aws.MockFargate, testParams prepareCommandTestCase) {
+	if recordedTaskPhase(testParams) == "" {
+		return
+	}
+
+	mockAwsFargate.On(
+		"GetTaskStatus",
+		testParams.context,
+		testParams.existingData.TaskARN,
+		testParams.fargateConfig.Cluster,
+	).
+		Return(testParams.taskStatus, testParams.getTaskStatusError).
+		Once()
+}
+
+func setExpectationsForExecutor(mockExecutor *executors.MockExecutor, testParams prepareCommandTestCase) {
+	if recordedTaskPhase(testParams) != task.PhaseReachable || testParams.getTaskStatusError != nil ||
+		testParams.taskStatus != aws.TaskStatusRunning {
+		return
+	}
+
+	expectedSettings := executors.ConnectionSettings{
+		Hostname:       testParams.existingData.ContainerIP,
+		Port:           executors.DefaultPort,
+		PrivateKey:     testParams.existingData.PrivateKey,
+		ConnectTimeout: defaultReachabilityTimeout,
+	}
+
+	mockExecutor.On("CheckConnection", testParams.context, expectedSettings).
+		Return(testParams.checkConnectionError).
+		Once()
+}
+
+func setExpectationForFargateFindTask(mockAwsFargate *aws.MockFargate, testParams prepareCommandTestCase) {
+	if testParams.findTaskCalls < 1 {
+		return
+	}
+
+	mockAwsFargate.On(
+		"FindTask",
+		mock.Anything,
+		testParams.fargateConfig.Cluster,
+		testParams.clientToken,
+	).
+		Return(testParams.foundTaskARN, testParams.fargateFindTaskError).
+		Times(testParams.findTaskCalls)
+}
+
 func setExpectationForFargateRunTask(mockAwsFargate *aws.MockFargate, testParams prepareCommandTestCase) {
-	if testParams.shouldNotCallRunTask {
+	if testParams.shouldNotCallRunTask || testParams.clientTokenError != nil || testParams.createKeyPairError != nil ||
+		testParams.serviceTokenError != nil || testParams.persistErrors[task.PhaseKeyGenerated] != nil ||
+		testParams.fargateInitError != nil || testParams.getMetadataError != nil {
 		return
 	}
 
@@ -249,6 +995,16 @@ func setExpectationForFargateRunTask(mockAwsFargate *aws.MockFargate, testParams
 		EnvironmentVariables: map[string]string{
 			"SSH_PUBLIC_KEY": string(testParams.keyPair.PublicKey),
 		},
+		ClientToken: testParams.clientToken,
+		Tags:        map[string]string{jobTagKey: task.JobKey()},
+	}
+	if testParams.serviceToken != "" {
+		expectedTaskSettings.EnvironmentVariables["SSH_SERVICE_TOKEN"] = testParams.serviceToken
+		expectedTaskSettings.EnvironmentVariables["SSH_HOST_KEY_SEED"] = base64.StdEncoding.EncodeToString(ssh.HostKeySeed(testParams.keyPair.PrivateKey))
+	}
+	if testParams.leaseConfig.Enabled {
+		expectedTaskSettings.EnvironmentVariables["SSH_SERVICE_LEASE"] = expectedLeaseDuration(testParams).String()
+		expectedTaskSettings.EnvironmentVariables["SSH_SERVICE_STARTUP_DEADLINE"] = testParams.leaseConfig.StartupDeadline.String()
 	}
 
 	mockAwsFargate.On(
@@ -269,8 +1025,9 @@ func setExpectationForFargateWaitTask(mockAwsFargate *aws.MockFargate, testParam
 	mockAwsFargate.On(
 		"WaitUntilTaskRunning",
 		testParams.context,
-		*testParams.taskARN,
+		startedTaskARN(testParams),
 		testParams.fargateConfig.Cluster,
+		mock.AnythingOfType("aws.TaskStatusObserver"),
 	).
 		Return(testParams.fargateWaitTaskError).
 		Once()
@@ -283,7 +1040,7 @@ func setExpectationForFargateGetIP(mockAwsFargate *aws.MockFargate, testParams p
 
 	mockAwsFargate.On("GetContainerIP",
 		testParams.context,
-		*testParams.taskARN,
+		startedTaskARN(testParams),
 		testParams.fargateConfig.Cluster,
 		testParams.fargateConfig.EnablePublicIP,
 	).
@@ -291,53 +1048,86 @@ func setExpectationForFargateGetIP(mockAwsFargate *aws.MockFargate, testParams p
 		Once()
 }
 
+func setExpectationForFargateDescribeTask(mockAwsFargate *aws.MockFargate, testParams prepareCommandTestCase) {
+	if testParams.shouldNotCallGetContainerIP || testParams.fargateContainerIPError != nil {
+		return
+	}
+
+	mockAwsFargate.On("DescribeTask",
+		testParams.context,
+		startedTaskARN(testParams),
+		testParams.fargateConfig.Cluster,
+	).
+		Return(&aws.TaskDescription{Status: aws.TaskStatusRunning}, testParams.fargateDescribeTaskError).
+		Once()
+}
+
 func setExpectationForFargateStopTask(mockAwsFargate *aws.MockFargate, testParams prepareCommandTestCase) {
 	if testParams.shouldNotCallStopTask {
 		return
 	}
 
+	// The task must be stopped even when the context of the command was cancelled
+	detachedContext := mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Err() == nil && ctx != testParams.context
+	})
+
+	stoppedTaskARN := testParams.stoppedTaskARN
+	if stoppedTaskARN == "" {
+		stoppedTaskARN = startedTaskARN(testParams)
+	}
+
 	mockAwsFargate.On(
 		"StopTask",
-		testParams.context,
-		*testParams.taskARN,
+		detachedContext,
+		stoppedTaskARN,
 		testParams.fargateConfig.Cluster,
+		mock.MatchedBy(func(reason string) bool {
+			return strings.Contains(reason, "was rolled back")
+		}),
 	).
 		Return(testParams.fargateStopTaskError).
 		Once()
 }
 
-func setExpectationsForMetadataManager(mockManager *task.MockMetadataManager, testParams prepareCommandTestCase) {
-	setExpectationsForPersistingTaskARN(mockManager, testParams)
-	setExpectationsForPersistingContainerIP(mockManager, testParams)
-}
+// setExpectationsForMetadataManager returns the data of all the Persist calls
+func setExpectationsForMetadataManager(mockManager *task.MockMetadataManager, testParams prepareCommandTestCase) *[]task.Data {
+	persisted := new([]task.Data)
 
-func setExpectationsForPersistingTaskARN(mockManager *task.MockMetadataManager, testParams prepareCommandTestCase) {
-	if testParams.shouldNotPersistARN {
-		return
+	if testParams.fargateInitError != nil {
+		return persisted
 	}
 
-	expectedDataFirstCall := task.Data{
-		TaskARN:    *testParams.taskARN,
-		PrivateKey: testParams.keyPair.PrivateKey,
+	existingData, existingErr := task.Data{}, fmt.Errorf("fetching file: %w", os.ErrNotExist)
+	if testParams.existingData != nil {
+		existingData, existingErr = *testParams.existingData, nil
+	}
+	if testParams.getMetadataError != nil {
+		existingErr = testParams.getMetadataError
 	}
-	mockManager.On("Persist", expectedDataFirstCall).
-		Return(testParams.persistARNError).
+
+	mockManager.On("Get").
+		Return(existingData, existingErr).
 		Once()
+
+	mockManager.On("Persist", mock.AnythingOfType("task.Data")).
+		Run(func(args mock.Arguments) {
+			*persisted = append(*persisted, args.Get(0).(task.Data))
+		}).
+		Return(func(data task.Data) error {
+			return testParams.persistErrors[data.Phase]
+		}).
+		Maybe()
+
+	return persisted
 }
 
-func setExpectationsForPersistingContainerIP(mockManager *task.MockMetadataManager, testParams prepareCommandTestCase) {
-	if testParams.shouldNotPersistIP {
-		return
+func expectedLeaseDuration(testParams prepareCommandTestCase) time.Duration {
+	if !testParams.leaseConfig.Enabled {
+		return 0
 	}
 
-	expectedDataSecondCall := task.Data{
-		TaskARN:     *testParams.taskARN,
-		ContainerIP: testParams.containerIP,
-		PrivateKey:  testParams.keyPair.PrivateKey,
-	}
-	mockManager.On("Persist", expectedDataSecondCall).
-		Return(testParams.persistIPError).
-		Once()
+	return testParams.leaseConfig.DefaultDuration.Duration + leaseMargin
 }
 
 func createCliContextForTests(testParams prepareCommandTestCase) *cli.Context {
@@ -345,6 +1135,8 @@ func createCliContextForTests(testParams prepareCommandTestCase) *cli.Context {
 	cliCtx.SetConfig(config.Global{
 		Fargate:      testParams.fargateConfig,
 		TaskMetadata: testParams.metadataConfig,
+		SSH:          testParams.sshConfig,
+		Lease:        testParams.leaseConfig,
 	})
 	cliCtx.SetLogger(createTestLogger())
 	cliCtx.Ctx = testParams.context
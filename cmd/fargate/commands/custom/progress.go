@@ -0,0 +1,93 @@
+package custom
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+)
+
+// progressReport prints the progress of the provisioning to the job log,
+// grouped in the collapsible sections of GitLab
+type progressReport struct {
+	out     io.Writer
+	now     func() time.Time
+	started time.Time
+
+	// status is the last status of the task printed
+	status string
+}
+
+func newProgressReport(out io.Writer, now func() time.Time) *progressReport {
+	return &progressReport{
+		out:     out,
+		now:     now,
+		started: now(),
+	}
+}
+
+// startSection opens a section of the job log. The collapsed sections only
+// show their header until expanded
+func (r *progressReport) startSection(name string, header string, collapsed bool) {
+	options := ""
+	if collapsed {
+		options = "[collapsed=true]"
+	}
+
+	r.printf("\033[0Ksection_start:%d:%s%s\r\033[0K%s\n", r.now().Unix(), name, options, header)
+}
+
+func (r *progressReport) endSection(name string) {
+	r.printf("\033[0Ksection_end:%d:%s\r\033[0K\n", r.now().Unix(), name)
+}
+
+func (r *progressReport) printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(r.out, format, args...)
+}
+
+// taskStatus prints the transitions of the status of the task, with their
+// time and the time elapsed since the provisioning started
+func (r *progressReport) taskStatus(status string) {
+	if status == r.status {
+		return
+	}
+
+	r.status = status
+
+	now := r.now()
+	r.printf("[%s] %-13s (+%s)\n", now.UTC().Format("15:04:05Z"), status, r.elapsed(now))
+}
+
+// taskDetails prints the placement and the resources of the task
+func (r *progressReport) taskDetails(cluster string, taskARN string, description *aws.TaskDescription) {
+	r.printf("Cluster:           %s\n", cluster)
+	r.printf("Task ARN:          %s\n", taskARN)
+
+	if description == nil {
+		return
+	}
+
+	r.printf("Availability zone: %s\n", valueOrUnknown(description.AvailabilityZone))
+	r.printf("Architecture:      %s\n", valueOrUnknown(description.Architecture))
+	r.printf("CPU:               %s units\n", valueOrUnknown(description.CPU))
+	r.printf("Memory:            %s MiB\n", valueOrUnknown(description.Memory))
+	r.printf("Image digest:      %s\n", valueOrUnknown(description.ImageDigest))
+}
+
+// ready prints the time taken by the provisioning
+func (r *progressReport) ready() {
+	r.printf("AWS Fargate task ready in %s\n", r.elapsed(r.now()))
+}
+
+func (r *progressReport) elapsed(now time.Time) time.Duration {
+	return now.Sub(r.started).Round(time.Second)
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+
+	return value
+}
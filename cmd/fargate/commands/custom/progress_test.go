@@ -0,0 +1,59 @@
+package custom
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+)
+
+func TestProgressReport(t *testing.T) {
+	now := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		return now
+	}
+
+	output := new(strings.Builder)
+	report := newProgressReport(output, clock)
+
+	report.startSection("fargate_task_status", "Waiting for the AWS Fargate task", false)
+	for _, status := range []string{"PROVISIONING", "PROVISIONING", "PENDING", "RUNNING"} {
+		now = now.Add(5 * time.Second)
+		report.taskStatus(status)
+	}
+	report.endSection("fargate_task_status")
+
+	report.startSection("fargate_task_details", "AWS Fargate task details", true)
+	report.taskDetails("cluster", "task-arn", &aws.TaskDescription{
+		AvailabilityZone: "us-east-1a",
+		Architecture:     "arm64",
+		CPU:              "1024",
+		Memory:           "2048",
+	})
+	report.endSection("fargate_task_details")
+
+	now = now.Add(1500 * time.Millisecond)
+	report.ready()
+
+	assert.Equal(t, ""+
+		"\033[0Ksection_start:1583323200:fargate_task_status\r\033[0KWaiting for the AWS Fargate task\n"+
+		"[12:00:05Z] PROVISIONING  (+5s)\n"+
+		"[12:00:15Z] PENDING       (+15s)\n"+
+		"[12:00:20Z] RUNNING       (+20s)\n"+
+		"\033[0Ksection_end:1583323220:fargate_task_status\r\033[0K\n"+
+		"\033[0Ksection_start:1583323220:fargate_task_details[collapsed=true]\r\033[0KAWS Fargate task details\n"+
+		"Cluster:           cluster\n"+
+		"Task ARN:          task-arn\n"+
+		"Availability zone: us-east-1a\n"+
+		"Architecture:      arm64\n"+
+		"CPU:               1024 units\n"+
+		"Memory:            2048 MiB\n"+
+		"Image digest:      unknown\n"+
+		"\033[0Ksection_end:1583323220:fargate_task_details\r\033[0K\n"+
+		"AWS Fargate task ready in 22s\n",
+		output.String(),
+	)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
@@ -11,6 +13,7 @@ import (
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
 )
 
@@ -22,15 +25,15 @@ func NewRunCommand() cli.Command {
 	cmd := new(RunCommand)
 	cmd.abstractCustomCommand.customCommand = cmd
 
-	cmd.newMetadataManager = func(logger logging.Logger, directory string) task.MetadataManager {
-		return task.NewMetadataManager(logger, directory)
-	}
+	cmd.newMetadataManager = task.NewMetadataManagerForConfig
 	cmd.newExecutor = func(logger logging.Logger) executors.Executor {
 		return ssh.NewExecutor(logger)
 	}
 	cmd.newFS = func() fs.FS {
 		return fs.NewOS()
 	}
+	cmd.runLocal = runLocalCommand
+	cmd.retryDelay = stageRetryDelay
 
 	return cli.Command{
 		Handler: cmd,
@@ -60,6 +63,8 @@ type RunCommand struct {
 	abstractCustomCommand
 
 	cfg    config.Global
+	job    runner.JobContext
+	policy config.Stage
 	logger logging.Logger
 
 	metadataManager task.MetadataManager
@@ -67,9 +72,11 @@ type RunCommand struct {
 	fs              fs.FS
 
 	// Wrapping constructors to make easier mocking in the unit tests
-	newMetadataManager func(logger logging.Logger, directory string) task.MetadataManager
+	newMetadataManager func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error)
 	newExecutor        func(logger logging.Logger) executors.Executor
 	newFS              func() fs.FS
+	runLocal           func(ctx context.Context, stdin io.Reader, stdout io.Writer, name string, args ...string) error
+	retryDelay         func(attempt int) time.Duration
 }
 
 // CustomExecute is the "core" of the implementation for the "run" stage
@@ -79,12 +86,16 @@ func (c *RunCommand) CustomExecute(ctx *cli.Context) error {
 		return ErrMissingRequiredArguments
 	}
 
-	c.init(ctx)
+	err := c.init(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing RunCommand: %w", err)
+	}
 
 	c.logger.Info("Executing the command")
 
 	args := ctx.Cli.Args()
 	scriptPath := args.Get(0)
+	stage := args.Get(1)
 
 	script, err := c.readFileContent(scriptPath)
 	if err != nil {
@@ -96,7 +107,40 @@ func (c *RunCommand) CustomExecute(ctx *cli.Context) error {
 		return fmt.Errorf("obtaining information about the running task: %w", err)
 	}
 
-	err = c.executeScriptOnTaskContainer(ctx.Ctx, taskData, c.cfg.SSH, script)
+	stageCtx := ctx.Ctx
+	if c.policy.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithTimeout(stageCtx, c.policy.Timeout.Duration)
+		defer cancel()
+	}
+
+	err = c.executeStage(stageCtx, taskData, stage, scriptPath, script)
+
+	// The interrupted scripts don't fail, so the timeout is detected on the
+	// context. Only the timeout of the stage is a failure of the build
+	if errors.Is(stageCtx.Err(), context.DeadlineExceeded) && ctx.Ctx.Err() == nil {
+		return runner.NewBuildFailureError(fmt.Errorf("%w: %q exceeded %s", ErrStageTimeout, stage, c.policy.Timeout.Duration))
+	}
+
+	return err
+}
+
+func (c *RunCommand) executeStage(ctx context.Context, taskData task.Data, stage string, scriptPath string, script []byte) error {
+	if c.isHostStage(stage) {
+		err := c.executeOnHost(ctx, taskData, stage, scriptPath, script)
+		if err != nil {
+			return fmt.Errorf("executing the %q stage on the Runner host: %w", stage, err)
+		}
+
+		return nil
+	}
+
+	err := c.syncFiles(ctx, taskData, script)
+	if err != nil {
+		return fmt.Errorf("syncing the files of the file-type variables: %w", err)
+	}
+
+	err = c.executeScriptOnTaskContainer(ctx, taskData, c.cfg.SSH, script)
 	if err != nil {
 		return fmt.Errorf("executing the script on the remote host: %w", err)
 	}
@@ -104,8 +148,10 @@ func (c *RunCommand) CustomExecute(ctx *cli.Context) error {
 	return nil
 }
 
-func (c *RunCommand) init(ctx *cli.Context) {
+func (c *RunCommand) init(ctx *cli.Context) error {
 	c.cfg = ctx.Config()
+	c.job = ctx.JobContext()
+	c.policy = c.cfg.Stage(ctx.Cli.Args().Get(1))
 	c.logger = ctx.
 		Logger().
 		WithFields(logging.Fields{
@@ -114,8 +160,15 @@ func (c *RunCommand) init(ctx *cli.Context) {
 		})
 
 	c.sshExecutor = c.newExecutor(c.logger)
-	c.metadataManager = c.newMetadataManager(c.logger, c.cfg.TaskMetadata.Directory)
 	c.fs = c.newFS()
+
+	var err error
+	c.metadataManager, err = c.newMetadataManager(c.logger, c.cfg.TaskMetadata, c.cfg.Fargate.Region)
+	if err != nil {
+		return fmt.Errorf("initializing metadata manager: %w", err)
+	}
+
+	return nil
 }
 
 func (c *RunCommand) readFileContent(filePath string) ([]byte, error) {
@@ -147,25 +200,128 @@ func (c *RunCommand) executeScriptOnTaskContainer(ctx context.Context, taskData
 		WithField("taskARN", taskData.TaskARN).
 		Info("Executing script in the task container")
 
-	port := sshConfig.Port
-	if port < 1 {
-		port = executors.DefaultPort
-	}
+	settings := newConnectionSettings(taskData, sshConfig)
 
-	settings := executors.ConnectionSettings{
-		Hostname:   taskData.ContainerIP,
-		Port:       port,
-		Username:   sshConfig.Username,
-		PrivateKey: taskData.PrivateKey,
+	// The shell lines are added only to the scripts of the default shell.
+	// The interpreters get the variables through the session environment
+	var interpreter []byte
+	if c.policy.Interpreter == "" {
+		script = withSecrets(taskData.SecretsFile, withTaskVariables(taskData, c.cfg.Fargate.Cluster, script))
+	} else {
+		settings.Environment = taskEnvironment(taskData, c.cfg.Fargate.Cluster)
+		interpreter = interpreterCommand(taskData.SecretsFile, c.policy.Interpreter)
 	}
 
 	runCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	err := c.sshExecutor.Execute(runCtx, settings, script)
+	err := c.executeWithRetries(runCtx, settings, interpreter, script)
 	if err != nil {
 		return fmt.Errorf("executing script on container with IP %q: %w", taskData.ContainerIP, err)
 	}
 
 	return nil
 }
+
+// syncFiles uploads the files of the file-type variables, and the CA file of
+// the GitLab instance, to the same paths in the task container, as the Runner
+// writes them on its host. Only the files of the temporary directory of the
+// project, named like their variables, are synced. The ones that are not
+// regular files on the host, like the symbolic links, are ignored, as well as
+// the files synced by a previous stage
+func (c *RunCommand) syncFiles(ctx context.Context, taskData task.Data, script []byte) error {
+	files := fileVariablePaths(script)
+
+	tmpDir, ok := fileVariablesDir(script)
+	if ok && isFileVariablePath(tmpDir, "CI_SERVER_TLS_CA_FILE", c.job.TLSCAFile) {
+		files = append(files, c.job.TLSCAFile)
+	}
+
+	settings := newConnectionSettings(taskData, c.cfg.SSH)
+
+	var synced []string
+	for _, file := range files {
+		if containsString(taskData.SyncedFiles, file) || containsString(synced, file) {
+			continue
+		}
+
+		regular, err := c.fs.IsRegularFile(file)
+		if err != nil {
+			return fmt.Errorf("checking file %q: %w", file, err)
+		}
+
+		if !regular {
+			continue
+		}
+
+		content, err := c.readFileContent(file)
+		if err != nil {
+			return err
+		}
+
+		err = c.sshExecutor.Upload(ctx, settings, file, content)
+		if err != nil {
+			return fmt.Errorf("uploading file %q: %w", file, err)
+		}
+
+		synced = append(synced, file)
+	}
+
+	if len(synced) == 0 {
+		return nil
+	}
+
+	c.logger.
+		WithField("files", synced).
+		Info("Synced files to the task container")
+
+	taskData.SyncedFiles = append(taskData.SyncedFiles, synced...)
+
+	err := c.metadataManager.Persist(taskData)
+	if err != nil {
+		return fmt.Errorf("recording the synced files: %w", err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withSecrets prepends the sourcing of the secrets delivered by the
+// "prepare" stage to the script. The script fails when the file is missing
+func withSecrets(secretsFile string, script []byte) []byte {
+	if secretsFile == "" {
+		return script
+	}
+
+	source := fmt.Sprintf(". %s\n", executors.ShellQuote(secretsFile))
+
+	return append([]byte(source), script...)
+}
+
+// newConnectionSettings describes the connection to the SSH service of the
+// task container
+func newConnectionSettings(taskData task.Data, sshConfig config.SSH) executors.ConnectionSettings {
+	port := sshConfig.Port
+	if port < 1 {
+		port = executors.DefaultPort
+	}
+
+	return executors.ConnectionSettings{
+		Hostname:   taskData.ContainerIP,
+		Port:       port,
+		Username:   sshConfig.Username,
+		PrivateKey: taskData.PrivateKey,
+
+		HostKeyFingerprint: taskData.HostKeyFingerprint,
+		LeaseDuration:      taskData.LeaseDuration,
+	}
+}
@@ -125,8 +125,8 @@ func TestCustomExecuteRun(t *testing.T) {
 			setExpectationForExecuteScript(mockExecutor, shouldCallExecuteScript, tt)
 
 			run := new(RunCommand)
-			run.newMetadataManager = func(logger logging.Logger, directory string) task.MetadataManager {
-				return mockMetadataManager
+			run.newMetadataManager = func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error) {
+				return mockMetadataManager, nil
 			}
 			run.newExecutor = func(logger logging.Logger) executors.Executor {
 				return mockExecutor
@@ -150,6 +150,121 @@ func TestCustomExecuteRun(t *testing.T) {
 	}
 }
 
+func TestRunCommand_syncFiles(t *testing.T) {
+	testContext := context.Background()
+	testError := errors.New("simulated error")
+	testTask := task.Data{TaskARN: "task-arn", ContainerIP: "1.2.3.4"}
+	testScript := []byte(`export CI_PROJECT_DIR=/builds/group/project
+export KUBECONFIG=$'/builds/group/project.tmp/KUBECONFIG'
+export RUNNER_CONFIG=$'/etc/gitlab-runner/config.toml'
+export CI_SERVER_TLS_CA_FILE=$'/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE'
+`)
+	testCAFile := "/builds/group/project.tmp/CI_SERVER_TLS_CA_FILE"
+
+	tests := map[string]struct {
+		syncedFiles         []string
+		tlsCAFile           string
+		notRegular          bool
+		regularFileError    error
+		readFileError       error
+		uploadError         error
+		persistError        error
+		expectedUploads     []string
+		expectedSyncedFiles []string
+		expectedError       error
+	}{
+		"Files synced": {
+			tlsCAFile:           testCAFile,
+			expectedUploads:     []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+			expectedSyncedFiles: []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+		},
+		"CA file outside of the temporary directory": {
+			tlsCAFile:           "/etc/gitlab-runner/config.toml",
+			expectedUploads:     []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+			expectedSyncedFiles: []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+		},
+		"Files not regular": {
+			notRegular:      true,
+			expectedUploads: []string{},
+		},
+		"Files synced by a previous stage": {
+			syncedFiles:     []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+			expectedUploads: []string{},
+		},
+		"Some files synced by a previous stage": {
+			syncedFiles:         []string{testCAFile},
+			expectedUploads:     []string{"/builds/group/project.tmp/KUBECONFIG"},
+			expectedSyncedFiles: []string{testCAFile, "/builds/group/project.tmp/KUBECONFIG"},
+		},
+		"Error on checking a file": {
+			regularFileError: testError,
+			expectedError:    testError,
+		},
+		"Error on reading a file": {
+			readFileError: testError,
+			expectedError: testError,
+		},
+		"Error on uploading a file": {
+			uploadError:     testError,
+			expectedUploads: []string{"/builds/group/project.tmp/KUBECONFIG"},
+			expectedError:   testError,
+		},
+		"Error on recording the synced files": {
+			persistError:        testError,
+			expectedUploads:     []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+			expectedSyncedFiles: []string{"/builds/group/project.tmp/KUBECONFIG", testCAFile},
+			expectedError:       testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockFS := new(fs.MockFS)
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			mockMetadataManager := new(task.MockMetadataManager)
+			defer mockMetadataManager.AssertExpectations(t)
+
+			mockFS.On("IsRegularFile", mock.Anything).Return(!tt.notRegular, tt.regularFileError)
+			mockFS.On("ReadFile", mock.Anything).Return([]byte("content"), tt.readFileError)
+
+			for _, file := range tt.expectedUploads {
+				mockExecutor.On("Upload", testContext, mock.Anything, file, []byte("content")).
+					Return(tt.uploadError).
+					Once()
+			}
+
+			if tt.expectedSyncedFiles != nil {
+				expectedData := testTask
+				expectedData.SyncedFiles = tt.expectedSyncedFiles
+
+				mockMetadataManager.On("Persist", expectedData).
+					Return(tt.persistError).
+					Once()
+			}
+
+			taskData := testTask
+			taskData.SyncedFiles = tt.syncedFiles
+
+			run := new(RunCommand)
+			run.job.TLSCAFile = tt.tlsCAFile
+			run.logger = test.NewNullLogger()
+			run.fs = mockFS
+			run.sshExecutor = mockExecutor
+			run.metadataManager = mockMetadataManager
+
+			err := run.syncFiles(testContext, taskData, testScript)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
 func setExpectationForReadScriptFile(mockFS *fs.MockFS, shouldCall bool, testParams runCommandTestCase) {
 	if !shouldCall {
 		return
@@ -198,7 +313,9 @@ func setExpectationForExecuteScript(mockExecutor *executors.MockExecutor, should
 		return true
 	})
 
-	mockExecutor.On("Execute", contextMatcher, expectedConnectionSettings, testParams.scriptContent).
+	expectedScript := withTaskVariables(testParams.task, "", testParams.scriptContent)
+
+	mockExecutor.On("Execute", contextMatcher, expectedConnectionSettings, expectedScript).
 		Return(testParams.executeScriptError).
 		Once()
 }
@@ -229,3 +346,10 @@ func createContextForRunCmdTests(t *testing.T, testParams runCommandTestCase) *c
 
 	return &ctx
 }
+
+func TestWithSecrets(t *testing.T) {
+	script := []byte("echo 1\n")
+
+	assert.Equal(t, script, withSecrets("", script))
+	assert.Equal(t, ". '/dev/shm/secrets.env'\necho 1\n", string(withSecrets("/dev/shm/secrets.env", script)))
+}
@@ -0,0 +1,89 @@
+package custom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+)
+
+// ErrStageTimeout is returned when a stage exceeds the timeout of its
+// [Stages.<name>] section
+var ErrStageTimeout = errors.New("stage timed out")
+
+const (
+	stageRetryBaseDelay = 2 * time.Second
+	stageRetryMaxDelay  = 30 * time.Second
+)
+
+// executeWithRetries executes the script in the task container, retrying it
+// after the transport errors as many times as the stage allows, with an
+// exponential backoff. The scripts exiting with a non-zero status, and the
+// canceled ones, are never retried
+func (c *RunCommand) executeWithRetries(ctx context.Context, settings executors.ConnectionSettings, interpreter []byte, script []byte) error {
+	attempts := 1 + c.policy.Retries
+
+	for attempt := 1; ; attempt++ {
+		err := c.executeWithInterpreter(ctx, settings, interpreter, script)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= attempts || errors.Is(err, executors.ErrScriptFailed) || ctx.Err() != nil {
+			return err
+		}
+
+		c.logger.
+			WithError(err).
+			WithField("attempt", attempt).
+			Warning("Retrying the stage after a transport error")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(c.retryDelay(attempt)):
+		}
+	}
+}
+
+// stageRetryDelay doubles the delay after each failed attempt
+func stageRetryDelay(attempt int) time.Duration {
+	delay := stageRetryBaseDelay
+	for i := 1; i < attempt && delay < stageRetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > stageRetryMaxDelay {
+		return stageRetryMaxDelay
+	}
+
+	return delay
+}
+
+// executeWithInterpreter executes the script with the shell of the SSH
+// service, or pipes it to the interpreter command when one is given
+func (c *RunCommand) executeWithInterpreter(ctx context.Context, settings executors.ConnectionSettings, interpreter []byte, script []byte) error {
+	if interpreter == nil {
+		return c.sshExecutor.Execute(ctx, settings, script)
+	}
+
+	return c.sshExecutor.Stream(ctx, settings, interpreter, bytes.NewReader(script), os.Stdout)
+}
+
+// hostInterpreter returns the command line executing the scripts of the
+// stage on the Runner host
+func hostInterpreter(policy config.Stage, hostStages config.HostStages) []string {
+	switch {
+	case strings.TrimSpace(policy.Interpreter) != "":
+		return strings.Fields(policy.Interpreter)
+	case hostStages.Shell != "":
+		return []string{hostStages.Shell}
+	}
+
+	return []string{defaultHostShell}
+}
@@ -0,0 +1,250 @@
+package custom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestRunCommand_executeWithRetries(t *testing.T) {
+	testContext := context.Background()
+	testSettings := executors.ConnectionSettings{Hostname: "1.2.3.4"}
+	testScript := []byte("echo 1\n")
+	testInterpreter := []byte("exec /bin/sh -e\n")
+	testTransportError := errors.New("connection lost")
+	testScriptError := executors.ErrScriptFailed
+
+	tests := map[string]struct {
+		policy         config.Stage
+		interpreter    []byte
+		errors         []error
+		useStream      bool
+		expectedDelays []int
+		expectedError  error
+	}{
+		"Executed with success": {
+			errors: []error{nil},
+		},
+		"Transport error not retried by default": {
+			errors:        []error{testTransportError},
+			expectedError: testTransportError,
+		},
+		"Transport error retried": {
+			policy:         config.Stage{Retries: 2},
+			errors:         []error{testTransportError, testTransportError, nil},
+			expectedDelays: []int{1, 2},
+		},
+		"Retries exhausted": {
+			policy:         config.Stage{Retries: 1},
+			errors:         []error{testTransportError, testTransportError},
+			expectedDelays: []int{1},
+			expectedError:  testTransportError,
+		},
+		"Script failure not retried": {
+			policy:        config.Stage{Retries: 2},
+			errors:        []error{testScriptError},
+			expectedError: executors.ErrScriptFailed,
+		},
+		"Script piped to the interpreter": {
+			interpreter: testInterpreter,
+			errors:      []error{nil},
+			useStream:   true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockExecutor := new(executors.MockExecutor)
+			defer mockExecutor.AssertExpectations(t)
+
+			for _, err := range tt.errors {
+				if tt.useStream {
+					mockExecutor.
+						On("Stream", testContext, testSettings, testInterpreter, bytes.NewReader(testScript), os.Stdout).
+						Return(err).
+						Once()
+
+					continue
+				}
+
+				mockExecutor.On("Execute", testContext, testSettings, testScript).
+					Return(err).
+					Once()
+			}
+
+			var delays []int
+
+			run := new(RunCommand)
+			run.policy = tt.policy
+			run.logger = test.NewNullLogger()
+			run.sshExecutor = mockExecutor
+			run.retryDelay = func(attempt int) time.Duration {
+				delays = append(delays, attempt)
+				return 0
+			}
+
+			err := run.executeWithRetries(testContext, testSettings, tt.interpreter, testScript)
+			assert.Equal(t, tt.expectedDelays, delays)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRunCommand_executeWithRetries_CanceledBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSettings := executors.ConnectionSettings{Hostname: "1.2.3.4"}
+	testScript := []byte("echo 1\n")
+	testTransportError := errors.New("connection lost")
+
+	mockExecutor := new(executors.MockExecutor)
+	defer mockExecutor.AssertExpectations(t)
+
+	mockExecutor.On("Execute", ctx, testSettings, testScript).
+		Return(testTransportError).
+		Once()
+
+	run := new(RunCommand)
+	run.policy = config.Stage{Retries: 2}
+	run.logger = test.NewNullLogger()
+	run.sshExecutor = mockExecutor
+	run.retryDelay = func(int) time.Duration {
+		cancel()
+		return time.Hour
+	}
+
+	err := run.executeWithRetries(ctx, testSettings, nil, testScript)
+	assertions.ErrorIs(t, err, testTransportError)
+}
+
+func TestRunCommand_executeScriptOnTaskContainer_Interpreter(t *testing.T) {
+	testScript := []byte("print(1)\n")
+	testTask := task.Data{
+		TaskARN:     "task-arn",
+		ContainerIP: "1.2.3.4",
+		SecretsFile: "/dev/shm/secrets.env",
+	}
+
+	expectedSettings := newConnectionSettings(testTask, config.SSH{})
+	expectedSettings.Environment = map[string]string{
+		"FARGATE_TASK_ARN": "task-arn",
+		"FARGATE_CLUSTER":  "cluster",
+	}
+
+	mockExecutor := new(executors.MockExecutor)
+	defer mockExecutor.AssertExpectations(t)
+
+	mockExecutor.
+		On("Stream", mock.Anything, expectedSettings, interpreterCommand(testTask.SecretsFile, "python3"), bytes.NewReader(testScript), os.Stdout).
+		Return(nil).
+		Once()
+
+	run := new(RunCommand)
+	run.cfg = config.Global{Fargate: config.Fargate{Cluster: "cluster"}}
+	run.policy = config.Stage{Interpreter: "python3"}
+	run.logger = test.NewNullLogger()
+	run.sshExecutor = mockExecutor
+
+	err := run.executeScriptOnTaskContainer(context.Background(), testTask, config.SSH{}, testScript)
+	assert.NoError(t, err)
+}
+
+func TestStageRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1: 2 * time.Second,
+		2: 4 * time.Second,
+		4: 16 * time.Second,
+		5: 30 * time.Second,
+		8: 30 * time.Second,
+	}
+
+	for attempt, expectedDelay := range tests {
+		assert.Equal(t, expectedDelay, stageRetryDelay(attempt), "attempt: %d", attempt)
+	}
+}
+
+func TestRunCommand_stageTimeout(t *testing.T) {
+	tt := runCommandTestCase{
+		context:                 context.Background(),
+		setCommandLineArguments: true,
+		scriptPath:              "/path/to/script",
+		scriptContent:           []byte("test script"),
+		task:                    task.Data{ContainerIP: "1.2.3.4"},
+	}
+
+	mockMetadataManager := new(task.MockMetadataManager)
+	defer mockMetadataManager.AssertExpectations(t)
+
+	mockExecutor := new(executors.MockExecutor)
+	defer mockExecutor.AssertExpectations(t)
+
+	mockFS := new(fs.MockFS)
+	defer mockFS.AssertExpectations(t)
+
+	setExpectationForReadScriptFile(mockFS, true, tt)
+	setExpectationForReadMetadata(mockMetadataManager, true, tt)
+
+	// The interrupted scripts return without errors
+	mockExecutor.On("Execute", mock.Anything, mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, _ executors.ConnectionSettings, _ []byte) error {
+			<-ctx.Done()
+			return nil
+		}).
+		Once()
+
+	run := new(RunCommand)
+	run.newMetadataManager = func(logger logging.Logger, cfg config.TaskMetadata, region string) (task.MetadataManager, error) {
+		return mockMetadataManager, nil
+	}
+	run.newExecutor = func(logger logging.Logger) executors.Executor {
+		return mockExecutor
+	}
+	run.newFS = func() fs.FS {
+		return mockFS
+	}
+
+	ctx := createContextForRunCmdTests(t, tt)
+	ctx.SetConfig(config.Global{
+		Stages: map[string]config.Stage{
+			"prepare_exec": {Timeout: config.Duration{Duration: 10 * time.Millisecond}},
+		},
+	})
+
+	err := run.CustomExecute(ctx)
+	assertions.ErrorIs(t, err, ErrStageTimeout)
+
+	var buildFailure *runner.BuildFailureError
+	assert.True(t, errors.As(err, &buildFailure), "The timeout should fail the build")
+}
+
+func TestHostInterpreter(t *testing.T) {
+	assert.Equal(t, []string{"bash"}, hostInterpreter(config.Stage{}, config.HostStages{}))
+	assert.Equal(t, []string{"/bin/sh"}, hostInterpreter(config.Stage{}, config.HostStages{Shell: "/bin/sh"}))
+	assert.Equal(
+		t,
+		[]string{"bash", "-e", "-o", "pipefail"},
+		hostInterpreter(config.Stage{Interpreter: "bash -e -o pipefail"}, config.HostStages{Shell: "/bin/sh"}),
+	)
+}
@@ -0,0 +1,23 @@
+// Package metadata provides the commands maintaining the task metadata
+// stored by the Custom Executor stages
+package metadata
+
+import (
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+// NewMetadataCategory groups the commands maintaining the task metadata
+func NewMetadataCategory() cli.Category {
+	return cli.Category{
+		Config: cli.Config{
+			Name:    "metadata",
+			Aliases: []string{"m"},
+			Usage:   "Maintenance of the task metadata storage",
+			Description: `These commands operate on the metadata of all the jobs stored in the
+backend configured in the [TaskMetadata] section.`,
+		},
+		SubCommands: []cli.Command{
+			NewReencryptCommand(),
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+// ErrEncryptionDisabled is returned when no encryption provider is configured
+var ErrEncryptionDisabled = errors.New("encryption provider is not configured")
+
+// NewReencryptCommand constructs the command line abstraction for the "reencrypt" command
+func NewReencryptCommand() cli.Command {
+	cmd := new(ReencryptCommand)
+
+	cmd.newStore = task.NewStoreForConfig
+	cmd.newEncrypter = encryption.NewEncrypterForConfig
+	cmd.reencrypt = task.Reencrypt
+
+	return cli.Command{
+		Handler: cmd,
+		Config: cli.Config{
+			Name:  "reencrypt",
+			Usage: "Re-encrypt the stored private keys with the current key",
+			Description: `
+After adding a new key to the key file, adding a new key to the kernel
+keyring or changing the KMS key, this command encrypts again the private
+keys of all the stored records with the current key. Records stored in
+plaintext, before the encryption was enabled, are encrypted too.
+
+The previous keys must stay available until this command succeeds.`,
+		},
+	}
+}
+
+// ReencryptCommand provides data and operations related to the "reencrypt" command
+type ReencryptCommand struct {
+	// Wrapping constructors to make easier mocking in the unit tests
+	newStore     func(cfg config.TaskMetadata, defaultRegion string) (task.Store, error)
+	newEncrypter func(cfg config.Encryption, defaultRegion string) (encryption.Encrypter, error)
+	reencrypt    func(logger logging.Logger, store task.Store, encrypter encryption.Encrypter) (task.ReencryptResult, error)
+}
+
+// Execute re-encrypts the records of the configured metadata storage
+func (c *ReencryptCommand) Execute(ctx *cli.Context) error {
+	cfg := ctx.Config()
+	logger := ctx.Logger().WithField("command", "metadata_reencrypt")
+
+	if cfg.TaskMetadata.Encryption.Provider == "" {
+		return ErrEncryptionDisabled
+	}
+
+	store, err := c.newStore(cfg.TaskMetadata, cfg.Fargate.Region)
+	if err != nil {
+		return fmt.Errorf("creating metadata store: %w", err)
+	}
+
+	encrypter, err := c.newEncrypter(cfg.TaskMetadata.Encryption, cfg.Fargate.Region)
+	if err != nil {
+		return fmt.Errorf("creating metadata encrypter: %w", err)
+	}
+
+	logger.Info("Re-encrypting metadata records")
+
+	result, err := c.reencrypt(logger, store, encrypter)
+
+	logger.
+		WithFields(logging.Fields{
+			"reencrypted": result.Reencrypted,
+			"unchanged":   result.Unchanged,
+			"failed":      result.Failed,
+		}).
+		Info("Re-encryption finished")
+
+	if err != nil {
+		return fmt.Errorf("re-encrypting metadata records: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestNewReencryptCommand(t *testing.T) {
+	cmd := NewReencryptCommand()
+
+	assert.NotNil(t, cmd, "Command should be created")
+	assert.NotNil(t, cmd.Handler, "Handler should be created")
+}
+
+func TestReencryptCommand_Execute(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		provider            string
+		newStoreError       error
+		newEncrypterError   error
+		reencryptError      error
+		shouldCallReencrypt bool
+		expectedError       error
+	}{
+		"Re-encrypt with success": {
+			provider:            config.EncryptionProviderKeyFile,
+			shouldCallReencrypt: true,
+		},
+		"Encryption not configured": {
+			expectedError: ErrEncryptionDisabled,
+		},
+		"Error creating the store": {
+			provider:      config.EncryptionProviderKeyFile,
+			newStoreError: testError,
+			expectedError: testError,
+		},
+		"Error creating the encrypter": {
+			provider:          config.EncryptionProviderKeyFile,
+			newEncrypterError: testError,
+			expectedError:     testError,
+		},
+		"Error re-encrypting records": {
+			provider:            config.EncryptionProviderKeyFile,
+			reencryptError:      task.ErrReencryptionFailed,
+			shouldCallReencrypt: true,
+			expectedError:       task.ErrReencryptionFailed,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockStore := new(task.MockStore)
+			mockEncrypter := new(encryption.MockEncrypter)
+			reencryptCalled := false
+
+			cmd := new(ReencryptCommand)
+			cmd.newStore = func(cfg config.TaskMetadata, defaultRegion string) (task.Store, error) {
+				assert.Equal(t, "us-east-1", defaultRegion)
+				return mockStore, tt.newStoreError
+			}
+			cmd.newEncrypter = func(cfg config.Encryption, defaultRegion string) (encryption.Encrypter, error) {
+				return mockEncrypter, tt.newEncrypterError
+			}
+			cmd.reencrypt = func(logger logging.Logger, store task.Store, encrypter encryption.Encrypter) (task.ReencryptResult, error) {
+				reencryptCalled = true
+				assert.Equal(t, mockStore, store)
+				assert.Equal(t, mockEncrypter, encrypter)
+
+				return task.ReencryptResult{}, tt.reencryptError
+			}
+
+			ctx := new(cli.Context)
+			ctx.SetConfig(config.Global{
+				Fargate: config.Fargate{Region: "us-east-1"},
+				TaskMetadata: config.TaskMetadata{
+					Encryption: config.Encryption{Provider: tt.provider},
+				},
+			})
+			ctx.SetLogger(test.NewNullLogger())
+
+			err := cmd.Execute(ctx)
+			assert.Equal(t, tt.shouldCallReencrypt, reencryptCalled)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
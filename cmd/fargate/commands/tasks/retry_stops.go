@@ -0,0 +1,83 @@
+package tasks
+
+import (
+	"fmt"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+// NewRetryStopsCommand constructs the command line abstraction for the "retry-stops" command
+func NewRetryStopsCommand() cli.Command {
+	cmd := new(RetryStopsCommand)
+
+	cmd.newFargate = func(logger logging.Logger, awsRegion string) aws.Fargate {
+		return aws.NewFargate(logger, awsRegion)
+	}
+	cmd.newStopQueue = task.NewStopQueue
+
+	return cli.Command{
+		Handler: cmd,
+		Config: cli.Config{
+			Name:  "retry-stops",
+			Usage: "Retry stopping the tasks the cleanup stage couldn't stop",
+			Description: `
+The tasks that the "cleanup" stage couldn't stop are queued in the
+metadata directory, or in a directory of the host when the metadata is
+stored remotely, and stopping them is retried by the next executions of
+the "prepare" and "cleanup" stages. This command retries them without
+waiting for a job.
+
+The delay between the attempts of each task doubles after every failure.
+Tasks still not stopped after the last attempt are removed from the
+queue and reported, and the command fails. They must be stopped manually.`,
+		},
+	}
+}
+
+// RetryStopsCommand provides data and operations related to the "retry-stops" command
+type RetryStopsCommand struct {
+	// Wrapping constructors to make easier mocking in the unit tests
+	newFargate   func(logger logging.Logger, awsRegion string) aws.Fargate
+	newStopQueue func(logger logging.Logger, directory string) (task.StopQueue, error)
+}
+
+// Execute retries the queued stops whose backoff elapsed
+func (c *RetryStopsCommand) Execute(ctx *cli.Context) error {
+	cfg := ctx.Config()
+	logger := ctx.Logger().WithField("command", "tasks_retry_stops")
+
+	queue, err := c.newStopQueue(logger, task.StopQueueDirectory(cfg.TaskMetadata))
+	if err != nil {
+		return fmt.Errorf("creating stop queue: %w", err)
+	}
+
+	fargate := c.newFargate(logger, cfg.Fargate.Region)
+	err = fargate.Init()
+	if err != nil {
+		return fmt.Errorf("initializing Fargate adapter: %w", err)
+	}
+
+	logger.Info("Retrying the queued stops of Fargate tasks")
+
+	result, err := queue.Drain(task.IgnoreTaskNotFound(func(request task.StopRequest) error {
+		return fargate.StopTask(ctx.Ctx, request.TaskARN, request.Cluster, request.Reason)
+	}))
+
+	logger.
+		WithFields(logging.Fields{
+			"stopped":   len(result.Stopped),
+			"failed":    len(result.Failed),
+			"exhausted": len(result.Exhausted),
+			"waiting":   result.Waiting,
+		}).
+		Info("Retrying finished")
+
+	if err != nil {
+		return fmt.Errorf("retrying the queued stops: %w", err)
+	}
+
+	return nil
+}
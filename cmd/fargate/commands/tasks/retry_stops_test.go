@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/task"
+)
+
+func TestNewRetryStopsCommand(t *testing.T) {
+	cmd := NewRetryStopsCommand()
+
+	assert.NotNil(t, cmd, "Command should be created")
+	assert.NotNil(t, cmd.Handler, "Handler should be created")
+}
+
+func TestRetryStopsCommand_Execute(t *testing.T) {
+	testError := errors.New("simulated error")
+	queued := task.StopRequest{TaskARN: "task-arn", Cluster: "cluster", Reason: "Job finished"}
+
+	tests := map[string]struct {
+		stopQueueInitError error
+		fargateInitError   error
+		stopTaskError      error
+		drainError         error
+		shouldCallDrain    bool
+		expectedError      error
+	}{
+		"Retry the stops with success": {
+			shouldCallDrain: true,
+		},
+		"Stop queue not configured": {
+			stopQueueInitError: task.ErrStopQueueNotConfigured,
+			expectedError:      task.ErrStopQueueNotConfigured,
+		},
+		"Error during Fargate init": {
+			fargateInitError: testError,
+			expectedError:    testError,
+		},
+		"Tasks couldn't be stopped after the last attempt": {
+			stopTaskError:   testError,
+			drainError:      task.ErrStopAttemptsExhausted,
+			shouldCallDrain: true,
+			expectedError:   task.ErrStopAttemptsExhausted,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			testContext, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mockAwsFargate := new(aws.MockFargate)
+			defer mockAwsFargate.AssertExpectations(t)
+
+			mockStopQueue := new(task.MockStopQueue)
+			defer mockStopQueue.AssertExpectations(t)
+
+			if tt.stopQueueInitError == nil {
+				mockAwsFargate.On("Init").
+					Return(tt.fargateInitError).
+					Once()
+			}
+
+			if tt.shouldCallDrain {
+				mockAwsFargate.On("StopTask", testContext, queued.TaskARN, queued.Cluster, queued.Reason).
+					Return(tt.stopTaskError).
+					Once()
+
+				mockStopQueue.On("Drain", mock.AnythingOfType("task.StopFunc")).
+					Run(func(args mock.Arguments) {
+						err := args.Get(0).(task.StopFunc)(queued)
+						assert.Equal(t, tt.stopTaskError, err)
+					}).
+					Return(task.DrainResult{}, tt.drainError).
+					Once()
+			}
+
+			cmd := new(RetryStopsCommand)
+			cmd.newFargate = func(logger logging.Logger, awsRegion string) aws.Fargate {
+				assert.Equal(t, "us-east-1", awsRegion)
+				return mockAwsFargate
+			}
+			cmd.newStopQueue = func(logger logging.Logger, directory string) (task.StopQueue, error) {
+				assert.Equal(t, "/metadata", directory)
+				if tt.stopQueueInitError != nil {
+					return nil, tt.stopQueueInitError
+				}
+
+				return mockStopQueue, nil
+			}
+
+			ctx := new(cli.Context)
+			ctx.Ctx = testContext
+			ctx.SetConfig(config.Global{
+				Fargate:      config.Fargate{Region: "us-east-1"},
+				TaskMetadata: config.TaskMetadata{Directory: "/metadata"},
+			})
+			ctx.SetLogger(test.NewNullLogger())
+
+			err := cmd.Execute(ctx)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package tasks provides the commands maintaining the Fargate tasks started
+// by the Custom Executor stages
+package tasks
+
+import (
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+)
+
+// NewTasksCategory groups the commands maintaining the Fargate tasks
+func NewTasksCategory() cli.Category {
+	return cli.Category{
+		Config: cli.Config{
+			Name:    "tasks",
+			Aliases: []string{"t"},
+			Usage:   "Maintenance of the Fargate tasks started for the jobs",
+			Description: `These commands operate on the Fargate tasks started by the "prepare"
+stage of the jobs.`,
+		},
+		SubCommands: []cli.Command{
+			NewRetryStopsCommand(),
+		},
+	}
+}
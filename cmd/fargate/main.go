@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/cmd/fargate/commands/configuration"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/cmd/fargate/commands/custom"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/cmd/fargate/commands/metadata"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/cmd/fargate/commands/tasks"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
@@ -16,6 +20,16 @@ import (
 
 const (
 	defaultConfigFile = "config.toml"
+
+	// customEnvPrefix starts the names of the CI variables of the job,
+	// passed by the Custom Executor as environment variables
+	customEnvPrefix = "CUSTOM_ENV_"
+
+	// profileVariable selects the profile of the job
+	profileVariable = customEnvPrefix + "FARGATE_PROFILE"
+
+	// defaultExitCode is used by the commands not executed by the Custom Executor
+	defaultExitCode = 1
 )
 
 type globalFlags struct {
@@ -26,8 +40,8 @@ type globalFlags struct {
 
 	ConfigFile string `long:"config" description:"Path to configuration file"`
 
-	TaskDefinition  string `long:"task-def" description:"Task definition" env:"CUSTOM_ENV_FARGATE_TASK_DEFINITION"`
-	PlatformVersion string `long:"platform-version" description:"Fargate platform version" env:"CUSTOM_ENV_FARGATE_PLATFORM_VERSION"`
+	TaskDefinition  string `long:"task-def" description:"Task definition"`
+	PlatformVersion string `long:"platform-version" description:"Fargate platform version"`
 }
 
 var (
@@ -51,8 +65,16 @@ func main() {
 			WithError(err).
 			Error("Application execution failed")
 
-		runner.GetAdapter().GenerateExitFromError(err)
+		exitFromError(err)
+	}
+}
+
+func exitFromError(err error) {
+	if !runner.IsAdapterInitialized() {
+		os.Exit(defaultExitCode)
 	}
+
+	runner.GetAdapter().GenerateExitFromError(err)
 }
 
 func startSignalHandler(logger logging.Logger) context.Context {
@@ -70,8 +92,13 @@ func setUpApplication(ctx context.Context, logger logging.Logger) *cli.App {
 
 		return nil
 	})
+	a.AddBeforeFunc(loadJobContext)
 	a.AddBeforeFunc(loadConfigurationFile)
-	a.AddBeforeFunc(loadCliArgsEnvVars)
+	a.AddBeforeFunc(maskReferencedValues)
+	a.AddBeforeFunc(applyProfile)
+	a.AddBeforeFunc(renderTemplates)
+	a.AddBeforeFunc(applyVariableOverrides)
+	a.AddBeforeFunc(loadCliArgs)
 	a.AddBeforeFunc(updateLogLevel)
 	a.AddBeforeFunc(updateLogFormat)
 	a.AddBeforeFunc(setLoggingToFile)
@@ -84,6 +111,9 @@ func setUpApplication(ctx context.Context, logger logging.Logger) *cli.App {
 	a.AddGlobalFlagsFromStruct(global)
 
 	a.RegisterCategory(custom.NewCustomCategory())
+	a.RegisterCategory(metadata.NewMetadataCategory())
+	a.RegisterCategory(tasks.NewTasksCategory())
+	a.RegisterCategory(configuration.NewConfigCategory())
 
 	return a
 }
@@ -94,24 +124,156 @@ func logStartupMessage(ctx *cli.Context) error {
 		WithFields(logging.Fields{
 			"version": fargate.Version().ShortLine(),
 		}).
+		WithFields(ctx.JobContext().LogFields()).
 		Infof("Starting %s", fargate.NAME)
 
 	return nil
 }
 
+// loadJobContext parses the predefined CI variables of the job once, for
+// the profiles, templates and logging
+func loadJobContext(ctx *cli.Context) error {
+	jobContext, err := runner.NewJobContext()
+	if err != nil {
+		return fmt.Errorf("reading job context: %w", err)
+	}
+
+	for _, variable := range jobContext.IgnoredVariables() {
+		ctx.Logger().
+			WithField("variable", variable).
+			Warning("Ignoring the invalid value of the variable")
+	}
+
+	ctx.SetJobContext(jobContext)
+
+	return nil
+}
+
 func loadConfigurationFile(ctx *cli.Context) error {
+	if configuration.IsConfigurationCommand(ctx.Cli.Args()) {
+		return nil
+	}
+
 	cfg, err := config.LoadFromFile(global.ConfigFile)
 	if err != nil {
 		return err
 	}
 
+	shadowed := cfg.ShadowedSettings()
+	if len(shadowed) > 0 {
+		settings := make([]string, len(shadowed))
+		for i, setting := range shadowed {
+			settings[i] = setting.String()
+		}
+
+		ctx.Logger().
+			WithField("settings", settings).
+			Info("Configuration settings overridden by the later configuration files")
+	}
+
+	ctx.SetConfig(cfg)
+
+	return nil
+}
+
+// maskReferencedValues masks the values resolved from the references of the
+// configuration, like the secrets stored in SSM, in the logs of every command
+func maskReferencedValues(ctx *cli.Context) error {
+	if configuration.IsConfigurationCommand(ctx.Cli.Args()) {
+		return nil
+	}
+
+	ctx.Logger().Mask(ctx.Config().ReferencedValues()...)
+
+	return nil
+}
+
+// applyProfile applies the profile selected by the job, or by the tags of the
+// runner. The CI variables and the command line arguments take precedence
+// over the settings of the profile
+func applyProfile(ctx *cli.Context) error {
+	if configuration.IsConfigurationCommand(ctx.Cli.Args()) {
+		return nil
+	}
+
+	cfg := ctx.Config()
+
+	requested := os.Getenv(profileVariable)
+
+	profile, err := cfg.SelectProfile(requested, ctx.JobContext().RunnerTags)
+	if err != nil {
+		return fmt.Errorf("selecting configuration profile: %w", err)
+	}
+
+	if profile == "" {
+		return nil
+	}
+
+	if requested != "" {
+		cfg, err = cfg.RequestProfile(profile)
+	} else {
+		cfg, err = cfg.WithProfile(profile)
+	}
+	if err != nil {
+		return fmt.Errorf("applying configuration profile: %w", err)
+	}
+
+	ctx.Logger().
+		WithField("profile", profile).
+		Info("Configuration profile applied")
+
+	ctx.SetConfig(cfg)
+
+	return nil
+}
+
+// renderTemplates renders the templated settings with the context of the
+// job. The commands not executed by the Custom Executor render them with an
+// empty context
+func renderTemplates(ctx *cli.Context) error {
+	if configuration.IsConfigurationCommand(ctx.Cli.Args()) {
+		return nil
+	}
+
+	cfg, err := ctx.Config().Render(ctx.JobContext().TemplateData())
+	if err != nil {
+		return fmt.Errorf("rendering configuration templates: %w", err)
+	}
+
+	ctx.SetConfig(cfg)
+
+	return nil
+}
+
+// applyVariableOverrides overrides the settings allowed by the configuration
+// with the FARGATE_* CI variables of the job, passed by the Custom Executor
+func applyVariableOverrides(ctx *cli.Context) error {
+	if configuration.IsConfigurationCommand(ctx.Cli.Args()) {
+		return nil
+	}
+
+	cfg := ctx.Config()
+
+	overridden, err := cfg.ApplyOverrides(func(variable string) string {
+		return os.Getenv(customEnvPrefix + variable)
+	})
+	if err != nil {
+		return fmt.Errorf("overriding configuration with CI variables: %w", err)
+	}
+
+	if len(overridden) > 0 {
+		ctx.Logger().
+			WithField("settings", overridden).
+			Info("Configuration overridden with CI variables")
+	}
+
 	ctx.SetConfig(cfg)
 
 	return nil
 }
 
-func loadCliArgsEnvVars(ctx *cli.Context) error {
-	// Override parameters from config.toml if received by command line or env variable
+func loadCliArgs(ctx *cli.Context) error {
+	// Override parameters from config.toml if received by command line
 	config := ctx.Config()
 
 	if global.TaskDefinition != "" {
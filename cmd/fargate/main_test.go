@@ -1,15 +1,25 @@
 package main
 
 import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	urfave "github.com/urfave/cli"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/cli"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 )
 
-func TestLoadCliArgsEnvVars(t *testing.T) {
+func TestLoadCliArgs(t *testing.T) {
 	originalTaskDefinition := "default-task-def:1"
 	originalPlatformVersion := "LATEST"
 	overrideTaskDefinition := "another-task-def:1"
@@ -21,22 +31,22 @@ func TestLoadCliArgsEnvVars(t *testing.T) {
 		expectedTaskDefinition       string
 		expectedPlatformVersion      string
 	}{
-		"Should keep original values if nothing received by command line or env variable": {
+		"Should keep original values if nothing received by command line": {
 			taskDefinitionOverrideValue: "",
 			expectedTaskDefinition:      originalTaskDefinition,
 			expectedPlatformVersion:     originalPlatformVersion,
 		},
-		"Should override task definition if received by command line or env variable": {
+		"Should override task definition if received by command line": {
 			taskDefinitionOverrideValue: overrideTaskDefinition,
 			expectedTaskDefinition:      overrideTaskDefinition,
 			expectedPlatformVersion:     originalPlatformVersion,
 		},
-		"Should override platform version if received by command line or env variable": {
+		"Should override platform version if received by command line": {
 			platformVersionOverrideValue: overridePlatformVersion,
 			expectedTaskDefinition:       originalTaskDefinition,
 			expectedPlatformVersion:      overridePlatformVersion,
 		},
-		"Should override platform version and task definition if received by command line or env variable": {
+		"Should override platform version and task definition if received by command line": {
 			taskDefinitionOverrideValue:  overrideTaskDefinition,
 			platformVersionOverrideValue: overridePlatformVersion,
 			expectedTaskDefinition:       overrideTaskDefinition,
@@ -60,7 +70,7 @@ func TestLoadCliArgsEnvVars(t *testing.T) {
 			assert.Equal(t, originalTaskDefinition, testContext.Config().Fargate.TaskDefinition)
 			assert.Equal(t, originalPlatformVersion, testContext.Config().Fargate.PlatformVersion)
 
-			err := loadCliArgsEnvVars(testContext)
+			err := loadCliArgs(testContext)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedTaskDefinition, testContext.Config().Fargate.TaskDefinition)
 			assert.Equal(t, tt.expectedPlatformVersion, testContext.Config().Fargate.PlatformVersion)
@@ -79,3 +89,292 @@ func createCliContextForTests(taskDefinition string, platformVersion string) *cl
 
 	return cliCtx
 }
+
+func TestApplyVariableOverrides(t *testing.T) {
+	tests := map[string]struct {
+		args                   []string
+		allowed                []string
+		expectedTaskDefinition string
+		expectedError          error
+	}{
+		"Should override allowed setting": {
+			args:                   []string{"custom", "prepare"},
+			allowed:                []string{"Fargate.TaskDefinition"},
+			expectedTaskDefinition: "another-task-def:1",
+		},
+		"Should fail when the setting is not allowed": {
+			args:          []string{"custom", "prepare"},
+			expectedError: config.ErrOverrideNotAllowed,
+		},
+		"Should ignore variables for the configuration commands": {
+			args:                   []string{"config", "validate"},
+			expectedTaskDefinition: "default-task-def:1",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			require.NoError(t, os.Setenv("CUSTOM_ENV_FARGATE_TASK_DEFINITION", "another-task-def:1"))
+			defer os.Unsetenv("CUSTOM_ENV_FARGATE_TASK_DEFINITION")
+
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			require.NoError(t, flags.Parse(tt.args))
+
+			testContext := createCliContextForTests("default-task-def:1", "LATEST")
+			testContext.Cli = urfave.NewContext(nil, flags, nil)
+			testContext.SetLogger(test.NewNullLogger())
+
+			cfg := testContext.Config()
+			cfg.Fargate.Cluster = "cluster"
+			cfg.Fargate.Region = "us-east-1"
+			cfg.Fargate.Subnet = "subnet-1"
+			cfg.Fargate.SecurityGroup = "sg-1"
+			cfg.TaskMetadata.Directory = "/metadata"
+			cfg.Overrides.Allowed = tt.allowed
+			testContext.SetConfig(cfg)
+
+			err := applyVariableOverrides(testContext)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTaskDefinition, testContext.Config().Fargate.TaskDefinition)
+		})
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	cfg, err := config.Load([]byte(`
+[Fargate]
+  Cluster = "cluster"
+  Region = "us-east-1"
+  Subnet = "subnet-1"
+  SecurityGroup = "sg-1"
+  TaskDefinition = "default-task-def:1"
+  EnablePublicIP = true
+
+[TaskMetadata]
+  Directory = "/metadata"
+
+[Profiles.team-a]
+  RunnerTags = ["team-a"]
+
+  [Profiles.team-a.Fargate]
+    Subnet = "subnet-a"
+    EnablePublicIP = false
+`))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		args             []string
+		profile          string
+		runnerTags       []string
+		expectedProfile  string
+		expectedSubnet   string
+		expectedPublicIP bool
+		expectedError    error
+	}{
+		"Should apply the profile selected by the job": {
+			args:             []string{"custom", "prepare"},
+			profile:          "team-a",
+			expectedProfile:  "team-a",
+			expectedSubnet:   "subnet-a",
+			expectedPublicIP: false,
+		},
+		"Should apply the profile selected by the runner tags": {
+			args:             []string{"custom", "prepare"},
+			runnerTags:       []string{"docker", "team-a"},
+			expectedProfile:  "team-a",
+			expectedSubnet:   "subnet-a",
+			expectedPublicIP: false,
+		},
+		"Should keep the base configuration without profile": {
+			args:             []string{"custom", "prepare"},
+			runnerTags:       []string{"docker", "linux"},
+			expectedSubnet:   "subnet-1",
+			expectedPublicIP: true,
+		},
+		"Should fail with an unknown profile": {
+			args:          []string{"custom", "prepare"},
+			profile:       "team-b",
+			expectedError: config.ErrUnknownProfile,
+		},
+		"Should ignore the profile for the configuration commands": {
+			args:             []string{"config", "validate"},
+			profile:          "team-a",
+			expectedSubnet:   "subnet-1",
+			expectedPublicIP: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			require.NoError(t, os.Setenv("CUSTOM_ENV_FARGATE_PROFILE", tt.profile))
+			defer os.Unsetenv("CUSTOM_ENV_FARGATE_PROFILE")
+
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			require.NoError(t, flags.Parse(tt.args))
+
+			testContext := new(cli.Context)
+			testContext.Cli = urfave.NewContext(nil, flags, nil)
+			testContext.SetLogger(test.NewNullLogger())
+			testContext.SetConfig(cfg)
+			testContext.SetJobContext(runner.JobContext{RunnerTags: tt.runnerTags})
+
+			err := applyProfile(testContext)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedProfile, testContext.Config().Profile())
+			assert.Equal(t, tt.expectedSubnet, testContext.Config().Fargate.Subnet)
+			assert.Equal(t, tt.expectedPublicIP, testContext.Config().Fargate.EnablePublicIP)
+			assert.Equal(t, "default-task-def:1", testContext.Config().Fargate.TaskDefinition)
+		})
+	}
+}
+
+func TestMaskReferencedValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+[Fargate]
+  Cluster = "cluster"
+  Region = "us-east-1"
+  Subnet = "subnet-1"
+  SecurityGroup = "sg-1"
+  TaskDefinition = "default-task-def:1"
+
+[TaskMetadata]
+  Directory = "/metadata"
+
+[SSH]
+  Username = "${env:TEST_FARGATE_SSH_USERNAME}"
+`), 0600))
+
+	require.NoError(t, os.Setenv("TEST_FARGATE_SSH_USERNAME", "secret-user"))
+	defer os.Unsetenv("TEST_FARGATE_SSH_USERNAME")
+
+	cfg, err := config.LoadFromFile(file)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		args           []string
+		expectedMasked bool
+	}{
+		"Should mask the referenced values": {
+			args:           []string{"custom", "run"},
+			expectedMasked: true,
+		},
+		"Should ignore the configuration commands": {
+			args: []string{"config", "validate"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			require.NoError(t, flags.Parse(tt.args))
+
+			logger, output := test.NewBufferedLogger()
+
+			testContext := new(cli.Context)
+			testContext.Cli = urfave.NewContext(nil, flags, nil)
+			testContext.SetLogger(logger)
+			testContext.SetConfig(cfg)
+
+			err := maskReferencedValues(testContext)
+			require.NoError(t, err)
+
+			logger.WithField("user", cfg.SSH.Username).Info("Connecting")
+
+			if tt.expectedMasked {
+				assert.NotContains(t, output.String(), "secret-user")
+				assert.Contains(t, output.String(), "[MASKED]")
+				return
+			}
+
+			assert.Contains(t, output.String(), "secret-user")
+		})
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	tests := map[string]struct {
+		args                   []string
+		expectedTaskDefinition string
+	}{
+		"Should render the templates with the job context": {
+			args:                   []string{"custom", "prepare"},
+			expectedTaskDefinition: "ci-group-subgroup-arm64",
+		},
+		"Should ignore the templates for the configuration commands": {
+			args:                   []string{"config", "validate"},
+			expectedTaskDefinition: `ci-{{ .ProjectNamespace | replace "/" "-" }}-{{ .Architecture }}`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			require.NoError(t, flags.Parse(tt.args))
+
+			testContext := createCliContextForTests(`ci-{{ .ProjectNamespace | replace "/" "-" }}-{{ .Architecture }}`, "LATEST")
+			testContext.Cli = urfave.NewContext(nil, flags, nil)
+
+			cfg := testContext.Config()
+			cfg.Fargate.Cluster = "cluster"
+			cfg.Fargate.Region = "us-east-1"
+			cfg.Fargate.Subnet = "subnet-1"
+			cfg.Fargate.SecurityGroup = "sg-1"
+			cfg.TaskMetadata.Directory = "/metadata"
+			testContext.SetConfig(cfg)
+			testContext.SetJobContext(runner.JobContext{
+				ProjectNamespace:     "group/subgroup",
+				RunnerExecutableArch: "linux/arm64",
+			})
+
+			err := renderTemplates(testContext)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTaskDefinition, testContext.Config().Fargate.TaskDefinition)
+		})
+	}
+}
+
+func TestLoadJobContext(t *testing.T) {
+	require.NoError(t, os.Setenv("CUSTOM_ENV_CI_PROJECT_PATH", "group/project"))
+	defer os.Unsetenv("CUSTOM_ENV_CI_PROJECT_PATH")
+
+	testContext := new(cli.Context)
+	require.NoError(t, loadJobContext(testContext))
+	assert.Equal(t, "group/project", testContext.JobContext().ProjectPath)
+
+	require.NoError(t, os.Setenv("CUSTOM_ENV_CI_JOB_ID", "abc"))
+	defer os.Unsetenv("CUSTOM_ENV_CI_JOB_ID")
+
+	assertions.ErrorIs(t, loadJobContext(new(cli.Context)), strconv.ErrSyntax)
+}
+
+func TestLoadJobContext_DebugTraceSetByJob(t *testing.T) {
+	require.NoError(t, os.Setenv("CUSTOM_ENV_CI_DEBUG_TRACE", "yes"))
+	defer os.Unsetenv("CUSTOM_ENV_CI_DEBUG_TRACE")
+
+	logger, output := test.NewBufferedLogger()
+
+	testContext := new(cli.Context)
+	testContext.SetLogger(logger)
+
+	require.NoError(t, loadJobContext(testContext))
+	assert.False(t, testContext.JobContext().DebugTrace)
+	assert.Contains(t, output.String(), "CUSTOM_ENV_CI_DEBUG_TRACE")
+}
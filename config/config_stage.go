@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ConfigStage configures the output of the "config" stage, read by the Runner
+// before preparing the job
+type ConfigStage struct {
+	// BuildsDir and CacheDir are the directories of the task container
+	// holding the builds and the local cache. The ones of the Runner are
+	// used when empty
+	BuildsDir string `pattern:"^/" format:"an absolute path"`
+	CacheDir  string `pattern:"^/" format:"an absolute path"`
+	// BuildsDirIsShared tells the Runner whether the builds directory is
+	// shared by concurrent jobs. The Runner decides when not set
+	BuildsDirIsShared *bool
+	// JobEnv are the variables given to the next stages of the driver,
+	// along with the ones of the driver, like FARGATE_CLUSTER
+	JobEnv map[string]string
+}
+
+func (c ConfigStage) problems() []Problem {
+	names := make([]string, 0, len(c.JobEnv))
+	for name := range c.JobEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+
+	for _, name := range names {
+		if !variableNameRx.MatchString(name) {
+			problems = append(problems, Problem{
+				Key:     "ConfigStage.JobEnv",
+				Message: fmt.Sprintf("invalid variable name %q", name),
+			})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestLoad_ConfigStage(t *testing.T) {
+	cfg, err := Load([]byte(validConfig + `
+[ConfigStage]
+  BuildsDir = "/builds"
+  CacheDir = "/cache"
+  BuildsDirIsShared = false
+
+  [ConfigStage.JobEnv]
+    DEPLOY_ENV = "staging"
+`))
+	require.NoError(t, err)
+
+	shared := false
+	assert.Equal(t, ConfigStage{
+		BuildsDir:         "/builds",
+		CacheDir:          "/cache",
+		BuildsDirIsShared: &shared,
+		JobEnv:            map[string]string{"DEPLOY_ENV": "staging"},
+	}, cfg.ConfigStage)
+}
+
+func TestLoad_InvalidConfigStage(t *testing.T) {
+	_, err := Load([]byte(validConfig + `
+[ConfigStage]
+  BuildsDir = "builds"
+
+  [ConfigStage.JobEnv]
+    "DEPLOY-ENV" = "staging"
+`))
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []Problem{
+		{Key: "ConfigStage.BuildsDir", Line: 25, Message: "must be an absolute path"},
+		{Key: "ConfigStage.JobEnv", Line: 27, Message: `invalid variable name "DEPLOY-ENV"`},
+	}, validationErr.Problems)
+}
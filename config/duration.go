@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration allows to define time.Duration values in the configuration
+// file using the Go duration format, like "1h30m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from its textual representation
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("parsing duration %q: %w", string(text), err)
+	}
+
+	d.Duration = duration
+
+	return nil
+}
+
+// MarshalText returns the textual representation of the duration
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
@@ -0,0 +1,424 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of the configuration files, detected by their extension
+const (
+	FormatTOML = "toml"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// ErrUnsupportedFormat is returned when the extension of the configuration
+// file doesn't match any format
+var ErrUnsupportedFormat = errors.New("unsupported configuration file format")
+
+var formatExtensions = map[string]string{
+	".toml": FormatTOML,
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+}
+
+func fileFormat(file string) (string, error) {
+	format, ok := formatExtensions[strings.ToLower(filepath.Ext(file))]
+	if !ok {
+		return "", fmt.Errorf("%w: %q must have a .toml, .json, .yaml or .yml extension", ErrUnsupportedFormat, file)
+	}
+
+	return format, nil
+}
+
+// fragmentsDir returns the directory holding the fragments of the
+// configuration file, like "config.d" for "config.toml"
+func fragmentsDir(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".d"
+}
+
+// configurationFiles lists the configuration file followed by its fragments,
+// in lexical order. When path is a directory, its fragments are listed
+func configurationFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read configuration file %q: %w", path, err)
+	}
+
+	if info.IsDir() {
+		fragments, err := listFragments(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fragments) == 0 {
+			return nil, fmt.Errorf("couldn't read configuration directory %q: %w", path, os.ErrNotExist)
+		}
+
+		return fragments, nil
+	}
+
+	fragments, err := listFragments(fragmentsDir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{path}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{path}, fragments...), nil
+}
+
+// listFragments ignores the hidden files and the files of other formats,
+// like the backups left by the editors
+func listFragments(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read configuration directory %q: %w", dir, err)
+	}
+
+	var fragments []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if _, err := fileFormat(entry.Name()); err != nil {
+			continue
+		}
+
+		fragments = append(fragments, filepath.Join(dir, entry.Name()))
+	}
+
+	return fragments, nil
+}
+
+// keyLocator returns the file and the line defining the dotted key of a
+// setting. They are empty when unknown
+type keyLocator interface {
+	locate(key string) (string, int)
+}
+
+func (l keyLines) locate(key string) (string, int) {
+	return "", l.find(key)
+}
+
+// fileKeyLines locates the keys of one of the merged files. The lines are
+// only known for TOML files
+type fileKeyLines struct {
+	file  string
+	lines keyLines
+}
+
+func (f fileKeyLines) locate(key string) (string, int) {
+	return f.file, f.lines.find(key)
+}
+
+// decodeTree decodes the configuration file into a tree of settings, made of
+// tables, lists and values of the types used by the TOML decoder
+func decodeTree(file string) (map[string]interface{}, fileKeyLines, error) {
+	location := fileKeyLines{file: file}
+
+	format, err := fileFormat(file)
+	if err != nil {
+		return nil, location, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, location, fmt.Errorf("couldn't read configuration file %q: %w", file, err)
+	}
+
+	var tree map[string]interface{}
+
+	switch format {
+	case FormatTOML:
+		_, err = toml.Decode(string(data), &tree)
+		location.lines = indexKeyLines(data)
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&tree)
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &tree)
+	}
+
+	if err != nil {
+		return nil, location, fmt.Errorf("parsing %s content of %q: %w", strings.ToUpper(format), file, err)
+	}
+
+	table, err := normalizeTreeValue(tree)
+	if err != nil {
+		return nil, location, fmt.Errorf("parsing %s content of %q: %w", strings.ToUpper(format), file, err)
+	}
+
+	return table.(map[string]interface{}), location, nil
+}
+
+// normalizeTreeValue converts the tables decoded from YAML, and the numbers
+// decoded from JSON, to the types of the TOML decoder. The null values are
+// dropped, as if the settings were not set
+func normalizeTreeValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		table := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+
+			normalized, err := normalizeTreeValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			table[key] = normalized
+		}
+
+		return table, nil
+	case map[interface{}]interface{}:
+		table := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			table[fmt.Sprint(key)] = item
+		}
+
+		return normalizeTreeValue(table)
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+
+		return normalizeTreeValue(list)
+	case []interface{}:
+		return normalizeTreeList(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+
+		return v.Float64()
+	case int:
+		return int64(v), nil
+	default:
+		return v, nil
+	}
+}
+
+// normalizeTreeList converts the lists of tables to the arrays of tables of
+// the TOML encoder
+func normalizeTreeList(list []interface{}) (interface{}, error) {
+	normalized := make([]interface{}, len(list))
+	tables := make([]map[string]interface{}, 0, len(list))
+
+	for i, item := range list {
+		value, err := normalizeTreeValue(item)
+		if err != nil {
+			return nil, err
+		}
+
+		normalized[i] = value
+
+		if table, ok := value.(map[string]interface{}); ok {
+			tables = append(tables, table)
+		}
+	}
+
+	if len(list) > 0 && len(tables) == len(list) {
+		return tables, nil
+	}
+
+	return normalized, nil
+}
+
+// mergedFiles deep-merges the configuration files in order: the tables are
+// merged, and the other values are replaced by the later files. It records
+// the file defining each setting, to report the problems where the setting
+// was defined
+type mergedFiles struct {
+	tree    map[string]interface{}
+	origins map[string]fileKeyLines
+
+	// conflicts lists the settings defined as a table in a file and as a
+	// value in another one, which can't be merged
+	conflicts []Problem
+	// shadowed lists the values replaced by the later files
+	shadowed []Problem
+}
+
+func mergeFiles(files []string) (*mergedFiles, error) {
+	m := &mergedFiles{
+		tree:    make(map[string]interface{}),
+		origins: make(map[string]fileKeyLines),
+	}
+
+	for _, file := range files {
+		tree, location, err := decodeTree(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m.merge(m.tree, tree, "", location)
+	}
+
+	return m, nil
+}
+
+func (m *mergedFiles) merge(dst map[string]interface{}, src map[string]interface{}, prefix string, location fileKeyLines) {
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := src[key]
+
+		// The settings are matched case-insensitively, like the TOML
+		// decoder does, keeping the name used by the first file
+		existingKey, existing, ok := lookupTreeKey(dst, key)
+		if !ok {
+			dst[key] = value
+			m.record(joinTreeKey(prefix, key), value, location)
+			continue
+		}
+
+		path := joinTreeKey(prefix, existingKey)
+		_, line := location.locate(path)
+		previous, _ := m.locate(path)
+
+		srcTable, srcIsTable := value.(map[string]interface{})
+		dstTable, dstIsTable := existing.(map[string]interface{})
+
+		switch {
+		case srcIsTable && dstIsTable:
+			m.merge(dstTable, srcTable, path, location)
+		case srcIsTable != dstIsTable:
+			m.conflicts = append(m.conflicts, Problem{
+				Key:     path,
+				File:    location.file,
+				Line:    line,
+				Message: fmt.Sprintf("can't be merged with the %s defined in %s", treeValueKind(existing), previous),
+			})
+		case !reflect.DeepEqual(existing, value):
+			m.shadowed = append(m.shadowed, Problem{
+				Key:     path,
+				File:    location.file,
+				Line:    line,
+				Message: fmt.Sprintf("overrides the value defined in %s", previous),
+			})
+
+			m.forget(path)
+			dst[existingKey] = value
+			m.record(path, value, location)
+		}
+	}
+}
+
+func lookupTreeKey(table map[string]interface{}, key string) (string, interface{}, bool) {
+	if value, ok := table[key]; ok {
+		return key, value, true
+	}
+
+	for existingKey, value := range table {
+		if strings.EqualFold(existingKey, key) {
+			return existingKey, value, true
+		}
+	}
+
+	return "", nil, false
+}
+
+func joinTreeKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+func treeValueKind(value interface{}) string {
+	if _, ok := value.(map[string]interface{}); ok {
+		return "table"
+	}
+
+	return "value"
+}
+
+// record sets the origin of the setting, and of the settings it contains.
+// The tables keep the first file defining them. The elements of the arrays
+// of tables are indexed like the problems, as "rules[0]"
+func (m *mergedFiles) record(path string, value interface{}, location fileKeyLines) {
+	key := strings.ToLower(path)
+	if _, ok := m.origins[key]; !ok {
+		m.origins[key] = location
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for child, item := range v {
+			m.record(joinTreeKey(path, child), item, location)
+		}
+	case []map[string]interface{}:
+		for i, item := range v {
+			m.record(fmt.Sprintf("%s[%d]", path, i), item, location)
+		}
+	}
+}
+
+// forget removes the origins of the replaced value
+func (m *mergedFiles) forget(path string) {
+	key := strings.ToLower(path)
+	for origin := range m.origins {
+		if origin == key || strings.HasPrefix(origin, key+".") || strings.HasPrefix(origin, key+"[") {
+			delete(m.origins, origin)
+		}
+	}
+}
+
+// locate returns the file defining the setting, or the closest table
+// containing it, and the line of the setting in that file
+func (m *mergedFiles) locate(key string) (string, int) {
+	key = strings.ToLower(key)
+	for parent := key; parent != ""; {
+		if location, ok := m.origins[parent]; ok {
+			return location.locate(key)
+		}
+
+		i := strings.LastIndexAny(parent, ".[")
+		if i < 0 {
+			break
+		}
+
+		parent = parent[:i]
+	}
+
+	return "", 0
+}
+
+// encode returns the merged tree as a TOML document
+func (m *mergedFiles) encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	err := toml.NewEncoder(buf).Encode(m.tree)
+	if err != nil {
+		return nil, fmt.Errorf("encoding merged configuration: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
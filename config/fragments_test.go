@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const fragmentsBaseConfig = `
+[Fargate]
+  Cluster = "cluster-name"
+  Region = "us-east-1"
+  Subnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "my-task-definition:1"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+`
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	}
+}
+
+func TestLoadFromFile_Fragments(t *testing.T) {
+	tests := map[string]struct {
+		files            map[string]string
+		path             string
+		assertConfig     func(t *testing.T, cfg Global)
+		expectedShadowed []Problem
+		expectedProblems []Problem
+	}{
+		"Fragments merged in lexical order": {
+			files: map[string]string{
+				"config.toml": fragmentsBaseConfig,
+				"config.d/20-fargate.json": `{
+					"Fargate": {"Subnet": "subnet-4567efgh", "CPU": 1024},
+					"Lease": {"Enabled": true, "DefaultDuration": "1h"}
+				}`,
+				"config.d/10-ssh.yaml": "ssh:\n  username: root\n  port: 2222\nfargate:\n  subnet: subnet-89ab\n",
+				"config.d/README.md":   "not a fragment",
+				"config.d/.30.toml":    "not a fragment",
+			},
+			path: "config.toml",
+			assertConfig: func(t *testing.T, cfg Global) {
+				assert.Equal(t, "cluster-name", cfg.Fargate.Cluster)
+				assert.Equal(t, "subnet-4567efgh", cfg.Fargate.Subnet)
+				assert.Equal(t, 1024, cfg.Fargate.CPU)
+				assert.Equal(t, "root", cfg.SSH.Username)
+				assert.Equal(t, 2222, cfg.SSH.Port)
+				assert.True(t, cfg.Lease.Enabled)
+				assert.Equal(t, time.Hour, cfg.Lease.DefaultDuration.Duration)
+			},
+			expectedShadowed: []Problem{
+				{Key: "Fargate.Subnet", File: "config.d/10-ssh.yaml", Message: "overrides the value defined in config.toml"},
+				{Key: "Fargate.Subnet", File: "config.d/20-fargate.json", Message: "overrides the value defined in config.d/10-ssh.yaml"},
+			},
+		},
+		"Directory of fragments": {
+			files: map[string]string{
+				"fargate/00-base.toml": fragmentsBaseConfig,
+				"fargate/10-rules.yml": "Overrides:\n  Allowed: [Fargate.CPU]\nRules:\n  - Effect: allow\n    CPU: [512, 1024]\n",
+			},
+			path: "fargate",
+			assertConfig: func(t *testing.T, cfg Global) {
+				assert.Equal(t, "cluster-name", cfg.Fargate.Cluster)
+				require.Len(t, cfg.Rules, 1)
+				assert.Equal(t, []int{512, 1024}, cfg.Rules[0].CPU)
+			},
+		},
+		"Problems located in the fragments": {
+			files: map[string]string{
+				"config.toml":            fragmentsBaseConfig,
+				"config.d/10-ssh.toml":   "\n[SSH]\n  Username = \"root\"\n  Port = 70000\n",
+				"config.d/20-lease.json": `{"Lease": {"Enable": true}}`,
+			},
+			path: "config.toml",
+			expectedProblems: []Problem{
+				{Key: "SSH.Port", File: "config.d/10-ssh.toml", Line: 4, Message: "must be at most 65535"},
+				{Key: "Lease.Enable", File: "config.d/20-lease.json", Message: "is not a known setting"},
+			},
+		},
+		"Conflicting fragments": {
+			files: map[string]string{
+				"config.toml":          fragmentsBaseConfig,
+				"config.d/10-ssh.json": `{"SSH": "root"}`,
+				"config.d/20-ssh.toml": "[SSH]\n  Username = \"root\"\n",
+			},
+			path: "config.toml",
+			expectedProblems: []Problem{
+				{Key: "SSH", File: "config.d/20-ssh.toml", Line: 1, Message: "can't be merged with the value defined in config.d/10-ssh.json"},
+			},
+		},
+		"Single YAML file": {
+			files: map[string]string{
+				"config.yaml": "Fargate:\n  Cluster: cluster-name\n  Region: us-east-1\n  Subnet: subnet-0123abcd\n" +
+					"  SecurityGroup: sg-0123abcd\n  TaskDefinition: my-task-definition:1\n" +
+					"TaskMetadata:\n  Directory: /fargate-driver/\n",
+			},
+			path: "config.yaml",
+			assertConfig: func(t *testing.T, cfg Global) {
+				assert.Equal(t, "my-task-definition:1", cfg.Fargate.TaskDefinition)
+				assert.Equal(t, "/fargate-driver/", cfg.TaskMetadata.Directory)
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			writeConfigFiles(t, dir, tt.files)
+
+			cfg, err := LoadFromFile(filepath.Join(dir, tt.path))
+
+			if tt.expectedProblems != nil {
+				assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+				var validationErr *ValidationError
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.expectedProblems, relativeProblems(t, dir, validationErr.Problems))
+				return
+			}
+
+			require.NoError(t, err)
+			tt.assertConfig(t, cfg)
+			assert.Equal(t, tt.expectedShadowed, relativeProblems(t, dir, cfg.ShadowedSettings()))
+		})
+	}
+}
+
+// relativeProblems replaces the paths of the files with their path relative
+// to the directory
+func relativeProblems(t *testing.T, dir string, problems []Problem) []Problem {
+	for i, problem := range problems {
+		file, err := filepath.Rel(dir, problem.File)
+		require.NoError(t, err)
+
+		problems[i].File = filepath.ToSlash(file)
+		problems[i].Message = strings.ReplaceAll(problem.Message, dir+string(filepath.Separator), "")
+	}
+
+	return problems
+}
+
+func TestLoadFromFile_UnsupportedFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeConfigFiles(t, dir, map[string]string{"config.ini": fragmentsBaseConfig})
+
+	_, err = LoadFromFile(filepath.Join(dir, "config.ini"))
+	assertions.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	_, err = LoadFromFile(filepath.Join(dir, "missing"))
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestProblem_String(t *testing.T) {
+	assert.Equal(t, "line 3: SSH.Port: must be at least 1", Problem{Key: "SSH.Port", Line: 3, Message: "must be at least 1"}.String())
+	assert.Equal(t, "ssh.toml: line 3: SSH.Port: must be at least 1", Problem{Key: "SSH.Port", File: "ssh.toml", Line: 3, Message: "must be at least 1"}.String())
+	assert.Equal(t, "ssh.json: SSH.Port: must be at least 1", Problem{Key: "SSH.Port", File: "ssh.json", Message: "must be at least 1"}.String())
+}
@@ -3,51 +3,280 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
-
-	"github.com/BurntSushi/toml"
 )
 
+// Settings are validated against the rules declared in their tags, which
+// are also exported in the JSON Schema of the configuration:
+//
+//	required  the setting must be set
+//	enum      comma-separated list of the allowed values
+//	pattern   regular expression the value must match
+//	format    description of the pattern used in the error messages
+//	minimum   lowest allowed value of the integer settings
+//	maximum   highest allowed value of the integer settings
+//
+// Settings can be overridden by CI variables when allowed in the Overrides
+// section, unless tagged with `override:"false"`.
+//
+// String settings can be templates rendered with the job context, unless
+// tagged with `template:"false"`, and can reference values stored outside of
+// the file, like "${ssm:/ci/token}", unless tagged with `reference:"false"`.
+// The rules other than "required" are checked on the rendered and resolved
+// values
 type Global struct {
-	LogLevel  string
+	LogLevel  string `enum:"trace,debug,info,warn,warning,error,fatal,panic"`
 	LogFile   string
-	LogFormat string
+	LogFormat string `enum:"text,text-simple,json"`
 
 	Fargate      Fargate
 	TaskMetadata TaskMetadata
 	SSH          SSH
+	Lease        Lease
+	// Helper can't be overridden, as it names files of the Runner host
+	Helper Helper `override:"false"`
+	// HostStages can't be overridden, as it executes scripts on the Runner host
+	HostStages HostStages `override:"false"`
+	// ConfigStage can't be overridden, as the Runner reads it before the
+	// variables of the job are known to the driver
+	ConfigStage ConfigStage `override:"false"`
+
+	// References configures the resolution of the references, so it can't
+	// reference values itself
+	References References `override:"false" template:"false" reference:"false"`
+	// Secrets can't be overridden, as the jobs request their secrets with
+	// the FARGATE_SECRETS variable
+	Secrets Secrets `override:"false" template:"false" reference:"false"`
+
+	// Profiles, Overrides and Rules can't be overridden by the jobs. The
+	// profiles are rendered once applied
+	Profiles  map[string]Profile `override:"false" template:"false"`
+	Overrides Overrides          `override:"false" template:"false" reference:"false"`
+	Rules     []Rule             `override:"false" template:"false" reference:"false"`
+	// Stages configures the stages by name, and can't be overridden as it
+	// decides where the scripts are executed
+	Stages map[string]Stage `override:"false" template:"false"`
+
+	// profile is the name of the profile applied with WithProfile
+	profile string
+	// profileRequested is set when the job requested the profile
+	profileRequested bool
+	// overridden lists the keys of the settings overridden by the job
+	overridden []string
+	// shadowed lists the settings replaced by the later configuration files
+	shadowed []Problem
+	// referenced lists the values resolved from the references
+	referenced []string
+	// rendered is set once the templates are rendered
+	rendered bool
 }
 
 type Fargate struct {
-	Cluster         string
+	Cluster         string `required:"true" pattern:"^(arn:aws[a-z-]*:ecs:[a-z0-9-]+:[0-9]{12}:cluster/)?[A-Za-z0-9_-]{1,255}$" format:"a cluster name or ARN"`
 	EnablePublicIP  bool
 	PlatformVersion string
-	Region          string
-	Subnet          string
-	SecurityGroup   string
-	TaskDefinition  string
+	Region          string `required:"true" pattern:"^[a-z]{2}(-[a-z]+)+-[0-9]+$" format:"an AWS region, like \"us-east-1\""`
+	Subnet          string `required:"true" pattern:"^subnet-\\S+$" format:"a subnet ID, like \"subnet-0123abcd\""`
+	SecurityGroup   string `required:"true" pattern:"^sg-\\S+$" format:"a security group ID, like \"sg-0123abcd\""`
+	TaskDefinition  string `required:"true" pattern:"^(arn:aws[a-z-]*:ecs:[a-z0-9-]+:[0-9]{12}:task-definition/)?[A-Za-z0-9_-]{1,255}(:[0-9]+)?$" format:"a task definition family, family:revision or ARN"`
+	// CPU and Memory override the task size of the task definition when set.
+	// Fargate accepts only some combinations of them
+	CPU    int `enum:"256,512,1024,2048,4096,8192,16384"`
+	Memory int `minimum:"512" maximum:"122880"`
 }
 
+// Backends supported by the TaskMetadata storage
+const (
+	MetadataBackendFile     = "file"
+	MetadataBackendS3       = "s3"
+	MetadataBackendDynamoDB = "dynamodb"
+)
+
 type TaskMetadata struct {
+	// Backend selects where the task metadata is stored. Defaults to
+	// MetadataBackendFile, which requires all stages to run on the same host
+	Backend   string `enum:"file,s3,dynamodb"`
 	Directory string
+
+	S3       S3Store
+	DynamoDB DynamoDBStore
+
+	Encryption Encryption
+}
+
+// S3Store configures an S3-compatible bucket as the TaskMetadata storage
+type S3Store struct {
+	Bucket string
+	Prefix string
+	// Region defaults to the region of the Fargate cluster
+	Region string
+	// Endpoint and ForcePathStyle allow using S3-compatible services
+	Endpoint       string
+	ForcePathStyle bool
+}
+
+// Providers of the keys used to encrypt the sensitive fields of the TaskMetadata
+const (
+	EncryptionProviderKeyFile = "keyfile"
+	EncryptionProviderKeyring = "keyring"
+	EncryptionProviderKMS     = "kms"
+)
+
+// Encryption configures the envelope encryption of the sensitive fields of
+// the TaskMetadata. The fields are stored in plaintext when Provider is empty
+type Encryption struct {
+	Provider string `enum:"keyfile,keyring,kms"`
+	// KeyFile lists the keys as "<id> <base64 encoded 256-bit key>" lines.
+	// The last key encrypts the new records, the others are kept for decryption
+	KeyFile string
+	// KeyringKey is the description of the "user" key, stored in the user
+	// keyring, that encrypts the new records
+	KeyringKey string
+	KMS        KMS
+}
+
+// KMS configures the AWS KMS key, or a KMS-compatible service, used by the
+// EncryptionProviderKMS provider
+type KMS struct {
+	KeyID string
+	// Region defaults to the region of the Fargate cluster
+	Region   string
+	Endpoint string
+}
+
+// DynamoDBStore configures a DynamoDB table as the TaskMetadata storage.
+// The table must use a string partition key named "Key"
+type DynamoDBStore struct {
+	Table string
+	// Region defaults to the region of the Fargate cluster
+	Region   string
+	Endpoint string
 }
 
 type SSH struct {
-	Username string
-	Port     int
+	Username      string
+	Port          int `minimum:"1" maximum:"65535"`
+	ReadinessPort int `minimum:"1" maximum:"65535"`
 }
 
+// Lease configures the self-termination of the task done by the SSH service
+type Lease struct {
+	Enabled bool
+	// DefaultDuration is used when the job timeout is not provided by the Runner
+	DefaultDuration Duration
+	// StartupDeadline limits the time for the first SSH connection to the task
+	StartupDeadline Duration
+}
+
+// Helper configures the provisioning of the gitlab-runner helper binary into
+// the task container, used by the artifacts and cache stages of the jobs
+type Helper struct {
+	// CacheDir is the directory of the Runner host holding the binaries, as
+	// "<version>/gitlab-runner-linux-<arch>". The provisioning is disabled
+	// when empty
+	CacheDir string `pattern:"^/" format:"an absolute path"`
+	// Path is the file of the binary in the container,
+	// "/usr/local/bin/gitlab-runner" by default
+	Path string `pattern:"^/" format:"an absolute path"`
+}
+
+// HostStages configures the execution of the artifacts and cache stages of
+// the jobs on the Runner host, as an alternative to the helper in the
+// container. The project directory is moved between the task container and
+// the host around each of these stages
+type HostStages struct {
+	Enabled bool
+	// Shell executes the scripts of the stages, "bash" by default
+	Shell string
+	// BuildsDir is the directory of the host the project directories of the
+	// jobs are moved to, the temporary directory by default
+	BuildsDir string `pattern:"^/" format:"an absolute path"`
+}
+
+// LoadFromFile loads and validates the configuration file, deep-merged with
+// the fragments of its ".d" directory in lexical order, like "config.d" for
+// "config.toml". When file is a directory, its fragments are merged. The
+// files can be written in TOML, JSON or YAML, detected by their extension
 func LoadFromFile(file string) (Global, error) {
-	data, err := ioutil.ReadFile(file)
+	files, err := configurationFiles(file)
+	if err != nil {
+		return Global{}, err
+	}
+
+	data, locator, shadowed, err := readConfigurationFiles(files)
 	if err != nil {
-		return Global{}, fmt.Errorf("couldn't read configuration file %q: %w", file, err)
+		return Global{}, fmt.Errorf("couldn't load configuration file %q: %w", file, err)
 	}
 
-	var cfg Global
+	cfg, err := load(data, locator)
+	if err != nil {
+		return Global{}, fmt.Errorf("couldn't load configuration file %q: %w", file, err)
+	}
 
-	err = toml.Unmarshal(data, &cfg)
+	cfg.shadowed = shadowed
+
+	err = cfg.resolveAndValidate(locator, defaultReferenceResolvers(cfg.References, cfg.Fargate.Region))
 	if err != nil {
-		return Global{}, fmt.Errorf("couldn't parse TOML content of the configuration file: %w", err)
+		return Global{}, fmt.Errorf("couldn't load configuration file %q: %w", file, err)
 	}
 
 	return cfg, nil
 }
+
+// readConfigurationFiles returns the TOML document of the configuration. A
+// single TOML file is returned as is, the other files are merged
+func readConfigurationFiles(files []string) ([]byte, keyLocator, []Problem, error) {
+	if format, _ := fileFormat(files[0]); len(files) == 1 && format == FormatTOML {
+		data, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("couldn't read configuration file %q: %w", files[0], err)
+		}
+
+		return data, indexKeyLines(data), nil, nil
+	}
+
+	merged, err := mergeFiles(files)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(merged.conflicts) > 0 {
+		sortProblems(merged.conflicts)
+		return nil, nil, nil, &ValidationError{Problems: merged.conflicts}
+	}
+
+	data, err := merged.encode()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return data, merged, merged.shadowed, nil
+}
+
+// ShadowedSettings lists the values of the configuration files replaced by
+// the later files
+func (g Global) ShadowedSettings() []Problem {
+	return g.shadowed
+}
+
+// ReferencedValues lists the values resolved from the references, which
+// must be masked in the logs
+func (g Global) ReferencedValues() []string {
+	return g.referenced
+}
+
+// resolveAndValidate resolves the references of the loaded configuration,
+// and validates the resolved values
+func (g *Global) resolveAndValidate(locator keyLocator, resolvers *referenceResolvers) error {
+	problems := g.resolveReferences(resolvers)
+	if len(problems) == 0 {
+		problems = g.problems()
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	locateProblems(problems, locator)
+
+	return &ValidationError{Problems: problems}
+}
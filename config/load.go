@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Load decodes the TOML configuration and validates it. Unknown keys are
+// reported as problems, with their line, together with the invalid settings
+func Load(data []byte) (Global, error) {
+	return load(data, indexKeyLines(data))
+}
+
+// load decodes the TOML configuration, and reports the problems where the
+// locator finds their settings
+func load(data []byte, locator keyLocator) (Global, error) {
+	var cfg Global
+
+	metadata, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		return Global{}, fmt.Errorf("parsing TOML content: %w", err)
+	}
+
+	cfg.recordDefinedProfileKeys(metadata)
+
+	var problems []Problem
+	for _, key := range unknownKeys(metadata) {
+		problems = append(problems, Problem{
+			Key:     key.String(),
+			Message: "is not a known setting",
+		})
+	}
+
+	problems = append(problems, cfg.problems()...)
+	locateProblems(problems, locator)
+
+	if len(problems) > 0 {
+		sortProblems(problems)
+		return cfg, &ValidationError{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// unknownKeys returns the keys not matching any setting. The keys of the
+// unknown tables are not returned, only the tables
+func unknownKeys(metadata toml.MetaData) []toml.Key {
+	undecoded := metadata.Undecoded()
+
+	unknown := make(map[string]bool, len(undecoded))
+	var keys []toml.Key
+
+	for _, key := range undecoded {
+		if len(key) > 1 && unknown[key[:len(key)-1].String()] {
+			unknown[key.String()] = true
+			continue
+		}
+
+		unknown[key.String()] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+var (
+	tableHeaderRx = regexp.MustCompile(`^\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyValueRx    = regexp.MustCompile(`^("[^"]*"|'[^']*'|[A-Za-z0-9_.-]+)\s*=`)
+)
+
+// keyLines maps the lowercased dotted keys of the TOML document, and the
+// names of its tables, to the line where they are defined
+type keyLines map[string]int
+
+// indexKeyLines scans the document line by line, as the TOML decoder
+// doesn't report the position of the keys. Values spanning multiple lines
+// are not parsed, so keys defined inside them may be indexed
+func indexKeyLines(data []byte) keyLines {
+	lines := make(keyLines)
+	table := ""
+	arrayTables := make(map[string]int)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if match := tableHeaderRx.FindStringSubmatch(line); match != nil {
+			table = normalizeKey(match[1])
+			lines.add(table, number)
+
+			// The tables of an array are indexed like the problems of their
+			// elements, as "rules[0]", "rules[1]"...
+			if strings.HasPrefix(line, "[[") {
+				name := table
+				table = fmt.Sprintf("%s[%d]", name, arrayTables[name])
+				arrayTables[name]++
+				lines.add(table, number)
+			}
+
+			continue
+		}
+
+		if match := keyValueRx.FindStringSubmatch(line); match != nil {
+			key := normalizeKey(match[1])
+			if table != "" {
+				key = table + "." + key
+			}
+
+			lines.add(key, number)
+		}
+	}
+
+	return lines
+}
+
+func normalizeKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+
+	return strings.ToLower(strings.Join(parts, "."))
+}
+
+func (l keyLines) add(key string, line int) {
+	if _, ok := l[key]; !ok {
+		l[key] = line
+	}
+}
+
+// find returns the line of the key, or of the closest table containing it.
+// The TOML decoder matches the keys case-insensitively, so they are
+// compared lowercased
+func (l keyLines) find(key string) int {
+	key = strings.ToLower(key)
+	for key != "" {
+		if line, ok := l[key]; ok {
+			return line
+		}
+
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+
+		key = key[:i]
+	}
+
+	return 0
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const validConfig = `
+LogLevel = "info"
+LogFormat = "json"
+
+[Fargate]
+  Cluster = "cluster-name"
+  Region = "us-east-1"
+  Subnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "my-task-definition:1"
+  EnablePublicIP = true
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+
+[SSH]
+  Username = "root"
+  Port = 22
+
+[Lease]
+  Enabled = true
+  DefaultDuration = "1h"
+`
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		content            string
+		expectedProblems   []Problem
+		expectedParseError bool
+	}{
+		"Valid configuration": {
+			content: validConfig,
+		},
+		"Invalid TOML": {
+			content:            "[Fargate",
+			expectedParseError: true,
+		},
+		"Unknown keys": {
+			content: `
+[Fargate]
+  Cluster = "cluster-name"
+  Region = "us-east-1"
+  Subnnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "my-task-definition:1"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+
+[Leasee]
+  Enabled = true
+`,
+			expectedProblems: []Problem{
+				{Key: "Fargate.Subnet", Line: 2, Message: "is required"},
+				{Key: "Fargate.Subnnet", Line: 5, Message: "is not a known setting"},
+				{Key: "Leasee", Line: 12, Message: "is not a known setting"},
+			},
+		},
+		"Invalid settings": {
+			content: `
+LogLevel = "verbose"
+
+[Fargate]
+  Cluster = "arn:aws:ecs:us-east-1:123:cluster/name"
+  Region = "us-east-1"
+  Subnet = "sg-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+
+[TaskMetadata]
+  Backend = "s3"
+
+[SSH]
+  Port = 70000
+`,
+			expectedProblems: []Problem{
+				{Key: "LogLevel", Line: 2, Message: "must be one of trace, debug, info, warn, warning, error, fatal, panic"},
+				{Key: "Fargate.TaskDefinition", Line: 4, Message: "is required"},
+				{Key: "Fargate.Cluster", Line: 5, Message: "must be a cluster name or ARN"},
+				{Key: "Fargate.Subnet", Line: 7, Message: `must be a subnet ID, like "subnet-0123abcd"`},
+				{Key: "TaskMetadata.S3.Bucket", Line: 10, Message: "is required by the s3 backend"},
+				{Key: "SSH.Port", Line: 14, Message: "must be at most 65535"},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg, err := Load([]byte(tt.content))
+
+			if tt.expectedProblems != nil {
+				assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+				var validationErr *ValidationError
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.expectedProblems, validationErr.Problems)
+				return
+			}
+
+			if tt.expectedParseError {
+				assert.Error(t, err)
+				assert.False(t, errors.Is(err, ErrInvalidConfig))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "cluster-name", cfg.Fargate.Cluster)
+			assert.Equal(t, time.Hour, cfg.Lease.DefaultDuration.Duration)
+		})
+	}
+}
+
+func TestLoadFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.toml")
+
+	_, err = LoadFromFile(file)
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(validConfig), 0600))
+
+	cfg, err := LoadFromFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "subnet-0123abcd", cfg.Fargate.Subnet)
+}
+
+func TestGlobal_Validate(t *testing.T) {
+	cfg, err := Load([]byte(validConfig))
+	require.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Fargate.TaskDefinition = "arn:aws:ecs:us-east-1:123456789012:task-definition/family:3"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Fargate.TaskDefinition = "family:latest"
+	cfg.TaskMetadata.Encryption.Provider = EncryptionProviderKMS
+
+	err = cfg.Validate()
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "Fargate.TaskDefinition: must be a task definition family, family:revision or ARN")
+	assert.Contains(t, err.Error(), "TaskMetadata.Encryption.KMS.KeyID: is required by the kms provider")
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// overrideVariablePrefix starts the names of the CI variables overriding the
+// settings. The settings of the [Fargate] section don't repeat the section
+// name, so Fargate.Subnet is overridden by FARGATE_SUBNET and SSH.Port by
+// FARGATE_SSH_PORT
+const overrideVariablePrefix = "FARGATE_"
+
+// ErrOverrideNotAllowed is returned when a CI variable overrides a setting
+// not listed in Overrides.Allowed
+var ErrOverrideNotAllowed = errors.New("overriding the setting is not allowed")
+
+// Overrides configures the settings that the jobs can override with CI variables
+type Overrides struct {
+	// Allowed lists the keys of the settings that can be overridden, like
+	// "Fargate.TaskDefinition"
+	Allowed []string
+}
+
+type overridableSetting struct {
+	key   string
+	field reflect.StructField
+	index []int
+}
+
+// overridableSettings maps the names of the CI variables to the settings
+// they override. The fields tagged with `override:"false"` are excluded
+func overridableSettings() map[string]overridableSetting {
+	settings := make(map[string]overridableSetting)
+	collectOverridableSettings(reflect.TypeOf(Global{}), nil, nil, settings)
+
+	return settings
+}
+
+func collectOverridableSettings(t reflect.Type, path []string, index []int, settings map[string]overridableSetting) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("override") == "false" {
+			continue
+		}
+
+		fieldPath := append(append([]string{}, path...), field.Name)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if isSectionList(field.Type) || isSectionMap(field.Type) {
+			continue
+		}
+
+		if !isSetting(field.Type) {
+			collectOverridableSettings(field.Type, fieldPath, fieldIndex, settings)
+			continue
+		}
+
+		settings[overrideVariable(fieldPath)] = overridableSetting{
+			key:   strings.Join(fieldPath, "."),
+			field: field,
+			index: fieldIndex,
+		}
+	}
+}
+
+func overrideVariable(path []string) string {
+	if len(path) > 1 && path[0] == "Fargate" {
+		path = path[1:]
+	}
+
+	names := make([]string, 0, len(path))
+	for _, name := range path {
+		names = append(names, toScreamingSnakeCase(name))
+	}
+
+	return overrideVariablePrefix + strings.Join(names, "_")
+}
+
+// toScreamingSnakeCase converts "EnablePublicIP" to "ENABLE_PUBLIC_IP"
+func toScreamingSnakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousLower := !unicode.IsUpper(runes[i-1])
+			endOfAcronym := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if previousLower || endOfAcronym {
+				b.WriteRune('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// ApplyOverrides sets the settings overridden by the CI variables returned
+// by lookup, which returns an empty string for the undefined variables.
+// Only the settings listed in Overrides.Allowed can be overridden. The
+// values are converted and validated like the ones of the configuration
+// file. The keys of the overridden settings are returned, and kept for
+// Authorize
+func (g *Global) ApplyOverrides(lookup func(variable string) string) ([]string, error) {
+	settings := overridableSettings()
+
+	variables := make([]string, 0, len(settings))
+	for variable := range settings {
+		if lookup(variable) != "" {
+			variables = append(variables, variable)
+		}
+	}
+	sort.Strings(variables)
+
+	err := g.checkOverridesAllowed(variables, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	applied := make([]string, 0, len(variables))
+
+	for _, variable := range variables {
+		setting := settings[variable]
+		value := reflect.ValueOf(g).Elem().FieldByIndex(setting.index)
+
+		// The templates and references are processed before applying the
+		// overrides, and must not be provided by the jobs
+		if isTemplate(lookup(variable)) {
+			problems = append(problems, Problem{Key: variable, Message: "templates are not allowed"})
+			continue
+		}
+
+		if isReference(lookup(variable)) {
+			problems = append(problems, Problem{Key: variable, Message: "references are not allowed"})
+			continue
+		}
+
+		err := parseSetting(value, lookup(variable))
+		if err != nil {
+			problems = append(problems, Problem{Key: variable, Message: err.Error()})
+			continue
+		}
+
+		message := checkSetting(setting.field, value)
+		if message != "" {
+			problems = append(problems, Problem{Key: variable, Message: message})
+			continue
+		}
+
+		applied = append(applied, setting.key)
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	if len(applied) == 0 {
+		return nil, nil
+	}
+
+	g.overridden = applied
+
+	// The settings required by the overridden backends and providers
+	return applied, g.Validate()
+}
+
+func (g *Global) checkOverridesAllowed(variables []string, settings map[string]overridableSetting) error {
+	var denied []string
+	for _, variable := range variables {
+		if !g.Overrides.allows(settings[variable].key) {
+			denied = append(denied, fmt.Sprintf("%s (%s)", variable, settings[variable].key))
+		}
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("%w: %s", ErrOverrideNotAllowed, strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+// allows compares the keys case-insensitively, like the TOML decoder does
+func (o Overrides) allows(key string) bool {
+	for _, allowed := range o.Allowed {
+		if strings.EqualFold(allowed, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o Overrides) problems() []Problem {
+	var problems []Problem
+	for _, allowed := range o.Allowed {
+		if !strings.EqualFold(allowed, ProfileKey) && !isOverridableSetting(allowed) {
+			problems = append(problems, Problem{
+				Key:     "Overrides.Allowed",
+				Message: fmt.Sprintf("%q is not a setting that can be overridden", allowed),
+			})
+		}
+	}
+
+	return problems
+}
+
+func isOverridableSetting(key string) bool {
+	for _, setting := range overridableSettings() {
+		if strings.EqualFold(setting.key, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseSetting converts the textual value of a CI variable like the TOML
+// decoder converts the values of the configuration file
+func parseSetting(value reflect.Value, text string) error {
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("parsing boolean %q: %w", text, err)
+		}
+
+		value.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("parsing integer %q: %w", text, err)
+		}
+
+		value.SetInt(int64(i))
+	default:
+		return fmt.Errorf("settings of type %s can't be overridden", value.Type())
+	}
+
+	return nil
+}
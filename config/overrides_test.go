@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestOverridableSettings(t *testing.T) {
+	settings := overridableSettings()
+
+	expectedKeys := map[string]string{
+		"FARGATE_CLUSTER":                             "Fargate.Cluster",
+		"FARGATE_TASK_DEFINITION":                     "Fargate.TaskDefinition",
+		"FARGATE_PLATFORM_VERSION":                    "Fargate.PlatformVersion",
+		"FARGATE_ENABLE_PUBLIC_IP":                    "Fargate.EnablePublicIP",
+		"FARGATE_LOG_LEVEL":                           "LogLevel",
+		"FARGATE_SSH_PORT":                            "SSH.Port",
+		"FARGATE_TASK_METADATA_ENCRYPTION_KMS_KEY_ID": "TaskMetadata.Encryption.KMS.KeyID",
+		"FARGATE_LEASE_DEFAULT_DURATION":              "Lease.DefaultDuration",
+	}
+
+	for variable, key := range expectedKeys {
+		assert.Equal(t, key, settings[variable].key, "variable: %s", variable)
+	}
+
+	for variable, setting := range settings {
+		assert.NotContains(t, setting.key, "Overrides", "variable: %s", variable)
+	}
+}
+
+func TestGlobal_ApplyOverrides(t *testing.T) {
+	tests := map[string]struct {
+		allowed          []string
+		variables        map[string]string
+		expectedOverride []string
+		expectedError    error
+		assertConfig     func(t *testing.T, cfg Global)
+	}{
+		"No variables defined": {
+			allowed: []string{"Fargate.Subnet"},
+			assertConfig: func(t *testing.T, cfg Global) {
+				assert.Equal(t, "subnet-0123abcd", cfg.Fargate.Subnet)
+			},
+		},
+		"Allowed settings are overridden": {
+			allowed: []string{"fargate.subnet", "Fargate.EnablePublicIP", "SSH.Port", "Lease.DefaultDuration"},
+			variables: map[string]string{
+				"FARGATE_SUBNET":                 "subnet-4567ef",
+				"FARGATE_ENABLE_PUBLIC_IP":       "false",
+				"FARGATE_SSH_PORT":               "2222",
+				"FARGATE_LEASE_DEFAULT_DURATION": "2h",
+				"FARGATE_UNKNOWN":                "ignored",
+			},
+			expectedOverride: []string{"Fargate.EnablePublicIP", "Lease.DefaultDuration", "SSH.Port", "Fargate.Subnet"},
+			assertConfig: func(t *testing.T, cfg Global) {
+				assert.Equal(t, "subnet-4567ef", cfg.Fargate.Subnet)
+				assert.False(t, cfg.Fargate.EnablePublicIP)
+				assert.Equal(t, 2222, cfg.SSH.Port)
+				assert.Equal(t, 2*time.Hour, cfg.Lease.DefaultDuration.Duration)
+			},
+		},
+		"Setting not allowed": {
+			allowed:       []string{"Fargate.TaskDefinition"},
+			variables:     map[string]string{"FARGATE_CLUSTER": "other-cluster"},
+			expectedError: ErrOverrideNotAllowed,
+		},
+		"Invalid value": {
+			allowed:       []string{"Fargate.Subnet", "SSH.Port"},
+			variables:     map[string]string{"FARGATE_SUBNET": "sg-1234", "FARGATE_SSH_PORT": "ssh"},
+			expectedError: ErrInvalidConfig,
+		},
+		"Setting required by the overridden backend": {
+			allowed:       []string{"TaskMetadata.Backend"},
+			variables:     map[string]string{"FARGATE_TASK_METADATA_BACKEND": "s3"},
+			expectedError: ErrInvalidConfig,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg, err := Load([]byte(validConfig))
+			require.NoError(t, err)
+
+			cfg.Overrides.Allowed = tt.allowed
+
+			overridden, err := cfg.ApplyOverrides(func(variable string) string {
+				return tt.variables[variable]
+			})
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOverride, overridden)
+			tt.assertConfig(t, cfg)
+		})
+	}
+}
+
+func TestOverrides_Validate(t *testing.T) {
+	cfg, err := Load([]byte(validConfig + `
+[Overrides]
+  Allowed = ["Fargate.TaskDefinition", "Overrides.Allowed", "Fargate.Subnnet"]
+`))
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []Problem{
+		{Key: "Overrides.Allowed", Line: 25, Message: `"Overrides.Allowed" is not a setting that can be overridden`},
+		{Key: "Overrides.Allowed", Line: 25, Message: `"Fargate.Subnnet" is not a setting that can be overridden`},
+	}, validationErr.Problems)
+	assert.Equal(t, []string{"Fargate.TaskDefinition", "Overrides.Allowed", "Fargate.Subnnet"}, cfg.Overrides.Allowed)
+}
+
+func TestToScreamingSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Cluster":        "CLUSTER",
+		"EnablePublicIP": "ENABLE_PUBLIC_IP",
+		"SSH":            "SSH",
+		"ReadinessPort":  "READINESS_PORT",
+		"KeyID":          "KEY_ID",
+		"S3":             "S3",
+	}
+
+	for name, expected := range tests {
+		assert.Equal(t, expected, toScreamingSnakeCase(name), "name: %s", name)
+	}
+}
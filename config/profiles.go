@@ -0,0 +1,183 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ProfileKey identifies the selected profile in the rules and in the
+// messages of Authorize
+const ProfileKey = "Profile"
+
+// ErrUnknownProfile is returned when the selected profile is not configured
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Profile overrides the settings of the base configuration. Only the settings
+// defined in the [Profiles.<name>] tables override the base ones, the others
+// are inherited
+type Profile struct {
+	// RunnerTags selects the profile for the runners having one of the tags,
+	// when the job doesn't select a profile
+	RunnerTags []string
+
+	Fargate Fargate
+	SSH     SSH
+	Lease   Lease
+
+	// defined lists the keys of the settings defined in the profile, like
+	// "Fargate.Subnet"
+	defined []string
+}
+
+// recordDefinedProfileKeys records the settings defined by each profile, as
+// the zero values can't be told apart from the undefined settings
+func (g *Global) recordDefinedProfileKeys(metadata toml.MetaData) {
+	for name, profile := range g.Profiles {
+		profile.defined = nil
+
+		for _, key := range metadata.Keys() {
+			if len(key) > 2 && strings.EqualFold(key[0], "Profiles") && key[1] == name {
+				profile.defined = append(profile.defined, strings.Join(key[2:], "."))
+			}
+		}
+
+		g.Profiles[name] = profile
+	}
+}
+
+func (p Profile) defines(key string) bool {
+	for _, defined := range p.defined {
+		if strings.EqualFold(defined, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve returns the sections of the base configuration with the settings
+// defined by the profile
+func (p Profile) resolve(g Global) Profile {
+	resolved := Profile{
+		RunnerTags: p.RunnerTags,
+		Fargate:    g.Fargate,
+		SSH:        g.SSH,
+		Lease:      g.Lease,
+	}
+
+	target := reflect.ValueOf(&resolved).Elem()
+	walkSettings(reflect.TypeOf(p), reflect.ValueOf(p), "", func(key string, _ reflect.StructField, value reflect.Value) {
+		if p.defines(key) {
+			fieldByKey(target, key).Set(value)
+		}
+	})
+
+	return resolved
+}
+
+func fieldByKey(v reflect.Value, key string) reflect.Value {
+	for _, name := range strings.Split(key, ".") {
+		v = v.FieldByName(name)
+	}
+
+	return v
+}
+
+// SelectProfile returns the name of the profile requested by the job, or of
+// the first profile, in alphabetical order, selected by the tags of the
+// runner. It returns an empty name when no profile is selected
+func (g Global) SelectProfile(requested string, runnerTags []string) (string, error) {
+	if requested != "" {
+		if _, ok := g.Profiles[requested]; !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownProfile, requested)
+		}
+
+		return requested, nil
+	}
+
+	for _, name := range g.profileNames() {
+		for _, tag := range runnerTags {
+			if contains(g.Profiles[name].RunnerTags, tag) {
+				return name, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// WithProfile returns the configuration with the settings of the profile
+func (g Global) WithProfile(name string) (Global, error) {
+	profile, ok := g.Profiles[name]
+	if !ok {
+		return g, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+
+	resolved := profile.resolve(g)
+
+	g.Fargate = resolved.Fargate
+	g.SSH = resolved.SSH
+	g.Lease = resolved.Lease
+	g.profile = name
+
+	return g, nil
+}
+
+// RequestProfile returns the configuration with the settings of the profile
+// requested by the job. Unlike the profiles selected by the tags of the
+// runner, Authorize denies it unless it's listed in Overrides.Allowed or
+// allowed by a rule
+func (g Global) RequestProfile(name string) (Global, error) {
+	g, err := g.WithProfile(name)
+	if err != nil {
+		return g, err
+	}
+
+	g.profileRequested = true
+
+	return g, nil
+}
+
+// Profile returns the name of the profile applied with WithProfile
+func (g Global) Profile() string {
+	return g.profile
+}
+
+func (g Global) profileNames() []string {
+	names := make([]string, 0, len(g.Profiles))
+	for name := range g.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// profilesProblems checks the settings defined by the profiles. The
+// inherited settings are checked in the base configuration
+func (g Global) profilesProblems() []Problem {
+	var problems []Problem
+
+	for _, name := range g.profileNames() {
+		profile := g.Profiles[name]
+		prefix := fmt.Sprintf("Profiles.%s.", name)
+
+		walkSettings(reflect.TypeOf(profile), reflect.ValueOf(profile), "", func(key string, field reflect.StructField, value reflect.Value) {
+			if !profile.defines(key) {
+				return
+			}
+
+			message := checkSetting(field, value)
+			if message != "" {
+				problems = append(problems, Problem{Key: prefix + key, Message: message})
+			}
+		})
+	}
+
+	return problems
+}
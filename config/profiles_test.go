@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const profilesConfig = validConfig + `
+[Profiles.team-a]
+  RunnerTags = ["team-a"]
+
+  [Profiles.team-a.Fargate]
+    Subnet = "subnet-a"
+    EnablePublicIP = false
+
+  [Profiles.team-a.SSH]
+    Username = "builder"
+
+[Profiles.team-b.Fargate]
+  Cluster = "cluster-b"
+`
+
+func TestGlobal_WithProfile(t *testing.T) {
+	cfg, err := Load([]byte(profilesConfig))
+	require.NoError(t, err)
+
+	_, err = cfg.WithProfile("team-c")
+	assertions.ErrorIs(t, err, ErrUnknownProfile)
+
+	profiled, err := cfg.WithProfile("team-a")
+	require.NoError(t, err)
+
+	assert.Equal(t, "team-a", profiled.Profile())
+	assert.Equal(t, "subnet-a", profiled.Fargate.Subnet)
+	assert.False(t, profiled.Fargate.EnablePublicIP)
+	assert.Equal(t, "builder", profiled.SSH.Username)
+	assert.Equal(t, 22, profiled.SSH.Port)
+	assert.Equal(t, "cluster-name", profiled.Fargate.Cluster)
+
+	assert.Empty(t, cfg.Profile())
+	assert.Equal(t, "subnet-0123abcd", cfg.Fargate.Subnet)
+	assert.True(t, cfg.Fargate.EnablePublicIP)
+}
+
+func TestGlobal_SelectProfile(t *testing.T) {
+	cfg, err := Load([]byte(profilesConfig))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		requested       string
+		runnerTags      []string
+		expectedProfile string
+		expectedError   error
+	}{
+		"Requested by the job": {
+			requested:       "team-b",
+			runnerTags:      []string{"team-a"},
+			expectedProfile: "team-b",
+		},
+		"Unknown profile requested by the job": {
+			requested:     "team-c",
+			expectedError: ErrUnknownProfile,
+		},
+		"Selected by the runner tags": {
+			runnerTags:      []string{"docker", "team-a"},
+			expectedProfile: "team-a",
+		},
+		"Not selected": {
+			runnerTags: []string{"docker"},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			profile, err := cfg.SelectProfile(tt.requested, tt.runnerTags)
+
+			assertions.ErrorIs(t, err, tt.expectedError)
+			assert.Equal(t, tt.expectedProfile, profile)
+		})
+	}
+}
+
+func TestLoad_InvalidProfiles(t *testing.T) {
+	_, err := Load([]byte(validConfig + `
+[Profiles.team-a.Fargate]
+  Subnet = "sg-0123abcd"
+  Subnnet = "subnet-0123abcd"
+`))
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []Problem{
+		{Key: "Profiles.team-a.Fargate.Subnet", Line: 25, Message: `must be a subnet ID, like "subnet-0123abcd"`},
+		{Key: "Profiles.team-a.Fargate.Subnnet", Line: 26, Message: "is not a known setting"},
+	}, validationErr.Problems)
+}
+
+func TestGlobal_Authorize_Profile(t *testing.T) {
+	cfg, err := Load([]byte(profilesConfig + `
+[[Rules]]
+  Projects = ["team-a/*"]
+  Effect = "allow"
+  Profiles = ["team-a"]
+`))
+	require.NoError(t, err)
+
+	profiled, err := cfg.WithProfile("team-a")
+	require.NoError(t, err)
+
+	assert.NoError(t, profiled.Authorize(Job{ProjectPath: "team-a/project"}))
+
+	err = profiled.Authorize(Job{ProjectPath: "team-b/project"})
+	assertions.ErrorIs(t, err, ErrNotAuthorized)
+	assert.Contains(t, err.Error(), "Profile=team-a (not allowed by any rule)")
+
+	assert.NoError(t, cfg.Authorize(Job{ProjectPath: "team-b/project"}))
+}
+
+func TestGlobal_Authorize_RequestedProfile(t *testing.T) {
+	allowedProfile := `
+[Overrides]
+  Allowed = ["Profile"]
+`
+	profileRules := `
+[[Rules]]
+  Projects = ["team-a/*"]
+  Effect = "allow"
+  Profiles = ["team-a"]
+
+[[Rules]]
+  Effect = "deny"
+  Profiles = ["team-b"]
+`
+
+	tests := map[string]struct {
+		config          string
+		requested       string
+		job             Job
+		expectedError   error
+		expectedMessage string
+	}{
+		"No rules and profile not allowed": {
+			config:          profilesConfig,
+			requested:       "team-a",
+			job:             Job{ProjectPath: "team-a/project"},
+			expectedError:   ErrNotAuthorized,
+			expectedMessage: "Profile=team-a (not allowed by any rule nor by Overrides.Allowed)",
+		},
+		"No rules and profile allowed": {
+			config:    profilesConfig + allowedProfile,
+			requested: "team-a",
+			job:       Job{ProjectPath: "team-a/project"},
+		},
+		"Profile allowed by a rule": {
+			config:    profilesConfig + profileRules,
+			requested: "team-a",
+			job:       Job{ProjectPath: "team-a/project"},
+		},
+		"Profile not decided by any rule": {
+			config:          profilesConfig + profileRules,
+			requested:       "team-a",
+			job:             Job{ProjectPath: "team-b/project"},
+			expectedError:   ErrNotAuthorized,
+			expectedMessage: "Profile=team-a (not allowed by any rule nor by Overrides.Allowed)",
+		},
+		"Profile allowed and not decided by any rule": {
+			config:    profilesConfig + allowedProfile + profileRules,
+			requested: "team-a",
+			job:       Job{ProjectPath: "team-b/project"},
+		},
+		"Profile allowed and denied by a rule": {
+			config:          profilesConfig + allowedProfile + profileRules,
+			requested:       "team-b",
+			job:             Job{ProjectPath: "team-b/project"},
+			expectedError:   ErrNotAuthorized,
+			expectedMessage: "Profile=team-b (denied by rule #2)",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg, err := Load([]byte(tt.config))
+			require.NoError(t, err)
+
+			requested, err := cfg.RequestProfile(tt.requested)
+			require.NoError(t, err)
+
+			err = requested.Authorize(tt.job)
+			if tt.expectedError == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assertions.ErrorIs(t, err, tt.expectedError)
+			assert.Contains(t, err.Error(), tt.expectedMessage)
+		})
+	}
+}
+
+func TestGlobal_Authorize_RunnerTagsProfile(t *testing.T) {
+	cfg, err := Load([]byte(profilesConfig))
+	require.NoError(t, err)
+
+	profiled, err := cfg.WithProfile("team-a")
+	require.NoError(t, err)
+
+	assert.NoError(t, profiled.Authorize(Job{ProjectPath: "team-b/project"}))
+}
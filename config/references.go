@@ -0,0 +1,169 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Schemes of the references
+const (
+	ReferenceSchemeEnv            = "env"
+	ReferenceSchemeFile           = "file"
+	ReferenceSchemeSSM            = "ssm"
+	ReferenceSchemeSecretsManager = "secretsmanager"
+)
+
+// ErrReferenceNotFound is returned when the value referenced doesn't exist
+var ErrReferenceNotFound = errors.New("referenced value not found")
+
+// referenceRx matches the references, like "${ssm:/ci/token}"
+var referenceRx = regexp.MustCompile(`\$\{(env|file|ssm|secretsmanager):([^}]+)\}`)
+
+// References configures the resolution of the references to values stored
+// outside of the configuration file
+type References struct {
+	// Region of the ssm and secretsmanager references. Defaults to the
+	// region of the Fargate cluster
+	Region string
+	// SSMEndpoint and SecretsManagerEndpoint allow using local stand-ins of
+	// the services
+	SSMEndpoint            string
+	SecretsManagerEndpoint string
+}
+
+// referenceResolver returns the value of the name referenced with its scheme
+type referenceResolver func(name string) (string, error)
+
+// referenceResolvers resolves each reference once, as the same secret may
+// be referenced by several settings
+type referenceResolvers struct {
+	resolvers map[string]referenceResolver
+	cache     map[string]string
+}
+
+func newReferenceResolvers(resolvers map[string]referenceResolver) *referenceResolvers {
+	return &referenceResolvers{
+		resolvers: resolvers,
+		cache:     make(map[string]string),
+	}
+}
+
+// defaultReferenceResolvers creates the clients of the AWS services only
+// when they are referenced
+func defaultReferenceResolvers(cfg References, defaultRegion string) *referenceResolvers {
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	return newReferenceResolvers(map[string]referenceResolver{
+		ReferenceSchemeEnv:            resolveEnvReference,
+		ReferenceSchemeFile:           resolveFileReference,
+		ReferenceSchemeSSM:            newSSMResolver(region, cfg.SSMEndpoint),
+		ReferenceSchemeSecretsManager: newSecretsManagerResolver(region, cfg.SecretsManagerEndpoint),
+	})
+}
+
+func (r *referenceResolvers) resolve(reference string) (string, error) {
+	match := referenceRx.FindStringSubmatch(reference)
+
+	return r.resolveName(match[1], match[2])
+}
+
+func (r *referenceResolvers) resolveName(scheme string, name string) (string, error) {
+	key := scheme + ":" + name
+	if value, ok := r.cache[key]; ok {
+		return value, nil
+	}
+
+	value, err := r.resolvers[scheme](name)
+	if err != nil {
+		return "", err
+	}
+
+	r.cache[key] = value
+
+	return value, nil
+}
+
+func isReference(value string) bool {
+	return referenceRx.MatchString(value)
+}
+
+// resolveReferences replaces the references of the string settings with the
+// values they reference. The sections tagged with `reference:"false"` are
+// not resolved. The errors name the setting and the reference, but never
+// the referenced value.
+//
+// The references are resolved before the templates are rendered, so the
+// values are inserted as literals in the rendered settings, where they
+// would otherwise be parsed as templates
+func (g *Global) resolveReferences(resolvers *referenceResolvers) []Problem {
+	var problems []Problem
+
+	rendered := g.renderedKeys()
+
+	g.walkStringSettings("reference", func(key string, value reflect.Value) {
+		var resolveErr error
+
+		templated := isTemplate(value.String())
+
+		resolved := referenceRx.ReplaceAllStringFunc(value.String(), func(reference string) string {
+			if resolveErr != nil {
+				return reference
+			}
+
+			v, err := resolvers.resolve(reference)
+			if err != nil {
+				resolveErr = fmt.Errorf("resolving %s: %w", reference, err)
+				return reference
+			}
+
+			g.referenced = append(g.referenced, v)
+
+			if rendered[key] && (templated || isTemplate(v)) {
+				return templateLiteral(v)
+			}
+
+			return v
+		})
+
+		if resolveErr != nil {
+			problems = append(problems, Problem{Key: key, Message: resolveErr.Error()})
+			return
+		}
+
+		value.SetString(resolved)
+	})
+
+	return problems
+}
+
+func resolveEnvReference(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %q is not defined", ErrReferenceNotFound, name)
+	}
+
+	return value, nil
+}
+
+// resolveFileReference ignores the trailing newline, usually added by the
+// editors and the shell redirections
+func resolveFileReference(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: file %q doesn't exist", ErrReferenceNotFound, path)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("reading file %q: %w", path, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// referenceTimeout limits each request resolving a reference, so an
+// unreachable endpoint can't block the stages
+const referenceTimeout = 30 * time.Second
+
+type ssmClient interface {
+	GetParameterWithContext(aws.Context, *ssm.GetParameterInput, ...request.Option) (*ssm.GetParameterOutput, error)
+}
+
+type secretsManagerClient interface {
+	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+func newAWSSession(region string, endpoint string) (*session.Session, error) {
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if endpoint != "" {
+		awsConfig.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create AWS session: %w", err)
+	}
+
+	return sess, nil
+}
+
+// newSSMResolver creates the client on the first resolution
+func newSSMResolver(region string, endpoint string) referenceResolver {
+	var client ssmClient
+
+	return func(name string) (string, error) {
+		if client == nil {
+			sess, err := newAWSSession(region, endpoint)
+			if err != nil {
+				return "", err
+			}
+
+			client = ssm.New(sess)
+		}
+
+		return resolveSSMReference(client, name)
+	}
+}
+
+// resolveSSMReference decrypts the SecureString parameters
+func resolveSSMReference(client ssmClient, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), referenceTimeout)
+	defer cancel()
+
+	output, err := client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if isAWSErrorCode(err, ssm.ErrCodeParameterNotFound) {
+		return "", fmt.Errorf("%w: parameter %q doesn't exist", ErrReferenceNotFound, name)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("requesting SSM: %w", err)
+	}
+
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("%w: parameter %q has no value", ErrReferenceNotFound, name)
+	}
+
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+// newSecretsManagerResolver creates the client on the first resolution
+func newSecretsManagerResolver(region string, endpoint string) referenceResolver {
+	var client secretsManagerClient
+
+	return func(name string) (string, error) {
+		if client == nil {
+			sess, err := newAWSSession(region, endpoint)
+			if err != nil {
+				return "", err
+			}
+
+			client = secretsmanager.New(sess)
+		}
+
+		return resolveSecretsManagerReference(client, name)
+	}
+}
+
+// resolveSecretsManagerReference returns the current version of the secret,
+// which must be stored as a string
+func resolveSecretsManagerReference(client secretsManagerClient, secretID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), referenceTimeout)
+	defer cancel()
+
+	output, err := client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if isAWSErrorCode(err, secretsmanager.ErrCodeResourceNotFoundException) {
+		return "", fmt.Errorf("%w: secret %q doesn't exist", ErrReferenceNotFound, secretID)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("requesting Secrets Manager: %w", err)
+	}
+
+	if output.SecretString == nil {
+		return "", fmt.Errorf("%w: secret %q has no string value", ErrReferenceNotFound, secretID)
+	}
+
+	return aws.StringValue(output.SecretString), nil
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	var awsErr awserr.Error
+
+	return errors.As(err, &awsErr) && awsErr.Code() == code
+}
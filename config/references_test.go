@@ -0,0 +1,315 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const referencedConfig = `
+[Fargate]
+  Cluster = "${env:TEST_FARGATE_CLUSTER}"
+  Region = "us-east-1"
+  Subnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "ci-${env:TEST_FARGATE_CLUSTER}:${ssm:/ci/revision}"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+
+[SSH]
+  Username = "${secretsmanager:ci-user}"
+`
+
+func TestGlobal_resolveAndValidate(t *testing.T) {
+	values := map[string]string{
+		"env:TEST_FARGATE_CLUSTER": "cluster-name",
+		"ssm:/ci/revision":         "3",
+		"secretsmanager:ci-user":   "runner",
+	}
+
+	tests := map[string]struct {
+		values           map[string]string
+		expectedProblems []Problem
+	}{
+		"References resolved": {
+			values: values,
+		},
+		"Reference not found": {
+			values: map[string]string{
+				"env:TEST_FARGATE_CLUSTER": "cluster-name",
+				"ssm:/ci/revision":         "3",
+			},
+			expectedProblems: []Problem{
+				{Key: "SSH.Username", Line: 13, Message: "resolving ${secretsmanager:ci-user}: referenced value not found"},
+			},
+		},
+		"Resolved value invalid": {
+			values: map[string]string{
+				"env:TEST_FARGATE_CLUSTER": "cluster name",
+				"ssm:/ci/revision":         "3",
+				"secretsmanager:ci-user":   "runner",
+			},
+			expectedProblems: []Problem{
+				{Key: "Fargate.Cluster", Line: 3, Message: "must be a cluster name or ARN"},
+				{Key: "Fargate.TaskDefinition", Line: 7, Message: "must be a task definition family, family:revision or ARN"},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			calls := make(map[string]int)
+
+			resolver := func(scheme string) referenceResolver {
+				return func(name string) (string, error) {
+					calls[scheme+":"+name]++
+
+					value, ok := tt.values[scheme+":"+name]
+					if !ok {
+						return "", ErrReferenceNotFound
+					}
+
+					return value, nil
+				}
+			}
+
+			resolvers := newReferenceResolvers(map[string]referenceResolver{
+				ReferenceSchemeEnv:            resolver(ReferenceSchemeEnv),
+				ReferenceSchemeFile:           resolver(ReferenceSchemeFile),
+				ReferenceSchemeSSM:            resolver(ReferenceSchemeSSM),
+				ReferenceSchemeSecretsManager: resolver(ReferenceSchemeSecretsManager),
+			})
+
+			cfg, err := Load([]byte(referencedConfig))
+			require.NoError(t, err)
+
+			err = cfg.resolveAndValidate(indexKeyLines([]byte(referencedConfig)), resolvers)
+			assert.Equal(t, 1, calls["env:TEST_FARGATE_CLUSTER"], "the references must be resolved once")
+
+			if tt.expectedProblems != nil {
+				assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+				var validationErr *ValidationError
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.expectedProblems, validationErr.Problems)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "cluster-name", cfg.Fargate.Cluster)
+			assert.Equal(t, "ci-cluster-name:3", cfg.Fargate.TaskDefinition)
+			assert.Equal(t, "runner", cfg.SSH.Username)
+			assert.Subset(t, cfg.ReferencedValues(), []string{"cluster-name", "3", "runner"})
+		})
+	}
+}
+
+func TestLoadFromFile_References(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "subnet")
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte("subnet-secret\n"), 0600))
+
+	file := filepath.Join(dir, "config.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+[Fargate]
+  Cluster = "${env:TEST_FARGATE_CLUSTER}"
+  Region = "us-east-1"
+  Subnet = "${file:`+secretFile+`}"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "my-task-definition:1"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+`), 0600))
+
+	os.Unsetenv("TEST_FARGATE_CLUSTER")
+
+	_, err = LoadFromFile(file)
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), `line 3: Fargate.Cluster: resolving ${env:TEST_FARGATE_CLUSTER}: referenced value not found: environment variable "TEST_FARGATE_CLUSTER" is not defined`)
+
+	os.Setenv("TEST_FARGATE_CLUSTER", "cluster-name")
+	defer os.Unsetenv("TEST_FARGATE_CLUSTER")
+
+	cfg, err := LoadFromFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-name", cfg.Fargate.Cluster)
+	assert.Equal(t, "subnet-secret", cfg.Fargate.Subnet)
+
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte("not a subnet\n"), 0600))
+
+	_, err = LoadFromFile(file)
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "line 5: Fargate.Subnet: must be a subnet ID")
+	assert.NotContains(t, err.Error(), "not a subnet", "the referenced values must not be revealed")
+}
+
+func TestGlobal_resolveReferences_Literals(t *testing.T) {
+	data := `LogFile = "/var/log/{{ .ProjectPath }}/${ssm:/ci/log}"
+` + validConfig + `
+[Profiles.team-a.Fargate]
+  PlatformVersion = "${ssm:/ci/version}"
+
+[Stages.build_script]
+  Interpreter = "/bin/sh"
+`
+
+	values := map[string]string{
+		"ssm:/ci/log":     `{{ .RunnerToken }}".log`,
+		"ssm:/ci/version": "{{ .RunnerToken",
+	}
+
+	resolvers := newReferenceResolvers(map[string]referenceResolver{
+		ReferenceSchemeSSM: func(name string) (string, error) {
+			return values[ReferenceSchemeSSM+":"+name], nil
+		},
+	})
+
+	cfg, err := Load([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, cfg.resolveAndValidate(indexKeyLines([]byte(data)), resolvers))
+
+	rendered := cfg.renderedKeys()
+	assert.True(t, rendered["LogFile"])
+	assert.True(t, rendered["Profiles.team-a.Fargate.PlatformVersion"])
+	assert.False(t, rendered["Stages.build_script.Interpreter"], "the stages are not rendered")
+
+	profiled, err := cfg.WithProfile("team-a")
+	require.NoError(t, err)
+
+	renderedCfg, err := profiled.Render(TemplateData{ProjectPath: "group/project", RunnerToken: "token"})
+	require.NoError(t, err)
+
+	assert.Equal(t, `/var/log/group/project/{{ .RunnerToken }}".log`, renderedCfg.LogFile)
+	assert.Equal(t, "{{ .RunnerToken", renderedCfg.Fargate.PlatformVersion)
+}
+
+// hasDeadline matches the contexts limiting the requests
+func hasDeadline(ctx context.Context) bool {
+	_, ok := ctx.Deadline()
+	return ok
+}
+
+func TestResolveSSMReference(t *testing.T) {
+	requestErr := awserr.New(ssm.ErrCodeInternalServerError, "failure", nil)
+
+	tests := map[string]struct {
+		output        *ssm.GetParameterOutput
+		err           error
+		expectedValue string
+		expectedError error
+	}{
+		"Parameter found": {
+			output: &ssm.GetParameterOutput{
+				Parameter: &ssm.Parameter{Value: aws.String("secret")},
+			},
+			expectedValue: "secret",
+		},
+		"Parameter not found": {
+			err:           awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil),
+			expectedError: ErrReferenceNotFound,
+		},
+		"Request failed": {
+			err:           requestErr,
+			expectedError: requestErr,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockSsmClient)
+			defer client.AssertExpectations(t)
+
+			client.On("GetParameterWithContext", mock.MatchedBy(hasDeadline), &ssm.GetParameterInput{
+				Name:           aws.String("/ci/token"),
+				WithDecryption: aws.Bool(true),
+			}).Return(tt.output, tt.err).Once()
+
+			value, err := resolveSSMReference(client, "/ci/token")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestResolveSecretsManagerReference(t *testing.T) {
+	tests := map[string]struct {
+		output        *secretsmanager.GetSecretValueOutput
+		err           error
+		expectedValue string
+		expectedError error
+	}{
+		"Secret found": {
+			output:        &secretsmanager.GetSecretValueOutput{SecretString: aws.String("secret")},
+			expectedValue: "secret",
+		},
+		"Secret not found": {
+			err:           awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil),
+			expectedError: ErrReferenceNotFound,
+		},
+		"Binary secret": {
+			output:        &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("secret")},
+			expectedError: ErrReferenceNotFound,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockSecretsManagerClient)
+			defer client.AssertExpectations(t)
+
+			client.On("GetSecretValueWithContext", mock.MatchedBy(hasDeadline), &secretsmanager.GetSecretValueInput{
+				SecretId: aws.String("ci-token"),
+			}).Return(tt.output, tt.err).Once()
+
+			value, err := resolveSecretsManagerReference(client, "ci-token")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestGlobal_ApplyOverrides_Reference(t *testing.T) {
+	cfg, err := Load([]byte(templatedConfig))
+	require.NoError(t, err)
+
+	_, err = cfg.ApplyOverrides(func(variable string) string {
+		if variable == "FARGATE_TASK_DEFINITION" {
+			return "${env:AWS_SECRET_ACCESS_KEY}"
+		}
+
+		return ""
+	})
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "FARGATE_TASK_DEFINITION: references are not allowed")
+}
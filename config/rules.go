@@ -0,0 +1,258 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Effects of the rules
+const (
+	RuleEffectAllow = "allow"
+	RuleEffectDeny  = "deny"
+)
+
+// ErrNotAuthorized is returned when the job overrides settings with values
+// not allowed by the rules
+var ErrNotAuthorized = errors.New("job is not authorized to use the requested task settings")
+
+// Rule allows or denies the settings overridden by the jobs matching its
+// conditions. Empty conditions match any job. Patterns are globs, where "*"
+// doesn't match "/", or regular expressions when enclosed in slashes
+type Rule struct {
+	// Projects are patterns matching the path of the project, like "group/project"
+	Projects []string
+	// Refs are patterns matching the branch or tag of the pipeline
+	Refs []string
+	// Protected matches the protection of the branch or tag when set
+	Protected *bool
+	// PipelineSources lists the sources of the pipeline, like "push" or "schedule"
+	PipelineSources []string
+
+	// Profiles are patterns matching the name of the selected profile
+	Profiles []string
+
+	Effect string `required:"true" enum:"allow,deny"`
+
+	// TaskDefinitions and Clusters are patterns matching the overridden values
+	TaskDefinitions []string
+	Clusters        []string
+	// CPU and Memory list the overridden task sizes
+	CPU    []int
+	Memory []int
+	// Overrides lists the keys of the other settings that can be overridden
+	Overrides []string
+}
+
+// Job describes the job matched by the conditions of the rules
+type Job struct {
+	ProjectPath    string
+	Ref            string
+	RefProtected   bool
+	PipelineSource string
+}
+
+// Authorize checks the selected profile and the settings overridden by the
+// job against the rules. For each of them, the first rule matching the job
+// and the value decides. Values not matched by any rule are denied. All the
+// overrides and the profiles selected by the tags of the runner are allowed
+// when no rule is configured, but a profile requested by the job must always
+// be listed in Overrides.Allowed or allowed by a rule
+func (g Global) Authorize(job Job) error {
+	keys := g.overridden
+	if g.profile != "" {
+		keys = append([]string{ProfileKey}, keys...)
+	}
+
+	var denied []string
+	for _, key := range keys {
+		value := g.settingValue(key)
+
+		allowed, rule := g.decide(job, key, value)
+		if allowed || (rule < 0 && g.allowsUndecided(key)) {
+			continue
+		}
+
+		reason := "not allowed by any rule"
+		if key == ProfileKey && g.profileRequested {
+			reason = "not allowed by any rule nor by Overrides.Allowed"
+		}
+		if rule >= 0 {
+			reason = fmt.Sprintf("denied by rule #%d", rule+1)
+		}
+
+		denied = append(denied, fmt.Sprintf("%s=%v (%s)", key, value, reason))
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("%w: %s", ErrNotAuthorized, strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+// decide returns the effect of the first rule deciding on the value, and
+// the index of the rule. The index is -1 when no rule decides
+func (g Global) decide(job Job, key string, value interface{}) (bool, int) {
+	for i, rule := range g.Rules {
+		if rule.matches(job) && rule.covers(key, value) {
+			return rule.Effect == RuleEffectAllow, i
+		}
+	}
+
+	return false, -1
+}
+
+// allowsUndecided reports whether a value no rule decides on is allowed
+func (g Global) allowsUndecided(key string) bool {
+	if key == ProfileKey && g.profileRequested {
+		return g.Overrides.allows(ProfileKey)
+	}
+
+	return len(g.Rules) == 0
+}
+
+func (g Global) settingValue(key string) interface{} {
+	if key == ProfileKey {
+		return g.profile
+	}
+
+	var value interface{}
+
+	walkSettings(reflect.TypeOf(g), reflect.ValueOf(g), "", func(settingKey string, _ reflect.StructField, settingValue reflect.Value) {
+		if strings.EqualFold(settingKey, key) {
+			value = settingValue.Interface()
+		}
+	})
+
+	return value
+}
+
+func (r Rule) matches(job Job) bool {
+	if len(r.Projects) > 0 && !matchesAnyPattern(r.Projects, job.ProjectPath) {
+		return false
+	}
+
+	if len(r.Refs) > 0 && !matchesAnyPattern(r.Refs, job.Ref) {
+		return false
+	}
+
+	if r.Protected != nil && *r.Protected != job.RefProtected {
+		return false
+	}
+
+	return len(r.PipelineSources) == 0 || contains(r.PipelineSources, job.PipelineSource)
+}
+
+// covers reports whether the rule decides on the value of the setting
+func (r Rule) covers(key string, value interface{}) bool {
+	switch strings.ToLower(key) {
+	case strings.ToLower(ProfileKey):
+		return matchesAnyPattern(r.Profiles, fmt.Sprint(value))
+	case "fargate.taskdefinition":
+		return matchesAnyPattern(r.TaskDefinitions, fmt.Sprint(value))
+	case "fargate.cluster":
+		return matchesAnyPattern(r.Clusters, fmt.Sprint(value))
+	case "fargate.cpu":
+		return containsInt(r.CPU, value)
+	case "fargate.memory":
+		return containsInt(r.Memory, value)
+	}
+
+	for _, override := range r.Overrides {
+		if strings.EqualFold(override, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) problems(index int) []Problem {
+	var problems []Problem
+
+	add := func(setting string, message string) {
+		problems = append(problems, Problem{
+			Key:     fmt.Sprintf("Rules[%d].%s", index, setting),
+			Message: message,
+		})
+	}
+
+	patterns := map[string][]string{
+		"Projects":        r.Projects,
+		"Refs":            r.Refs,
+		"Profiles":        r.Profiles,
+		"TaskDefinitions": r.TaskDefinitions,
+		"Clusters":        r.Clusters,
+	}
+
+	for _, setting := range []string{"Projects", "Refs", "Profiles", "TaskDefinitions", "Clusters"} {
+		for _, pattern := range patterns[setting] {
+			_, err := matchPattern(pattern, "")
+			if err != nil {
+				add(setting, fmt.Sprintf("invalid pattern %q: %v", pattern, err))
+			}
+		}
+	}
+
+	for _, override := range r.Overrides {
+		if !isOverridableSetting(override) {
+			add("Overrides", fmt.Sprintf("%q is not a setting that can be overridden", override))
+		}
+	}
+
+	return problems
+}
+
+func (g Global) rulesProblems() []Problem {
+	var problems []Problem
+	for i, rule := range g.Rules {
+		problems = append(problems, rule.problems(i)...)
+	}
+
+	return problems
+}
+
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		matched, err := matchPattern(pattern, value)
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern matches the value with a glob, or with a regular expression
+// when the pattern is enclosed in slashes. Both must match the whole value
+func matchPattern(pattern string, value string) (bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr := pattern[1 : len(pattern)-1]
+
+		// The expression is checked alone, so the errors don't mention the anchors
+		_, err := regexp.Compile(expr)
+		if err != nil {
+			return false, err
+		}
+
+		rx := regexp.MustCompile(fmt.Sprintf("^(?:%s)$", expr))
+
+		return rx.MatchString(value), nil
+	}
+
+	return path.Match(pattern, value)
+}
+
+func containsInt(values []int, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const rulesConfig = validConfig + `
+[Overrides]
+  Allowed = ["Fargate.TaskDefinition", "Fargate.Cluster", "Fargate.CPU", "Fargate.Memory", "Fargate.PlatformVersion"]
+
+[[Rules]]
+  Projects = ["infra/*"]
+  Protected = true
+  Effect = "allow"
+  TaskDefinitions = ["deploy:*"]
+  Clusters = ["/^deploy-[a-z]+$/"]
+
+[[Rules]]
+  Effect = "deny"
+  TaskDefinitions = ["deploy*"]
+
+[[Rules]]
+  PipelineSources = ["push", "merge_request_event"]
+  Effect = "allow"
+  TaskDefinitions = ["*"]
+  CPU = [256, 512]
+  Memory = [512, 1024]
+  Overrides = ["Fargate.PlatformVersion"]
+`
+
+func TestGlobal_Authorize(t *testing.T) {
+	protectedInfraJob := Job{ProjectPath: "infra/tools", Ref: "main", RefProtected: true, PipelineSource: "push"}
+	unprotectedInfraJob := Job{ProjectPath: "infra/tools", Ref: "feature", PipelineSource: "push"}
+	scheduledJob := Job{ProjectPath: "group/project", Ref: "main", PipelineSource: "schedule"}
+
+	tests := map[string]struct {
+		noRules         bool
+		variables       map[string]string
+		job             Job
+		expectedDenied  []string
+		expectedAllowed bool
+	}{
+		"No overrides": {
+			job:             scheduledJob,
+			expectedAllowed: true,
+		},
+		"No rules": {
+			noRules:         true,
+			variables:       map[string]string{"FARGATE_TASK_DEFINITION": "deploy:1"},
+			job:             scheduledJob,
+			expectedAllowed: true,
+		},
+		"Allowed by a project rule": {
+			variables: map[string]string{
+				"FARGATE_TASK_DEFINITION": "deploy:3",
+				"FARGATE_CLUSTER":         "deploy-prod",
+			},
+			job:             protectedInfraJob,
+			expectedAllowed: true,
+		},
+		"Denied by an earlier rule": {
+			variables: map[string]string{"FARGATE_TASK_DEFINITION": "deploy:3"},
+			job:       unprotectedInfraJob,
+			expectedDenied: []string{
+				"Fargate.TaskDefinition=deploy:3 (denied by rule #2)",
+			},
+		},
+		"Allowed by a pipeline source rule": {
+			variables: map[string]string{
+				"FARGATE_TASK_DEFINITION":  "build:2",
+				"FARGATE_CPU":              "512",
+				"FARGATE_MEMORY":           "1024",
+				"FARGATE_PLATFORM_VERSION": "1.4.0",
+			},
+			job:             unprotectedInfraJob,
+			expectedAllowed: true,
+		},
+		"Not allowed by any rule": {
+			variables: map[string]string{
+				"FARGATE_TASK_DEFINITION": "build:2",
+				"FARGATE_CPU":             "4096",
+				"FARGATE_MEMORY":          "8192",
+			},
+			job: scheduledJob,
+			expectedDenied: []string{
+				"Fargate.CPU=4096 (not allowed by any rule)",
+				"Fargate.Memory=8192 (not allowed by any rule)",
+				"Fargate.TaskDefinition=build:2 (not allowed by any rule)",
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg, err := Load([]byte(rulesConfig))
+			require.NoError(t, err)
+
+			if tt.noRules {
+				cfg.Rules = nil
+			}
+
+			_, err = cfg.ApplyOverrides(func(variable string) string {
+				return tt.variables[variable]
+			})
+			require.NoError(t, err)
+
+			err = cfg.Authorize(tt.job)
+			if tt.expectedAllowed {
+				assert.NoError(t, err)
+				return
+			}
+
+			assertions.ErrorIs(t, err, ErrNotAuthorized)
+			for _, denied := range tt.expectedDenied {
+				assert.Contains(t, err.Error(), denied)
+			}
+		})
+	}
+}
+
+func TestLoad_InvalidRules(t *testing.T) {
+	_, err := Load([]byte(validConfig + `
+[[Rules]]
+  Effect = "allow"
+
+[[Rules]]
+  Projects = ["/group/[/"]
+  Effect = "permit"
+  Overrides = ["Fargate.Subnets"]
+`))
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []Problem{
+		{Key: "Rules[1].Projects", Line: 28, Message: "invalid pattern \"/group/[/\": error parsing regexp: missing closing ]: `[`"},
+		{Key: "Rules[1].Effect", Line: 29, Message: "must be one of allow, deny"},
+		{Key: "Rules[1].Overrides", Line: 30, Message: `"Fargate.Subnets" is not a setting that can be overridden`},
+	}, validationErr.Problems)
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := map[string]struct {
+		pattern       string
+		value         string
+		expectedMatch bool
+	}{
+		"Glob matching": {
+			pattern:       "group/*",
+			value:         "group/project",
+			expectedMatch: true,
+		},
+		"Glob not matching nested paths": {
+			pattern: "group/*",
+			value:   "group/subgroup/project",
+		},
+		"Regular expression matching": {
+			pattern:       `/group\/project/`,
+			value:         "group/project",
+			expectedMatch: true,
+		},
+		"Regular expression not matching a longer value": {
+			pattern: `/group\/project/`,
+			value:   "evilgroup/project-fork",
+		},
+		"Regular expression not matching a suffix": {
+			pattern: `/group\/project/`,
+			value:   "group/project-fork",
+		},
+		"Regular expression with alternatives": {
+			pattern:       "/deploy-staging|deploy-production/",
+			value:         "deploy-production",
+			expectedMatch: true,
+		},
+		"Regular expression with alternatives not matching a longer value": {
+			pattern: "/deploy-staging|deploy-production/",
+			value:   "deploy-staging-copy",
+		},
+		"Explicitly anchored regular expression": {
+			pattern:       "/^deploy-[a-z]+$/",
+			value:         "deploy-prod",
+			expectedMatch: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			matched, err := matchPattern(tt.pattern, tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedMatch, matched)
+		})
+	}
+}
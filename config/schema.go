@@ -0,0 +1,152 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+	durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+)
+
+// JSONSchema describes the configuration file with a JSON Schema, built from
+// the rules declared in the tags of the configuration structs. The settings
+// required only by some backends and providers are not described
+func JSONSchema() ([]byte, error) {
+	schema := objectSchema(reflect.TypeOf(Global{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "Configuration of the Fargate driver for GitLab Runner's Custom Executor"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type jsonSchema map[string]interface{}
+
+func objectSchema(t reflect.Type) jsonSchema {
+	properties := make(map[string]jsonSchema)
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if field.Tag.Get("required") == "true" {
+			required = append(required, field.Name)
+		}
+
+		if isSetting(field.Type) {
+			properties[field.Name] = settingSchema(field)
+			continue
+		}
+
+		if isSectionList(field.Type) {
+			properties[field.Name] = jsonSchema{"type": "array", "items": objectSchema(field.Type.Elem())}
+			continue
+		}
+
+		if isSectionMap(field.Type) {
+			properties[field.Name] = jsonSchema{"type": "object", "additionalProperties": objectSchema(field.Type.Elem())}
+			continue
+		}
+
+		properties[field.Name] = objectSchema(field.Type)
+	}
+
+	schema := jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func settingSchema(field reflect.StructField) jsonSchema {
+	schema := jsonSchema{"type": schemaType(field.Type)}
+
+	if field.Type.Kind() == reflect.Slice {
+		schema["items"] = jsonSchema{"type": schemaType(field.Type.Elem())}
+	}
+
+	if field.Type.Kind() == reflect.Map {
+		schema["additionalProperties"] = jsonSchema{"type": schemaType(field.Type.Elem())}
+	}
+
+	if field.Type == reflect.TypeOf(Duration{}) {
+		schema["pattern"] = durationPattern
+	}
+
+	if enum, ok := field.Tag.Lookup("enum"); ok {
+		schema["enum"] = enumValues(field, strings.Split(enum, ","))
+	}
+
+	// The templates and references are accepted, as their rendered and
+	// resolved values are checked
+	if pattern, ok := field.Tag.Lookup("pattern"); ok {
+		schema["pattern"] = fmt.Sprintf("(%s)|%s|%s", pattern, regexp.QuoteMeta(templateActionDelimiter), referenceRx.String())
+	}
+
+	if format, ok := field.Tag.Lookup("format"); ok {
+		schema["description"] = "Must be " + format
+	}
+
+	if minimum, ok := intTag(field, "minimum"); ok {
+		schema["minimum"] = minimum
+	}
+
+	if maximum, ok := intTag(field, "maximum"); ok {
+		schema["maximum"] = maximum
+	}
+
+	return schema
+}
+
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Slice:
+		return "array"
+	case reflect.Map:
+		return "object"
+	case reflect.Ptr:
+		return schemaType(t.Elem())
+	default:
+		return "string"
+	}
+}
+
+// enumValues converts the values allowed for the integer settings, which
+// would not match the integers of the document as strings
+func enumValues(field reflect.StructField, values []string) []interface{} {
+	enum := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if field.Type.Kind() != reflect.Int {
+			enum = append(enum, value)
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid \"enum\" tag of the %s field: %v", field.Name, err))
+		}
+
+		enum = append(enum, i)
+	}
+
+	return enum
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSchema(t *testing.T) {
+	content, err := JSONSchema()
+	require.NoError(t, err)
+
+	var schema struct {
+		Schema               string `json:"$schema"`
+		AdditionalProperties bool
+		Properties           map[string]struct {
+			Required   []string
+			Properties map[string]map[string]interface{}
+		}
+	}
+
+	require.NoError(t, json.Unmarshal(content, &schema))
+	assert.Equal(t, jsonSchemaDraft, schema.Schema)
+	assert.False(t, schema.AdditionalProperties)
+
+	fargate := schema.Properties["Fargate"]
+	assert.Equal(t, []string{"Cluster", "Region", "Subnet", "SecurityGroup", "TaskDefinition"}, fargate.Required)
+	assert.Equal(t, "string", fargate.Properties["Subnet"]["type"])
+	assert.Equal(t, `(^subnet-\S+$)|\{\{|\$\{(env|file|ssm|secretsmanager):([^}]+)\}`, fargate.Properties["Subnet"]["pattern"])
+	assert.Equal(t, "boolean", fargate.Properties["EnablePublicIP"]["type"])
+
+	ssh := schema.Properties["SSH"]
+	assert.Equal(t, "integer", ssh.Properties["Port"]["type"])
+	assert.Equal(t, float64(65535), ssh.Properties["Port"]["maximum"])
+
+	lease := schema.Properties["Lease"]
+	assert.Equal(t, durationPattern, lease.Properties["DefaultDuration"]["pattern"])
+
+	configStage := schema.Properties["ConfigStage"]
+	assert.Equal(t, "boolean", configStage.Properties["BuildsDirIsShared"]["type"])
+	assert.Equal(t, "object", configStage.Properties["JobEnv"]["type"])
+	assert.Equal(t, map[string]interface{}{"type": "string"}, configStage.Properties["JobEnv"]["additionalProperties"])
+}
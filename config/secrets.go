@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Delivery methods of the secrets
+const (
+	SecretsDeliveryFile        = "file"
+	SecretsDeliveryEnvironment = "environment"
+)
+
+var (
+	// ErrInvalidSecret is returned when the definition of a secret can't be parsed
+	ErrInvalidSecret = errors.New("invalid secret definition")
+
+	// ErrSecretNotAllowed is returned when the job requests a secret not
+	// allowed by Secrets.Allowed
+	ErrSecretNotAllowed = errors.New("secret is not allowed")
+)
+
+var variableNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Secrets configures the secrets resolved by the driver when the task is
+// prepared, and delivered to the job as variables. The secrets are defined
+// as "NAME=ssm:/parameter" or "NAME=secretsmanager:name-or-arn"
+type Secrets struct {
+	// Variables lists the secrets delivered to all the jobs
+	Variables []string
+	// Allowed are patterns matching the references the jobs can request
+	// with the FARGATE_SECRETS variable, like "ssm:/ci/*"
+	Allowed []string
+	// Delivery is the method delivering the secrets, "file" by default. The
+	// file method writes them over SSH to EnvFile, sourced by the run stages.
+	// The environment method sets them in the container overrides of the
+	// task, where anyone allowed to describe the task can read them
+	Delivery string `enum:"file,environment"`
+	// EnvFile is the file of the container receiving the secrets. It should
+	// be on a tmpfs, like /dev/shm, so the secrets are never written to disk
+	EnvFile string `pattern:"^/" format:"an absolute path"`
+}
+
+// Secret is a variable of the job, whose value is stored in SSM Parameter
+// Store or Secrets Manager
+type Secret struct {
+	Variable string
+	Scheme   string
+	Name     string
+}
+
+// Reference returns the reference of the value, like "ssm:/ci/db"
+func (s Secret) Reference() string {
+	return s.Scheme + ":" + s.Name
+}
+
+// ParseSecret parses the definition of a secret, like "DB_PASS=ssm:/ci/db"
+func ParseSecret(definition string) (Secret, error) {
+	parts := strings.SplitN(strings.TrimSpace(definition), "=", 2)
+	if len(parts) != 2 {
+		return Secret{}, fmt.Errorf("%w: %q must be NAME=scheme:reference", ErrInvalidSecret, definition)
+	}
+
+	if !variableNameRx.MatchString(parts[0]) {
+		return Secret{}, fmt.Errorf("%w: %q is not a valid variable name", ErrInvalidSecret, parts[0])
+	}
+
+	reference := strings.SplitN(parts[1], ":", 2)
+	if len(reference) != 2 || reference[1] == "" {
+		return Secret{}, fmt.Errorf("%w: %q must be NAME=scheme:reference", ErrInvalidSecret, definition)
+	}
+
+	switch reference[0] {
+	case ReferenceSchemeSSM, ReferenceSchemeSecretsManager:
+	default:
+		return Secret{}, fmt.Errorf("%w: scheme of %q must be %s or %s", ErrInvalidSecret, definition, ReferenceSchemeSSM, ReferenceSchemeSecretsManager)
+	}
+
+	return Secret{Variable: parts[0], Scheme: reference[0], Name: reference[1]}, nil
+}
+
+// JobSecrets returns the secrets of the configuration and the ones requested
+// by the job, sorted by variable. The requested secrets must match one of the
+// Secrets.Allowed patterns, and replace the configured ones of the same name
+func (g Global) JobSecrets(requested []string) ([]Secret, error) {
+	secrets := make(map[string]Secret)
+
+	for _, definition := range g.Secrets.Variables {
+		secret, err := ParseSecret(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		secrets[secret.Variable] = secret
+	}
+
+	var denied []string
+	for _, definition := range requested {
+		secret, err := ParseSecret(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matchesAnyPattern(g.Secrets.Allowed, secret.Reference()) {
+			denied = append(denied, fmt.Sprintf("%s=%s", secret.Variable, secret.Reference()))
+			continue
+		}
+
+		secrets[secret.Variable] = secret
+	}
+
+	if len(denied) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotAllowed, strings.Join(denied, ", "))
+	}
+
+	list := make([]Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		list = append(list, secret)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Variable < list[j].Variable
+	})
+
+	return list, nil
+}
+
+// ResolveSecrets returns the values of the secrets by variable. The values
+// are fetched with the settings of the References section. The errors name
+// the references, but never the values
+func (g Global) ResolveSecrets(secrets []Secret) (map[string]string, error) {
+	return resolveSecrets(secrets, defaultReferenceResolvers(g.References, g.Fargate.Region))
+}
+
+func resolveSecrets(secrets []Secret, resolvers *referenceResolvers) (map[string]string, error) {
+	values := make(map[string]string, len(secrets))
+
+	for _, secret := range secrets {
+		value, err := resolvers.resolveName(secret.Scheme, secret.Name)
+		if err != nil {
+			return nil, fmt.Errorf("resolving secret %s from %s: %w", secret.Variable, secret.Reference(), err)
+		}
+
+		values[secret.Variable] = value
+	}
+
+	return values, nil
+}
+
+func (s Secrets) problems() []Problem {
+	var problems []Problem
+
+	for _, definition := range s.Variables {
+		_, err := ParseSecret(definition)
+		if err != nil {
+			problems = append(problems, Problem{Key: "Secrets.Variables", Message: err.Error()})
+		}
+	}
+
+	for _, pattern := range s.Allowed {
+		_, err := matchPattern(pattern, "")
+		if err != nil {
+			problems = append(problems, Problem{
+				Key:     "Secrets.Allowed",
+				Message: fmt.Sprintf("invalid pattern %q: %v", pattern, err),
+			})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestParseSecret(t *testing.T) {
+	tests := map[string]struct {
+		definition     string
+		expectedSecret Secret
+		expectedError  error
+	}{
+		"SSM parameter": {
+			definition:     "DB_PASS=ssm:/ci/db",
+			expectedSecret: Secret{Variable: "DB_PASS", Scheme: ReferenceSchemeSSM, Name: "/ci/db"},
+		},
+		"Secrets Manager secret": {
+			definition:     " TOKEN=secretsmanager:arn:aws:secretsmanager:us-east-1:123:secret:token ",
+			expectedSecret: Secret{Variable: "TOKEN", Scheme: ReferenceSchemeSecretsManager, Name: "arn:aws:secretsmanager:us-east-1:123:secret:token"},
+		},
+		"Missing reference": {
+			definition:    "DB_PASS",
+			expectedError: ErrInvalidSecret,
+		},
+		"Invalid variable name": {
+			definition:    "DB-PASS=ssm:/ci/db",
+			expectedError: ErrInvalidSecret,
+		},
+		"Empty name": {
+			definition:    "DB_PASS=ssm:",
+			expectedError: ErrInvalidSecret,
+		},
+		"Unsupported scheme": {
+			definition:    "DB_PASS=file:/etc/passwd",
+			expectedError: ErrInvalidSecret,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			secret, err := ParseSecret(tt.definition)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSecret, secret)
+		})
+	}
+}
+
+func TestGlobal_JobSecrets(t *testing.T) {
+	tests := map[string]struct {
+		secrets         Secrets
+		requested       []string
+		expectedSecrets []Secret
+		expectedError   error
+	}{
+		"No secrets": {
+			expectedSecrets: []Secret{},
+		},
+		"Configured secrets": {
+			secrets: Secrets{Variables: []string{"TOKEN=secretsmanager:token", "DB_PASS=ssm:/ci/db"}},
+			expectedSecrets: []Secret{
+				{Variable: "DB_PASS", Scheme: ReferenceSchemeSSM, Name: "/ci/db"},
+				{Variable: "TOKEN", Scheme: ReferenceSchemeSecretsManager, Name: "token"},
+			},
+		},
+		"Requested secrets allowed": {
+			secrets: Secrets{
+				Variables: []string{"DB_PASS=ssm:/ci/db"},
+				Allowed:   []string{"ssm:/ci/*"},
+			},
+			requested: []string{"DB_PASS=ssm:/ci/other-db", "API_KEY=ssm:/ci/api"},
+			expectedSecrets: []Secret{
+				{Variable: "API_KEY", Scheme: ReferenceSchemeSSM, Name: "/ci/api"},
+				{Variable: "DB_PASS", Scheme: ReferenceSchemeSSM, Name: "/ci/other-db"},
+			},
+		},
+		"Requested secrets not allowed": {
+			secrets:       Secrets{Allowed: []string{"ssm:/ci/*"}},
+			requested:     []string{"DB_PASS=ssm:/ci/db", "ROOT=ssm:/admin/root"},
+			expectedError: ErrSecretNotAllowed,
+		},
+		"Requested secrets allowed by a regular expression": {
+			secrets:   Secrets{Allowed: []string{`/ssm:\/ci\/(db|api)/`}},
+			requested: []string{"DB_PASS=ssm:/ci/db"},
+			expectedSecrets: []Secret{
+				{Variable: "DB_PASS", Scheme: ReferenceSchemeSSM, Name: "/ci/db"},
+			},
+		},
+		"Requested secrets partially matching a regular expression": {
+			secrets:       Secrets{Allowed: []string{`/ssm:\/ci\/(db|api)/`}},
+			requested:     []string{"DB_PASS=ssm:/ci/db-admin"},
+			expectedError: ErrSecretNotAllowed,
+		},
+		"Requested secrets containing a regular expression match": {
+			secrets:       Secrets{Allowed: []string{`/ssm:\/ci\/db/`}},
+			requested:     []string{"ROOT=secretsmanager:ssm:/ci/db"},
+			expectedError: ErrSecretNotAllowed,
+		},
+		"Requested secrets without allowed patterns": {
+			requested:     []string{"DB_PASS=ssm:/ci/db"},
+			expectedError: ErrSecretNotAllowed,
+		},
+		"Invalid requested secret": {
+			secrets:       Secrets{Allowed: []string{"*"}},
+			requested:     []string{"DB_PASS"},
+			expectedError: ErrInvalidSecret,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg := Global{Secrets: tt.secrets}
+
+			secrets, err := cfg.JobSecrets(tt.requested)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedSecrets, secrets)
+		})
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	secrets := []Secret{
+		{Variable: "DB_PASS", Scheme: ReferenceSchemeSSM, Name: "/ci/db"},
+		{Variable: "TOKEN", Scheme: ReferenceSchemeSecretsManager, Name: "token"},
+	}
+
+	tests := map[string]struct {
+		resolveError   error
+		expectedValues map[string]string
+		expectedError  error
+	}{
+		"Secrets resolved": {
+			expectedValues: map[string]string{"DB_PASS": "ssm-/ci/db", "TOKEN": "secretsmanager-token"},
+		},
+		"Error on resolving a secret": {
+			resolveError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			resolvers := newReferenceResolvers(map[string]referenceResolver{
+				ReferenceSchemeSSM: func(name string) (string, error) {
+					return "ssm-" + name, nil
+				},
+				ReferenceSchemeSecretsManager: func(name string) (string, error) {
+					return "secretsmanager-" + name, tt.resolveError
+				},
+			})
+
+			values, err := resolveSecrets(secrets, resolvers)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				assert.Contains(t, err.Error(), "TOKEN from secretsmanager:token")
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedValues, values)
+		})
+	}
+}
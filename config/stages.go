@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Executors of the stages
+const (
+	StageExecutorContainer = "container"
+	StageExecutorHost      = "host"
+)
+
+// knownStages are the stages of the jobs passed by GitLab Runner to the "run"
+// stage of the Custom Executor
+var knownStages = []string{
+	"prepare_script",
+	"get_sources",
+	"restore_cache",
+	"download_artifacts",
+	"step_script",
+	"step_release",
+	"build_script",
+	"after_script",
+	"archive_cache",
+	"archive_cache_on_failure",
+	"upload_artifacts_on_success",
+	"upload_artifacts_on_failure",
+	"cleanup_file_variables",
+}
+
+// retryableStages are safe to retry, as they only fetch files into the
+// project directory
+var retryableStages = []string{"get_sources", "restore_cache", "download_artifacts"}
+
+// ProjectTransfer is the direction the project directory is moved in, around
+// a stage executed on the Runner host
+type ProjectTransfer int
+
+const (
+	// TransferFromContainer copies the project directory to the host before
+	// the stage, for the stages reading the files of the job
+	TransferFromContainer ProjectTransfer = iota
+	// TransferToContainer copies the project directory to the container
+	// after the stage, for the stages writing the files of the job
+	TransferToContainer
+)
+
+type hostStage struct {
+	name     string
+	transfer ProjectTransfer
+}
+
+// hostStages can be executed on the Runner host, as the files they need are
+// known
+var hostStages = []hostStage{
+	{name: "archive_cache", transfer: TransferFromContainer},
+	{name: "archive_cache_on_failure", transfer: TransferFromContainer},
+	{name: "upload_artifacts_on_success", transfer: TransferFromContainer},
+	{name: "upload_artifacts_on_failure", transfer: TransferFromContainer},
+	{name: "restore_cache", transfer: TransferToContainer},
+	{name: "download_artifacts", transfer: TransferToContainer},
+}
+
+// HostCapableStages returns the stages that can be executed on the Runner host
+func HostCapableStages() []string {
+	names := make([]string, 0, len(hostStages))
+	for _, stage := range hostStages {
+		names = append(names, stage.name)
+	}
+
+	return names
+}
+
+// HostStageTransfer returns how the project directory is moved around the
+// stage executed on the Runner host. It returns false for the other stages
+func HostStageTransfer(name string) (ProjectTransfer, bool) {
+	for _, stage := range hostStages {
+		if stage.name == name {
+			return stage.transfer, true
+		}
+	}
+
+	return 0, false
+}
+
+// Stage configures the execution of a stage of the jobs, like "get_sources".
+// The stages are configured in the [Stages.<name>] tables
+type Stage struct {
+	// Timeout limits the duration of the stage. The stage is not limited
+	// when zero
+	Timeout Duration
+	// Retries is the number of times the stage is retried after a transport
+	// error, like a lost SSH connection. Only the stages that are safe to
+	// retry can be retried
+	Retries int `minimum:"0" maximum:"10"`
+	// Interpreter executes the script of the stage, like "/bin/sh". The
+	// script is passed through its standard input in the container
+	Interpreter string
+	// Executor executes the stage in the task container or on the Runner
+	// host, overriding the HostStages section
+	Executor string `enum:"container,host"`
+}
+
+// Stage returns the configuration of the stage, which is empty when the
+// stage is not configured
+func (g Global) Stage(name string) Stage {
+	return g.Stages[name]
+}
+
+func (g Global) stagesProblems() []Problem {
+	names := make([]string, 0, len(g.Stages))
+	for name := range g.Stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []Problem
+
+	for _, name := range names {
+		stage := g.Stages[name]
+		prefix := fmt.Sprintf("Stages.%s.", name)
+
+		if !contains(knownStages, name) {
+			problems = append(problems, Problem{
+				Key:     "Stages." + name,
+				Message: fmt.Sprintf("is not a known stage: %s", strings.Join(knownStages, ", ")),
+			})
+
+			continue
+		}
+
+		walkSettings(reflect.TypeOf(stage), reflect.ValueOf(stage), "", func(key string, field reflect.StructField, value reflect.Value) {
+			message := checkSetting(field, value)
+			if message != "" {
+				problems = append(problems, Problem{Key: prefix + key, Message: message})
+			}
+		})
+
+		if stage.Retries > 0 && !contains(retryableStages, name) {
+			problems = append(problems, Problem{
+				Key:     prefix + "Retries",
+				Message: fmt.Sprintf("can only be set for the stages safe to retry: %s", strings.Join(retryableStages, ", ")),
+			})
+		}
+
+		if _, ok := HostStageTransfer(name); stage.Executor == StageExecutorHost && !ok {
+			problems = append(problems, Problem{
+				Key:     prefix + "Executor",
+				Message: fmt.Sprintf("can only be %q for the stages: %s", StageExecutorHost, strings.Join(HostCapableStages(), ", ")),
+			})
+		}
+	}
+
+	return problems
+}
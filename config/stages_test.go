@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestLoad_Stages(t *testing.T) {
+	cfg, err := Load([]byte(validConfig + `
+[Stages.get_sources]
+  Timeout = "10m"
+  Retries = 2
+
+[Stages.build_script]
+  Interpreter = "/bin/sh -e"
+
+[Stages.archive_cache]
+  Executor = "host"
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, Stage{Timeout: Duration{10 * time.Minute}, Retries: 2}, cfg.Stage("get_sources"))
+	assert.Equal(t, Stage{Interpreter: "/bin/sh -e"}, cfg.Stage("build_script"))
+	assert.Equal(t, Stage{Executor: StageExecutorHost}, cfg.Stage("archive_cache"))
+	assert.Equal(t, Stage{}, cfg.Stage("after_script"))
+}
+
+func TestLoad_InvalidStages(t *testing.T) {
+	_, err := Load([]byte(validConfig + `
+[Stages.get_sources]
+  Retries = 11
+
+[Stages.build_script]
+  Retries = 1
+  Executor = "host"
+
+[Stages.archive_cache]
+  Executor = "ec2"
+
+[Stages.get_source]
+  Retries = 1
+`))
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []Problem{
+		{Key: "Stages.get_sources.Retries", Line: 25, Message: "must be at most 10"},
+		{Key: "Stages.build_script.Retries", Line: 28, Message: "can only be set for the stages safe to retry: get_sources, restore_cache, download_artifacts"},
+		{Key: "Stages.build_script.Executor", Line: 29, Message: `can only be "host" for the stages: archive_cache, archive_cache_on_failure, upload_artifacts_on_success, upload_artifacts_on_failure, restore_cache, download_artifacts`},
+		{Key: "Stages.archive_cache.Executor", Line: 32, Message: "must be one of container, host"},
+		{Key: "Stages.get_source", Line: 34, Message: "is not a known stage: prepare_script, get_sources, restore_cache, download_artifacts, step_script, step_release, build_script, after_script, archive_cache, archive_cache_on_failure, upload_artifacts_on_success, upload_artifacts_on_failure, cleanup_file_variables"},
+	}, validationErr.Problems)
+}
+
+func TestHostStageTransfer(t *testing.T) {
+	for _, stage := range HostCapableStages() {
+		assert.Contains(t, knownStages, stage)
+
+		_, ok := HostStageTransfer(stage)
+		assert.True(t, ok, "stage: %s", stage)
+	}
+
+	transfer, ok := HostStageTransfer("archive_cache")
+	assert.True(t, ok)
+	assert.Equal(t, TransferFromContainer, transfer)
+
+	transfer, ok = HostStageTransfer("restore_cache")
+	assert.True(t, ok)
+	assert.Equal(t, TransferToContainer, transfer)
+
+	_, ok = HostStageTransfer("build_script")
+	assert.False(t, ok)
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const templateActionDelimiter = "{{"
+
+// TemplateData is the job context the templated settings are rendered with,
+// like TaskDefinition = "ci-{{ .ProjectNamespace }}"
+type TemplateData struct {
+	ProjectPath      string
+	ProjectNamespace string
+	JobID            int64
+	PipelineID       int64
+	Ref              string
+	RunnerToken      string
+	// Architecture is the architecture of the Runner, like "arm64"
+	Architecture string
+}
+
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// replace is written for pipelines, like {{ .ProjectPath | replace "/" "-" }}
+	"replace": func(old string, new string, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+}
+
+func isTemplate(value string) bool {
+	return strings.Contains(value, templateActionDelimiter)
+}
+
+// templateLiteral returns a template rendering the value as it is
+func templateLiteral(value string) string {
+	return fmt.Sprintf("{{ %s }}", strconv.Quote(value))
+}
+
+// renderedKeys returns the keys of the string settings rendered by Render,
+// including the ones of the profiles, which are rendered once applied
+func (g Global) renderedKeys() map[string]bool {
+	var settings []string
+	g.walkStringSettings("template", func(key string, _ reflect.Value) {
+		settings = append(settings, key)
+	})
+
+	keys := make(map[string]bool)
+	for _, key := range settings {
+		keys[key] = true
+
+		for name := range g.Profiles {
+			keys[fmt.Sprintf("Profiles.%s.%s", name, key)] = true
+		}
+	}
+
+	return keys
+}
+
+func parseTemplate(key string, value string) (*template.Template, error) {
+	return template.New(key).
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(value)
+}
+
+// Render returns the configuration with the templated string settings
+// rendered with the job context, and validates the rendered values. The
+// sections tagged with `template:"false"` are not rendered
+func (g Global) Render(data TemplateData) (Global, error) {
+	var problems []Problem
+
+	g.walkStringSettings("template", func(key string, value reflect.Value) {
+		rendered, err := renderValue(key, value.String(), data)
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Message: err.Error()})
+			return
+		}
+
+		value.SetString(rendered)
+	})
+
+	if len(problems) > 0 {
+		return g, &ValidationError{Problems: problems}
+	}
+
+	g.rendered = true
+
+	return g, g.Validate()
+}
+
+func renderValue(key string, value string, data TemplateData) (string, error) {
+	if !isTemplate(value) {
+		return value, nil
+	}
+
+	tpl, err := parseTemplate(key, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	out := new(bytes.Buffer)
+
+	err = tpl.Execute(out, data)
+	if err != nil {
+		return "", fmt.Errorf("rendering template: %w", err)
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+const templatedConfig = `
+LogFile = "/var/log/fargate/{{ .ProjectPath }}/{{ .JobID }}.log"
+
+[Fargate]
+  Cluster = "ci-{{ .ProjectNamespace | replace \"/\" \"-\" | lower }}"
+  Region = "us-east-1"
+  Subnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "ci-{{ .Architecture }}"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+
+[Overrides]
+  Allowed = ["Fargate.TaskDefinition"]
+`
+
+func TestGlobal_Render(t *testing.T) {
+	data := TemplateData{
+		ProjectPath:      "Group/Sub/project",
+		ProjectNamespace: "Group/Sub",
+		JobID:            123,
+		Architecture:     "arm64",
+	}
+
+	tests := map[string]struct {
+		content          string
+		data             TemplateData
+		expectedLogFile  string
+		expectedCluster  string
+		expectedProblems []Problem
+	}{
+		"Templates rendered": {
+			content:         templatedConfig,
+			data:            data,
+			expectedLogFile: "/var/log/fargate/Group/Sub/project/123.log",
+			expectedCluster: "ci-group-sub",
+		},
+		"Rendered value invalid": {
+			content: templatedConfig,
+			data:    TemplateData{ProjectNamespace: "group.sub", Architecture: "arm64"},
+			expectedProblems: []Problem{
+				{Key: "Fargate.Cluster", Message: "must be a cluster name or ARN"},
+			},
+		},
+		"Unknown field": {
+			content: templatedConfig + `
+[SSH]
+  Username = "{{ .Username }}"
+`,
+			data: data,
+			expectedProblems: []Problem{
+				{Key: "SSH.Username", Message: "rendering template: template: SSH.Username:1:3: executing \"SSH.Username\" at <.Username>: can't evaluate field Username in type config.TemplateData"},
+			},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cfg, err := Load([]byte(tt.content))
+			require.NoError(t, err)
+
+			rendered, err := cfg.Render(tt.data)
+
+			if tt.expectedProblems != nil {
+				assertions.ErrorIs(t, err, ErrInvalidConfig)
+
+				var validationErr *ValidationError
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.expectedProblems, validationErr.Problems)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLogFile, rendered.LogFile)
+			assert.Equal(t, tt.expectedCluster, rendered.Fargate.Cluster)
+			assert.Equal(t, "ci-arm64", rendered.Fargate.TaskDefinition)
+			assert.Equal(t, []string{"Fargate.TaskDefinition"}, rendered.Overrides.Allowed)
+		})
+	}
+}
+
+func TestLoad_InvalidTemplate(t *testing.T) {
+	_, err := Load([]byte(`
+[Fargate]
+  Cluster = "ci-{{ .ProjectNamespace"
+  Region = "us-east-1"
+  Subnet = "subnet-0123abcd"
+  SecurityGroup = "sg-0123abcd"
+  TaskDefinition = "ci-{{ .Architecture }}"
+
+[TaskMetadata]
+  Directory = "/fargate-driver/"
+`))
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "line 3: Fargate.Cluster: invalid template")
+	assert.NotContains(t, err.Error(), "Fargate.TaskDefinition")
+}
+
+func TestGlobal_ApplyOverrides_Template(t *testing.T) {
+	cfg, err := Load([]byte(templatedConfig))
+	require.NoError(t, err)
+
+	_, err = cfg.ApplyOverrides(func(variable string) string {
+		if variable == "FARGATE_TASK_DEFINITION" {
+			return "{{ .RunnerToken }}"
+		}
+
+		return ""
+	})
+
+	assertions.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "FARGATE_TASK_DEFINITION: templates are not allowed")
+}
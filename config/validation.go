@@ -0,0 +1,369 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidConfig is returned when the configuration has problems
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Problem describes an invalid setting of the configuration
+type Problem struct {
+	// Key is the dotted path of the setting, like "Fargate.Subnet"
+	Key string
+	// File is the configuration file defining the setting, when it's made of
+	// several files or not written in TOML. It's empty otherwise
+	File string
+	// Line is the line of the setting in the configuration file, or of its
+	// section when the setting is missing. It's zero when unknown, and for
+	// the JSON and YAML files
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	location := ""
+	if p.File != "" {
+		location = p.File + ": "
+	}
+
+	if p.Line > 0 {
+		location += fmt.Sprintf("line %d: ", p.Line)
+	}
+
+	return fmt.Sprintf("%s%s: %s", location, p.Key, p.Message)
+}
+
+// ValidationError lists all the problems found in the configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+
+	return fmt.Sprintf("%v:\n  %s", ErrInvalidConfig, strings.Join(lines, "\n  "))
+}
+
+// Unwrap makes the ValidationError match ErrInvalidConfig
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Validate checks the settings against the rules declared in the tags of
+// the configuration structs, and the settings required by the selected
+// backends and providers
+func (g Global) Validate() error {
+	problems := g.problems()
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: problems}
+}
+
+func (g Global) problems() []Problem {
+	var problems []Problem
+
+	check := checkSetting
+	if g.rendered {
+		check = checkRenderedSetting
+	}
+
+	walkSettings(reflect.TypeOf(g), reflect.ValueOf(g), "", func(key string, field reflect.StructField, value reflect.Value) {
+		message := check(field, value)
+		if message != "" {
+			problems = append(problems, Problem{Key: key, Message: message})
+		}
+	})
+
+	return append(problems, g.conditionalProblems()...)
+}
+
+// conditionalProblems checks the settings required only by some values of
+// other settings, which can't be declared in the tags
+func (g Global) conditionalProblems() []Problem {
+	var problems []Problem
+
+	require := func(key string, value string, reason string) {
+		if value == "" {
+			problems = append(problems, Problem{Key: key, Message: fmt.Sprintf("is required %s", reason)})
+		}
+	}
+
+	metadata := g.TaskMetadata
+	switch metadata.Backend {
+	case "", MetadataBackendFile:
+		require("TaskMetadata.Directory", metadata.Directory, "by the file backend")
+	case MetadataBackendS3:
+		require("TaskMetadata.S3.Bucket", metadata.S3.Bucket, "by the s3 backend")
+	case MetadataBackendDynamoDB:
+		require("TaskMetadata.DynamoDB.Table", metadata.DynamoDB.Table, "by the dynamodb backend")
+	}
+
+	encryption := metadata.Encryption
+	switch encryption.Provider {
+	case EncryptionProviderKeyFile:
+		require("TaskMetadata.Encryption.KeyFile", encryption.KeyFile, "by the keyfile provider")
+	case EncryptionProviderKeyring:
+		require("TaskMetadata.Encryption.KeyringKey", encryption.KeyringKey, "by the keyring provider")
+	case EncryptionProviderKMS:
+		require("TaskMetadata.Encryption.KMS.KeyID", encryption.KMS.KeyID, "by the kms provider")
+	}
+
+	problems = append(problems, g.profilesProblems()...)
+	problems = append(problems, g.Overrides.problems()...)
+	problems = append(problems, g.Secrets.problems()...)
+	problems = append(problems, g.stagesProblems()...)
+	problems = append(problems, g.ConfigStage.problems()...)
+
+	return append(problems, g.rulesProblems()...)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isSetting reports whether the field is a single setting, and not a section,
+// a list of sections or a map of sections
+func isSetting(t reflect.Type) bool {
+	if isSectionList(t) || isSectionMap(t) {
+		return false
+	}
+
+	return t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// isSectionList reports whether the field is an array of tables, like [[Rules]]
+func isSectionList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+// isSectionMap reports whether the field is a table of named tables, like
+// [Profiles.<name>]
+func isSectionMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct
+}
+
+// walkSettings calls fn for every setting of the sections, with its dotted key
+func walkSettings(t reflect.Type, v reflect.Value, prefix string, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := prefix + field.Name
+
+		if isSetting(field.Type) {
+			fn(key, field, v.Field(i))
+			continue
+		}
+
+		// The sections of the maps are walked by their owners, as their
+		// settings may be partially defined, like the ones of the profiles
+		if isSectionMap(field.Type) {
+			continue
+		}
+
+		if isSectionList(field.Type) {
+			for j := 0; j < v.Field(i).Len(); j++ {
+				walkSettings(field.Type.Elem(), v.Field(i).Index(j), fmt.Sprintf("%s[%d].", key, j), fn)
+			}
+			continue
+		}
+
+		walkSettings(field.Type, v.Field(i), key+".", fn)
+	}
+}
+
+// walkStringSettings calls fn for the string settings, and the elements of
+// the string lists, of the sections not tagged with `<tag>:"false"`. The
+// values can be set. The lists are copied first, as they may be shared with
+// other copies of the configuration
+func (g *Global) walkStringSettings(tag string, fn func(key string, value reflect.Value)) {
+	visit := func(key string, _ reflect.StructField, value reflect.Value) {
+		switch {
+		case value.Kind() == reflect.String:
+			fn(key, value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+			list := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+			reflect.Copy(list, value)
+			value.Set(list)
+
+			for i := 0; i < list.Len(); i++ {
+				fn(key, list.Index(i))
+			}
+		}
+	}
+
+	t := reflect.TypeOf(*g)
+	v := reflect.ValueOf(g).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get(tag) == "false" {
+			continue
+		}
+
+		switch {
+		case isSetting(field.Type):
+			visit(field.Name, field, v.Field(i))
+		case isSectionMap(field.Type):
+			// The sections of the maps can't be set in place, so they are
+			// walked as copies stored back in a new map
+			sections := reflect.MakeMap(field.Type)
+
+			iter := v.Field(i).MapRange()
+			for iter.Next() {
+				section := reflect.New(field.Type.Elem()).Elem()
+				section.Set(iter.Value())
+
+				walkSettings(field.Type.Elem(), section, fmt.Sprintf("%s.%s.", field.Name, iter.Key()), visit)
+				sections.SetMapIndex(iter.Key(), section)
+			}
+
+			v.Field(i).Set(sections)
+		case isSectionList(field.Type):
+			for j := 0; j < v.Field(i).Len(); j++ {
+				walkSettings(field.Type.Elem(), v.Field(i).Index(j), fmt.Sprintf("%s[%d].", field.Name, j), visit)
+			}
+		default:
+			walkSettings(field.Type, v.Field(i), field.Name+".", visit)
+		}
+	}
+}
+
+// checkSetting returns the message describing the problem of the value, or
+// an empty string. Rules other than "required" are checked only for the
+// values that are set, and only once the templates are rendered and the
+// references resolved
+func checkSetting(field reflect.StructField, value reflect.Value) string {
+	return checkValue(field, value, false)
+}
+
+// checkRenderedSetting checks the values of a rendered configuration, which
+// are never templates, even when the referenced values contain the template
+// delimiters
+func checkRenderedSetting(field reflect.StructField, value reflect.Value) string {
+	return checkValue(field, value, true)
+}
+
+func checkValue(field reflect.StructField, value reflect.Value, rendered bool) string {
+	if value.IsZero() {
+		if field.Tag.Get("required") == "true" {
+			return "is required"
+		}
+
+		return ""
+	}
+
+	if !rendered && value.Kind() == reflect.String && isTemplate(value.String()) {
+		_, err := parseTemplate(field.Name, value.String())
+		if err != nil {
+			return fmt.Sprintf("invalid template: %v", err)
+		}
+
+		return ""
+	}
+
+	if value.Kind() == reflect.String && isReference(value.String()) {
+		return ""
+	}
+
+	if enum, ok := field.Tag.Lookup("enum"); ok {
+		values := strings.Split(enum, ",")
+		if !contains(values, fmt.Sprint(value.Interface())) {
+			return fmt.Sprintf("must be one of %s", strings.Join(values, ", "))
+		}
+	}
+
+	if pattern, ok := field.Tag.Lookup("pattern"); ok {
+		if !regexp.MustCompile(pattern).MatchString(value.String()) {
+			return patternMessage(field, pattern)
+		}
+	}
+
+	if value.Kind() == reflect.Int {
+		return checkRange(field, value.Int())
+	}
+
+	return ""
+}
+
+func patternMessage(field reflect.StructField, pattern string) string {
+	if format, ok := field.Tag.Lookup("format"); ok {
+		return fmt.Sprintf("must be %s", format)
+	}
+
+	return fmt.Sprintf("must match %q", pattern)
+}
+
+func checkRange(field reflect.StructField, value int64) string {
+	if minimum, ok := intTag(field, "minimum"); ok && value < minimum {
+		return fmt.Sprintf("must be at least %d", minimum)
+	}
+
+	if maximum, ok := intTag(field, "maximum"); ok && value > maximum {
+		return fmt.Sprintf("must be at most %d", maximum)
+	}
+
+	return ""
+}
+
+func intTag(field reflect.StructField, name string) (int64, bool) {
+	tag, ok := field.Tag.Lookup(name)
+	if !ok {
+		return 0, false
+	}
+
+	value, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid %q tag of the %s field: %v", name, field.Name, err))
+	}
+
+	return value, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// locateProblems sets the file and the line of the problems, and sorts them
+func locateProblems(problems []Problem, locator keyLocator) {
+	for i := range problems {
+		problems[i].File, problems[i].Line = locator.locate(problems[i].Key)
+	}
+
+	sortProblems(problems)
+}
+
+// sortProblems orders the problems by file and line, placing last the ones
+// without line
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+
+		if problems[j].Line == 0 {
+			return problems[i].Line != 0
+		}
+
+		return problems[i].Line != 0 && problems[i].Line < problems[j].Line
+	})
+}
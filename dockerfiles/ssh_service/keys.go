@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const keyBitSize = 2048
+
+var errNoAuthorizedKeys = errors.New("no authorized keys defined")
+
+const passwdFilePath = "/etc/passwd"
+
+const (
+	defaultUsername = "root"
+	defaultHomeDir  = "/root"
+	defaultShell    = "/bin/sh"
+)
+
+type key struct {
+	PublicKey []byte
+
+	Signer ssh.Signer
+}
+
+// Fingerprint returns the SHA256 fingerprint of the public key, in the
+// same format as printed by OpenSSH tools
+func (k key) Fingerprint() string {
+	return ssh.FingerprintSHA256(k.Signer.PublicKey())
+}
+
+// account describes the system user that executes the commands
+// requested through the SSH sessions
+type account struct {
+	Username string
+	HomeDir  string
+	Shell    string
+}
+
+func (a account) authorizedKeysFilePath() string {
+	return filepath.Join(a.HomeDir, ".ssh", "authorized_keys")
+}
+
+// hostKeyFromSeed creates the Ed25519 host key of the base64-encoded seed
+// provided by the driver, which knows the key without trusting the service
+// to announce it
+func hostKeyFromSeed(encodedSeed string) (key, error) {
+	seed, err := base64.StdEncoding.DecodeString(encodedSeed)
+	if err != nil {
+		return key{}, fmt.Errorf("decoding host key seed: %w", err)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return key{}, fmt.Errorf("host key seed must have %d bytes", ed25519.SeedSize)
+	}
+
+	signer, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+	if err != nil {
+		return key{}, fmt.Errorf("creating signer from Ed25519 key: %w", err)
+	}
+
+	key := key{
+		PublicKey: ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		Signer:    signer,
+	}
+
+	return key, nil
+}
+
+func generateKey(name string) (key, error) {
+	fmt.Printf("Generating %s key\n", name)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBitSize)
+	if err != nil {
+		return key{}, fmt.Errorf("generating RSA key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return key{}, fmt.Errorf("creating signer from RSA key: %w", err)
+	}
+
+	key := key{
+		PublicKey: ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		Signer:    signer,
+	}
+
+	return key, nil
+}
+
+// installAuthorizedKeys replaces the authorized_keys file with the public
+// keys provided by the driver. Keys that were already present in the file,
+// e.g. baked into the image, are discarded and never trusted
+func installAuthorizedKeys(account account, publicKeys []byte) ([]ssh.PublicKey, error) {
+	publicKeys = bytes.TrimSpace(publicKeys)
+	if len(publicKeys) < 1 {
+		return nil, errNoAuthorizedKeys
+	}
+
+	keys, err := parseAuthorizedKeys(publicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeAuthorizedKeys(account.authorizedKeysFilePath(), publicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func writeAuthorizedKeys(authorizedKeysFilePath string, publicKeys []byte) error {
+	fmt.Printf("Installing public keys into %q file\n", authorizedKeysFilePath)
+
+	authorizedKeysPath := filepath.Dir(authorizedKeysFilePath)
+	err := os.MkdirAll(authorizedKeysPath, 0700)
+	if err != nil {
+		return fmt.Errorf("creating authorized keys directory %q: %w", authorizedKeysPath, err)
+	}
+
+	// The file is replaced atomically, so that the previous content is never
+	// merged with the provisioned keys
+	tmpFile, err := ioutil.TempFile(authorizedKeysPath, ".authorized_keys")
+	if err != nil {
+		return fmt.Errorf("creating temporary authorized keys file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(append(publicKeys, '\n'))
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), authorizedKeysFilePath)
+	}
+	if err != nil {
+		return fmt.Errorf("writing authorized keys file %q: %w", authorizedKeysFilePath, err)
+	}
+
+	return nil
+}
+
+func parseAuthorizedKeys(content []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+
+	rest := bytes.TrimSpace(content)
+	for len(rest) > 0 {
+		publicKey, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("parsing authorized key: %w", err)
+		}
+
+		keys = append(keys, publicKey)
+		rest = bytes.TrimSpace(next)
+	}
+
+	if len(keys) < 1 {
+		return nil, errNoAuthorizedKeys
+	}
+
+	return keys, nil
+}
+
+// currentAccount resolves the account of the process owner. It reads
+// /etc/passwd directly, so it works in static binaries and in images
+// that don't ship any user management tools
+func currentAccount() (account, error) {
+	acc := account{
+		Username: defaultUsername,
+		HomeDir:  defaultHomeDir,
+		Shell:    defaultShell,
+	}
+
+	file, err := os.Open(passwdFilePath)
+	if os.IsNotExist(err) {
+		return acc, nil
+	}
+	if err != nil {
+		return acc, fmt.Errorf("opening %q: %w", passwdFilePath, err)
+	}
+	defer file.Close()
+
+	uid := fmt.Sprintf("%d", os.Getuid())
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || fields[2] != uid {
+			continue
+		}
+
+		acc.Username = fields[0]
+		if fields[5] != "" {
+			acc.HomeDir = fields[5]
+		}
+		if fields[6] != "" && !strings.HasSuffix(fields[6], "nologin") {
+			acc.Shell = fields[6]
+		}
+
+		break
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return acc, fmt.Errorf("reading %q: %w", passwdFilePath, err)
+	}
+
+	return acc, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	driverssh "gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/ssh"
+)
+
+func TestInstallAuthorizedKeys(t *testing.T) {
+	provisionedKey, err := generateKey("provisioned")
+	require.NoError(t, err)
+
+	imageKey, err := generateKey("image")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		existingContent []byte
+		publicKeys      []byte
+		expectedKeys    []key
+		expectedError   string
+	}{
+		"No existing file": {
+			publicKeys:   provisionedKey.PublicKey,
+			expectedKeys: []key{provisionedKey},
+		},
+		"Existing keys are replaced": {
+			existingContent: imageKey.PublicKey,
+			publicKeys:      provisionedKey.PublicKey,
+			expectedKeys:    []key{provisionedKey},
+		},
+		"Multiple provisioned keys": {
+			publicKeys:   append(append([]byte{}, provisionedKey.PublicKey...), imageKey.PublicKey...),
+			expectedKeys: []key{provisionedKey, imageKey},
+		},
+		"No provisioned keys with existing file": {
+			existingContent: imageKey.PublicKey,
+			publicKeys:      []byte(" \n"),
+			expectedError:   errNoAuthorizedKeys.Error(),
+		},
+		"Invalid provisioned key": {
+			existingContent: imageKey.PublicKey,
+			publicKeys:      []byte("invalid"),
+			expectedError:   "parsing authorized key",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			homeDir, err := ioutil.TempDir("", "ssh_service")
+			require.NoError(t, err)
+			defer os.RemoveAll(homeDir)
+
+			acc := account{HomeDir: homeDir}
+			authorizedKeysFilePath := acc.authorizedKeysFilePath()
+
+			if tt.existingContent != nil {
+				require.NoError(t, os.MkdirAll(filepath.Dir(authorizedKeysFilePath), 0700))
+				require.NoError(t, ioutil.WriteFile(authorizedKeysFilePath, tt.existingContent, 0600))
+			}
+
+			keys, err := installAuthorizedKeys(acc, tt.publicKeys)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+
+				content, _ := ioutil.ReadFile(authorizedKeysFilePath)
+				assert.Equal(t, tt.existingContent, content, "existing file should be left untouched")
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, keys, len(tt.expectedKeys))
+			for i, expectedKey := range tt.expectedKeys {
+				assert.Equal(t, expectedKey.Signer.PublicKey().Marshal(), keys[i].Marshal())
+			}
+
+			content, err := ioutil.ReadFile(authorizedKeysFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, append(bytes.TrimSpace(tt.publicKeys), '\n'), content)
+
+			stat, err := os.Stat(authorizedKeysFilePath)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+		})
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	testKey, err := generateKey("test")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		content       []byte
+		expectedCount int
+		expectedError string
+	}{
+		"Single key": {
+			content:       testKey.PublicKey,
+			expectedCount: 1,
+		},
+		"Keys separated by blank lines": {
+			content:       []byte("\n" + string(testKey.PublicKey) + "\n\n" + string(testKey.PublicKey)),
+			expectedCount: 2,
+		},
+		"Empty content": {
+			content:       []byte("\n"),
+			expectedError: errNoAuthorizedKeys.Error(),
+		},
+		"Invalid key": {
+			content:       []byte("ssh-rsa invalid"),
+			expectedError: "parsing authorized key",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			keys, err := parseAuthorizedKeys(tt.content)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, keys, tt.expectedCount)
+		})
+	}
+}
+
+func TestHostKeyFromSeed(t *testing.T) {
+	seed := driverssh.HostKeySeed([]byte("private key"))
+
+	hostKey, err := hostKeyFromSeed(base64.StdEncoding.EncodeToString(seed))
+	require.NoError(t, err)
+
+	expectedFingerprint, err := driverssh.HostKeyFingerprint(seed)
+	require.NoError(t, err)
+	assert.Equal(t, expectedFingerprint, hostKey.Fingerprint(), "The host key should be the one known by the driver")
+
+	_, err = hostKeyFromSeed("not base64")
+	assert.Error(t, err)
+
+	_, err = hostKeyFromSeed(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
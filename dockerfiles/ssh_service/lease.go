@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// leaseRenewRequest is the global request sent by the driver on every "run" stage.
+// An optional payload (uint32, seconds) changes the duration of the lease
+const leaseRenewRequest = "lease-renew@gitlab.com"
+
+const leaseCheckInterval = 5 * time.Second
+
+// lease terminates the service when the driver stops renewing it, so tasks
+// that were never cleaned up stop themselves
+type lease struct {
+	duration        time.Duration
+	startupDeadline time.Duration
+
+	mu        sync.Mutex
+	startedAt time.Time
+	expiresAt time.Time
+	connected bool
+
+	now func() time.Time
+}
+
+func newLease(duration time.Duration, startupDeadline time.Duration) *lease {
+	l := &lease{
+		duration:        duration,
+		startupDeadline: startupDeadline,
+		now:             time.Now,
+	}
+
+	l.startedAt = l.now()
+	l.expiresAt = l.startedAt.Add(duration)
+
+	return l
+}
+
+// Connected marks that at least one client was authenticated
+func (l *lease) Connected() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.connected = true
+}
+
+// Renew extends the lease. When the payload contains a duration, it replaces
+// the one defined at startup
+func (l *lease) Renew(payload []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(payload) > 0 {
+		if len(payload) != 4 {
+			return fmt.Errorf("invalid lease renewal payload length %d", len(payload))
+		}
+
+		l.duration = time.Duration(binary.BigEndian.Uint32(payload)) * time.Second
+	}
+
+	l.expiresAt = l.now().Add(l.duration)
+	fmt.Printf("Lease renewed until %s\n", l.expiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// expired returns the reason of the expiration, or an empty string when
+// the lease is still valid
+func (l *lease) expired() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if !l.connected && l.startupDeadline > 0 && now.After(l.startedAt.Add(l.startupDeadline)) {
+		return fmt.Sprintf("no session connected within the startup deadline of %s", l.startupDeadline)
+	}
+
+	if l.duration > 0 && now.After(l.expiresAt) {
+		return fmt.Sprintf("lease not renewed since %s", l.expiresAt.Add(-l.duration).Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// Watch blocks until the lease expires or the context is cancelled
+func (l *lease) Watch(ctx context.Context) (string, bool) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-ticker.C:
+			reason := l.expired()
+			if reason != "" {
+				return reason, true
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease_Expired(t *testing.T) {
+	startedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		duration        time.Duration
+		startupDeadline time.Duration
+		connected       bool
+		renewedAfter    time.Duration
+		elapsed         time.Duration
+		expectedReason  string
+	}{
+		"Lease still valid": {
+			duration: time.Hour,
+			elapsed:  30 * time.Minute,
+		},
+		"Lease not renewed": {
+			duration:       time.Hour,
+			connected:      true,
+			elapsed:        61 * time.Minute,
+			expectedReason: "lease not renewed since 2020-01-01T12:00:00Z",
+		},
+		"Lease renewed": {
+			duration:     time.Hour,
+			connected:    true,
+			renewedAfter: 30 * time.Minute,
+			elapsed:      61 * time.Minute,
+		},
+		"Lease renewal expired": {
+			duration:       time.Hour,
+			connected:      true,
+			renewedAfter:   30 * time.Minute,
+			elapsed:        91 * time.Minute,
+			expectedReason: "lease not renewed since 2020-01-01T12:30:00Z",
+		},
+		"No session within the startup deadline": {
+			startupDeadline: 10 * time.Minute,
+			elapsed:         11 * time.Minute,
+			expectedReason:  "no session connected within the startup deadline of 10m0s",
+		},
+		"Session connected within the startup deadline": {
+			startupDeadline: 10 * time.Minute,
+			connected:       true,
+			elapsed:         11 * time.Minute,
+		},
+		"Lease disabled": {
+			elapsed: 100 * time.Hour,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			now := startedAt
+			l := &lease{
+				duration:        tt.duration,
+				startupDeadline: tt.startupDeadline,
+				startedAt:       startedAt,
+				expiresAt:       startedAt.Add(tt.duration),
+				now:             func() time.Time { return now },
+			}
+
+			if tt.connected {
+				l.Connected()
+			}
+
+			if tt.renewedAfter > 0 {
+				now = startedAt.Add(tt.renewedAfter)
+				require.NoError(t, l.Renew(nil))
+			}
+
+			now = startedAt.Add(tt.elapsed)
+			assert.Equal(t, tt.expectedReason, l.expired())
+		})
+	}
+}
+
+func TestLease_Renew(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		payload           []byte
+		expectedDuration  time.Duration
+		expectedExpiresAt time.Time
+		expectedError     string
+	}{
+		"No payload keeps the duration": {
+			expectedDuration:  time.Hour,
+			expectedExpiresAt: now.Add(time.Hour),
+		},
+		"Payload replaces the duration": {
+			payload:           []byte{0, 0, 0x1c, 0x20},
+			expectedDuration:  2 * time.Hour,
+			expectedExpiresAt: now.Add(2 * time.Hour),
+		},
+		"Invalid payload length": {
+			payload:           []byte{1, 2},
+			expectedDuration:  time.Hour,
+			expectedExpiresAt: now,
+			expectedError:     "invalid lease renewal payload length 2",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			l := &lease{
+				duration:  time.Hour,
+				startedAt: now,
+				expiresAt: now,
+				now:       func() time.Time { return now },
+			}
+
+			err := l.Renew(tt.payload)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedDuration, l.duration)
+			assert.Equal(t, tt.expectedExpiresAt, l.expiresAt)
+		})
+	}
+}
+
+func TestServer_LeaseRenewalRequest(t *testing.T) {
+	tests := map[string]struct {
+		lease      *lease
+		payload    []byte
+		expectedOK bool
+	}{
+		"Lease not enabled": {},
+		"Renewal without payload": {
+			lease:      newLease(time.Minute, 0),
+			expectedOK: true,
+		},
+		"Renewal with duration": {
+			lease:      newLease(time.Minute, 0),
+			payload:    []byte{0, 0, 0, 10},
+			expectedOK: true,
+		},
+		"Renewal with invalid payload": {
+			lease:   newLease(time.Minute, 0),
+			payload: []byte{1},
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ts, stop := startTestServer(t, false, tt.lease)
+			defer stop()
+
+			client, err := ts.dial(testUsername, ts.client.Signer)
+			require.NoError(t, err)
+			defer client.Close()
+
+			ok, _, err := client.SendRequest(leaseRenewRequest, true, tt.payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOK, ok)
+
+			if tt.lease != nil && len(tt.payload) == 4 && tt.expectedOK {
+				tt.lease.mu.Lock()
+				defer tt.lease.mu.Unlock()
+				assert.Equal(t, time.Duration(binary.BigEndian.Uint32(tt.payload))*time.Second, tt.lease.duration)
+			}
+		})
+	}
+}
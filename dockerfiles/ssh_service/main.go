@@ -2,208 +2,222 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path/filepath"
 	"syscall"
+	"time"
 )
 
 const httpPort = 8888
 const sshdPort = 2222
-const sshConfig = `
-Port %d
-ListenAddress 0.0.0.0
-HostKey %s
-PermitRootLogin yes
-PubkeyAuthentication yes
-PasswordAuthentication no
-ChallengeResponseAuthentication no
-AuthorizedKeysFile      .ssh/authorized_keys
-AllowTcpForwarding no
-GatewayPorts no
-X11Forwarding no
-PrintMotd no
-AcceptEnv LANG LC_*
-Subsystem       sftp    /usr/lib/openssh/sftp-server
-`
-const authorizedKeysFilePath = "/root/.ssh/authorized_keys"
-const username = "root"
-
-type Info struct {
-	Port           int
-	Username       string
-	HostPrivateKey string
-	HostPublicKey  string
-	UserPrivateKey string
-	UserPublicKey  string
-}
 
-type key struct {
-	PrivateKeyPath string
-	PublicKeyPath  string
+const (
+	publicKeyVariable       = "SSH_PUBLIC_KEY"
+	serviceTokenVariable    = "SSH_SERVICE_TOKEN"
+	hostKeySeedVariable     = "SSH_HOST_KEY_SEED"
+	disableSFTPVariable     = "SSH_DISABLE_SFTP"
+	leaseVariable           = "SSH_SERVICE_LEASE"
+	startupDeadlineVariable = "SSH_SERVICE_STARTUP_DEADLINE"
+	serviceTokenMinLength   = 16
+)
 
-	PrivateKey []byte
-	PublicKey  []byte
-}
+// drainTimeout limits the time given to the open sessions to finish
+// once the lease expired
+const drainTimeout = 30 * time.Second
 
 func main() {
 	ctx, cancel := getSignalContext()
 	defer cancel()
 
-	hostKey, err := generateKey("host")
+	account, err := currentAccount()
 	if err != nil {
 		panic(err)
 	}
 
-	userKey, err := generateKey("user")
+	hostKey, err := createHostKey()
 	if err != nil {
 		panic(err)
 	}
 
-	configFilePath, err := createConfigFile(hostKey)
+	authorizedKeys, err := installAuthorizedKeys(account, []byte(os.Getenv(publicKeyVariable)))
+	if err != nil {
+		panic(fmt.Sprintf("installing %s: %v", publicKeyVariable, err))
+	}
+
+	serviceLease, err := createLease()
 	if err != nil {
 		panic(err)
 	}
 
-	err = writeAuthorizedKeys(userKey)
+	sshServer, err := newServer(serverSettings{
+		Account:        account,
+		HostKey:        hostKey,
+		AuthorizedKeys: authorizedKeys,
+		EnableSFTP:     os.Getenv(disableSFTPVariable) == "",
+		Lease:          serviceLease,
+	})
 	if err != nil {
 		panic(err)
 	}
 
-	run(ctx, configFilePath, hostKey, userKey)
+	run(ctx, sshServer, serviceLease, account, hostKey)
 }
 
-func getSignalContext() (context.Context, func()) {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	go func() {
-		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+// createHostKey uses the host key provided by the driver, or generates one
+// when the driver doesn't provide it
+func createHostKey() (key, error) {
+	seed := os.Getenv(hostKeySeedVariable)
+	if seed == "" {
+		return generateKey("host")
+	}
 
-		select {
-		case sig := <-signals:
-			fmt.Printf("Received %v, exitting\n", sig)
-			cancel()
-		case <-ctx.Done():
-		}
-	}()
+	fmt.Println("Using host key provided by the driver")
 
-	return ctx, cancel
+	return hostKeyFromSeed(seed)
 }
 
-func generateKey(name string) (key, error) {
-	fmt.Printf("Generating %s key\n", name)
-
-	privateKeyDir, err := ioutil.TempDir("", "ssh-key")
-	if err != nil {
-		return key{}, fmt.Errorf("creating key storage directory: %w", err)
-	}
-
-	privateKeyFilePath := filepath.Join(privateKeyDir, "id_rsa")
-	publicKeyFilePath := privateKeyFilePath + ".pub"
-
-	cmd := exec.Command("ssh-keygen", "-t", "rsa", "-b", "2048", "-N", "", "-f", privateKeyFilePath)
-	err = cmd.Run()
+// createLease returns nil when neither the lease nor the startup deadline
+// were requested by the driver
+func createLease() (*lease, error) {
+	duration, err := durationFromVariable(leaseVariable)
 	if err != nil {
-		return key{}, fmt.Errorf("executing %q command: %w", cmd.String(), err)
+		return nil, err
 	}
 
-	privateKey, err := ioutil.ReadFile(privateKeyFilePath)
+	startupDeadline, err := durationFromVariable(startupDeadlineVariable)
 	if err != nil {
-		return key{}, fmt.Errorf("reading private key file %q: %w", privateKeyFilePath, err)
+		return nil, err
 	}
 
-	publicKey, err := ioutil.ReadFile(publicKeyFilePath)
-	if err != nil {
-		return key{}, fmt.Errorf("reading public key file %q: %w", publicKeyFilePath, err)
+	if duration == 0 && startupDeadline == 0 {
+		return nil, nil
 	}
 
-	key := key{
-		PrivateKeyPath: privateKeyFilePath,
-		PublicKeyPath:  publicKeyFilePath,
-		PrivateKey:     privateKey,
-		PublicKey:      publicKey,
-	}
+	fmt.Printf("Using lease of %s with startup deadline of %s\n", duration, startupDeadline)
 
-	return key, nil
+	return newLease(duration, startupDeadline), nil
 }
 
-func createConfigFile(key key) (string, error) {
-	fmt.Println("Creating SSHD configuration file")
-
-	fileContent := fmt.Sprintf(sshConfig, sshdPort, key.PrivateKeyPath)
-
-	configDir, err := ioutil.TempDir("", "sshd-config")
-	if err != nil {
-		return "", fmt.Errorf("creating key storage directory: %w", err)
+func durationFromVariable(variable string) (time.Duration, error) {
+	value := os.Getenv(variable)
+	if value == "" {
+		return 0, nil
 	}
 
-	configFilePath := filepath.Join(configDir, "sshd_config")
-	err = ioutil.WriteFile(configFilePath, []byte(fileContent), 0600)
+	duration, err := time.ParseDuration(value)
 	if err != nil {
-		return "", fmt.Errorf("writing configuration file %q: %w", configFilePath, err)
+		return 0, fmt.Errorf("parsing %s: %w", variable, err)
 	}
 
-	return configFilePath, nil
+	return duration, nil
 }
 
-func writeAuthorizedKeys(key key) error {
-	fmt.Printf("Creating %q file\n", authorizedKeysFilePath)
+func getSignalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	authorizedKeysPath := filepath.Dir(authorizedKeysFilePath)
-	err := os.MkdirAll(authorizedKeysPath, 0700)
-	if err != nil {
-		return fmt.Errorf("creating authorized keys directory %q: %w", authorizedKeysPath, err)
-	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	err = ioutil.WriteFile(authorizedKeysFilePath, key.PublicKey, 0600)
-	if err != nil {
-		return fmt.Errorf("writing authorized keys file %q: %w", authorizedKeysFilePath, err)
-	}
+		select {
+		case sig := <-signals:
+			fmt.Printf("Received %v, exitting\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	return nil
+	return ctx, cancel
 }
 
-func run(ctx context.Context, configFilePath string, hostKey key, userKey key) {
-	sshWait := startSSHServer(ctx, configFilePath)
-	httpWait := startHTTPServer(ctx, serveConfig(hostKey, userKey))
+func run(ctx context.Context, sshServer *server, serviceLease *lease, account account, hostKey key) {
+	ready := make(chan struct{})
+
+	sshWait := startSSHServer(ctx, sshServer, ready)
+	httpWait := startReadinessServer(ctx, account, hostKey, ready)
+	leaseExpired := watchLease(ctx, serviceLease)
 
 	select {
 	case err := <-sshWait:
 		panic(fmt.Sprintf("ssh server exited with error: %v", err))
 	case err := <-httpWait:
 		panic(fmt.Sprintf("http server exited with error: %v", err))
+	case reason := <-leaseExpired:
+		fmt.Printf("Lease expired (%s), draining the SSH server\n", reason)
+		if !sshServer.Drain(drainTimeout) {
+			fmt.Printf("Sessions still open after %s, exiting anyway\n", drainTimeout)
+		}
 	case <-ctx.Done():
 	}
 }
 
-func startSSHServer(ctx context.Context, configFilePath string) chan error {
-	fmt.Printf("Starting SSH server with %q configuration file at port %d\n", configFilePath, sshdPort)
+func watchLease(ctx context.Context, serviceLease *lease) chan string {
+	expired := make(chan string)
+	if serviceLease == nil {
+		return expired
+	}
+
+	go func() {
+		reason, ok := serviceLease.Watch(ctx)
+		if ok {
+			expired <- reason
+		}
+	}()
+
+	return expired
+}
+
+func startSSHServer(ctx context.Context, sshServer *server, ready chan struct{}) chan error {
+	addr := fmt.Sprintf(":%d", sshdPort)
+	fmt.Printf("Starting SSH server at %s\n", addr)
 
 	wait := make(chan error)
 
 	go func() {
-		cmd := exec.CommandContext(ctx, "/usr/sbin/sshd", "-D", "-f", configFilePath)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		wait <- cmd.Run()
+		err := sshServer.ListenAndServe(ctx, addr, func() { close(ready) })
+		if err != nil {
+			wait <- fmt.Errorf("SSH server listener: %w", err)
+		}
 	}()
 
 	return wait
 }
 
+// startReadinessServer starts the HTTP endpoint only when the driver
+// provided a token to authenticate the requests
+func startReadinessServer(ctx context.Context, account account, hostKey key, ready chan struct{}) chan error {
+	wait := make(chan error)
+
+	token := os.Getenv(serviceTokenVariable)
+	if token == "" {
+		fmt.Printf("%s is not set, readiness endpoint is disabled\n", serviceTokenVariable)
+		return wait
+	}
+
+	if len(token) < serviceTokenMinLength {
+		go func() {
+			wait <- fmt.Errorf("%s must have at least %d characters", serviceTokenVariable, serviceTokenMinLength)
+		}()
+		return wait
+	}
+
+	info := readinessInfo{
+		Port:               sshdPort,
+		Username:           account.Username,
+		HostPublicKey:      string(hostKey.PublicKey),
+		HostKeyFingerprint: hostKey.Fingerprint(),
+	}
+
+	return startHTTPServer(ctx, newReadinessHandler(token, info, ready))
+}
+
 func startHTTPServer(ctx context.Context, handler http.Handler) chan error {
 	addr := fmt.Sprintf(":%d", httpPort)
-
 	fmt.Printf("Starting HTTP server at %s\n", addr)
 
 	wait := make(chan error)
-
 	s := &http.Server{
 		Addr:    addr,
 		Handler: handler,
@@ -219,31 +233,10 @@ func startHTTPServer(ctx context.Context, handler http.Handler) chan error {
 
 	go func() {
 		err := s.ListenAndServe()
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			wait <- fmt.Errorf("HTTP server listener: %w", err)
 		}
 	}()
 
 	return wait
 }
-
-func serveConfig(hostKey key, userKey key) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
-		sshInfo := Info{
-			Username:       username,
-			Port:           sshdPort,
-			HostPrivateKey: string(hostKey.PrivateKey),
-			HostPublicKey:  string(hostKey.PublicKey),
-			UserPrivateKey: string(userKey.PrivateKey),
-			UserPublicKey:  string(userKey.PublicKey),
-		}
-
-		encoder := json.NewEncoder(rw)
-		err := encoder.Encode(sshInfo)
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = fmt.Fprintf(rw, "couldn't encode response: %v", err)
-			return
-		}
-	}
-}
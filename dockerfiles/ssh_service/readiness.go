@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+// readinessInfo is returned to the driver once the SSH server accepts
+// connections. It contains only public data: the fingerprint allows the
+// driver to verify the host key when connecting
+type readinessInfo struct {
+	Ready              bool
+	Port               int
+	Username           string
+	HostPublicKey      string
+	HostKeyFingerprint string
+}
+
+func newReadinessHandler(token string, info readinessInfo, ready <-chan struct{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Handlers are called concurrently, so the shared info is never modified
+		response := info
+
+		status := http.StatusServiceUnavailable
+		select {
+		case <-ready:
+			response.Ready = true
+			status = http.StatusOK
+		default:
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+
+		err := json.NewEncoder(rw).Encode(response)
+		if err != nil {
+			fmt.Printf("Couldn't encode readiness response: %v\n", err)
+		}
+	}
+}
+
+func authorized(r *http.Request, token string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return false
+	}
+
+	received := strings.TrimPrefix(header, bearerPrefix)
+
+	return subtle.ConstantTimeCompare([]byte(received), []byte(token)) == 1
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	testToken := "test-token-0123456789"
+
+	info := readinessInfo{
+		Port:               sshdPort,
+		Username:           "root",
+		HostPublicKey:      "ssh-rsa AAAA",
+		HostKeyFingerprint: "SHA256:fingerprint",
+	}
+
+	tests := map[string]struct {
+		authorization  string
+		ready          bool
+		expectedStatus int
+		expectedReady  bool
+	}{
+		"Missing authorization header": {
+			ready:          true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Invalid token": {
+			authorization:  bearerPrefix + "invalid",
+			ready:          true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Token without bearer prefix": {
+			authorization:  testToken,
+			ready:          true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Server not ready": {
+			authorization:  bearerPrefix + testToken,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		"Server ready": {
+			authorization:  bearerPrefix + testToken,
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedReady:  true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ready := make(chan struct{})
+			if tt.ready {
+				close(ready)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rw := httptest.NewRecorder()
+			newReadinessHandler(testToken, info, ready)(rw, req)
+
+			require.Equal(t, tt.expectedStatus, rw.Code)
+
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rw.Header().Get("WWW-Authenticate"))
+				assert.Empty(t, rw.Body.String())
+				return
+			}
+
+			var response readinessInfo
+			require.NoError(t, json.NewDecoder(rw.Body).Decode(&response))
+
+			expected := info
+			expected.Ready = tt.expectedReady
+			assert.Equal(t, expected, response)
+		})
+	}
+}
+
+func TestReadinessHandler_ConcurrentRequests(t *testing.T) {
+	testToken := "test-token-0123456789"
+
+	ready := make(chan struct{})
+	handler := newReadinessHandler(testToken, readinessInfo{Port: sshdPort}, ready)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			close(ready)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", bearerPrefix+testToken)
+
+			handler(httptest.NewRecorder(), req)
+		}()
+	}
+
+	wg.Wait()
+}
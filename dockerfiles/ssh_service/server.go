@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const sessionChannelType = "session"
+
+var errUnauthorizedKey = errors.New("public key is not authorized")
+
+type serverSettings struct {
+	Account        account
+	HostKey        key
+	AuthorizedKeys []ssh.PublicKey
+	EnableSFTP     bool
+	// Lease is optional, when defined the driver is allowed to renew it
+	Lease *lease
+}
+
+// server is a minimal SSH server supporting the subset of the protocol
+// that is used by the Fargate driver: command execution with environment
+// variables and signals, plus an optional SFTP subsystem
+type server struct {
+	settings serverSettings
+	config   *ssh.ServerConfig
+
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	listener net.Listener
+	draining bool
+}
+
+func newServer(settings serverSettings) (*server, error) {
+	if settings.HostKey.Signer == nil {
+		return nil, errors.New("host key signer is not defined")
+	}
+
+	s := &server{
+		settings: settings,
+	}
+
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.authorize,
+	}
+	s.config.AddHostKey(settings.HostKey.Signer)
+
+	return s, nil
+}
+
+func (s *server) authorize(meta ssh.ConnMetadata, publicKey ssh.PublicKey) (*ssh.Permissions, error) {
+	if meta.User() != s.settings.Account.Username {
+		return nil, fmt.Errorf("unknown user %q", meta.User())
+	}
+
+	marshaledKey := publicKey.Marshal()
+	for _, authorizedKey := range s.settings.AuthorizedKeys {
+		if bytes.Equal(authorizedKey.Marshal(), marshaledKey) {
+			return &ssh.Permissions{}, nil
+		}
+	}
+
+	return nil, errUnauthorizedKey
+}
+
+// ListenAndServe accepts connections until the context is cancelled.
+// The listening function is called as soon as the server accepts connections
+func (s *server) ListenAndServe(ctx context.Context, addr string, listening func()) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %q: %w", addr, err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	listening()
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || s.isDraining() {
+				s.wg.Wait()
+				return nil
+			}
+
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConnection(ctx, conn)
+		}()
+	}
+}
+
+// Drain stops accepting new connections and waits for the existing ones to
+// finish. It returns false if the connections were still open after the timeout
+func (s *server) Drain(timeout time.Duration) bool {
+	s.mu.Lock()
+	s.draining = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (s *server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.draining
+}
+
+func (s *server) handleConnection(ctx context.Context, conn net.Conn) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		fmt.Printf("SSH handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	defer serverConn.Close()
+
+	fmt.Printf("New SSH connection from %s as %q\n", serverConn.RemoteAddr(), serverConn.User())
+
+	if s.settings.Lease != nil {
+		s.settings.Lease.Connected()
+	}
+
+	go s.handleGlobalRequests(requests)
+
+	go func() {
+		<-ctx.Done()
+		_ = serverConn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for newChannel := range channels {
+		if newChannel.ChannelType() != sessionChannelType {
+			_ = newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unsupported channel type %q", newChannel.ChannelType()))
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			fmt.Printf("Accepting session channel failed: %v\n", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newSession(s.settings, channel, channelRequests).serve()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *server) handleGlobalRequests(requests <-chan *ssh.Request) {
+	for req := range requests {
+		ok := false
+
+		if req.Type == leaseRenewRequest && s.settings.Lease != nil {
+			err := s.settings.Lease.Renew(req.Payload)
+			if err != nil {
+				fmt.Printf("Lease renewal rejected: %v\n", err)
+			}
+
+			ok = err == nil
+		}
+
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+}
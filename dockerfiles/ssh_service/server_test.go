@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+const testUsername = "test-user"
+
+type testServer struct {
+	addr    string
+	hostKey key
+	client  key
+	lease   *lease
+}
+
+func startTestServer(t *testing.T, enableSFTP bool, serviceLease *lease) (*testServer, func()) {
+	homeDir, err := ioutil.TempDir("", "ssh_service")
+	require.NoError(t, err)
+
+	hostKey, err := generateKey("host")
+	require.NoError(t, err)
+
+	clientKey, err := generateKey("client")
+	require.NoError(t, err)
+
+	s, err := newServer(serverSettings{
+		Account: account{
+			Username: testUsername,
+			HomeDir:  homeDir,
+			Shell:    "/bin/sh",
+		},
+		HostKey:        hostKey,
+		AuthorizedKeys: []ssh.PublicKey{clientKey.Signer.PublicKey()},
+		EnableSFTP:     enableSFTP,
+		Lease:          serviceLease,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	listening := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(ctx, "127.0.0.1:0", func() { close(listening) })
+	}()
+
+	select {
+	case <-listening:
+	case err := <-done:
+		require.NoError(t, err)
+	}
+
+	s.mu.Lock()
+	addr := s.listener.Addr().String()
+	s.mu.Unlock()
+
+	ts := &testServer{
+		addr:    addr,
+		hostKey: hostKey,
+		client:  clientKey,
+		lease:   serviceLease,
+	}
+
+	return ts, func() {
+		cancel()
+		assert.NoError(t, <-done)
+		_ = os.RemoveAll(homeDir)
+	}
+}
+
+func (ts *testServer) dial(username string, signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", ts.addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(ts.hostKey.Signer.PublicKey()),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestServer_Authorization(t *testing.T) {
+	otherKey, err := generateKey("other")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		username      string
+		useOtherKey   bool
+		expectedError bool
+	}{
+		"Authorized key and user": {
+			username: testUsername,
+		},
+		"Unknown user": {
+			username:      "root",
+			expectedError: true,
+		},
+		"Unauthorized key": {
+			username:      testUsername,
+			useOtherKey:   true,
+			expectedError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ts, stop := startTestServer(t, false, nil)
+			defer stop()
+
+			signer := ts.client.Signer
+			if tt.useOtherKey {
+				signer = otherKey.Signer
+			}
+
+			client, err := ts.dial(tt.username, signer)
+			if tt.expectedError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "unable to authenticate")
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, client.Close())
+		})
+	}
+}
+
+func TestServer_Exec(t *testing.T) {
+	tests := map[string]struct {
+		command          string
+		env              map[string]string
+		stdin            string
+		signal           ssh.Signal
+		expectedStdout   string
+		expectedStderr   string
+		expectedStatus   int
+		expectedSignal   string
+		expectedExitType bool
+	}{
+		"Successful command": {
+			command:        "echo out; echo err >&2",
+			expectedStdout: "out\n",
+			expectedStderr: "err\n",
+		},
+		"Failing command": {
+			command:          "exit 3",
+			expectedStatus:   3,
+			expectedExitType: true,
+		},
+		"Command using the environment": {
+			command:        `echo "$TEST_VARIABLE $USER"`,
+			env:            map[string]string{"TEST_VARIABLE": "value"},
+			expectedStdout: "value " + testUsername + "\n",
+		},
+		"Command reading the standard input": {
+			command:        "cat",
+			stdin:          "script content",
+			expectedStdout: "script content",
+		},
+		"Command terminated by signal": {
+			command:          "echo started; sleep 30",
+			signal:           ssh.SIGTERM,
+			expectedStdout:   "started\n",
+			expectedStatus:   128 + 15,
+			expectedSignal:   string(ssh.SIGTERM),
+			expectedExitType: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ts, stop := startTestServer(t, false, nil)
+			defer stop()
+
+			client, err := ts.dial(testUsername, ts.client.Signer)
+			require.NoError(t, err)
+			defer client.Close()
+
+			session, err := client.NewSession()
+			require.NoError(t, err)
+			defer session.Close()
+
+			for name, value := range tt.env {
+				require.NoError(t, session.Setenv(name, value))
+			}
+
+			stdout := new(syncBuffer)
+			stderr := new(bytes.Buffer)
+			session.Stdout = stdout
+			session.Stderr = stderr
+			session.Stdin = strings.NewReader(tt.stdin)
+
+			require.NoError(t, session.Start(tt.command))
+
+			if tt.signal != "" {
+				require.Eventually(t, func() bool {
+					return stdout.String() != ""
+				}, 5*time.Second, 10*time.Millisecond)
+				require.NoError(t, session.Signal(tt.signal))
+			}
+
+			err = session.Wait()
+
+			assert.Equal(t, tt.expectedStdout, stdout.String())
+			assert.Equal(t, tt.expectedStderr, stderr.String())
+
+			if !tt.expectedExitType {
+				assert.NoError(t, err)
+				return
+			}
+
+			var exitErr *ssh.ExitError
+			require.True(t, errors.As(err, &exitErr), "expected *ssh.ExitError, got %v", err)
+			assert.Equal(t, tt.expectedStatus, exitErr.ExitStatus())
+			assert.Equal(t, tt.expectedSignal, exitErr.Signal())
+		})
+	}
+}
+
+func TestServer_SecondProgramInSessionIsRejected(t *testing.T) {
+	ts, stop := startTestServer(t, false, nil)
+	defer stop()
+
+	client, err := ts.dial(testUsername, ts.client.Signer)
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.Start("sleep 1"))
+	assert.Error(t, session.Start("true"))
+}
+
+func TestServer_Subsystems(t *testing.T) {
+	tests := map[string]struct {
+		enableSFTP    bool
+		subsystem     string
+		expectedError bool
+	}{
+		"SFTP enabled": {
+			enableSFTP: true,
+			subsystem:  sftpSubsystem,
+		},
+		"SFTP disabled": {
+			subsystem:     sftpSubsystem,
+			expectedError: true,
+		},
+		"Unknown subsystem": {
+			enableSFTP:    true,
+			subsystem:     "unknown",
+			expectedError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ts, stop := startTestServer(t, tt.enableSFTP, nil)
+			defer stop()
+
+			client, err := ts.dial(testUsername, ts.client.Signer)
+			require.NoError(t, err)
+			defer client.Close()
+
+			session, err := client.NewSession()
+			require.NoError(t, err)
+			defer session.Close()
+
+			err = session.RequestSubsystem(tt.subsystem)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// syncBuffer allows to read the output while the session writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const sftpSubsystem = "sftp"
+
+var errSessionAlreadyStarted = errors.New("session already started")
+
+var signals = map[ssh.Signal]syscall.Signal{
+	ssh.SIGABRT: syscall.SIGABRT,
+	ssh.SIGALRM: syscall.SIGALRM,
+	ssh.SIGFPE:  syscall.SIGFPE,
+	ssh.SIGHUP:  syscall.SIGHUP,
+	ssh.SIGILL:  syscall.SIGILL,
+	ssh.SIGINT:  syscall.SIGINT,
+	ssh.SIGKILL: syscall.SIGKILL,
+	ssh.SIGPIPE: syscall.SIGPIPE,
+	ssh.SIGQUIT: syscall.SIGQUIT,
+	ssh.SIGSEGV: syscall.SIGSEGV,
+	ssh.SIGTERM: syscall.SIGTERM,
+	ssh.SIGUSR1: syscall.SIGUSR1,
+	ssh.SIGUSR2: syscall.SIGUSR2,
+}
+
+// Payloads of the session requests, as defined by RFC 4254
+type envRequest struct {
+	Name  string
+	Value string
+}
+
+type execRequest struct {
+	Command string
+}
+
+type subsystemRequest struct {
+	Name string
+}
+
+type signalRequest struct {
+	Signal string
+}
+
+type exitStatusMessage struct {
+	Status uint32
+}
+
+type exitSignalMessage struct {
+	Signal     string
+	CoreDumped bool
+	Error      string
+	Lang       string
+}
+
+// session handles a single "session" channel. Only one program (a command,
+// a shell or a subsystem) can be started per session
+type session struct {
+	settings serverSettings
+	channel  ssh.Channel
+	requests <-chan *ssh.Request
+
+	env []string
+
+	mu      sync.Mutex
+	started bool
+	exited  bool
+	cmd     *exec.Cmd
+}
+
+func newSession(settings serverSettings, channel ssh.Channel, requests <-chan *ssh.Request) *session {
+	return &session{
+		settings: settings,
+		channel:  channel,
+		requests: requests,
+	}
+}
+
+func (s *session) serve() {
+	for req := range s.requests {
+		var err error
+
+		switch req.Type {
+		case "env":
+			err = s.handleEnv(req)
+		case "exec":
+			err = s.handleExec(req)
+		case "shell":
+			err = s.startCommand()
+		case "subsystem":
+			err = s.handleSubsystem(req)
+		case "signal":
+			err = s.handleSignal(req)
+		default:
+			err = fmt.Errorf("unsupported request type %q", req.Type)
+		}
+
+		if err != nil {
+			fmt.Printf("Session request %q rejected: %v\n", req.Type, err)
+		}
+
+		if req.WantReply {
+			_ = req.Reply(err == nil, nil)
+		}
+	}
+
+	s.kill()
+}
+
+func (s *session) handleEnv(req *ssh.Request) error {
+	var payload envRequest
+	err := ssh.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("parsing env request: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.env = append(s.env, fmt.Sprintf("%s=%s", payload.Name, payload.Value))
+
+	return nil
+}
+
+func (s *session) handleExec(req *ssh.Request) error {
+	var payload execRequest
+	err := ssh.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("parsing exec request: %w", err)
+	}
+
+	return s.startCommand("-c", payload.Command)
+}
+
+func (s *session) startCommand(args ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return errSessionAlreadyStarted
+	}
+
+	cmd := exec.Command(s.settings.Account.Shell, args...)
+	cmd.Dir = s.settings.Account.HomeDir
+	cmd.Env = s.environment()
+	cmd.Stdout = s.channel
+	cmd.Stderr = s.channel.Stderr()
+	// A dedicated process group allows to deliver signals to all
+	// processes started by the script
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// The pipe is fed manually, so that Wait() doesn't block on a client
+	// that never closes its side of the channel
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("creating stdin pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("starting %q: %w", s.settings.Account.Shell, err)
+	}
+
+	s.started = true
+	s.cmd = cmd
+
+	go func() {
+		_, _ = io.Copy(stdin, s.channel)
+		_ = stdin.Close()
+	}()
+
+	go s.wait(cmd)
+
+	return nil
+}
+
+func (s *session) environment() []string {
+	env := os.Environ()
+	env = append(
+		env,
+		"HOME="+s.settings.Account.HomeDir,
+		"USER="+s.settings.Account.Username,
+		"LOGNAME="+s.settings.Account.Username,
+		"SHELL="+s.settings.Account.Shell,
+	)
+
+	return append(env, s.env...)
+}
+
+func (s *session) wait(cmd *exec.Cmd) {
+	_ = cmd.Wait()
+
+	s.mu.Lock()
+	s.exited = true
+	s.mu.Unlock()
+
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		s.sendExitSignal(status.Signal(), status.CoreDump())
+	} else {
+		s.sendExitStatus(uint32(cmd.ProcessState.ExitCode()))
+	}
+
+	_ = s.channel.Close()
+}
+
+func (s *session) handleSubsystem(req *ssh.Request) error {
+	var payload subsystemRequest
+	err := ssh.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("parsing subsystem request: %w", err)
+	}
+
+	if payload.Name != sftpSubsystem || !s.settings.EnableSFTP {
+		return fmt.Errorf("unsupported subsystem %q", payload.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return errSessionAlreadyStarted
+	}
+
+	server, err := sftp.NewServer(s.channel)
+	if err != nil {
+		return fmt.Errorf("creating SFTP server: %w", err)
+	}
+
+	s.started = true
+
+	go func() {
+		var status uint32
+		err := server.Serve()
+		if err != nil && err != io.EOF {
+			fmt.Printf("SFTP server failure: %v\n", err)
+			status = 1
+		}
+
+		_ = server.Close()
+		s.sendExitStatus(status)
+		_ = s.channel.Close()
+	}()
+
+	return nil
+}
+
+func (s *session) handleSignal(req *ssh.Request) error {
+	var payload signalRequest
+	err := ssh.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("parsing signal request: %w", err)
+	}
+
+	sig, ok := signals[ssh.Signal(payload.Signal)]
+	if !ok {
+		return fmt.Errorf("unsupported signal %q", payload.Signal)
+	}
+
+	return s.signal(sig)
+}
+
+func (s *session) signal(sig syscall.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil || s.exited {
+		return errors.New("no process started in this session")
+	}
+
+	err := syscall.Kill(-s.cmd.Process.Pid, sig)
+	if err != nil {
+		return fmt.Errorf("sending %v to process group %d: %w", sig, s.cmd.Process.Pid, err)
+	}
+
+	return nil
+}
+
+// kill ensures that nothing started by the session outlives the channel
+func (s *session) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil || s.exited {
+		return
+	}
+
+	_ = syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func (s *session) sendExitStatus(status uint32) {
+	_, _ = s.channel.SendRequest("exit-status", false, ssh.Marshal(exitStatusMessage{Status: status}))
+}
+
+func (s *session) sendExitSignal(sig syscall.Signal, coreDumped bool) {
+	name := string(ssh.SIGKILL)
+	for sshSignal, sysSignal := range signals {
+		if sysSignal == sig {
+			name = string(sshSignal)
+			break
+		}
+	}
+
+	msg := exitSignalMessage{
+		Signal:     name,
+		CoreDumped: coreDumped,
+		Error:      sig.String(),
+	}
+
+	_, _ = s.channel.SendRequest("exit-signal", false, ssh.Marshal(msg))
+}
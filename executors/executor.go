@@ -3,14 +3,45 @@ package executors
 
 import (
 	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
 )
 
 const DefaultPort = 22
 
+// ErrScriptFailed is matched by the errors of the scripts that were executed
+// and exited with a non-zero status. The other errors are transport errors,
+// like a lost connection
+var ErrScriptFailed = errors.New("script failed")
+
 // Executor is the interface to provide operations related to script execution
 type Executor interface {
 	// Execute connects to a host, runs the script and disconnects
 	Execute(ctx context.Context, connection ConnectionSettings, script []byte) error
+
+	// CheckConnection connects to a host, authenticating with the private key
+	// and verifying the host key, and disconnects
+	CheckConnection(ctx context.Context, connection ConnectionSettings) error
+
+	// Upload connects to a host, writes the content to the file, readable
+	// only by the user, and disconnects. The content is sent through the
+	// standard input of the remote shell, so it never appears in a command line
+	Upload(ctx context.Context, connection ConnectionSettings, file string, content []byte) error
+
+	// Output connects to a host, runs the script and disconnects, returning
+	// the standard output of the script
+	Output(ctx context.Context, connection ConnectionSettings, script []byte) ([]byte, error)
+
+	// Stream connects to a host, runs the script with the given standard
+	// input and output, and disconnects. The stdin may be nil
+	Stream(ctx context.Context, connection ConnectionSettings, script []byte, stdin io.Reader, stdout io.Writer) error
+}
+
+// ShellQuote quotes the value for the POSIX shells
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
 }
 
 // ConnectionSettings centralizes attributes related to the remote host settings
@@ -19,4 +50,16 @@ type ConnectionSettings struct {
 	Port       int
 	Username   string
 	PrivateKey []byte
+	// HostKeyFingerprint is the expected SHA256 fingerprint of the host key.
+	// When empty, the host key is not verified
+	HostKeyFingerprint string
+	// LeaseDuration is used to renew the lease of the SSH service before
+	// executing the script. When zero, the lease is not renewed
+	LeaseDuration time.Duration
+	// ConnectTimeout limits the time of establishing the connection. When
+	// zero, the connection attempt is not limited
+	ConnectTimeout time.Duration
+	// Environment holds the variables set in the environment of the script
+	// through the SSH session, without touching the script itself
+	Environment map[string]string
 }
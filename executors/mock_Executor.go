@@ -4,6 +4,7 @@ package executors
 
 import (
 	context "context"
+	io "io"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -13,6 +14,20 @@ type MockExecutor struct {
 	mock.Mock
 }
 
+// CheckConnection provides a mock function with given fields: ctx, connection
+func (_m *MockExecutor) CheckConnection(ctx context.Context, connection ConnectionSettings) error {
+	ret := _m.Called(ctx, connection)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ConnectionSettings) error); ok {
+		r0 = rf(ctx, connection)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Execute provides a mock function with given fields: ctx, connection, script
 func (_m *MockExecutor) Execute(ctx context.Context, connection ConnectionSettings, script []byte) error {
 	ret := _m.Called(ctx, connection, script)
@@ -26,3 +41,54 @@ func (_m *MockExecutor) Execute(ctx context.Context, connection ConnectionSettin
 
 	return r0
 }
+
+// Output provides a mock function with given fields: ctx, connection, script
+func (_m *MockExecutor) Output(ctx context.Context, connection ConnectionSettings, script []byte) ([]byte, error) {
+	ret := _m.Called(ctx, connection, script)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(context.Context, ConnectionSettings, []byte) []byte); ok {
+		r0 = rf(ctx, connection, script)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ConnectionSettings, []byte) error); ok {
+		r1 = rf(ctx, connection, script)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Stream provides a mock function with given fields: ctx, connection, script, stdin, stdout
+func (_m *MockExecutor) Stream(ctx context.Context, connection ConnectionSettings, script []byte, stdin io.Reader, stdout io.Writer) error {
+	ret := _m.Called(ctx, connection, script, stdin, stdout)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ConnectionSettings, []byte, io.Reader, io.Writer) error); ok {
+		r0 = rf(ctx, connection, script, stdin, stdout)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Upload provides a mock function with given fields: ctx, connection, file, content
+func (_m *MockExecutor) Upload(ctx context.Context, connection ConnectionSettings, file string, content []byte) error {
+	ret := _m.Called(ctx, connection, file, content)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ConnectionSettings, string, []byte) error); ok {
+		r0 = rf(ctx, connection, file, content)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -1,22 +1,32 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"path"
+	"sort"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors/ssh/internal/client"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/executors/ssh/internal/session"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
 )
 
 // ErrNotConnected is return when a previous connection was not established
 var ErrNotConnected = errors.New("not connected to server")
 
+// leaseRenewRequest is the global request understood by the SSH service
+// provided with the driver
+const leaseRenewRequest = "lease-renew@gitlab.com"
+
 // errInvalidPrivateKey will be used to wrap a ssh internal error
 type errInvalidPrivateKey struct {
 	inner error
@@ -30,16 +40,49 @@ func (e *errInvalidPrivateKey) Unwrap() error {
 	return e.inner
 }
 
+// errScriptFailed wraps the exit of a script with a non-zero status, so it
+// matches executors.ErrScriptFailed
+type errScriptFailed struct {
+	inner error
+}
+
+func (e *errScriptFailed) Error() string {
+	return e.inner.Error()
+}
+
+func (e *errScriptFailed) Unwrap() error {
+	return e.inner
+}
+
+func (e *errScriptFailed) Is(target error) bool {
+	return target == executors.ErrScriptFailed
+}
+
 func (e *errInvalidPrivateKey) Is(err error) bool {
 	_, ok := err.(*errInvalidPrivateKey)
 	return ok
 }
 
+// errHostKeyMismatch is returned when the server presents an unexpected host key
+type errHostKeyMismatch struct {
+	expected string
+	received string
+}
+
+func (e *errHostKeyMismatch) Error() string {
+	return fmt.Sprintf("host key mismatch: expected %q, received %q", e.expected, e.received)
+}
+
+func (e *errHostKeyMismatch) Is(err error) bool {
+	_, ok := err.(*errHostKeyMismatch)
+	return ok
+}
+
 type executor struct {
 	client client.Client
 	logger logging.Logger
 
-	connectClient func(network string, addr string, config *ssh.ClientConfig) (client.Client, error)
+	connectClient func(ctx context.Context, network string, addr string, config *ssh.ClientConfig) (client.Client, error)
 
 	stdout io.Writer
 	stderr io.Writer
@@ -61,7 +104,7 @@ func NewExecutor(logger logging.Logger) executors.Executor {
 func (s *executor) Execute(ctx context.Context, connection executors.ConnectionSettings, script []byte) (err error) {
 	s.logger.Debug("[Execute] Will connect to server and execute the specified shell script")
 
-	err = s.connect(connection)
+	err = s.connect(ctx, connection)
 	if err != nil {
 		return fmt.Errorf("connecting to server: %w", err)
 	}
@@ -74,7 +117,9 @@ func (s *executor) Execute(ctx context.Context, connection executors.ConnectionS
 		}
 	}()
 
-	err = s.executeScript(ctx, script, s.stdout, s.stderr)
+	s.renewLease(connection.LeaseDuration)
+
+	err = s.executeScript(ctx, script, connection.Environment, s.stdout, s.stderr)
 	if err != nil {
 		return fmt.Errorf("executing script: %w", err)
 	}
@@ -84,7 +129,109 @@ func (s *executor) Execute(ctx context.Context, connection executors.ConnectionS
 	return nil
 }
 
-func (s *executor) connect(connection executors.ConnectionSettings) error {
+func (s *executor) Upload(ctx context.Context, connection executors.ConnectionSettings, file string, content []byte) (err error) {
+	logger := s.logger.WithField("file", file)
+	logger.Debug("[Upload] Will connect to server and upload the file")
+
+	err = s.connect(ctx, connection)
+	if err != nil {
+		return fmt.Errorf("connecting to server: %w", err)
+	}
+
+	defer func() {
+		disconnectErr := s.disconnect()
+		if err == nil && disconnectErr != nil {
+			err = fmt.Errorf("disconnecting from server: %w", disconnectErr)
+		}
+	}()
+
+	script := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s", executors.ShellQuote(path.Dir(file)), executors.ShellQuote(file))
+
+	err = s.executeScriptWithInput(ctx, script, connection.Environment, bytes.NewReader(content), s.stdout, s.stderr)
+	if err != nil {
+		return fmt.Errorf("writing file %q: %w", file, err)
+	}
+
+	logger.Debug("[Upload] Successfully uploaded file")
+
+	return nil
+}
+
+func (s *executor) Output(ctx context.Context, connection executors.ConnectionSettings, script []byte) (output []byte, err error) {
+	s.logger.Debug("[Output] Will connect to server and capture the output of the script")
+
+	err = s.connect(ctx, connection)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to server: %w", err)
+	}
+
+	defer func() {
+		disconnectErr := s.disconnect()
+		if err == nil && disconnectErr != nil {
+			err = fmt.Errorf("disconnecting from server: %w", disconnectErr)
+		}
+	}()
+
+	stdout := new(bytes.Buffer)
+
+	err = s.executeScript(ctx, script, connection.Environment, stdout, s.stderr)
+	if err != nil {
+		return nil, fmt.Errorf("executing script: %w", err)
+	}
+
+	s.logger.Debug("[Output] Successfully executed script")
+
+	return stdout.Bytes(), nil
+}
+
+func (s *executor) Stream(ctx context.Context, connection executors.ConnectionSettings, script []byte, stdin io.Reader, stdout io.Writer) (err error) {
+	s.logger.Debug("[Stream] Will connect to server and stream the script")
+
+	err = s.connect(ctx, connection)
+	if err != nil {
+		return fmt.Errorf("connecting to server: %w", err)
+	}
+
+	defer func() {
+		disconnectErr := s.disconnect()
+		if err == nil && disconnectErr != nil {
+			err = fmt.Errorf("disconnecting from server: %w", disconnectErr)
+		}
+	}()
+
+	s.renewLease(connection.LeaseDuration)
+
+	err = s.executeScriptWithInput(ctx, string(script), connection.Environment, stdin, stdout, s.stderr)
+	if err != nil {
+		return fmt.Errorf("executing script: %w", err)
+	}
+
+	s.logger.Debug("[Stream] Successfully streamed script")
+
+	return nil
+}
+
+func (s *executor) CheckConnection(ctx context.Context, connection executors.ConnectionSettings) error {
+	s.logger.Debug("[CheckConnection] Will check the connection to server")
+
+	err := s.connect(ctx, connection)
+	if err != nil {
+		return fmt.Errorf("connecting to server: %w", err)
+	}
+
+	s.renewLease(connection.LeaseDuration)
+
+	err = s.disconnect()
+	if err != nil {
+		return fmt.Errorf("disconnecting from server: %w", err)
+	}
+
+	s.logger.Debug("[CheckConnection] Server accepts connections")
+
+	return nil
+}
+
+func (s *executor) connect(ctx context.Context, connection executors.ConnectionSettings) error {
 	s.logger.Debug("[connect] Will connect to server via SSH")
 
 	signer, err := ssh.ParsePrivateKey(connection.PrivateKey)
@@ -95,11 +242,12 @@ func (s *executor) connect(connection executors.ConnectionSettings) error {
 	config := &ssh.ClientConfig{
 		User:            connection.Username,
 		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback(connection.HostKeyFingerprint),
+		Timeout:         connection.ConnectTimeout,
 	}
 
 	addr := fmt.Sprintf("%s:%d", connection.Hostname, connection.Port)
-	cli, err := s.connectClient("tcp", addr, config)
+	cli, err := s.connectClient(ctx, "tcp", addr, config)
 	if err != nil {
 		return fmt.Errorf("connecting to server %q as user %q: %w", addr, connection.Username, err)
 	}
@@ -111,6 +259,42 @@ func (s *executor) connect(connection executors.ConnectionSettings) error {
 	return nil
 }
 
+func hostKeyCallback(fingerprint string) ssh.HostKeyCallback {
+	if fingerprint == "" {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		received := ssh.FingerprintSHA256(key)
+		if received != fingerprint {
+			return &errHostKeyMismatch{expected: fingerprint, received: received}
+		}
+
+		return nil
+	}
+}
+
+// renewLease doesn't fail the execution, as the SSH server may not support
+// leases at all
+func (s *executor) renewLease(duration time.Duration) {
+	if duration <= 0 || s.client == nil {
+		return
+	}
+
+	logger := s.logger.WithField("duration", duration)
+	logger.Debug("[renewLease] Will renew the lease of the SSH service")
+
+	payload := ssh.Marshal(struct{ Seconds uint32 }{Seconds: uint32(duration / time.Second)})
+
+	ok, _, err := s.client.SendRequest(leaseRenewRequest, true, payload)
+	if err != nil || !ok {
+		logger.WithError(err).Warning("Lease of the SSH service was not renewed")
+		return
+	}
+
+	logger.Debug("[renewLease] Lease renewed")
+}
+
 func (s *executor) disconnect() error {
 	s.logger.Debug("[disconnect] Will disconnect from server")
 
@@ -130,20 +314,53 @@ func (s *executor) disconnect() error {
 	return nil
 }
 
-func (s *executor) executeScript(ctx context.Context, script []byte, stdout io.Writer, stderr io.Writer) error {
+// setSessionEnv sets the variables, sorted by name, in the session
+func setSessionEnv(sess session.Session, env map[string]string) error {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := sess.Setenv(name, env[name])
+		if err != nil {
+			return fmt.Errorf("setting %q in the session environment: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *executor) executeScript(ctx context.Context, script []byte, env map[string]string, stdout io.Writer, stderr io.Writer) error {
+	return s.executeScriptWithInput(ctx, string(script), env, nil, stdout, stderr)
+}
+
+func (s *executor) executeScriptWithInput(ctx context.Context, script string, env map[string]string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	s.logger.Debug("[executeScript] Will execute a remote script")
 
 	if s.client == nil {
 		return ErrNotConnected
 	}
 
-	session, err := s.client.NewSession(stdout, stderr)
+	session, err := s.client.NewSession(stdin, stdout, stderr)
 	if err != nil {
 		return fmt.Errorf("creating session for ssh client: %w", err)
 	}
 	defer session.Close()
 
-	err = session.ExecuteScript(ctx, string(script))
+	err = setSessionEnv(session, env)
+	if err != nil {
+		return err
+	}
+
+	err = session.ExecuteScript(ctx, script)
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		err = &errScriptFailed{inner: err}
+	}
+
 	if err != nil {
 		return fmt.Errorf("executing remote script: %w", err)
 	}
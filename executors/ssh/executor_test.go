@@ -10,8 +10,12 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -26,6 +30,8 @@ import (
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
 )
 
+const integrationServiceToken = "integration-tests-token"
+
 func TestNewExecutor(t *testing.T) {
 	exec := NewExecutor(createTestLogger())
 	assert.NotNil(t, exec, "Function should have instantiated a new executor")
@@ -36,10 +42,10 @@ func createTestLogger() logging.Logger {
 	return test.NewNullLogger()
 }
 
-type connectClientFn func(string, string, *ssh.ClientConfig) (client.Client, error)
+type connectClientFn func(context.Context, string, string, *ssh.ClientConfig) (client.Client, error)
 
 func newConnectClientFn(cli client.Client, err error) connectClientFn {
-	return func(network string, addr string, config *ssh.ClientConfig) (client.Client, error) {
+	return func(ctx context.Context, network string, addr string, config *ssh.ClientConfig) (client.Client, error) {
 		return cli, err
 	}
 }
@@ -52,6 +58,7 @@ func TestExecute(t *testing.T) {
 	testErrorSSHInternal := new(errInvalidPrivateKey)
 	testErrorSession := errors.New("simulated error 1")
 	testErrorScript := errors.New("simulated error 2")
+	testErrorExit := fmt.Errorf("executing SSH command: %w", new(ssh.ExitError))
 
 	tests := map[string]struct {
 		client          *ssh.Client
@@ -70,7 +77,7 @@ func TestExecute(t *testing.T) {
 					Once()
 
 				cli := new(client.MockClient)
-				cli.On("NewSession", mock.Anything, mock.Anything).
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
 					Return(sess, nil).
 					Once()
 				cli.On("Disconnect").
@@ -104,7 +111,7 @@ func TestExecute(t *testing.T) {
 			validPrivateKey: true,
 			connectClient: func() (connectClientFn, func(*testing.T)) {
 				cli := new(client.MockClient)
-				cli.On("NewSession", mock.Anything, mock.Anything).
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
 					Return(nil, testErrorSession).
 					Once()
 				cli.On("Disconnect").
@@ -130,7 +137,7 @@ func TestExecute(t *testing.T) {
 					Once()
 
 				cli := new(client.MockClient)
-				cli.On("NewSession", mock.Anything, mock.Anything).
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
 					Return(sess, nil).
 					Once()
 				cli.On("Disconnect").
@@ -146,6 +153,33 @@ func TestExecute(t *testing.T) {
 			},
 			expectedError: testErrorScript,
 		},
+		"Script exiting with a non-zero status": {
+			validPrivateKey: true,
+			connectClient: func() (connectClientFn, func(*testing.T)) {
+				sess := new(session.MockSession)
+				sess.On("ExecuteScript", testContext, testScript).
+					Return(testErrorExit).
+					Once()
+				sess.On("Close").
+					Once()
+
+				cli := new(client.MockClient)
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
+					Return(sess, nil).
+					Once()
+				cli.On("Disconnect").
+					Return(nil).
+					Once()
+
+				mocksAssertions := func(t *testing.T) {
+					cli.AssertExpectations(t)
+					sess.AssertExpectations(t)
+				}
+
+				return newConnectClientFn(cli, nil), mocksAssertions
+			},
+			expectedError: executors.ErrScriptFailed,
+		},
 		"Error when not connected to server": {
 			validPrivateKey: true,
 			connectClient: func() (connectClientFn, func(*testing.T)) {
@@ -164,7 +198,7 @@ func TestExecute(t *testing.T) {
 					Once()
 
 				cli := new(client.MockClient)
-				cli.On("NewSession", mock.Anything, mock.Anything).
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
 					Return(sess, nil).
 					Once()
 				cli.On("Disconnect").
@@ -191,7 +225,7 @@ func TestExecute(t *testing.T) {
 					Once()
 
 				cli := new(client.MockClient)
-				cli.On("NewSession", mock.Anything, mock.Anything).
+				cli.On("NewSession", mock.Anything, mock.Anything, mock.Anything).
 					Return(sess, nil).
 					Once()
 				cli.On("Disconnect").
@@ -237,6 +271,281 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestUpload(t *testing.T) {
+	testContext := context.Background()
+	testContent := []byte("export DB_PASS='secret'\n")
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		executeError  error
+		expectedError error
+	}{
+		"Upload with success": {},
+		"Error on writing the file": {
+			executeError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			sess := new(session.MockSession)
+			defer sess.AssertExpectations(t)
+
+			sess.On("ExecuteScript", testContext, "umask 077 && mkdir -p '/dev/shm/driver' && cat > '/dev/shm/driver/secrets.env'").
+				Return(tt.executeError).
+				Once()
+			sess.On("Close").
+				Once()
+
+			cli := new(client.MockClient)
+			defer cli.AssertExpectations(t)
+
+			cli.On("NewSession", bytes.NewReader(testContent), mock.Anything, mock.Anything).
+				Return(sess, nil).
+				Once()
+			cli.On("Disconnect").
+				Return(nil).
+				Once()
+
+			executor := &executor{logger: createTestLogger()}
+			executor.connectClient = newConnectClientFn(cli, nil)
+
+			connection := executors.ConnectionSettings{
+				Hostname:   "localhost",
+				Port:       22,
+				Username:   "root",
+				PrivateKey: createFakePrivateKeyForTests(true),
+			}
+
+			err := executor.Upload(testContext, connection, "/dev/shm/driver/secrets.env", testContent)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Nil(t, executor.client, "SSH client should be nil after disconnecting")
+		})
+	}
+}
+
+func TestOutput(t *testing.T) {
+	testContext := context.Background()
+	testScript := "uname -m"
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		executeError   error
+		expectedOutput []byte
+		expectedError  error
+	}{
+		"Output captured": {
+			expectedOutput: []byte("x86_64\n"),
+		},
+		"Error on executing the script": {
+			executeError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var stdout io.Writer
+
+			sess := new(session.MockSession)
+			defer sess.AssertExpectations(t)
+
+			sess.On("ExecuteScript", testContext, testScript).
+				Run(func(mock.Arguments) {
+					_, _ = stdout.Write([]byte("x86_64\n"))
+				}).
+				Return(tt.executeError).
+				Once()
+			sess.On("Close").
+				Once()
+
+			cli := new(client.MockClient)
+			defer cli.AssertExpectations(t)
+
+			cli.On("NewSession", nil, mock.Anything, mock.Anything).
+				Run(func(args mock.Arguments) {
+					stdout = args.Get(1).(io.Writer)
+				}).
+				Return(sess, nil).
+				Once()
+			cli.On("Disconnect").
+				Return(nil).
+				Once()
+
+			executor := &executor{logger: createTestLogger()}
+			executor.connectClient = newConnectClientFn(cli, nil)
+
+			connection := executors.ConnectionSettings{
+				Hostname:   "localhost",
+				Port:       22,
+				Username:   "root",
+				PrivateKey: createFakePrivateKeyForTests(true),
+			}
+
+			output, err := executor.Output(testContext, connection, []byte(testScript))
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedOutput, output)
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	testContext := context.Background()
+	testScript := "python3"
+	testInput := []byte("print(1)\n")
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		setenvError   error
+		expectScript  bool
+		expectedError error
+	}{
+		"Stream with the environment": {
+			expectScript: true,
+		},
+		"Error on setting the environment": {
+			setenvError:   testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			sess := new(session.MockSession)
+			defer sess.AssertExpectations(t)
+
+			sess.On("Setenv", "FARGATE_CLUSTER", "cluster").
+				Return(tt.setenvError).
+				Once()
+			if tt.expectScript {
+				sess.On("Setenv", "FARGATE_TASK_ARN", "arn").
+					Return(nil).
+					Once()
+				sess.On("ExecuteScript", testContext, testScript).
+					Return(nil).
+					Once()
+			}
+			sess.On("Close").
+				Once()
+
+			cli := new(client.MockClient)
+			defer cli.AssertExpectations(t)
+
+			cli.On("NewSession", bytes.NewReader(testInput), mock.Anything, mock.Anything).
+				Return(sess, nil).
+				Once()
+			cli.On("Disconnect").
+				Return(nil).
+				Once()
+
+			executor := &executor{logger: createTestLogger()}
+			executor.connectClient = newConnectClientFn(cli, nil)
+
+			connection := executors.ConnectionSettings{
+				Hostname:   "localhost",
+				Port:       22,
+				Username:   "root",
+				PrivateKey: createFakePrivateKeyForTests(true),
+				Environment: map[string]string{
+					"FARGATE_TASK_ARN": "arn",
+					"FARGATE_CLUSTER":  "cluster",
+				},
+			}
+
+			err := executor.Stream(testContext, connection, []byte(testScript), bytes.NewReader(testInput), new(bytes.Buffer))
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckConnection(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		validPrivateKey bool
+		connectError    error
+		disconnectError error
+		expectedError   error
+	}{
+		"Check connection with success": {
+			validPrivateKey: true,
+		},
+		"Private key invalid": {
+			validPrivateKey: false,
+			expectedError:   new(errInvalidPrivateKey),
+		},
+		"Connect to server error": {
+			validPrivateKey: true,
+			connectError:    testError,
+			expectedError:   testError,
+		},
+		"Disconnect from server error": {
+			validPrivateKey: true,
+			disconnectError: testError,
+			expectedError:   testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cli := new(client.MockClient)
+			defer cli.AssertExpectations(t)
+
+			connectClient := newConnectClientFn(nil, tt.connectError)
+			if tt.validPrivateKey && tt.connectError == nil {
+				cli.On("SendRequest", leaseRenewRequest, true, mock.Anything).
+					Return(true, nil, nil).
+					Once()
+				cli.On("Disconnect").
+					Return(tt.disconnectError).
+					Once()
+
+				connectClient = newConnectClientFn(cli, nil)
+			}
+
+			executor := &executor{logger: createTestLogger()}
+			executor.connectClient = connectClient
+
+			connection := executors.ConnectionSettings{
+				Hostname:      "localhost",
+				Port:          22,
+				Username:      "root",
+				PrivateKey:    createFakePrivateKeyForTests(tt.validPrivateKey),
+				LeaseDuration: time.Hour,
+			}
+
+			err := executor.CheckConnection(context.Background(), connection)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Nil(t, executor.client, "SSH client should be nil after disconnecting")
+		})
+	}
+}
+
 func createFakePrivateKeyForTests(valid bool) []byte {
 	if !valid {
 		return []byte("invalid key")
@@ -258,40 +567,132 @@ func encodePrivateKeyToPEM(privateKey *rsa.PrivateKey) []byte {
 	return pem.EncodeToMemory(&privBlock)
 }
 
+func TestRenewLease(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		duration        time.Duration
+		requestAccepted bool
+		requestError    error
+		shouldNotSend   bool
+	}{
+		"Lease not used": {
+			duration:      0,
+			shouldNotSend: true,
+		},
+		"Lease renewed": {
+			duration:        time.Hour,
+			requestAccepted: true,
+		},
+		"Lease renewal rejected by the server": {
+			duration:        time.Hour,
+			requestAccepted: false,
+		},
+		"Error on sending the lease renewal": {
+			duration:     time.Hour,
+			requestError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cli := new(client.MockClient)
+			defer cli.AssertExpectations(t)
+
+			if !tt.shouldNotSend {
+				cli.On("SendRequest", leaseRenewRequest, true, []byte{0, 0, 0x0e, 0x10}).
+					Return(tt.requestAccepted, nil, tt.requestError).
+					Once()
+			}
+
+			executor := &executor{logger: createTestLogger(), client: cli}
+			executor.renewLease(tt.duration)
+		})
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		fingerprint   string
+		expectedError error
+	}{
+		"Host key not verified": {
+			fingerprint: "",
+		},
+		"Host key matching the fingerprint": {
+			fingerprint: ssh.FingerprintSHA256(publicKey),
+		},
+		"Host key not matching the fingerprint": {
+			fingerprint:   "SHA256:invalid",
+			expectedError: new(errHostKeyMismatch),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			err := hostKeyCallback(tt.fingerprint)("localhost", nil, publicKey)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestSSHIntegration requires the ssh_service image started with SSH_PUBLIC_KEY
+// set to the content of testdata/id_rsa.pub and SSH_SERVICE_TOKEN set to the
+// value of integrationServiceToken
 func TestSSHIntegration(t *testing.T) {
 	sshServiceHost := "ssh"
 	sshInfoService := fmt.Sprintf("http://%s:8888/", sshServiceHost)
 
-	resp, err := http.Get(sshInfoService)
+	req, err := http.NewRequest(http.MethodGet, sshInfoService, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+integrationServiceToken)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Skipf("Couldn't access SSH service: %v", err)
 	}
 
 	defer resp.Body.Close()
 
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
 	var sshInfo struct {
-		Port           int
-		Username       string
-		HostPrivateKey string
-		HostPublicKey  string
-		UserPrivateKey string
-		UserPublicKey  string
+		Port               int
+		Username           string
+		HostKeyFingerprint string
 	}
 
 	decoder := json.NewDecoder(resp.Body)
 	err = decoder.Decode(&sshInfo)
 	require.NoError(t, err)
 
+	privateKey, err := ioutil.ReadFile(filepath.Join("testdata", "id_rsa"))
+	require.NoError(t, err)
+
 	settings := executors.ConnectionSettings{
-		Hostname:   sshServiceHost,
-		Port:       sshInfo.Port,
-		Username:   sshInfo.Username,
-		PrivateKey: []byte(sshInfo.UserPrivateKey),
+		Hostname:           sshServiceHost,
+		Port:               sshInfo.Port,
+		Username:           sshInfo.Username,
+		PrivateKey:         privateKey,
+		HostKeyFingerprint: sshInfo.HostKeyFingerprint,
 	}
 
 	tests := map[string]struct {
-		ctx          func() context.Context
-		assertOutput func(t *testing.T, output string)
+		ctx           func() context.Context
+		assertOutput  func(t *testing.T, output string)
+		expectedError error
 	}{
 		"context finished before command": {
 			ctx: func() context.Context {
@@ -304,6 +705,7 @@ func TestSSHIntegration(t *testing.T) {
 				t.Log(output)
 				assert.NotContains(t, output, "Exiting!")
 			},
+			expectedError: context.Canceled,
 		},
 		"context finished after command": {
 			ctx: func() context.Context {
@@ -338,7 +740,11 @@ done
 
 			err := e.Execute(tt.ctx(), settings, []byte(script))
 
-			assert.NoError(t, err)
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
 			tt.assertOutput(t, out.String())
 		})
 	}
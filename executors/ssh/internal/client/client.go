@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"io"
+	"net"
 
 	"golang.org/x/crypto/ssh"
 
@@ -9,18 +11,52 @@ import (
 )
 
 type Client interface {
-	NewSession(stdout io.Writer, stderr io.Writer) (session.Session, error)
+	// NewSession creates a session reading stdin, which can be nil
+	NewSession(stdin io.Reader, stdout io.Writer, stderr io.Writer) (session.Session, error)
+	SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error)
 	Disconnect() error
 }
 
-func NewConnectClient(network string, addr string, config *ssh.ClientConfig) (Client, error) {
-	c, err := ssh.Dial(network, addr, config)
+// NewConnectClient dials the server and completes the SSH handshake. The
+// connection is aborted as soon as the context is done
+func NewConnectClient(ctx context.Context, network string, addr string, config *ssh.ClientConfig) (Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake doesn't accept a context, so the connection is closed
+	// to interrupt it
+	handshakeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(handshakeDone)
+
+	if err == nil && ctx.Err() != nil {
+		_ = c.Close()
+		err = ctx.Err()
+	}
+
 	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
 		return nil, err
 	}
 
 	cli := &defaultClient{
-		internal: c,
+		internal: ssh.NewClient(c, chans, reqs),
 	}
 
 	return cli, nil
@@ -30,18 +66,23 @@ type defaultClient struct {
 	internal *ssh.Client
 }
 
-func (c *defaultClient) NewSession(stdout io.Writer, stderr io.Writer) (session.Session, error) {
+func (c *defaultClient) NewSession(stdin io.Reader, stdout io.Writer, stderr io.Writer) (session.Session, error) {
 	s, err := c.internal.NewSession()
 	if err != nil {
 		return nil, err
 	}
 
+	s.Stdin = stdin
 	s.Stdout = stdout
 	s.Stderr = stderr
 
 	return session.New(s), nil
 }
 
+func (c *defaultClient) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return c.internal.SendRequest(name, wantReply, payload)
+}
+
 func (c *defaultClient) Disconnect() error {
 	return c.internal.Close()
 }
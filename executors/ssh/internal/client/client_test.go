@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestNewConnectClient_ContextDone(t *testing.T) {
+	// The listener accepts the connections but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conns = append(conns, conn)
+		}
+	}()
+
+	tests := map[string]struct {
+		ctx           func() (context.Context, func())
+		expectedError error
+	}{
+		"Context canceled before dialing": {
+			ctx: func() (context.Context, func()) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				return ctx, cancel
+			},
+			expectedError: context.Canceled,
+		},
+		"Context deadline exceeded during the handshake": {
+			ctx: func() (context.Context, func()) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			config := &ssh.ClientConfig{
+				User:            "root",
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			}
+
+			done := make(chan error)
+			go func() {
+				_, err := NewConnectClient(ctx, "tcp", listener.Addr().String(), config)
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				assertions.ErrorIs(t, err, tt.expectedError)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "handshake was not interrupted by the context")
+			}
+		})
+	}
+}
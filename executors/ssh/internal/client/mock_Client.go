@@ -28,13 +28,13 @@ func (_m *MockClient) Disconnect() error {
 	return r0
 }
 
-// NewSession provides a mock function with given fields: stdout, stderr
-func (_m *MockClient) NewSession(stdout io.Writer, stderr io.Writer) (session.Session, error) {
-	ret := _m.Called(stdout, stderr)
+// NewSession provides a mock function with given fields: stdin, stdout, stderr
+func (_m *MockClient) NewSession(stdin io.Reader, stdout io.Writer, stderr io.Writer) (session.Session, error) {
+	ret := _m.Called(stdin, stdout, stderr)
 
 	var r0 session.Session
-	if rf, ok := ret.Get(0).(func(io.Writer, io.Writer) session.Session); ok {
-		r0 = rf(stdout, stderr)
+	if rf, ok := ret.Get(0).(func(io.Reader, io.Writer, io.Writer) session.Session); ok {
+		r0 = rf(stdin, stdout, stderr)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(session.Session)
@@ -42,11 +42,41 @@ func (_m *MockClient) NewSession(stdout io.Writer, stderr io.Writer) (session.Se
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(io.Writer, io.Writer) error); ok {
-		r1 = rf(stdout, stderr)
+	if rf, ok := ret.Get(1).(func(io.Reader, io.Writer, io.Writer) error); ok {
+		r1 = rf(stdin, stdout, stderr)
 	} else {
 		r1 = ret.Error(1)
 	}
 
 	return r0, r1
 }
+
+// SendRequest provides a mock function with given fields: name, wantReply, payload
+func (_m *MockClient) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	ret := _m.Called(name, wantReply, payload)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string, bool, []byte) bool); ok {
+		r0 = rf(name, wantReply, payload)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 []byte
+	if rf, ok := ret.Get(1).(func(string, bool, []byte) []byte); ok {
+		r1 = rf(name, wantReply, payload)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]byte)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, bool, []byte) error); ok {
+		r2 = rf(name, wantReply, payload)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
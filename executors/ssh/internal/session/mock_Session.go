@@ -31,3 +31,17 @@ func (_m *MockSession) ExecuteScript(ctx context.Context, script string) error {
 
 	return r0
 }
+
+// Setenv provides a mock function with given fields: name, value
+func (_m *MockSession) Setenv(name string, value string) error {
+	ret := _m.Called(name, value)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(name, value)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
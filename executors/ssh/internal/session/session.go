@@ -8,6 +8,9 @@ import (
 )
 
 type Session interface {
+	// Setenv requests the variable to be set in the environment of the
+	// script. It must be called before ExecuteScript
+	Setenv(name string, value string) error
 	ExecuteScript(ctx context.Context, script string) error
 	Close()
 }
@@ -22,6 +25,10 @@ type defaultSession struct {
 	internal *ssh.Session
 }
 
+func (s *defaultSession) Setenv(name string, value string) error {
+	return s.internal.Setenv(name, value)
+}
+
 func (s *defaultSession) ExecuteScript(ctx context.Context, script string) error {
 	waitErr := make(chan error)
 
@@ -7,14 +7,16 @@ import (
 
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 )
 
 type Context struct {
 	Ctx stdContext.Context
 	Cli *cli.Context
 
-	config config.Global
-	logger logging.Logger
+	config     config.Global
+	logger     logging.Logger
+	jobContext runner.JobContext
 }
 
 func (c *Context) SetConfig(cfg config.Global) {
@@ -25,6 +27,16 @@ func (c *Context) Config() config.Global {
 	return c.config
 }
 
+func (c *Context) SetJobContext(jobContext runner.JobContext) {
+	c.jobContext = jobContext
+}
+
+// JobContext returns the predefined CI variables of the job, which are empty
+// for the commands not executed by the Custom Executor
+func (c *Context) JobContext() runner.JobContext {
+	return c.jobContext
+}
+
 func (c *Context) SetLogger(logger logging.Logger) {
 	c.logger = logger
 }
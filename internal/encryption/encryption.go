@@ -0,0 +1,188 @@
+// Package encryption provides the envelope encryption of sensitive data stored at rest
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+)
+
+const dataKeySize = 32
+
+var (
+	// ErrUnknownProvider is returned when the configuration selects an unsupported key provider
+	ErrUnknownProvider = errors.New("unknown encryption provider")
+
+	// ErrProviderMismatch is returned when the envelope was encrypted by another key provider
+	ErrProviderMismatch = errors.New("envelope was encrypted by another provider")
+
+	// ErrKeyNotFound is returned when the provider doesn't know the key that wrapped the data key
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// Envelope holds data encrypted with a random data key. The data key is
+// stored wrapped by the key of the provider identified by KeyID
+type Envelope struct {
+	Provider   string
+	KeyID      string
+	WrappedKey []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// Encrypter encrypts and decrypts the sensitive data
+type Encrypter interface {
+	// Encrypt seals the plaintext with a new data key wrapped by the current key
+	Encrypt(plaintext []byte) (*Envelope, error)
+
+	// Decrypt opens an envelope created by Encrypt, with the current or a previous key
+	Decrypt(envelope *Envelope) ([]byte, error)
+
+	// IsCurrent reports whether the envelope was wrapped by the current key
+	IsCurrent(envelope *Envelope) (bool, error)
+}
+
+// KeyProvider wraps and unwraps the data keys with the keys it manages
+type KeyProvider interface {
+	// Name identifies the provider in the envelopes
+	Name() string
+
+	// CurrentKeyID returns the ID of the key used by WrapKey
+	CurrentKeyID() (string, error)
+
+	// WrapKey encrypts the data key with the current key and returns its ID
+	WrapKey(dataKey []byte) (string, []byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by the identified key
+	UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+type envelopeEncrypter struct {
+	provider KeyProvider
+}
+
+// NewEncrypter is a constructor for the concrete type of the Encrypter interface
+func NewEncrypter(provider KeyProvider) Encrypter {
+	return &envelopeEncrypter{provider: provider}
+}
+
+// NewEncrypterForConfig creates the Encrypter using the configured key provider.
+// The region of the Fargate cluster is used when KMS doesn't define its own
+func NewEncrypterForConfig(cfg config.Encryption, defaultRegion string) (Encrypter, error) {
+	var provider KeyProvider
+	var err error
+
+	switch cfg.Provider {
+	case config.EncryptionProviderKeyFile:
+		provider, err = NewKeyFileProvider(cfg.KeyFile)
+	case config.EncryptionProviderKeyring:
+		provider, err = NewKeyringProvider(cfg.KeyringKey)
+	case config.EncryptionProviderKMS:
+		provider, err = NewKMSProvider(cfg.KMS, defaultRegion)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, cfg.Provider)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("creating %s provider: %w", cfg.Provider, err)
+	}
+
+	return NewEncrypter(provider), nil
+}
+
+func (e *envelopeEncrypter) Encrypt(plaintext []byte) (*Envelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+
+	nonce, ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting data: %w", err)
+	}
+
+	keyID, wrappedKey, err := e.provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	envelope := &Envelope{
+		Provider:   e.provider.Name(),
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}
+
+	return envelope, nil
+}
+
+func (e *envelopeEncrypter) Decrypt(envelope *Envelope) ([]byte, error) {
+	if envelope.Provider != e.provider.Name() {
+		return nil, fmt.Errorf("%w: %q", ErrProviderMismatch, envelope.Provider)
+	}
+
+	dataKey, err := e.provider.UnwrapKey(envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key with key %q: %w", envelope.KeyID, err)
+	}
+
+	plaintext, err := open(dataKey, envelope.Nonce, envelope.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func (e *envelopeEncrypter) IsCurrent(envelope *Envelope) (bool, error) {
+	if envelope.Provider != e.provider.Name() {
+		return false, nil
+	}
+
+	keyID, err := e.provider.CurrentKeyID()
+	if err != nil {
+		return false, fmt.Errorf("obtaining current key ID: %w", err)
+	}
+
+	return envelope.KeyID == keyID, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
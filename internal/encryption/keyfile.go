@@ -0,0 +1,92 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrNoKeys is returned when the key file doesn't define any key
+var ErrNoKeys = errors.New("no keys defined")
+
+// NewKeyFileProvider loads the keys defined in the file. Each line defines
+// a key as "<id> <base64 encoded 256-bit key>". The last key is the current
+// one, the previous keys are kept to decrypt the records they encrypted.
+// Empty lines and lines starting with "#" are ignored
+func NewKeyFileProvider(file string) (KeyProvider, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file %q: %w", file, err)
+	}
+
+	keys, currentKeyID, err := parseKeyFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing key file %q: %w", file, err)
+	}
+
+	provider := &localProvider{
+		name:         "keyfile",
+		currentKeyID: currentKeyID,
+		lookup: func(keyID string) ([]byte, error) {
+			key, ok := keys[keyID]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, keyID)
+			}
+
+			return key, nil
+		},
+	}
+
+	return provider, nil
+}
+
+func parseKeyFile(content []byte) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	currentKeyID := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, "", fmt.Errorf("line %d: expected \"<id> <key>\"", lineNumber)
+		}
+
+		keyID := fields[0]
+		if _, ok := keys[keyID]; ok {
+			return nil, "", fmt.Errorf("line %d: duplicated key %q", lineNumber, keyID)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: decoding key %q: %w", lineNumber, keyID, err)
+		}
+
+		err = validateKeySize(keyID, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		keys[keyID] = key
+		currentKeyID = keyID
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if currentKeyID == "" {
+		return nil, "", ErrNoKeys
+	}
+
+	return keys, currentKeyID, nil
+}
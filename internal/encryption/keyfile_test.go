@@ -0,0 +1,103 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), dataKeySize)))
+}
+
+func writeKeyFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, "keys")
+	err = ioutil.WriteFile(file, []byte(content), 0600)
+	require.NoError(t, err)
+
+	return file, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestParseKeyFile(t *testing.T) {
+	tests := map[string]struct {
+		content              string
+		expectedCurrentKeyID string
+		expectedKeys         int
+		expectedError        error
+		expectError          bool
+	}{
+		"Single key": {
+			content:              "v1 " + testKey('a') + "\n",
+			expectedCurrentKeyID: "v1",
+			expectedKeys:         1,
+		},
+		"Rotated keys with comments": {
+			content:              "# old key\nv1 " + testKey('a') + "\n\nv2 " + testKey('b') + "\n",
+			expectedCurrentKeyID: "v2",
+			expectedKeys:         2,
+		},
+		"Empty file": {
+			content:       "# no keys\n",
+			expectedError: ErrNoKeys,
+		},
+		"Invalid line": {
+			content:     "v1\n",
+			expectError: true,
+		},
+		"Invalid key size": {
+			content:     "v1 " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n",
+			expectError: true,
+		},
+		"Duplicated key": {
+			content:     "v1 " + testKey('a') + "\nv1 " + testKey('b') + "\n",
+			expectError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			keys, currentKeyID, err := parseKeyFile([]byte(tt.content))
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCurrentKeyID, currentKeyID)
+			assert.Len(t, keys, tt.expectedKeys)
+		})
+	}
+}
+
+func TestNewKeyFileProvider(t *testing.T) {
+	_, err := NewKeyFileProvider("/non/existing/file")
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+
+	file, cleanup := writeKeyFile(t, "v1 "+testKey('a')+"\n")
+	defer cleanup()
+
+	provider, err := NewKeyFileProvider(file)
+	require.NoError(t, err)
+
+	keyID, err := provider.CurrentKeyID()
+	require.NoError(t, err)
+	assert.Equal(t, "v1", keyID)
+	assert.Equal(t, "keyfile", provider.Name())
+}
@@ -0,0 +1,61 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const keyringKeyType = "user"
+
+// NewKeyringProvider uses the keys of type "user" stored in the user keyring
+// of the kernel, for example added with:
+//
+//	head -c 32 /dev/urandom | keyctl padd user fargate-metadata-v1 @u
+//
+// The key with the given description is the current one. Records encrypted
+// with previous keys can be decrypted while those keys stay in the keyring
+func NewKeyringProvider(description string) (KeyProvider, error) {
+	if description == "" {
+		return nil, errors.New("key description is not defined")
+	}
+
+	provider := &localProvider{
+		name:         "keyring",
+		currentKeyID: description,
+		lookup:       readKeyringKey,
+	}
+
+	return provider, nil
+}
+
+func readKeyringKey(description string) ([]byte, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, keyringKeyType, description, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, description)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("searching key %q: %w", description, err)
+	}
+
+	key := make([]byte, dataKeySize+1)
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, key, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading key %q: %w", description, err)
+	}
+
+	if size > len(key) {
+		size = len(key)
+	}
+
+	key = key[:size]
+
+	err = validateKeySize(description, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,40 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestKeyringProvider(t *testing.T) {
+	description := "fargate-driver-test-key"
+	key := []byte(strings.Repeat("k", dataKeySize))
+
+	id, err := unix.AddKey(keyringKeyType, description, key, unix.KEY_SPEC_USER_KEYRING)
+	if err != nil {
+		t.Skipf("kernel keyring is not available: %v", err)
+	}
+	defer func() { _, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_USER_KEYRING, 0, 0) }()
+
+	_, err = NewKeyringProvider("")
+	assert.Error(t, err)
+
+	provider, err := NewKeyringProvider(description)
+	require.NoError(t, err)
+
+	keyID, wrapped, err := provider.WrapKey([]byte("data-key"))
+	require.NoError(t, err)
+	assert.Equal(t, description, keyID)
+
+	unwrapped, err := provider.UnwrapKey(keyID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data-key"), unwrapped)
+
+	_, err = provider.UnwrapKey("fargate-driver-missing-key", wrapped)
+	assertions.ErrorIs(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package encryption
+
+import (
+	"errors"
+)
+
+// NewKeyringProvider is supported only on Linux
+func NewKeyringProvider(description string) (KeyProvider, error) {
+	return nil, errors.New("kernel keyring is supported only on Linux")
+}
@@ -0,0 +1,116 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+)
+
+// kmsEncryptionContext binds the wrapped keys to this application, so they
+// can't be decrypted by KMS in another context
+var kmsEncryptionContext = map[string]*string{
+	"application": aws.String("gitlab-fargate-driver"),
+}
+
+type kmsClient interface {
+	EncryptWithContext(aws.Context, *kms.EncryptInput, ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(aws.Context, *kms.DecryptInput, ...request.Option) (*kms.DecryptOutput, error)
+	DescribeKeyWithContext(aws.Context, *kms.DescribeKeyInput, ...request.Option) (*kms.DescribeKeyOutput, error)
+}
+
+// kmsProvider wraps the data keys with a KMS key. The ARN of the key is
+// stored in the envelopes, so the configured key may be an alias
+type kmsProvider struct {
+	client kmsClient
+	keyID  string
+
+	mu         sync.Mutex
+	currentARN string
+}
+
+// NewKMSProvider creates a KeyProvider using AWS KMS, or a KMS-compatible
+// service available at the configured endpoint
+func NewKMSProvider(cfg config.KMS, defaultRegion string) (KeyProvider, error) {
+	if cfg.KeyID == "" {
+		return nil, errors.New("KMS key ID is not defined")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create AWS session: %w", err)
+	}
+
+	provider := &kmsProvider{
+		client: kms.New(sess),
+		keyID:  cfg.KeyID,
+	}
+
+	return provider, nil
+}
+
+func (k *kmsProvider) Name() string {
+	return "kms"
+}
+
+func (k *kmsProvider) CurrentKeyID() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.currentARN != "" {
+		return k.currentARN, nil
+	}
+
+	output, err := k.client.DescribeKeyWithContext(aws.BackgroundContext(), &kms.DescribeKeyInput{
+		KeyId: aws.String(k.keyID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing KMS key %q: %w", k.keyID, err)
+	}
+
+	k.currentARN = aws.StringValue(output.KeyMetadata.Arn)
+
+	return k.currentARN, nil
+}
+
+func (k *kmsProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	output, err := k.client.EncryptWithContext(aws.BackgroundContext(), &kms.EncryptInput{
+		KeyId:             aws.String(k.keyID),
+		Plaintext:         dataKey,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypting with KMS key %q: %w", k.keyID, err)
+	}
+
+	return aws.StringValue(output.KeyId), output.CiphertextBlob, nil
+}
+
+func (k *kmsProvider) UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	output, err := k.client.DecryptWithContext(aws.BackgroundContext(), &kms.DecryptInput{
+		KeyId:             aws.String(keyID),
+		CiphertextBlob:    wrappedKey,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with KMS key %q: %w", keyID, err)
+	}
+
+	return output.Plaintext, nil
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestKMSProvider(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-1:123456789012:key/test"
+	dataKey := []byte("data-key")
+	wrappedKey := []byte("wrapped-key")
+
+	client := new(mockKmsClient)
+	defer client.AssertExpectations(t)
+
+	client.
+		On("EncryptWithContext", mock.Anything, mock.MatchedBy(func(input *kms.EncryptInput) bool {
+			return aws.StringValue(input.KeyId) == "alias/fargate" &&
+				string(input.Plaintext) == string(dataKey) &&
+				input.EncryptionContext["application"] != nil
+		})).
+		Return(&kms.EncryptOutput{KeyId: aws.String(keyARN), CiphertextBlob: wrappedKey}, nil).
+		Once()
+	client.
+		On("DecryptWithContext", mock.Anything, mock.MatchedBy(func(input *kms.DecryptInput) bool {
+			return aws.StringValue(input.KeyId) == keyARN && string(input.CiphertextBlob) == string(wrappedKey)
+		})).
+		Return(&kms.DecryptOutput{KeyId: aws.String(keyARN), Plaintext: dataKey}, nil).
+		Once()
+	client.
+		On("DescribeKeyWithContext", mock.Anything, mock.AnythingOfType("*kms.DescribeKeyInput")).
+		Return(&kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String(keyARN)}}, nil).
+		Once()
+
+	provider := &kmsProvider{client: client, keyID: "alias/fargate"}
+
+	keyID, wrapped, err := provider.WrapKey(dataKey)
+	require.NoError(t, err)
+	assert.Equal(t, keyARN, keyID)
+	assert.Equal(t, wrappedKey, wrapped)
+
+	unwrapped, err := provider.UnwrapKey(keyID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	for i := 0; i < 2; i++ {
+		currentKeyID, err := provider.CurrentKeyID()
+		require.NoError(t, err)
+		assert.Equal(t, keyARN, currentKeyID)
+	}
+}
+
+func TestKMSProvider_Errors(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	client := new(mockKmsClient)
+	defer client.AssertExpectations(t)
+
+	client.On("EncryptWithContext", mock.Anything, mock.Anything).Return(nil, testError).Once()
+	client.On("DecryptWithContext", mock.Anything, mock.Anything).Return(nil, testError).Once()
+	client.On("DescribeKeyWithContext", mock.Anything, mock.Anything).Return(nil, testError).Once()
+
+	provider := &kmsProvider{client: client, keyID: "alias/fargate"}
+
+	_, _, err := provider.WrapKey([]byte("data-key"))
+	assertions.ErrorIs(t, err, testError)
+
+	_, err = provider.UnwrapKey("key", []byte("wrapped"))
+	assertions.ErrorIs(t, err, testError)
+
+	_, err = provider.CurrentKeyID()
+	assertions.ErrorIs(t, err, testError)
+}
@@ -0,0 +1,66 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errInvalidWrappedKey = errors.New("invalid wrapped key")
+
+// localProvider wraps the data keys with AES-GCM, using 256-bit keys
+// available on the host
+type localProvider struct {
+	name         string
+	currentKeyID string
+
+	lookup func(keyID string) ([]byte, error)
+}
+
+func (l *localProvider) Name() string {
+	return l.name
+}
+
+func (l *localProvider) CurrentKeyID() (string, error) {
+	return l.currentKeyID, nil
+}
+
+func (l *localProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	key, err := l.lookup(l.currentKeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce, wrappedKey, err := seal(key, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return l.currentKeyID, append(nonce, wrappedKey...), nil
+}
+
+func (l *localProvider) UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	key, err := l.lookup(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(wrappedKey) < nonceSize {
+		return nil, errInvalidWrappedKey
+	}
+
+	return gcm.Open(nil, wrappedKey[:nonceSize], wrappedKey[nonceSize:], nil)
+}
+
+func validateKeySize(keyID string, key []byte) error {
+	if len(key) != dataKeySize {
+		return fmt.Errorf("key %q has %d bytes, expected %d", keyID, len(key), dataKeySize)
+	}
+
+	return nil
+}
@@ -1,17 +1,32 @@
 package fs
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
 )
 
 type FS interface {
 	ReadFile(filename string) ([]byte, error)
+	ReadDir(dirname string) ([]os.FileInfo, error)
 	WriteFile(filename string, data []byte, perm os.FileMode) error
+	// WriteFileAtomic writes the data to a synced temporary file renamed to
+	// filename, so a crash never leaves a partially written file
+	WriteFileAtomic(filename string, data []byte, perm os.FileMode) error
+	// Lock acquires an exclusive advisory lock on the file, creating it if
+	// needed. The lock is released by calling the returned function
+	Lock(filename string) (func() error, error)
 	Exists(path string) (bool, error)
+	// IsRegularFile reports whether the path exists and is a regular file.
+	// The symbolic links are not followed, so they are never regular files
+	IsRegularFile(path string) (bool, error)
 	TempDir(dir string, prefix string) (string, error)
 	Remove(path string) error
+	RemoveAll(path string) error
+	MkdirAll(path string, perm os.FileMode) error
 }
 
 type fs struct {
@@ -26,14 +41,112 @@ func (f *fs) ReadFile(filename string) ([]byte, error) {
 	return afero.ReadFile(f.afs, filename)
 }
 
+func (f *fs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return afero.ReadDir(f.afs, dirname)
+}
+
 func (f *fs) WriteFile(filename string, data []byte, perm os.FileMode) error {
 	return afero.WriteFile(f.afs, filename, data, perm)
 }
 
+func (f *fs) WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := afero.TempFile(f.afs, dir, fmt.Sprintf(".%s.tmp-", name))
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+
+	err = writeAndSync(tmp, data)
+	if err == nil {
+		err = f.afs.Chmod(tmp.Name(), perm)
+	}
+
+	if err == nil {
+		err = f.afs.Rename(tmp.Name(), filename)
+	}
+
+	if err != nil {
+		_ = f.afs.Remove(tmp.Name())
+		return err
+	}
+
+	return f.syncDir(dir)
+}
+
+func writeAndSync(file afero.File, data []byte) error {
+	_, err := file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// syncDir persists the rename of the file in the directory
+func (f *fs) syncDir(dir string) error {
+	d, err := f.afs.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (f *fs) Lock(filename string) (func() error, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file %q: %w", filename, err)
+	}
+
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("locking file %q: %w", filename, err)
+	}
+
+	unlock := func() error {
+		// Closing the file releases the lock
+		return file.Close()
+	}
+
+	return unlock, nil
+}
+
 func (f *fs) Exists(path string) (bool, error) {
 	return afero.Exists(f.afs, path)
 }
 
+func (f *fs) IsRegularFile(path string) (bool, error) {
+	var info os.FileInfo
+	var err error
+
+	if lstater, ok := f.afs.(afero.Lstater); ok {
+		info, _, err = lstater.LstatIfPossible(path)
+	} else {
+		info, err = f.afs.Stat(path)
+	}
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return info.Mode().IsRegular(), nil
+}
+
 func (f *fs) TempDir(dir string, prefix string) (string, error) {
 	return afero.TempDir(f.afs, dir, prefix)
 }
@@ -41,3 +154,11 @@ func (f *fs) TempDir(dir string, prefix string) (string, error) {
 func (f *fs) Remove(path string) error {
 	return f.afs.Remove(path)
 }
+
+func (f *fs) RemoveAll(path string) error {
+	return f.afs.RemoveAll(path)
+}
+
+func (f *fs) MkdirAll(path string, perm os.FileMode) error {
+	return f.afs.MkdirAll(path, perm)
+}
@@ -1,9 +1,11 @@
 package fs
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -58,6 +60,51 @@ func TestFs_Exists(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestFs_IsRegularFile(t *testing.T) {
+	fs := newMem()
+
+	file := "test-file"
+	regular, err := fs.IsRegularFile(file)
+	assert.False(t, regular)
+	assert.NoError(t, err)
+
+	err = fs.WriteFile(file, nil, 0600)
+	require.NoError(t, err)
+
+	regular, err = fs.IsRegularFile(file)
+	assert.True(t, regular)
+	assert.NoError(t, err)
+
+	dir, err := fs.TempDir("", "dir")
+	require.NoError(t, err)
+
+	regular, err = fs.IsRegularFile(dir)
+	assert.False(t, regular)
+	assert.NoError(t, err)
+}
+
+func TestFs_IsRegularFile_Symlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0600))
+
+	link := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink(file, link))
+
+	fs := NewOS()
+
+	regular, err := fs.IsRegularFile(file)
+	assert.True(t, regular)
+	assert.NoError(t, err)
+
+	regular, err = fs.IsRegularFile(link)
+	assert.False(t, regular, "the symbolic links must not be followed")
+	assert.NoError(t, err)
+}
+
 func TestFs_TempDir(t *testing.T) {
 	fs := newMem()
 
@@ -87,3 +134,87 @@ func TestFs_Remove(t *testing.T) {
 	assert.False(t, e)
 	assert.NoError(t, err)
 }
+
+func TestFs_MkdirAllAndRemoveAll(t *testing.T) {
+	fs := newMem()
+
+	dir := filepath.Join("parent", "dir")
+	err := fs.MkdirAll(dir, 0700)
+	require.NoError(t, err)
+
+	err = fs.WriteFile(filepath.Join(dir, "test-file"), nil, 0600)
+	require.NoError(t, err)
+
+	err = fs.RemoveAll("parent")
+	require.NoError(t, err)
+
+	e, err := fs.Exists(dir)
+	assert.False(t, e)
+	assert.NoError(t, err)
+}
+
+func TestFs_ReadDir(t *testing.T) {
+	fs := newMem()
+
+	err := fs.WriteFile(filepath.Join("dir", "file"), nil, 0600)
+	require.NoError(t, err)
+
+	files, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "file", files[0].Name())
+}
+
+func TestFs_WriteFileAtomic(t *testing.T) {
+	fs := newMem()
+
+	file := filepath.Join("dir", "test-file")
+	err := fs.WriteFile(file, []byte("old content"), 0600)
+	require.NoError(t, err)
+
+	err = fs.WriteFileAtomic(file, []byte("content"), 0640)
+	require.NoError(t, err)
+
+	data, err := fs.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+
+	files, err := fs.ReadDir("dir")
+	require.NoError(t, err)
+	require.Len(t, files, 1, "temporary file should be renamed")
+	assert.Equal(t, os.FileMode(0640), files[0].Mode().Perm())
+}
+
+func TestFs_Lock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := NewOS()
+	lockFile := filepath.Join(dir, ".lock")
+
+	unlock, err := fs.Lock(lockFile)
+	require.NoError(t, err)
+
+	locked := make(chan struct{})
+	go func() {
+		unlockSecond, err := fs.Lock(lockFile)
+		assert.NoError(t, err)
+		close(locked)
+		_ = unlockSecond()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("second lock should wait for the first one to be released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, unlock())
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("second lock should be acquired after the first one was released")
+	}
+}
@@ -34,6 +34,87 @@ func (_m *MockFS) Exists(path string) (bool, error) {
 	return r0, r1
 }
 
+// IsRegularFile provides a mock function with given fields: path
+func (_m *MockFS) IsRegularFile(path string) (bool, error) {
+	ret := _m.Called(path)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(path)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Lock provides a mock function with given fields: filename
+func (_m *MockFS) Lock(filename string) (func() error, error) {
+	ret := _m.Called(filename)
+
+	var r0 func() error
+	if rf, ok := ret.Get(0).(func(string) func() error); ok {
+		r0 = rf(filename)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(func() error)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(filename)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MkdirAll provides a mock function with given fields: path, perm
+func (_m *MockFS) MkdirAll(path string, perm os.FileMode) error {
+	ret := _m.Called(path, perm)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, os.FileMode) error); ok {
+		r0 = rf(path, perm)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReadDir provides a mock function with given fields: dirname
+func (_m *MockFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ret := _m.Called(dirname)
+
+	var r0 []os.FileInfo
+	if rf, ok := ret.Get(0).(func(string) []os.FileInfo); ok {
+		r0 = rf(dirname)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]os.FileInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(dirname)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadFile provides a mock function with given fields: filename
 func (_m *MockFS) ReadFile(filename string) ([]byte, error) {
 	ret := _m.Called(filename)
@@ -71,6 +152,20 @@ func (_m *MockFS) Remove(path string) error {
 	return r0
 }
 
+// RemoveAll provides a mock function with given fields: path
+func (_m *MockFS) RemoveAll(path string) error {
+	ret := _m.Called(path)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(path)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // TempDir provides a mock function with given fields: dir, prefix
 func (_m *MockFS) TempDir(dir string, prefix string) (string, error) {
 	ret := _m.Called(dir, prefix)
@@ -105,3 +200,17 @@ func (_m *MockFS) WriteFile(filename string, data []byte, perm os.FileMode) erro
 
 	return r0
 }
+
+// WriteFileAtomic provides a mock function with given fields: filename, data, perm
+func (_m *MockFS) WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	ret := _m.Called(filename, data, perm)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, []byte, os.FileMode) error); ok {
+		r0 = rf(filename, data, perm)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
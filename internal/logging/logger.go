@@ -36,6 +36,10 @@ type Logger interface {
 	SetLevel(level string) error
 	SetFormat(logFormat string) error
 	SetOutput(w io.Writer)
+
+	// Mask replaces the values in the messages and the fields logged by
+	// the logger and by all the loggers derived from the same root
+	Mask(values ...string)
 }
 
 func New() Logger {
@@ -23,33 +23,42 @@ func newLogrus() Logger {
 	logger.SetFormatter(newTextFormatter())
 	logger.SetOutput(os.Stderr)
 
-	return logrusWithLoggerAndEntry(logger, logger.WithField("PID", os.Getpid()))
+	masking := newMaskingHook()
+	logger.AddHook(masking)
+
+	return logrusWithLoggerAndEntry(logger, masking, logger.WithField("PID", os.Getpid()))
 }
 
 type logrusLogger struct {
 	*logrus.Entry
 
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	masking *maskingHook
 }
 
-func logrusWithLoggerAndEntry(logger *logrus.Logger, entry *logrus.Entry) Logger {
+func logrusWithLoggerAndEntry(logger *logrus.Logger, masking *maskingHook, entry *logrus.Entry) Logger {
 	log := new(logrusLogger)
 	log.logger = logger
+	log.masking = masking
 	log.Entry = entry
 
 	return log
 }
 
 func (l *logrusLogger) WithField(key string, value interface{}) Logger {
-	return logrusWithLoggerAndEntry(l.logger, l.Entry.WithField(key, value))
+	return logrusWithLoggerAndEntry(l.logger, l.masking, l.Entry.WithField(key, value))
 }
 
 func (l *logrusLogger) WithFields(fields Fields) Logger {
-	return logrusWithLoggerAndEntry(l.logger, l.Entry.WithFields(fields))
+	return logrusWithLoggerAndEntry(l.logger, l.masking, l.Entry.WithFields(fields))
 }
 
 func (l *logrusLogger) WithError(err error) Logger {
-	return logrusWithLoggerAndEntry(l.logger, l.Entry.WithError(err))
+	return logrusWithLoggerAndEntry(l.logger, l.masking, l.Entry.WithError(err))
+}
+
+func (l *logrusLogger) Mask(values ...string) {
+	l.masking.add(values...)
 }
 
 func (l *logrusLogger) SetLevel(level string) error {
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const maskedValue = "[MASKED]"
+
+// maskingHook replaces the masked values in the entries before they are
+// formatted, so they are masked whatever the format of the logs
+type maskingHook struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+func newMaskingHook() *maskingHook {
+	return new(maskingHook)
+}
+
+func (h *maskingHook) add(values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			h.values = append(h.values, value)
+		}
+	}
+}
+
+func (h *maskingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire masks a copy of the fields, as they are shared with the entries
+// derived from the same logger
+func (h *maskingHook) Fire(entry *logrus.Entry) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.values) == 0 {
+		return nil
+	}
+
+	entry.Message = h.mask(entry.Message)
+
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = h.maskField(value)
+	}
+	entry.Data = data
+
+	return nil
+}
+
+// maskField keeps the type of the values that don't contain any masked
+// value, so they are formatted as usual
+func (h *maskingHook) maskField(value interface{}) interface{} {
+	var text string
+
+	switch v := value.(type) {
+	case string:
+		text = v
+	case error:
+		text = v.Error()
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	masked := h.mask(text)
+	if masked == text {
+		return value
+	}
+
+	return masked
+}
+
+func (h *maskingHook) mask(text string) string {
+	for _, value := range h.values {
+		text = strings.ReplaceAll(text, value, maskedValue)
+	}
+
+	return text
+}
@@ -0,0 +1,30 @@
+package logging_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+)
+
+func TestLogger_Mask(t *testing.T) {
+	logger, output := test.NewBufferedLogger()
+
+	derived := logger.WithField("command", "prepare")
+	logger.Mask("s3cr3t", "")
+
+	derived.
+		WithField("value", "token=s3cr3t").
+		WithField("count", 2).
+		WithError(errors.New("invalid s3cr3t")).
+		Info("Resolved s3cr3t")
+
+	assert.NotContains(t, output.String(), "s3cr3t")
+	assert.Contains(t, output.String(), "Resolved [MASKED]")
+	assert.Contains(t, output.String(), "token=[MASKED]")
+	assert.Contains(t, output.String(), "invalid [MASKED]")
+	assert.Contains(t, output.String(), "count=2")
+	assert.Contains(t, output.String(), "command=prepare")
+}
@@ -74,6 +74,17 @@ func (_m *MockLogger) Infof(format string, args ...interface{}) {
 	_m.Called(_ca...)
 }
 
+// Mask provides a mock function with given fields: values
+func (_m *MockLogger) Mask(values ...string) {
+	_va := make([]interface{}, len(values))
+	for _i := range values {
+		_va[_i] = values[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _va...)
+	_m.Called(_ca...)
+}
+
 // Panic provides a mock function with given fields: args
 func (_m *MockLogger) Panic(args ...interface{}) {
 	var _ca []interface{}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
 
@@ -14,15 +15,6 @@ import (
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/env"
 )
 
-const (
-	runnerShortTokenVariable = "CUSTOM_ENV_CI_RUNNER_SHORT_TOKEN"
-	runnerProjectURLVariable = "CUSTOM_ENV_CI_PROJECT_URL"
-	runnerPipelineIDVariable = "CUSTOM_ENV_CI_PIPELINE_ID"
-	runnerJobIDVariable      = "CUSTOM_ENV_CI_JOB_ID"
-
-	unknownValue = "unknown"
-)
-
 var (
 	osExiter    = os.Exit
 	envResolver = env.New()
@@ -34,11 +26,7 @@ type Adapter struct {
 	buildFailureExitCode  int
 	systemFailureExitCode int
 
-	shortToken string
-	projectURL string
-
-	pipelineID int64
-	jobID      int64
+	jobContext JobContext
 }
 
 func (a *Adapter) GenerateExitFromError(err error) {
@@ -51,29 +39,54 @@ func (a *Adapter) GenerateExitFromError(err error) {
 }
 
 func (a *Adapter) ShortToken() string {
-	return a.shortToken
+	return a.jobContext.RunnerShortToken
 }
 
 func (a *Adapter) ProjectURL() string {
-	return a.projectURL
+	return a.jobContext.ProjectURL
+}
+
+func (a *Adapter) JobURL() string {
+	return a.jobContext.JobURL
 }
 
 func (a *Adapter) PipelineID() int64 {
-	return a.pipelineID
+	return a.jobContext.PipelineID
 }
 
 func (a *Adapter) JobID() int64 {
-	return a.jobID
+	return a.jobContext.JobID
+}
+
+// JobTimeout returns zero when the timeout is not provided by the Runner
+func (a *Adapter) JobTimeout() time.Duration {
+	return a.jobContext.JobTimeout
+}
+
+// JobContext returns all the predefined CI variables of the job
+func (a *Adapter) JobContext() JobContext {
+	return a.jobContext
 }
 
-func (a *Adapter) WriteCustomExecutorConfig(out io.Writer, hostname string) error {
+// WriteCustomExecutorConfig writes the output of the "config" stage. The
+// fields unknown to the version of the Runner are left out
+func (a *Adapter) WriteCustomExecutorConfig(out io.Writer, output ConfigOutput) error {
 	version := fargate.Version().ShortLine()
-	cOut := api.ConfigExecOutput{
-		Driver: &api.DriverInfo{
-			Name:    &fargate.Version().Name,
-			Version: &version,
+	cOut := configExecOutput{
+		ConfigExecOutput: api.ConfigExecOutput{
+			Driver: &api.DriverInfo{
+				Name:    &fargate.Version().Name,
+				Version: &version,
+			},
+			Hostname:          &output.Hostname,
+			BuildsDir:         optionalString(output.BuildsDir),
+			CacheDir:          optionalString(output.CacheDir),
+			BuildsDirIsShared: output.BuildsDirIsShared,
 		},
-		Hostname: &hostname,
+	}
+
+	if a.supports(jobEnvVersion) && len(output.JobEnv) > 0 {
+		cOut.JobEnv = output.JobEnv
 	}
 
 	return encoding.NewJSON().Encode(cOut, out)
@@ -94,15 +107,7 @@ func InitAdapter() error {
 		return err
 	}
 
-	adapter.shortToken = getVariableValueOrUnknown(runnerShortTokenVariable)
-	adapter.projectURL = getVariableValueOrUnknown(runnerProjectURLVariable)
-
-	adapter.pipelineID, err = getVariableInt64Value(runnerPipelineIDVariable)
-	if err != nil {
-		return err
-	}
-
-	adapter.jobID, err = getVariableInt64Value(runnerJobIDVariable)
+	adapter.jobContext, err = NewJobContext()
 	if err != nil {
 		return err
 	}
@@ -120,27 +125,10 @@ func getExitCodeFromVariable(variable string) (int, error) {
 	return exitCode, nil
 }
 
-func getVariableValueOrUnknown(variable string) string {
-	value := envResolver.Get(variable)
-	if value != "" {
-		return value
-	}
-
-	return unknownValue
-}
-
-func getVariableInt64Value(variable string) (int64, error) {
-	value := envResolver.Get(variable)
-	if value == "" {
-		return 0, nil
-	}
-
-	intValue, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
-		return -1, fmt.Errorf("couldn't parse int64 value %q from variable %q: %w", value, variable, err)
-	}
-
-	return intValue, nil
+// IsAdapterInitialized reports whether InitAdapter was called. Only the
+// commands executed by the Custom Executor initialize the adapter
+func IsAdapterInitialized() bool {
+	return adapter != nil
 }
 
 func GetAdapter() *Adapter {
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -129,12 +130,12 @@ func TestAdapter_ShortToken(t *testing.T) {
 		expectedValue string
 	}{
 		"variable is defined": {
-			stubs:         env.Stubs{runnerShortTokenVariable: testToken},
+			stubs:         env.Stubs{"CUSTOM_ENV_CI_RUNNER_SHORT_TOKEN": testToken},
 			expectedValue: testToken,
 		},
 		"variable is not defined": {
 			stubs:         env.Stubs{},
-			expectedValue: unknownValue,
+			expectedValue: "unknown",
 		},
 	}
 
@@ -156,12 +157,12 @@ func TestAdapter_ProjectURL(t *testing.T) {
 		expectedValue string
 	}{
 		"variable is defined": {
-			stubs:         env.Stubs{runnerProjectURLVariable: testURL},
+			stubs:         env.Stubs{"CUSTOM_ENV_CI_PROJECT_URL": testURL},
 			expectedValue: testURL,
 		},
 		"variable is not defined": {
 			stubs:         env.Stubs{},
-			expectedValue: unknownValue,
+			expectedValue: "unknown",
 		},
 	}
 
@@ -175,6 +176,33 @@ func TestAdapter_ProjectURL(t *testing.T) {
 	}
 }
 
+func TestAdapter_JobURL(t *testing.T) {
+	testURL := "https://gitlab.example.com/namespace/project/-/jobs/1"
+
+	tests := map[string]struct {
+		stubs         env.Stubs
+		expectedValue string
+	}{
+		"variable is defined": {
+			stubs:         env.Stubs{"CUSTOM_ENV_CI_JOB_URL": testURL},
+			expectedValue: testURL,
+		},
+		"variable is not defined": {
+			stubs:         env.Stubs{},
+			expectedValue: "unknown",
+		},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			defer mockEnvResolver(testCase.stubs)()
+
+			require.NoError(t, InitAdapter())
+			assert.Equal(t, testCase.expectedValue, GetAdapter().JobURL())
+		})
+	}
+}
+
 func TestAdapter_PipelineID(t *testing.T) {
 	tests := map[string]struct {
 		stubs              env.Stubs
@@ -182,7 +210,7 @@ func TestAdapter_PipelineID(t *testing.T) {
 		expectsErrorOnLoad bool
 	}{
 		"variable is defined": {
-			stubs:              env.Stubs{runnerPipelineIDVariable: "1234"},
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_PIPELINE_ID": "1234"},
 			expectedValue:      1234,
 			expectsErrorOnLoad: false,
 		},
@@ -192,7 +220,7 @@ func TestAdapter_PipelineID(t *testing.T) {
 			expectsErrorOnLoad: false,
 		},
 		"variable is not an integer": {
-			stubs:              env.Stubs{runnerPipelineIDVariable: "abcd"},
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_PIPELINE_ID": "abcd"},
 			expectedValue:      -1,
 			expectsErrorOnLoad: true,
 		},
@@ -220,7 +248,7 @@ func TestAdapter_JobID(t *testing.T) {
 		expectsErrorOnLoad bool
 	}{
 		"variable is defined": {
-			stubs:              env.Stubs{runnerJobIDVariable: "1234"},
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_JOB_ID": "1234"},
 			expectedValue:      1234,
 			expectsErrorOnLoad: false,
 		},
@@ -230,7 +258,7 @@ func TestAdapter_JobID(t *testing.T) {
 			expectsErrorOnLoad: false,
 		},
 		"variable is not an integer": {
-			stubs:              env.Stubs{runnerJobIDVariable: "abcd"},
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_JOB_ID": "abcd"},
 			expectedValue:      -1,
 			expectsErrorOnLoad: true,
 		},
@@ -251,17 +279,132 @@ func TestAdapter_JobID(t *testing.T) {
 	}
 }
 
+func TestAdapter_JobTimeout(t *testing.T) {
+	tests := map[string]struct {
+		stubs              env.Stubs
+		expectedValue      time.Duration
+		expectsErrorOnLoad bool
+	}{
+		"variable is defined": {
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_JOB_TIMEOUT": "3600"},
+			expectedValue:      time.Hour,
+			expectsErrorOnLoad: false,
+		},
+		"variable is not defined": {
+			stubs:              env.Stubs{},
+			expectedValue:      0,
+			expectsErrorOnLoad: false,
+		},
+		"variable is not an integer": {
+			stubs:              env.Stubs{"CUSTOM_ENV_CI_JOB_TIMEOUT": "1h"},
+			expectsErrorOnLoad: true,
+		},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			defer mockEnvResolver(testCase.stubs)()
+
+			if testCase.expectsErrorOnLoad {
+				require.Error(t, InitAdapter())
+				return
+			}
+
+			require.NoError(t, InitAdapter())
+			assert.Equal(t, testCase.expectedValue, GetAdapter().JobTimeout())
+		})
+	}
+}
+
 func TestAdapter_WriteCustomExecutorConfig(t *testing.T) {
-	defer mockEnvResolver(env.Stubs{})()
+	shared := false
 
-	require.NoError(t, InitAdapter())
+	tests := map[string]struct {
+		stubs        env.Stubs
+		output       ConfigOutput
+		expectedJSON string
+	}{
+		"hostname only": {
+			stubs:        env.Stubs{},
+			output:       ConfigOutput{Hostname: "some-vm-name"},
+			expectedJSON: `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name"}`,
+		},
+		"directories": {
+			stubs: env.Stubs{},
+			output: ConfigOutput{
+				Hostname:          "some-vm-name",
+				BuildsDir:         "/builds",
+				CacheDir:          "/cache",
+				BuildsDirIsShared: &shared,
+			},
+			expectedJSON: `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name","builds_dir":"/builds","cache_dir":"/cache","builds_dir_is_shared":false}`,
+		},
+		"job env with a supporting Runner": {
+			stubs: env.Stubs{"CUSTOM_ENV_CI_RUNNER_VERSION": "15.0.0~beta.1.g1234abcd"},
+			output: ConfigOutput{
+				Hostname: "some-vm-name",
+				JobEnv:   map[string]string{"FARGATE_CLUSTER": "cluster"},
+			},
+			expectedJSON: `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name","job_env":{"FARGATE_CLUSTER":"cluster"}}`,
+		},
+		"job env with an older Runner": {
+			stubs: env.Stubs{"CUSTOM_ENV_CI_RUNNER_VERSION": "14.4.2"},
+			output: ConfigOutput{
+				Hostname: "some-vm-name",
+				JobEnv:   map[string]string{"FARGATE_CLUSTER": "cluster"},
+			},
+			expectedJSON: `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name"}`,
+		},
+		"job env with an unknown Runner version": {
+			stubs: env.Stubs{},
+			output: ConfigOutput{
+				Hostname: "some-vm-name",
+				JobEnv:   map[string]string{"FARGATE_CLUSTER": "cluster"},
+			},
+			expectedJSON: `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name"}`,
+		},
+	}
 
-	out := new(bytes.Buffer)
-	err := GetAdapter().WriteCustomExecutorConfig(out, "some-vm-name")
-	assert.NoError(t, err)
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			defer mockEnvResolver(testCase.stubs)()
+
+			require.NoError(t, InitAdapter())
+
+			out := new(bytes.Buffer)
+			err := GetAdapter().WriteCustomExecutorConfig(out, testCase.output)
+			assert.NoError(t, err)
+
+			json := strings.Trim(out.String(), "\n")
+			assert.Equal(t, testCase.expectedJSON, json)
+		})
+	}
+}
+
+func TestParseRunnerVersion(t *testing.T) {
+	tests := map[string]struct {
+		version         string
+		expectedVersion runnerVersion
+		expectedOK      bool
+	}{
+		"release":             {version: "14.5.0", expectedVersion: runnerVersion{major: 14, minor: 5}, expectedOK: true},
+		"development version": {version: "15.0.0~beta.1.g1234abcd", expectedVersion: runnerVersion{major: 15}, expectedOK: true},
+		"prefixed":            {version: "v13.12.1", expectedVersion: runnerVersion{major: 13, minor: 12}, expectedOK: true},
+		"unknown":             {version: "unknown"},
+		"empty":               {version: ""},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			version, ok := parseRunnerVersion(testCase.version)
+			assert.Equal(t, testCase.expectedOK, ok)
+			assert.Equal(t, testCase.expectedVersion, version)
+		})
+	}
 
-	json := strings.Trim(out.String(), "\n")
-	assert.Equal(t, `{"driver":{"name":"fargate","version":"dev (HEAD)"},"hostname":"some-vm-name"}`, json)
+	assert.True(t, runnerVersion{major: 14, minor: 10}.atLeast(jobEnvVersion))
+	assert.True(t, runnerVersion{major: 15}.atLeast(jobEnvVersion))
+	assert.False(t, runnerVersion{major: 13, minor: 12}.atLeast(jobEnvVersion))
 }
 
 func TestGetAdapter_NotLoaded(t *testing.T) {
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"regexp"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+)
+
+// ConfigOutput is the output of the "config" stage, read by the Runner. The
+// empty fields are not written
+type ConfigOutput struct {
+	Hostname          string
+	BuildsDir         string
+	CacheDir          string
+	BuildsDirIsShared *bool
+	// JobEnv is only written for the Runners reading it
+	JobEnv map[string]string
+}
+
+// configExecOutput extends the output known by the vendored API of the
+// Runner with the fields of the newer versions
+type configExecOutput struct {
+	api.ConfigExecOutput
+
+	JobEnv map[string]string `json:"job_env,omitempty"`
+}
+
+// runnerVersion is the major and minor version of the Runner
+type runnerVersion struct {
+	major int
+	minor int
+}
+
+// jobEnvVersion is the first version of the Runner reading the job_env field
+var jobEnvVersion = runnerVersion{major: 14, minor: 5}
+
+var runnerVersionRx = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// parseRunnerVersion parses versions like "14.5.0" or "15.0.0~beta.1.g1234"
+func parseRunnerVersion(version string) (runnerVersion, bool) {
+	match := runnerVersionRx.FindStringSubmatch(version)
+	if match == nil {
+		return runnerVersion{}, false
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+
+	return runnerVersion{major: major, minor: minor}, true
+}
+
+func (v runnerVersion) atLeast(other runnerVersion) bool {
+	if v.major != other.major {
+		return v.major > other.major
+	}
+
+	return v.minor >= other.minor
+}
+
+// supports reports whether the Runner executing the job is at least of the
+// given version. The unknown versions support only the vendored API
+func (a *Adapter) supports(version runnerVersion) bool {
+	current, ok := parseRunnerVersion(a.jobContext.RunnerVersion)
+
+	return ok && current.atLeast(version)
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+const (
+	// customEnvPrefix starts the names of the CI variables of the job,
+	// passed by the Custom Executor as environment variables
+	customEnvPrefix = "CUSTOM_ENV_"
+
+	maskedValue = "[MASKED]"
+)
+
+// JobContext holds the predefined CI variables of the job. The fields are
+// parsed from the variables named in their tags:
+//
+//	variable  the CI variable, without the CUSTOM_ENV_ prefix
+//	default   value used when the variable is not defined
+//	masked    the value is not logged
+//	lenient   the variable is commonly set by the jobs, so an invalid value
+//	          is ignored instead of failing every stage of the job
+//
+// Strings, booleans, int64, durations in seconds and lists are supported.
+// The lists are JSON arrays of strings, or comma-separated values
+type JobContext struct {
+	RunnerShortToken     string   `variable:"CI_RUNNER_SHORT_TOKEN" default:"unknown"`
+	RunnerTags           []string `variable:"CI_RUNNER_TAGS"`
+	RunnerExecutableArch string   `variable:"CI_RUNNER_EXECUTABLE_ARCH"`
+	RunnerVersion        string   `variable:"CI_RUNNER_VERSION"`
+
+	ProjectURL       string `variable:"CI_PROJECT_URL" default:"unknown"`
+	ProjectPath      string `variable:"CI_PROJECT_PATH"`
+	ProjectNamespace string `variable:"CI_PROJECT_NAMESPACE"`
+
+	PipelineID     int64  `variable:"CI_PIPELINE_ID"`
+	PipelineSource string `variable:"CI_PIPELINE_SOURCE"`
+
+	JobID      int64         `variable:"CI_JOB_ID"`
+	JobURL     string        `variable:"CI_JOB_URL" default:"unknown"`
+	JobImage   string        `variable:"CI_JOB_IMAGE"`
+	JobTimeout time.Duration `variable:"CI_JOB_TIMEOUT"`
+	// JobServices is listed by the job, as the Runner doesn't expose the
+	// services of the job to the Custom Executor
+	JobServices []string `variable:"CI_JOB_SERVICES"`
+
+	RefName      string `variable:"CI_COMMIT_REF_NAME"`
+	RefProtected bool   `variable:"CI_COMMIT_REF_PROTECTED"`
+
+	UserID    int64  `variable:"GITLAB_USER_ID"`
+	UserLogin string `variable:"GITLAB_USER_LOGIN"`
+	UserEmail string `variable:"GITLAB_USER_EMAIL" masked:"true"`
+
+	DebugTrace bool `variable:"CI_DEBUG_TRACE" lenient:"true"`
+
+	// TLSCAFile is the file of the CA certificates of the GitLab instance,
+	// written by the Runner on its host
+	TLSCAFile string `variable:"CI_SERVER_TLS_CA_FILE"`
+
+	// RequestedSecrets are the secrets requested by the job, like
+	// "DB_PASS=ssm:/ci/db". Only their references are known here
+	RequestedSecrets []string `variable:"FARGATE_SECRETS"`
+
+	// ignored are the lenient variables whose invalid values were ignored
+	ignored []string
+}
+
+// NewJobContext parses the CI variables passed by the Custom Executor. All
+// the fields are empty, or set to their default, outside of a job. On error,
+// the fields parsed so far are returned, and the invalid integer is -1. The
+// lenient fields with invalid values are left empty, or set to their default
+func NewJobContext() (JobContext, error) {
+	var job JobContext
+
+	t := reflect.TypeOf(job)
+	v := reflect.ValueOf(&job).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		variable := customEnvPrefix + field.Tag.Get("variable")
+
+		value := envResolver.Get(variable)
+		if value == "" {
+			value = field.Tag.Get("default")
+		}
+
+		if value == "" {
+			continue
+		}
+
+		err := parseVariable(v.Field(i), value)
+		if err != nil && field.Tag.Get("lenient") == "true" {
+			v.Field(i).Set(reflect.Zero(field.Type))
+			if def := field.Tag.Get("default"); def != "" {
+				_ = parseVariable(v.Field(i), def)
+			}
+
+			job.ignored = append(job.ignored, variable)
+
+			continue
+		}
+
+		if err != nil {
+			return job, fmt.Errorf("couldn't parse %s value %q from variable %q: %w", field.Type, value, variable, err)
+		}
+	}
+
+	return job, nil
+}
+
+func parseVariable(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			field.SetInt(-1)
+			return err
+		}
+
+		field.SetInt(i)
+	case time.Duration:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		field.Set(reflect.ValueOf(time.Duration(seconds) * time.Second))
+	case []string:
+		field.Set(reflect.ValueOf(parseList(value)))
+	default:
+		panic(fmt.Sprintf("unsupported type %s of JobContext field", field.Type()))
+	}
+
+	return nil
+}
+
+// IgnoredVariables lists the variables whose invalid values were ignored, as
+// they are commonly set by the jobs
+func (j JobContext) IgnoredVariables() []string {
+	return j.ignored
+}
+
+// parseList accepts JSON arrays of strings, like the runner tags of the
+// recent Runner versions, and the comma-separated lists of the older ones
+func parseList(value string) []string {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"`)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Architecture returns the architecture of the Runner, named like Go does as
+// "amd64" or "arm64"
+func (j JobContext) Architecture() string {
+	return j.RunnerExecutableArch[strings.LastIndex(j.RunnerExecutableArch, "/")+1:]
+}
+
+// TemplateData returns the context the templated settings are rendered with
+func (j JobContext) TemplateData() config.TemplateData {
+	return config.TemplateData{
+		ProjectPath:      j.ProjectPath,
+		ProjectNamespace: j.ProjectNamespace,
+		JobID:            j.JobID,
+		PipelineID:       j.PipelineID,
+		Ref:              j.RefName,
+		RunnerToken:      j.RunnerShortToken,
+		Architecture:     j.Architecture(),
+	}
+}
+
+// AuthorizationJob returns the attributes of the job matched by the rules
+func (j JobContext) AuthorizationJob() config.Job {
+	return config.Job{
+		ProjectPath:    j.ProjectPath,
+		Ref:            j.RefName,
+		RefProtected:   j.RefProtected,
+		PipelineSource: j.PipelineSource,
+	}
+}
+
+// LogFields returns the fields that are set, named after their variables.
+// The values of the masked fields are replaced
+func (j JobContext) LogFields() logging.Fields {
+	fields := make(logging.Fields)
+
+	t := reflect.TypeOf(j)
+	v := reflect.ValueOf(j)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if v.Field(i).IsZero() {
+			continue
+		}
+
+		name := strings.ToLower(field.Tag.Get("variable"))
+		if field.Tag.Get("masked") == "true" {
+			fields[name] = maskedValue
+			continue
+		}
+
+		fields[name] = v.Field(i).Interface()
+	}
+
+	return fields
+}
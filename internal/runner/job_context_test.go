@@ -0,0 +1,150 @@
+package runner
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/env"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+func TestNewJobContext(t *testing.T) {
+	tests := map[string]struct {
+		stubs         env.Stubs
+		expectedJob   JobContext
+		expectedError error
+	}{
+		"variables are defined": {
+			stubs: env.Stubs{
+				"CUSTOM_ENV_CI_RUNNER_SHORT_TOKEN":     "token",
+				"CUSTOM_ENV_CI_RUNNER_TAGS":            `["docker", "linux"]`,
+				"CUSTOM_ENV_CI_RUNNER_EXECUTABLE_ARCH": "linux/arm64",
+				"CUSTOM_ENV_CI_PROJECT_PATH":           "group/project",
+				"CUSTOM_ENV_CI_PIPELINE_ID":            "12",
+				"CUSTOM_ENV_CI_PIPELINE_SOURCE":        "push",
+				"CUSTOM_ENV_CI_JOB_ID":                 "34",
+				"CUSTOM_ENV_CI_JOB_IMAGE":              "alpine:3.12",
+				"CUSTOM_ENV_CI_JOB_TIMEOUT":            "3600",
+				"CUSTOM_ENV_CI_JOB_SERVICES":           "postgres:12, redis",
+				"CUSTOM_ENV_CI_COMMIT_REF_NAME":        "main",
+				"CUSTOM_ENV_CI_COMMIT_REF_PROTECTED":   "true",
+				"CUSTOM_ENV_GITLAB_USER_ID":            "56",
+				"CUSTOM_ENV_CI_DEBUG_TRACE":            "false",
+			},
+			expectedJob: JobContext{
+				RunnerShortToken:     "token",
+				RunnerTags:           []string{"docker", "linux"},
+				RunnerExecutableArch: "linux/arm64",
+				ProjectURL:           "unknown",
+				ProjectPath:          "group/project",
+				PipelineID:           12,
+				PipelineSource:       "push",
+				JobID:                34,
+				JobURL:               "unknown",
+				JobImage:             "alpine:3.12",
+				JobTimeout:           time.Hour,
+				JobServices:          []string{"postgres:12", "redis"},
+				RefName:              "main",
+				RefProtected:         true,
+				UserID:               56,
+			},
+		},
+		"variables are not defined": {
+			stubs: env.Stubs{},
+			expectedJob: JobContext{
+				RunnerShortToken: "unknown",
+				ProjectURL:       "unknown",
+				JobURL:           "unknown",
+			},
+		},
+		"invalid integer": {
+			stubs:         env.Stubs{"CUSTOM_ENV_CI_JOB_ID": "abcd"},
+			expectedError: strconv.ErrSyntax,
+		},
+		"invalid boolean": {
+			stubs:         env.Stubs{"CUSTOM_ENV_CI_COMMIT_REF_PROTECTED": "yes"},
+			expectedError: strconv.ErrSyntax,
+		},
+		"invalid lenient boolean": {
+			stubs: env.Stubs{
+				"CUSTOM_ENV_CI_DEBUG_TRACE": "yes",
+				"CUSTOM_ENV_CI_JOB_ID":      "34",
+			},
+			expectedJob: JobContext{
+				RunnerShortToken: "unknown",
+				ProjectURL:       "unknown",
+				JobID:            34,
+				JobURL:           "unknown",
+				ignored:          []string{"CUSTOM_ENV_CI_DEBUG_TRACE"},
+			},
+		},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			defer mockEnvResolver(testCase.stubs)()
+
+			job, err := NewJobContext()
+
+			if testCase.expectedError != nil {
+				assertions.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedJob, job)
+		})
+	}
+}
+
+func TestJobContext(t *testing.T) {
+	job := JobContext{
+		RunnerShortToken:     "token",
+		RunnerExecutableArch: "linux/arm64",
+		ProjectPath:          "group/project",
+		ProjectNamespace:     "group",
+		PipelineID:           12,
+		JobID:                34,
+		RefName:              "main",
+		RefProtected:         true,
+		PipelineSource:       "push",
+		UserEmail:            "user@example.com",
+	}
+
+	assert.Equal(t, "arm64", job.Architecture())
+
+	assert.Equal(t, config.TemplateData{
+		ProjectPath:      "group/project",
+		ProjectNamespace: "group",
+		JobID:            34,
+		PipelineID:       12,
+		Ref:              "main",
+		RunnerToken:      "token",
+		Architecture:     "arm64",
+	}, job.TemplateData())
+
+	assert.Equal(t, config.Job{
+		ProjectPath:    "group/project",
+		Ref:            "main",
+		RefProtected:   true,
+		PipelineSource: "push",
+	}, job.AuthorizationJob())
+
+	assert.Equal(t, logging.Fields{
+		"ci_runner_short_token":     "token",
+		"ci_runner_executable_arch": "linux/arm64",
+		"ci_project_path":           "group/project",
+		"ci_project_namespace":      "group",
+		"ci_pipeline_id":            int64(12),
+		"ci_pipeline_source":        "push",
+		"ci_job_id":                 int64(34),
+		"ci_commit_ref_name":        "main",
+		"ci_commit_ref_protected":   true,
+		"gitlab_user_email":         "[MASKED]",
+	}, job.LogFields())
+}
@@ -0,0 +1,16 @@
+package runner
+
+// Stages of the job passed by the Custom Executor to the "run" stage
+const (
+	StagePrepareScript            = "prepare_script"
+	StageGetSources               = "get_sources"
+	StageRestoreCache             = "restore_cache"
+	StageDownloadArtifacts        = "download_artifacts"
+	StageStepScript               = "step_script"
+	StageBuildScript              = "build_script"
+	StageAfterScript              = "after_script"
+	StageArchiveCache             = "archive_cache"
+	StageArchiveCacheOnFailure    = "archive_cache_on_failure"
+	StageUploadArtifactsOnSuccess = "upload_artifacts_on_success"
+	StageUploadArtifactsOnFailure = "upload_artifacts_on_failure"
+)
@@ -0,0 +1,42 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostKeyContext separates the seed of the host key from the other values
+// that could be derived from the private key of the job
+const hostKeyContext = "fargate-driver/ssh-host-key"
+
+// HostKeySeed derives the seed of the Ed25519 host key of the SSH service
+// from the private key of the job. The driver provides the seed to the task,
+// so it knows the host key without trusting the task to announce it, and the
+// seed doesn't need to be recorded
+func HostKeySeed(privateKey []byte) []byte {
+	mac := hmac.New(sha256.New, privateKey)
+	_, _ = mac.Write([]byte(hostKeyContext))
+
+	return mac.Sum(nil)
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of the host key of the
+// seed, in the same format as printed by OpenSSH tools
+func HostKeyFingerprint(seed []byte) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("host key seed must have %d bytes", ed25519.SeedSize)
+	}
+
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	publicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return "", fmt.Errorf("creating host public key: %w", err)
+	}
+
+	return ssh.FingerprintSHA256(publicKey), nil
+}
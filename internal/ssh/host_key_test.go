@@ -0,0 +1,32 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeySeed(t *testing.T) {
+	seed := HostKeySeed([]byte("private key"))
+
+	assert.Len(t, seed, ed25519.SeedSize)
+	assert.Equal(t, seed, HostKeySeed([]byte("private key")))
+	assert.NotEqual(t, seed, HostKeySeed([]byte("other private key")))
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	seed := HostKeySeed([]byte("private key"))
+
+	signer, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+	require.NoError(t, err)
+
+	fingerprint, err := HostKeyFingerprint(seed)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), fingerprint)
+
+	_, err = HostKeyFingerprint([]byte("short"))
+	assert.Error(t, err)
+}
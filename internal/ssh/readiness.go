@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+const (
+	serviceTokenBytes = 32
+
+	defaultPollInterval   = 2 * time.Second
+	defaultRequestTimeout = 5 * time.Second
+)
+
+var (
+	// ErrUnauthorizedReadinessRequest is returned when the SSH service rejects the token
+	ErrUnauthorizedReadinessRequest = errors.New("readiness endpoint rejected the token")
+
+	// ErrFingerprintMismatch is returned when the SSH service announces another
+	// host key than the one provided by the driver
+	ErrFingerprintMismatch = errors.New("host key fingerprint doesn't match the host key provided by the driver")
+)
+
+// ServiceInfo centralizes the public data announced by the SSH service
+// running in the task container
+type ServiceInfo struct {
+	Ready              bool
+	Port               int
+	Username           string
+	HostPublicKey      string
+	HostKeyFingerprint string
+}
+
+// ReadinessChecker queries the readiness endpoint of the SSH service
+type ReadinessChecker interface {
+	// WaitUntilReady polls the endpoint until the SSH server reports that it accepts connections
+	WaitUntilReady(ctx context.Context, address string, token string) (*ServiceInfo, error)
+}
+
+type readinessChecker struct {
+	logger logging.Logger
+	client *http.Client
+
+	pollInterval time.Duration
+}
+
+// NewReadinessChecker instantiates a concrete instance of ReadinessChecker
+func NewReadinessChecker(logger logging.Logger) ReadinessChecker {
+	return &readinessChecker{
+		logger:       logger,
+		client:       &http.Client{Timeout: defaultRequestTimeout},
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// NewServiceToken generates a random token that authenticates the requests
+// sent to the readiness endpoint of the SSH service
+func NewServiceToken() (string, error) {
+	buf := make([]byte, serviceTokenBytes)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("reading random data: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func (r *readinessChecker) WaitUntilReady(ctx context.Context, address string, token string) (*ServiceInfo, error) {
+	logger := r.logger.WithField("address", address)
+	logger.Debug("[WaitUntilReady] Will wait until SSH service is ready")
+
+	for {
+		info, err := r.fetch(ctx, address, token)
+		if errors.Is(err, ErrUnauthorizedReadinessRequest) {
+			return nil, err
+		}
+
+		if err == nil && info.Ready {
+			logger.
+				WithField("fingerprint", info.HostKeyFingerprint).
+				Debug("[WaitUntilReady] SSH service is ready")
+
+			return info, nil
+		}
+
+		if err != nil {
+			logger.WithError(err).Debug("[WaitUntilReady] SSH service is not ready yet")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for SSH service at %q: %w", address, ctx.Err())
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+func (r *readinessChecker) fetch(ctx context.Context, address string, token string) (*ServiceInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/", address), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting readiness endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusServiceUnavailable:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrUnauthorizedReadinessRequest
+	default:
+		return nil, fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+
+	info := new(ServiceInfo)
+	err = json.NewDecoder(resp.Body).Decode(info)
+	if err != nil {
+		return nil, fmt.Errorf("decoding readiness response: %w", err)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+)
+
+func TestNewServiceToken(t *testing.T) {
+	token1, err := NewServiceToken()
+	require.NoError(t, err)
+
+	token2, err := NewServiceToken()
+	require.NoError(t, err)
+
+	assert.Len(t, token1, serviceTokenBytes*2)
+	assert.NotEqual(t, token1, token2)
+}
+
+func TestReadinessChecker_WaitUntilReady(t *testing.T) {
+	testToken := "test-token"
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	validInfo := ServiceInfo{
+		Ready:              true,
+		Port:               2222,
+		Username:           "root",
+		HostPublicKey:      string(ssh.MarshalAuthorizedKey(publicKey)),
+		HostKeyFingerprint: ssh.FingerprintSHA256(publicKey),
+	}
+
+	tests := map[string]struct {
+		token         string
+		responses     []ServiceInfo
+		expectedInfo  *ServiceInfo
+		expectedError error
+	}{
+		"Service ready at first request": {
+			token:        testToken,
+			responses:    []ServiceInfo{validInfo},
+			expectedInfo: &validInfo,
+		},
+		"Service ready after retries": {
+			token:        testToken,
+			responses:    []ServiceInfo{{Ready: false}, {Ready: false}, validInfo},
+			expectedInfo: &validInfo,
+		},
+		"Invalid token": {
+			token:         "invalid",
+			responses:     []ServiceInfo{validInfo},
+			expectedError: ErrUnauthorizedReadinessRequest,
+		},
+		"Service never ready": {
+			token:         testToken,
+			responses:     []ServiceInfo{{Ready: false}},
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer "+testToken {
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				response := tt.responses[len(tt.responses)-1]
+				if requests < len(tt.responses) {
+					response = tt.responses[requests]
+				}
+				requests++
+
+				if !response.Ready {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+
+				_ = json.NewEncoder(rw).Encode(response)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			checker := NewReadinessChecker(test.NewNullLogger()).(*readinessChecker)
+			checker.pollInterval = 10 * time.Millisecond
+
+			info, err := checker.WaitUntilReady(ctx, strings.TrimPrefix(server.URL, "http://"), tt.token)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedInfo, info)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+)
+
+const (
+	dynamoDBKeyAttribute     = "Key"
+	dynamoDBDataAttribute    = "Data"
+	dynamoDBVersionAttribute = "Version"
+)
+
+type dynamoDBClient interface {
+	GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+	DeleteItemWithContext(aws.Context, *dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput, error)
+	ScanPagesWithContext(aws.Context, *dynamodb.ScanInput, func(*dynamodb.ScanOutput, bool) bool, ...request.Option) error
+}
+
+// dynamoDBStore keeps every key as an item of the table. The version is a
+// counter stored with the item and checked by the condition expressions
+type dynamoDBStore struct {
+	client dynamoDBClient
+	table  string
+}
+
+func newDynamoDBStore(cfg config.DynamoDBStore, defaultRegion string) (*dynamoDBStore, error) {
+	if cfg.Table == "" {
+		return nil, errors.New("table is not defined")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create AWS session: %w", err)
+	}
+
+	return &dynamoDBStore{
+		client: dynamodb.New(sess),
+		table:  cfg.Table,
+	}, nil
+}
+
+func (d *dynamoDBStore) itemKey(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		dynamoDBKeyAttribute: {S: aws.String(key)},
+	}
+}
+
+func (d *dynamoDBStore) Get(key string) ([]byte, string, error) {
+	output, err := d.client.GetItemWithContext(aws.BackgroundContext(), &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("requesting DynamoDB: %w", err)
+	}
+
+	if len(output.Item) == 0 {
+		return nil, "", os.ErrNotExist
+	}
+
+	data, ok := output.Item[dynamoDBDataAttribute]
+	if !ok || data.B == nil {
+		return nil, "", fmt.Errorf("item has no %q attribute", dynamoDBDataAttribute)
+	}
+
+	version, ok := output.Item[dynamoDBVersionAttribute]
+	if !ok || version.N == nil {
+		return nil, "", fmt.Errorf("item has no %q attribute", dynamoDBVersionAttribute)
+	}
+
+	return data.B, aws.StringValue(version.N), nil
+}
+
+func (d *dynamoDBStore) Put(key string, content []byte, version string) (string, error) {
+	var currentVersion int64
+	if version != "" {
+		var err error
+		currentVersion, err = strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parsing version %q: %w", version, err)
+		}
+	}
+
+	newVersion := strconv.FormatInt(currentVersion+1, 10)
+
+	item := d.itemKey(key)
+	item[dynamoDBDataAttribute] = &dynamodb.AttributeValue{B: content}
+	item[dynamoDBVersionAttribute] = &dynamodb.AttributeValue{N: aws.String(newVersion)}
+
+	input := &dynamodb.PutItemInput{
+		TableName:                aws.String(d.table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String(dynamoDBKeyAttribute)},
+	}
+
+	if version != "" {
+		input.ConditionExpression = aws.String("#version = :version")
+		input.ExpressionAttributeNames = map[string]*string{"#version": aws.String(dynamoDBVersionAttribute)}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(version)},
+		}
+	}
+
+	_, err := d.client.PutItemWithContext(aws.BackgroundContext(), input)
+	if isConditionalCheckFailed(err) {
+		return "", ErrVersionConflict
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("requesting DynamoDB: %w", err)
+	}
+
+	return newVersion, nil
+}
+
+func (d *dynamoDBStore) Delete(key string, version string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      d.itemKey(key),
+		ConditionExpression:      aws.String("attribute_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String(dynamoDBKeyAttribute)},
+	}
+
+	if version != "" {
+		input.ConditionExpression = aws.String("attribute_exists(#key) AND #version = :version")
+		input.ExpressionAttributeNames["#version"] = aws.String(dynamoDBVersionAttribute)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(version)},
+		}
+	}
+
+	_, err := d.client.DeleteItemWithContext(aws.BackgroundContext(), input)
+	if isConditionalCheckFailed(err) {
+		return d.deleteConditionError(key, version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("requesting DynamoDB: %w", err)
+	}
+
+	return nil
+}
+
+// deleteConditionError tells apart a missing item from an item whose
+// version changed since it was read
+func (d *dynamoDBStore) deleteConditionError(key string, version string) error {
+	if version == "" {
+		return os.ErrNotExist
+	}
+
+	_, _, err := d.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return ErrVersionConflict
+}
+
+func (d *dynamoDBStore) List() ([]string, error) {
+	var keys []string
+
+	err := d.client.ScanPagesWithContext(
+		aws.BackgroundContext(),
+		&dynamodb.ScanInput{
+			TableName:                aws.String(d.table),
+			ProjectionExpression:     aws.String("#key"),
+			ExpressionAttributeNames: map[string]*string{"#key": aws.String(dynamoDBKeyAttribute)},
+		},
+		func(output *dynamodb.ScanOutput, _ bool) bool {
+			for _, item := range output.Items {
+				if key, ok := item[dynamoDBKeyAttribute]; ok && key.S != nil {
+					keys = append(keys, aws.StringValue(key.S))
+				}
+			}
+
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("requesting DynamoDB: %w", err)
+	}
+
+	return keys, nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+
+	return awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,235 @@
+package task
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestDynamoDBStore_Get(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		item            map[string]*dynamodb.AttributeValue
+		awsError        error
+		expectedContent []byte
+		expectedVersion string
+		expectedError   error
+	}{
+		"Item exists": {
+			item: map[string]*dynamodb.AttributeValue{
+				dynamoDBKeyAttribute:     {S: aws.String("key")},
+				dynamoDBDataAttribute:    {B: []byte("content")},
+				dynamoDBVersionAttribute: {N: aws.String("2")},
+			},
+			expectedContent: []byte("content"),
+			expectedVersion: "2",
+		},
+		"Item doesn't exist": {
+			item:          map[string]*dynamodb.AttributeValue{},
+			expectedError: os.ErrNotExist,
+		},
+		"DynamoDB API returning error": {
+			awsError:      testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockDynamoDBClient)
+			defer client.AssertExpectations(t)
+
+			client.
+				On("GetItemWithContext", mock.Anything, mock.MatchedBy(func(input *dynamodb.GetItemInput) bool {
+					return aws.StringValue(input.TableName) == "table" &&
+						aws.StringValue(input.Key[dynamoDBKeyAttribute].S) == "key" &&
+						aws.BoolValue(input.ConsistentRead)
+				})).
+				Return(&dynamodb.GetItemOutput{Item: tt.item}, tt.awsError).
+				Once()
+
+			store := &dynamoDBStore{client: client, table: "table"}
+			content, version, err := store.Get("key")
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, content)
+			assert.Equal(t, tt.expectedVersion, version)
+		})
+	}
+}
+
+func TestDynamoDBStore_Put(t *testing.T) {
+	conditionError := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+
+	tests := map[string]struct {
+		version           string
+		awsError          error
+		expectedCondition string
+		expectedVersion   string
+		expectedError     error
+	}{
+		"Create new item": {
+			expectedCondition: "attribute_not_exists(#key)",
+			expectedVersion:   "1",
+		},
+		"Update existing item": {
+			version:           "1",
+			expectedCondition: "#version = :version",
+			expectedVersion:   "2",
+		},
+		"Item modified concurrently": {
+			version:           "1",
+			awsError:          conditionError,
+			expectedCondition: "#version = :version",
+			expectedVersion:   "2",
+			expectedError:     ErrVersionConflict,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockDynamoDBClient)
+			defer client.AssertExpectations(t)
+
+			client.
+				On("PutItemWithContext", mock.Anything, mock.MatchedBy(func(input *dynamodb.PutItemInput) bool {
+					return aws.StringValue(input.ConditionExpression) == tt.expectedCondition &&
+						aws.StringValue(input.Item[dynamoDBVersionAttribute].N) == tt.expectedVersion
+				})).
+				Return(&dynamodb.PutItemOutput{}, tt.awsError).
+				Once()
+
+			store := &dynamoDBStore{client: client, table: "table"}
+			version, err := store.Put("key", []byte("content"), tt.version)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedVersion, version)
+		})
+	}
+}
+
+func TestDynamoDBStore_Delete(t *testing.T) {
+	conditionError := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+
+	storedItem := map[string]*dynamodb.AttributeValue{
+		dynamoDBDataAttribute:    {B: []byte("content")},
+		dynamoDBVersionAttribute: {N: aws.String("3")},
+	}
+
+	tests := map[string]struct {
+		version           string
+		awsError          error
+		getItem           map[string]*dynamodb.AttributeValue
+		expectGet         bool
+		expectedCondition string
+		expectedError     error
+	}{
+		"Delete existing item": {
+			expectedCondition: "attribute_exists(#key)",
+		},
+		"Item doesn't exist": {
+			awsError:          conditionError,
+			expectedCondition: "attribute_exists(#key)",
+			expectedError:     os.ErrNotExist,
+		},
+		"Delete item with matching version": {
+			version:           "2",
+			expectedCondition: "attribute_exists(#key) AND #version = :version",
+		},
+		"Item modified since it was read": {
+			version:           "2",
+			awsError:          conditionError,
+			getItem:           storedItem,
+			expectGet:         true,
+			expectedCondition: "attribute_exists(#key) AND #version = :version",
+			expectedError:     ErrVersionConflict,
+		},
+		"Item removed since it was read": {
+			version:           "2",
+			awsError:          conditionError,
+			expectGet:         true,
+			expectedCondition: "attribute_exists(#key) AND #version = :version",
+			expectedError:     os.ErrNotExist,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(mockDynamoDBClient)
+			defer client.AssertExpectations(t)
+
+			client.
+				On("DeleteItemWithContext", mock.Anything, mock.AnythingOfType("*dynamodb.DeleteItemInput")).
+				Run(func(args mock.Arguments) {
+					input := args.Get(1).(*dynamodb.DeleteItemInput)
+					assert.Equal(t, tt.expectedCondition, aws.StringValue(input.ConditionExpression))
+					if tt.version != "" {
+						assert.Equal(t, tt.version, aws.StringValue(input.ExpressionAttributeValues[":version"].N))
+					}
+				}).
+				Return(&dynamodb.DeleteItemOutput{}, tt.awsError).
+				Once()
+
+			if tt.expectGet {
+				client.
+					On("GetItemWithContext", mock.Anything, mock.AnythingOfType("*dynamodb.GetItemInput")).
+					Return(&dynamodb.GetItemOutput{Item: tt.getItem}, nil).
+					Once()
+			}
+
+			store := &dynamoDBStore{client: client, table: "table"}
+			err := store.Delete("key", tt.version)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDynamoDBStore_List(t *testing.T) {
+	client := new(mockDynamoDBClient)
+	defer client.AssertExpectations(t)
+
+	client.
+		On("ScanPagesWithContext", mock.Anything, mock.AnythingOfType("*dynamodb.ScanInput"), mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(*dynamodb.ScanOutput, bool) bool)
+			fn(&dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+				{dynamoDBKeyAttribute: {S: aws.String("key-1")}},
+			}}, false)
+			fn(&dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+				{dynamoDBKeyAttribute: {S: aws.String("key-2")}},
+			}}, true)
+		}).
+		Return(nil).
+		Once()
+
+	store := &dynamoDBStore{client: client, table: "table"}
+	keys, err := store.List()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key-1", "key-2"}, keys)
+}
@@ -0,0 +1,73 @@
+package task
+
+import (
+	"fmt"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+)
+
+// encryptedMetadataManager encrypts the private key before it reaches the
+// storage, and decrypts it when the metadata is fetched. Records stored in
+// plaintext, before the encryption was enabled, are still readable
+type encryptedMetadataManager struct {
+	MetadataManager
+
+	encrypter encryption.Encrypter
+}
+
+func newEncryptedMetadataManager(manager MetadataManager, encrypter encryption.Encrypter) *encryptedMetadataManager {
+	return &encryptedMetadataManager{
+		MetadataManager: manager,
+		encrypter:       encrypter,
+	}
+}
+
+func (e *encryptedMetadataManager) Persist(data Data) error {
+	data, err := encryptData(e.encrypter, data)
+	if err != nil {
+		return err
+	}
+
+	return e.MetadataManager.Persist(data)
+}
+
+func (e *encryptedMetadataManager) Get() (Data, error) {
+	data, err := e.MetadataManager.Get()
+	if err != nil {
+		return data, err
+	}
+
+	return decryptData(e.encrypter, data)
+}
+
+func encryptData(encrypter encryption.Encrypter, data Data) (Data, error) {
+	if len(data.PrivateKey) == 0 {
+		return data, nil
+	}
+
+	envelope, err := encrypter.Encrypt(data.PrivateKey)
+	if err != nil {
+		return data, fmt.Errorf("encrypting private key: %w", err)
+	}
+
+	data.PrivateKey = nil
+	data.EncryptedPrivateKey = envelope
+
+	return data, nil
+}
+
+func decryptData(encrypter encryption.Encrypter, data Data) (Data, error) {
+	if data.EncryptedPrivateKey == nil {
+		return data, nil
+	}
+
+	privateKey, err := encrypter.Decrypt(data.EncryptedPrivateKey)
+	if err != nil {
+		return data, fmt.Errorf("decrypting private key: %w", err)
+	}
+
+	data.PrivateKey = privateKey
+	data.EncryptedPrivateKey = nil
+
+	return data, nil
+}
@@ -0,0 +1,124 @@
+package task
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+)
+
+func TestEncryptedMetadataManager_Persist(t *testing.T) {
+	testError := errors.New("simulated error")
+	envelope := &encryption.Envelope{Provider: "keyfile", KeyID: "v1"}
+
+	tests := map[string]struct {
+		data              Data
+		shouldEncrypt     bool
+		encryptError      error
+		expectedPersisted Data
+		expectedError     error
+	}{
+		"Private key is encrypted": {
+			data:              Data{TaskARN: "task-arn", PrivateKey: []byte("private-key")},
+			shouldEncrypt:     true,
+			expectedPersisted: Data{TaskARN: "task-arn", EncryptedPrivateKey: envelope},
+		},
+		"Data without private key": {
+			data:              Data{TaskARN: "task-arn"},
+			expectedPersisted: Data{TaskARN: "task-arn"},
+		},
+		"Error encrypting the private key": {
+			data:          Data{TaskARN: "task-arn", PrivateKey: []byte("private-key")},
+			shouldEncrypt: true,
+			encryptError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockManager := new(MockMetadataManager)
+			defer mockManager.AssertExpectations(t)
+
+			mockEncrypter := new(encryption.MockEncrypter)
+			defer mockEncrypter.AssertExpectations(t)
+
+			if tt.shouldEncrypt {
+				mockEncrypter.On("Encrypt", tt.data.PrivateKey).Return(envelope, tt.encryptError).Once()
+			}
+
+			if tt.expectedError == nil {
+				mockManager.On("Persist", tt.expectedPersisted).Return(nil).Once()
+			}
+
+			manager := newEncryptedMetadataManager(mockManager, mockEncrypter)
+			err := manager.Persist(tt.data)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestEncryptedMetadataManager_Get(t *testing.T) {
+	testError := errors.New("simulated error")
+	envelope := &encryption.Envelope{Provider: "keyfile", KeyID: "v1"}
+
+	tests := map[string]struct {
+		stored        Data
+		shouldDecrypt bool
+		decryptError  error
+		expectedData  Data
+		expectedError error
+	}{
+		"Encrypted private key": {
+			stored:        Data{TaskARN: "task-arn", EncryptedPrivateKey: envelope},
+			shouldDecrypt: true,
+			expectedData:  Data{TaskARN: "task-arn", PrivateKey: []byte("private-key")},
+		},
+		"Private key stored in plaintext": {
+			stored:       Data{TaskARN: "task-arn", PrivateKey: []byte("private-key")},
+			expectedData: Data{TaskARN: "task-arn", PrivateKey: []byte("private-key")},
+		},
+		"Error decrypting the private key": {
+			stored:        Data{TaskARN: "task-arn", EncryptedPrivateKey: envelope},
+			shouldDecrypt: true,
+			decryptError:  testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockManager := new(MockMetadataManager)
+			defer mockManager.AssertExpectations(t)
+
+			mockEncrypter := new(encryption.MockEncrypter)
+			defer mockEncrypter.AssertExpectations(t)
+
+			mockManager.On("Get").Return(tt.stored, nil).Once()
+
+			if tt.shouldDecrypt {
+				mockEncrypter.On("Decrypt", envelope).Return([]byte("private-key"), tt.decryptError).Once()
+			}
+
+			manager := newEncryptedMetadataManager(mockManager, mockEncrypter)
+			data, err := manager.Get()
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+		})
+	}
+}
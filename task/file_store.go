@@ -0,0 +1,124 @@
+package task
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+)
+
+// fileStore keeps every key as a JSON file of the directory. The checksum
+// of the content is used as the version
+type fileStore struct {
+	fs        fs.FS
+	directory string
+}
+
+func newFileStore(directory string) *fileStore {
+	return &fileStore{
+		fs:        fs.NewOS(),
+		directory: directory,
+	}
+}
+
+// lock serializes the writes of the stages and the maintenance commands
+// running concurrently on the host
+func (f *fileStore) lock() (func() error, error) {
+	unlock, err := f.fs.Lock(lockFilePath(f.directory))
+	if err != nil {
+		return nil, fmt.Errorf("locking metadata directory %q: %w", f.directory, err)
+	}
+
+	return unlock, nil
+}
+
+func lockFilePath(directory string) string {
+	return filepath.Join(directory, ".lock")
+}
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.directory, fmt.Sprintf("%s.json", key))
+}
+
+func (f *fileStore) checksum(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+func (f *fileStore) Get(key string) ([]byte, string, error) {
+	content, err := f.fs.ReadFile(f.path(key))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading file %q: %w", f.path(key), err)
+	}
+
+	return content, f.checksum(content), nil
+}
+
+func (f *fileStore) Put(key string, content []byte, version string) (string, error) {
+	unlock, err := f.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, currentVersion, err := f.Get(key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if (version == "" && current != nil) || (version != "" && version != currentVersion) {
+		return "", ErrVersionConflict
+	}
+
+	err = f.fs.WriteFileAtomic(f.path(key), content, 0600)
+	if err != nil {
+		return "", fmt.Errorf("writing file %q: %w", f.path(key), err)
+	}
+
+	return f.checksum(content), nil
+}
+
+func (f *fileStore) Delete(key string, version string) error {
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, currentVersion, err := f.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if version != "" && version != currentVersion {
+		return ErrVersionConflict
+	}
+
+	err = f.fs.Remove(f.path(key))
+	if err != nil {
+		return fmt.Errorf("deleting file %q: %w", f.path(key), err)
+	}
+
+	return nil
+}
+
+func (f *fileStore) List() ([]string, error) {
+	files, err := f.fs.ReadDir(f.directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory %q: %w", f.directory, err)
+	}
+
+	var keys []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		keys = append(keys, strings.TrimSuffix(file.Name(), ".json"))
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,156 @@
+package task
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newFileStore(dir)
+
+	_, _, err = store.Get("key")
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+
+	version, err := store.Put("key", []byte("first"), "")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "key.json"))
+
+	_, err = store.Put("key", []byte("concurrent"), "")
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+
+	newVersion, err := store.Put("key", []byte("second"), version)
+	require.NoError(t, err)
+
+	_, err = store.Put("key", []byte("stale"), version)
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+
+	content, currentVersion, err := store.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), content)
+	assert.Equal(t, newVersion, currentVersion)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "other.txt"), nil, 0600)
+	require.NoError(t, err)
+
+	keys, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key"}, keys)
+
+	err = store.Delete("key", version)
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+	assert.FileExists(t, filepath.Join(dir, "key.json"))
+
+	err = store.Delete("key", newVersion)
+	require.NoError(t, err)
+
+	err = store.Delete("key", "")
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileStore_Errors(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		operation      func(store *fileStore) error
+		lockError      error
+		readFileError  error
+		writeFileError error
+		removeError    error
+		expectedError  error
+	}{
+		"Error on locking the directory": {
+			operation: func(store *fileStore) error {
+				_, err := store.Put("key", []byte("content"), "")
+				return err
+			},
+			lockError:     testError,
+			expectedError: testError,
+		},
+		"Error on reading the file before writing it": {
+			operation: func(store *fileStore) error {
+				_, err := store.Put("key", []byte("content"), "")
+				return err
+			},
+			readFileError: testError,
+			expectedError: testError,
+		},
+		"Error on writing the file": {
+			operation: func(store *fileStore) error {
+				_, err := store.Put("key", []byte("content"), "")
+				return err
+			},
+			readFileError:  os.ErrNotExist,
+			writeFileError: testError,
+			expectedError:  testError,
+		},
+		"Error on reading the file": {
+			operation: func(store *fileStore) error {
+				_, _, err := store.Get("key")
+				return err
+			},
+			readFileError: testError,
+			expectedError: testError,
+		},
+		"Error on deleting a missing file": {
+			operation: func(store *fileStore) error {
+				return store.Delete("key", "")
+			},
+			readFileError: os.ErrNotExist,
+			expectedError: os.ErrNotExist,
+		},
+		"Error on removing the file": {
+			operation: func(store *fileStore) error {
+				return store.Delete("key", "")
+			},
+			removeError:   testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockFS := new(fs.MockFS)
+
+			locked := false
+			unlocked := false
+			var unlock func() error
+			if tt.lockError == nil {
+				unlock = func() error {
+					unlocked = true
+					return nil
+				}
+			}
+
+			mockFS.On("Lock", "/tmp/.lock").
+				Run(func(mock.Arguments) { locked = true }).
+				Return(unlock, tt.lockError)
+			mockFS.On("ReadFile", "/tmp/key.json").
+				Return([]byte("content"), tt.readFileError)
+			mockFS.On("WriteFileAtomic", "/tmp/key.json", []byte("content"), os.FileMode(0600)).
+				Return(tt.writeFileError)
+			mockFS.On("Remove", "/tmp/key.json").
+				Return(tt.removeError)
+
+			store := newFileStore("/tmp")
+			store.fs = mockFS
+
+			err := tt.operation(store)
+			assertions.ErrorIs(t, err, tt.expectedError)
+			assert.Equal(t, locked && tt.lockError == nil, unlocked, "lock should be released")
+		})
+	}
+}
@@ -2,17 +2,19 @@
 package task
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"time"
 
-	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encoding"
-	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
 	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/runner"
 )
 
+// ErrUnknownMetadataBackend is returned when the configuration selects an unsupported backend
+var ErrUnknownMetadataBackend = errors.New("unknown metadata backend")
+
 // MetadataManager represents a repository to store temporary data related to task information
 type MetadataManager interface {
 	// Persist stores the desired data
@@ -27,115 +29,116 @@ type MetadataManager interface {
 
 // Data centralizes attributes that should be persisted in the metadata storage
 type Data struct {
-	TaskARN     string
-	ContainerIP string
-	PrivateKey  []byte
-}
-
-type fsMetadataManager struct {
-	logger    logging.Logger
-	fs        fs.FS
-	encoder   encoding.Encoder
-	directory string
-	filename  string
+	SchemaVersion int
+
+	TaskARN            string
+	ContainerIP        string
+	PrivateKey         []byte
+	PublicKey          []byte `json:",omitempty"`
+	HostKeyFingerprint string
+	LeaseDuration      time.Duration
+
+	// Phase and ClientToken let a re-invoked "prepare" stage resume the
+	// provisioning, or find and stop a task started before it was aborted
+	Phase       Phase
+	ClientToken string
+
+	// ServiceToken is kept to check the readiness of the SSH service when
+	// the provisioning is resumed. It only gives access to the host key
+	// fingerprint, so it's not encrypted
+	ServiceToken string `json:",omitempty"`
+
+	// EncryptedPrivateKey replaces PrivateKey when the encryption is enabled
+	EncryptedPrivateKey *encryption.Envelope `json:",omitempty"`
+
+	// SecretsFile is the file of the container holding the secrets of the
+	// job, sourced by the "run" stages. The secrets themselves are never
+	// recorded
+	SecretsFile string `json:",omitempty"`
+
+	// SyncedFiles are the files of the file-type variables uploaded to the
+	// container by the "run" stages, and removed by the "cleanup" stage
+	SyncedFiles []string `json:",omitempty"`
 }
 
-// NewMetadataManager is a constructor for the concrete type of the MetadataManager interface
+// NewMetadataManager creates the MetadataManager storing the data in a JSON
+// file of the directory. The writes are compared with the version read
+// while holding the lock of the directory, so concurrent stages can't
+// silently overwrite each other's updates
 func NewMetadataManager(logger logging.Logger, filesDir string) MetadataManager {
-	manager := new(fsMetadataManager)
-
-	manager.logger = logger
-	manager.fs = fs.NewOS()
-	manager.encoder = encoding.NewJSON()
-	manager.directory = filesDir
-
-	runnerAdapter := runner.GetAdapter()
-	runnerData := RunnerData{
-		ShortToken: runnerAdapter.ShortToken(),
-		ProjectURL: runnerAdapter.ProjectURL(),
-		PipelineID: runnerAdapter.PipelineID(),
-		JobID:      runnerAdapter.JobID(),
-	}
-	manager.filename = GenerateFilename(runnerData)
-
-	return manager
+	return newStoreMetadataManager(logger, newFileStore(filesDir))
 }
 
-func (f *fsMetadataManager) Persist(data Data) error {
-	f.logger.Debug("[Persist] Will persist metadata")
-
-	buf := new(bytes.Buffer)
-	err := f.encoder.Encode(data, buf)
-	if err != nil {
-		return fmt.Errorf("encoding data to JSON: %w", err)
+// NewMetadataManagerForConfig creates the MetadataManager for the backend
+// selected in the configuration, encrypting the sensitive fields when an
+// encryption provider is configured. The region of the Fargate cluster is
+// used when the backend doesn't define its own
+func NewMetadataManagerForConfig(logger logging.Logger, cfg config.TaskMetadata, defaultRegion string) (MetadataManager, error) {
+	var manager MetadataManager
+
+	switch cfg.Backend {
+	case "", config.MetadataBackendFile:
+		manager = NewMetadataManager(logger, cfg.Directory)
+	default:
+		store, err := NewStoreForConfig(cfg, defaultRegion)
+		if err != nil {
+			return nil, err
+		}
+
+		manager = newStoreMetadataManager(logger.WithField("backend", cfg.Backend), store)
 	}
 
-	err = f.fs.WriteFile(f.getFullFilePath(), buf.Bytes(), 0600)
-	if err != nil {
-		return fmt.Errorf("writing file %q: %w", f.getFullFilePath(), err)
+	if cfg.Encryption.Provider == "" {
+		return manager, nil
 	}
 
-	f.logger.Debug("[Persist] Metadata was persisted")
-
-	return nil
-}
-
-func (f *fsMetadataManager) getFullFilePath() string {
-	return filepath.Join(f.directory, fmt.Sprintf("%s.json", f.filename))
-}
-
-func (f *fsMetadataManager) Get() (Data, error) {
-	f.logger.Debug("[Get] Will get existing metadata")
-
-	data := Data{}
-
-	err := f.errIfFileNotExists()
+	encrypter, err := encryption.NewEncrypterForConfig(cfg.Encryption, defaultRegion)
 	if err != nil {
-		return data, fmt.Errorf("trying to access file %q: %w", f.getFullFilePath(), err)
+		return nil, fmt.Errorf("creating metadata encrypter: %w", err)
 	}
 
-	fileContent, err := f.fs.ReadFile(f.getFullFilePath())
-	if err != nil {
-		return data, fmt.Errorf("reading file %q: %w", f.getFullFilePath(), err)
-	}
-
-	err = f.encoder.Decode(bytes.NewBuffer(fileContent), &data)
-	if err != nil {
-		return data, fmt.Errorf("decoding JSON: %w", err)
-	}
-
-	f.logger.Debug("[Get] Metadata was fetched")
-
-	return data, nil
+	return newEncryptedMetadataManager(manager, encrypter), nil
 }
 
-func (f *fsMetadataManager) errIfFileNotExists() error {
-	exists, err := f.fs.Exists(f.getFullFilePath())
-	if err != nil {
-		return err
+// NewStoreForConfig creates the Store for the backend selected in the
+// configuration. It gives access to the metadata of all the jobs
+func NewStoreForConfig(cfg config.TaskMetadata, defaultRegion string) (Store, error) {
+	switch cfg.Backend {
+	case "", config.MetadataBackendFile:
+		return newFileStore(cfg.Directory), nil
+	case config.MetadataBackendS3:
+		store, err := newS3Store(cfg.S3, defaultRegion)
+		if err != nil {
+			return nil, fmt.Errorf("creating S3 metadata store: %w", err)
+		}
+
+		return store, nil
+	case config.MetadataBackendDynamoDB:
+		store, err := newDynamoDBStore(cfg.DynamoDB, defaultRegion)
+		if err != nil {
+			return nil, fmt.Errorf("creating DynamoDB metadata store: %w", err)
+		}
+
+		return store, nil
 	}
 
-	if !exists {
-		return os.ErrNotExist
-	}
-
-	return nil
+	return nil, fmt.Errorf("%w: %q", ErrUnknownMetadataBackend, cfg.Backend)
 }
 
-func (f *fsMetadataManager) Clear() error {
-	f.logger.Debug("[Clear] Will delete existing metadata")
-
-	err := f.errIfFileNotExists()
-	if err != nil {
-		return fmt.Errorf("trying to access file %q: %w", f.getFullFilePath(), err)
-	}
+// JobKey identifies the job executed by the Runner. It's also the name of
+// the metadata record of the job
+func JobKey() string {
+	return generateRunnerFilename()
+}
 
-	err = f.fs.Remove(f.getFullFilePath())
-	if err != nil {
-		return fmt.Errorf("deleting file %q: %w", f.getFullFilePath(), err)
+func generateRunnerFilename() string {
+	runnerAdapter := runner.GetAdapter()
+	runnerData := RunnerData{
+		ShortToken: runnerAdapter.ShortToken(),
+		ProjectURL: runnerAdapter.ProjectURL(),
+		PipelineID: runnerAdapter.PipelineID(),
+		JobID:      runnerAdapter.JobID(),
 	}
 
-	f.logger.Debug("[Clear] Metadata was deleted")
-
-	return nil
+	return GenerateFilename(runnerData)
 }
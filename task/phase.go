@@ -0,0 +1,24 @@
+package task
+
+// Phase is the last step of the provisioning recorded by the "prepare" stage
+type Phase string
+
+const (
+	// PhaseKeyGenerated is recorded when the SSH keys are generated and no
+	// task was requested yet
+	PhaseKeyGenerated Phase = "key-generated"
+
+	// PhaseLaunchRequested is recorded before the task is requested. The
+	// task may exist even when its ARN wasn't recorded, and it can be found
+	// with the client token
+	PhaseLaunchRequested Phase = "launch-requested"
+
+	// PhaseLaunched is recorded when the ARN of the started task is known
+	PhaseLaunched Phase = "launched"
+
+	// PhaseRunning is recorded when the task is running and its IP is known
+	PhaseRunning Phase = "running"
+
+	// PhaseReachable is recorded when the SSH service accepts connections
+	PhaseReachable Phase = "reachable"
+)
@@ -0,0 +1,118 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encoding"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+// ErrReencryptionFailed is returned when at least one record couldn't be re-encrypted
+var ErrReencryptionFailed = errors.New("some records couldn't be re-encrypted")
+
+// ReencryptResult counts the records processed by Reencrypt
+type ReencryptResult struct {
+	Reencrypted int
+	Unchanged   int
+	Failed      int
+}
+
+// Reencrypt encrypts with the current key the private keys of all the stored
+// records encrypted with a previous key, or stored in plaintext. Records
+// modified concurrently by a job are reported as failed and left untouched
+func Reencrypt(logger logging.Logger, store Store, encrypter encryption.Encrypter) (ReencryptResult, error) {
+	result := ReencryptResult{}
+
+	keys, err := store.List()
+	if err != nil {
+		return result, fmt.Errorf("listing metadata records: %w", err)
+	}
+
+	encoder := encoding.NewJSON()
+
+	for _, key := range keys {
+		log := logger.WithField("record", key)
+
+		changed, err := reencryptRecord(store, encoder, encrypter, key)
+		if err != nil {
+			log.WithError(err).Warning("Couldn't re-encrypt the record")
+			result.Failed++
+			continue
+		}
+
+		if !changed {
+			log.Debug("[Reencrypt] Record is already encrypted with the current key")
+			result.Unchanged++
+			continue
+		}
+
+		log.Info("Record was re-encrypted")
+		result.Reencrypted++
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%w: %d of %d", ErrReencryptionFailed, result.Failed, len(keys))
+	}
+
+	return result, nil
+}
+
+func reencryptRecord(store Store, encoder encoding.Encoder, encrypter encryption.Encrypter, key string) (bool, error) {
+	content, version, err := store.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("fetching record: %w", err)
+	}
+
+	var data Data
+	err = encoder.Decode(bytes.NewBuffer(content), &data)
+	if err != nil {
+		return false, fmt.Errorf("decoding JSON: %w", err)
+	}
+
+	// Records written by a newer driver would lose their unknown fields
+	err = migrateData(&data)
+	if err != nil {
+		return false, err
+	}
+
+	if data.EncryptedPrivateKey != nil {
+		current, err := encrypter.IsCurrent(data.EncryptedPrivateKey)
+		if err != nil {
+			return false, err
+		}
+
+		if current {
+			return false, nil
+		}
+
+		data, err = decryptData(encrypter, data)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(data.PrivateKey) == 0 {
+		return false, nil
+	}
+
+	data, err = encryptData(encrypter, data)
+	if err != nil {
+		return false, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = encoder.Encode(data, buf)
+	if err != nil {
+		return false, fmt.Errorf("encoding data to JSON: %w", err)
+	}
+
+	_, err = store.Put(key, buf.Bytes(), version)
+	if err != nil {
+		return false, fmt.Errorf("storing record: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encryption"
+)
+
+func encodeTestRecord(t *testing.T, data Data) []byte {
+	content, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	return content
+}
+
+func TestReencrypt(t *testing.T) {
+	oldEnvelope := &encryption.Envelope{Provider: "keyfile", KeyID: "v1", Ciphertext: []byte("old")}
+	currentEnvelope := &encryption.Envelope{Provider: "keyfile", KeyID: "v2", Ciphertext: []byte("current")}
+	privateKey := []byte("private-key")
+
+	mockStore := new(MockStore)
+	defer mockStore.AssertExpectations(t)
+
+	mockEncrypter := new(encryption.MockEncrypter)
+	defer mockEncrypter.AssertExpectations(t)
+
+	mockStore.On("List").Return([]string{"old", "current", "plaintext", "conflict", "no-key"}, nil).Once()
+
+	mockStore.On("Get", "old").Return(encodeTestRecord(t, Data{EncryptedPrivateKey: oldEnvelope}), "1", nil).Once()
+	mockStore.On("Get", "current").Return(encodeTestRecord(t, Data{EncryptedPrivateKey: currentEnvelope}), "1", nil).Once()
+	mockStore.On("Get", "plaintext").Return(encodeTestRecord(t, Data{PrivateKey: privateKey}), "1", nil).Once()
+	mockStore.On("Get", "conflict").Return(encodeTestRecord(t, Data{PrivateKey: privateKey}), "1", nil).Once()
+	mockStore.On("Get", "no-key").Return(encodeTestRecord(t, Data{TaskARN: "task-arn"}), "1", nil).Once()
+
+	mockEncrypter.On("IsCurrent", oldEnvelope).Return(false, nil).Once()
+	mockEncrypter.On("IsCurrent", currentEnvelope).Return(true, nil).Once()
+	mockEncrypter.On("Decrypt", oldEnvelope).Return(privateKey, nil).Once()
+	mockEncrypter.On("Encrypt", privateKey).Return(currentEnvelope, nil).Times(3)
+
+	isReencrypted := mock.MatchedBy(func(content []byte) bool {
+		var data Data
+		err := json.NewDecoder(bytes.NewReader(content)).Decode(&data)
+
+		return err == nil && data.PrivateKey == nil && data.EncryptedPrivateKey.KeyID == "v2"
+	})
+	mockStore.On("Put", "old", isReencrypted, "1").Return("2", nil).Once()
+	mockStore.On("Put", "plaintext", isReencrypted, "1").Return("2", nil).Once()
+	mockStore.On("Put", "conflict", isReencrypted, "1").Return("", ErrVersionConflict).Once()
+
+	result, err := Reencrypt(createTestLogger(), mockStore, mockEncrypter)
+	assertions.ErrorIs(t, err, ErrReencryptionFailed)
+	assert.Equal(t, ReencryptResult{Reencrypted: 2, Unchanged: 2, Failed: 1}, result)
+}
+
+func TestReencrypt_ListError(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	mockStore := new(MockStore)
+	defer mockStore.AssertExpectations(t)
+
+	mockStore.On("List").Return(nil, testError).Once()
+
+	_, err := Reencrypt(createTestLogger(), mockStore, new(encryption.MockEncrypter))
+	assertions.ErrorIs(t, err, testError)
+}
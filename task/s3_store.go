@@ -0,0 +1,196 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+)
+
+type s3Client interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+	HeadObjectWithContext(aws.Context, *s3.HeadObjectInput, ...request.Option) (*s3.HeadObjectOutput, error)
+	DeleteObjectWithContext(aws.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2PagesWithContext(aws.Context, *s3.ListObjectsV2Input, func(*s3.ListObjectsV2Output, bool) bool, ...request.Option) error
+}
+
+// s3Store keeps every key as an object of the bucket. The ETag of the object
+// is used as the version, and the writes are made conditional with the
+// If-Match and If-None-Match headers
+type s3Store struct {
+	client s3Client
+	bucket string
+	prefix string
+}
+
+func newS3Store(cfg config.S3Store, defaultRegion string) (*s3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is not defined")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(cfg.ForcePathStyle),
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create AWS session: %w", err)
+	}
+
+	return &s3Store{
+		client: s3.New(sess),
+		bucket: cfg.Bucket,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (s *s3Store) objectKey(key string) string {
+	return path.Join(s.prefix, fmt.Sprintf("%s.json", key))
+}
+
+func (s *s3Store) Get(key string) ([]byte, string, error) {
+	output, err := s.client.GetObjectWithContext(aws.BackgroundContext(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return nil, "", s.translateError(err)
+	}
+	defer output.Body.Close()
+
+	content, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading S3 object: %w", err)
+	}
+
+	return content, aws.StringValue(output.ETag), nil
+}
+
+func (s *s3Store) Put(key string, content []byte, version string) (string, error) {
+	headers := map[string]string{"If-None-Match": "*"}
+	if version != "" {
+		headers = map[string]string{"If-Match": version}
+	}
+
+	output, err := s.client.PutObjectWithContext(
+		aws.BackgroundContext(),
+		&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(s.objectKey(key)),
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String("application/json"),
+		},
+		request.WithSetRequestHeaders(headers),
+	)
+	if err != nil {
+		return "", s.translateError(err)
+	}
+
+	return aws.StringValue(output.ETag), nil
+}
+
+// Delete checks the ETag before deleting the object, and sends it in the
+// If-Match header so that the services supporting conditional deletes
+// also reject an object written in between
+func (s *s3Store) Delete(key string, version string) error {
+	head, err := s.client.HeadObjectWithContext(aws.BackgroundContext(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return s.translateError(err)
+	}
+
+	var options []request.Option
+	if version != "" {
+		if aws.StringValue(head.ETag) != version {
+			return ErrVersionConflict
+		}
+
+		options = append(options, request.WithSetRequestHeaders(map[string]string{"If-Match": version}))
+	}
+
+	_, err = s.client.DeleteObjectWithContext(
+		aws.BackgroundContext(),
+		&s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.objectKey(key)),
+		},
+		options...,
+	)
+	if err != nil {
+		return s.translateError(err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) List() ([]string, error) {
+	prefix := s.objectKey("")
+	prefix = strings.TrimSuffix(prefix, ".json")
+
+	var keys []string
+
+	err := s.client.ListObjectsV2PagesWithContext(
+		aws.BackgroundContext(),
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(prefix),
+		},
+		func(output *s3.ListObjectsV2Output, _ bool) bool {
+			for _, object := range output.Contents {
+				name := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+				if strings.Contains(name, "/") || !strings.HasSuffix(name, ".json") {
+					continue
+				}
+
+				keys = append(keys, strings.TrimSuffix(name, ".json"))
+			}
+
+			return true
+		},
+	)
+	if err != nil {
+		return nil, s.translateError(err)
+	}
+
+	return keys, nil
+}
+
+func (s *s3Store) translateError(err error) error {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return fmt.Errorf("requesting S3: %w", err)
+	}
+
+	switch reqErr.StatusCode() {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return ErrVersionConflict
+	}
+
+	return fmt.Errorf("requesting S3: %w", err)
+}
@@ -0,0 +1,172 @@
+package task
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+// fakeS3 is a minimal stand-in of an S3-compatible service, supporting
+// the conditional writes used by s3Store
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) etag(content []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(content)))
+}
+
+func (f *fakeS3) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Query().Get("list-type") == "2" {
+		f.writeList(rw, strings.TrimPrefix(r.URL.Path, "/")+"/", r.URL.Query().Get("prefix"))
+		return
+	}
+
+	content, exists := f.objects[r.URL.Path]
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			f.writeError(rw, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		rw.Header().Set("ETag", f.etag(content))
+		if r.Method == http.MethodGet {
+			_, _ = rw.Write(content)
+		}
+	case http.MethodPut:
+		ifMatch := r.Header.Get("If-Match")
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(ifMatch != "" && (!exists || ifMatch != f.etag(content))) {
+			f.writeError(rw, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+
+		rw.Header().Set("ETag", f.etag(body))
+	case http.MethodDelete:
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch != "" && (!exists || ifMatch != f.etag(content)) {
+			f.writeError(rw, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		delete(f.objects, r.URL.Path)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) writeList(rw http.ResponseWriter, bucketPath string, prefix string) {
+	_, _ = fmt.Fprint(rw, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+	for objectPath := range f.objects {
+		key := strings.TrimPrefix(objectPath, "/"+bucketPath)
+		if strings.HasPrefix(key, prefix) {
+			_, _ = fmt.Fprintf(rw, "<Contents><Key>%s</Key></Contents>", key)
+		}
+	}
+	_, _ = fmt.Fprint(rw, "</ListBucketResult>")
+}
+
+func (f *fakeS3) writeError(rw http.ResponseWriter, status int, code string) {
+	rw.WriteHeader(status)
+	_, _ = fmt.Fprintf(rw, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestS3Store(t *testing.T) (*s3Store, *fakeS3, func()) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+
+	server := httptest.NewServer(fake)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+
+	store := &s3Store{
+		client: s3.New(sess),
+		bucket: "bucket",
+		prefix: "metadata",
+	}
+
+	return store, fake, server.Close
+}
+
+func TestNewS3Store(t *testing.T) {
+	_, err := newS3Store(config.S3Store{}, "us-east-1")
+	assert.Error(t, err)
+
+	store, err := newS3Store(config.S3Store{Bucket: "bucket", Endpoint: "http://localhost:9000"}, "us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", store.bucket)
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake, cleanup := newTestS3Store(t)
+	defer cleanup()
+
+	_, _, err := store.Get("key")
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+
+	version, err := store.Put("key", []byte("first"), "")
+	require.NoError(t, err)
+	assert.Contains(t, fake.objects, "/bucket/metadata/key.json")
+
+	_, err = store.Put("key", []byte("concurrent"), "")
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+
+	newVersion, err := store.Put("key", []byte("second"), version)
+	require.NoError(t, err)
+	assert.NotEqual(t, version, newVersion)
+
+	_, err = store.Put("key", []byte("stale"), version)
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+
+	content, currentVersion, err := store.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), content)
+	assert.Equal(t, newVersion, currentVersion)
+
+	fake.objects["/bucket/metadata/nested/key.json"] = []byte("nested")
+	fake.objects["/bucket/other/key.json"] = []byte("other")
+
+	keys, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key"}, keys)
+
+	err = store.Delete("key", version)
+	assertions.ErrorIs(t, err, ErrVersionConflict)
+	assert.Contains(t, fake.objects, "/bucket/metadata/key.json")
+
+	err = store.Delete("key", newVersion)
+	require.NoError(t, err)
+	assert.NotContains(t, fake.objects, "/bucket/metadata/key.json")
+
+	err = store.Delete("key", "")
+	assertions.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,57 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CurrentSchemaVersion is the version of the Data structure written by this
+// version of the driver
+const CurrentSchemaVersion = 2
+
+// ErrUnsupportedSchemaVersion is returned when the record was written by a
+// newer version of the driver
+var ErrUnsupportedSchemaVersion = errors.New("unsupported metadata schema version")
+
+// migrations upgrade the Data from the version matching the index to the next
+// one. A record is migrated when it's read, so jobs started before a driver
+// upgrade can still be executed and cleaned up
+var migrations = []func(data *Data) error{
+	// 0: records written before the schema version was introduced. They
+	// have the same fields as the version 1
+	func(data *Data) error { return nil },
+
+	// 1: the phases of the provisioning were introduced. Records were
+	// written only after the task was started, and updated once the SSH
+	// service was reachable
+	func(data *Data) error {
+		switch {
+		case data.ContainerIP != "":
+			data.Phase = PhaseReachable
+		case data.TaskARN != "":
+			data.Phase = PhaseLaunched
+		default:
+			data.Phase = PhaseKeyGenerated
+		}
+
+		return nil
+	},
+}
+
+func migrateData(data *Data) error {
+	if data.SchemaVersion > CurrentSchemaVersion {
+		return fmt.Errorf("%w: %d, the newest supported version is %d",
+			ErrUnsupportedSchemaVersion, data.SchemaVersion, CurrentSchemaVersion)
+	}
+
+	for data.SchemaVersion < CurrentSchemaVersion {
+		err := migrations[data.SchemaVersion](data)
+		if err != nil {
+			return fmt.Errorf("migrating from schema version %d: %w", data.SchemaVersion, err)
+		}
+
+		data.SchemaVersion++
+	}
+
+	return nil
+}
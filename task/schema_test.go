@@ -0,0 +1,57 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+)
+
+func TestMigrations(t *testing.T) {
+	assert.Len(t, migrations, CurrentSchemaVersion, "each schema version must have a migration to the next one")
+}
+
+func TestMigrateData(t *testing.T) {
+	tests := map[string]struct {
+		data          Data
+		expectedData  Data
+		expectedError error
+	}{
+		"Record without schema version": {
+			data:         Data{TaskARN: "task-arn", PrivateKey: []byte("key")},
+			expectedData: Data{SchemaVersion: CurrentSchemaVersion, TaskARN: "task-arn", PrivateKey: []byte("key"), Phase: PhaseLaunched},
+		},
+		"Record of a reachable task without phase": {
+			data:         Data{SchemaVersion: 1, TaskARN: "task-arn", ContainerIP: "192.168.0.1"},
+			expectedData: Data{SchemaVersion: CurrentSchemaVersion, TaskARN: "task-arn", ContainerIP: "192.168.0.1", Phase: PhaseReachable},
+		},
+		"Record with the phase": {
+			data:         Data{SchemaVersion: 2, ClientToken: "token", Phase: PhaseLaunchRequested},
+			expectedData: Data{SchemaVersion: CurrentSchemaVersion, ClientToken: "token", Phase: PhaseLaunchRequested},
+		},
+		"Record with the current schema version": {
+			data:         Data{SchemaVersion: CurrentSchemaVersion, TaskARN: "task-arn"},
+			expectedData: Data{SchemaVersion: CurrentSchemaVersion, TaskARN: "task-arn"},
+		},
+		"Record written by a newer driver": {
+			data:          Data{SchemaVersion: CurrentSchemaVersion + 1},
+			expectedError: ErrUnsupportedSchemaVersion,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			data := tt.data
+			err := migrateData(&data)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+		})
+	}
+}
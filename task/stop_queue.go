@@ -0,0 +1,355 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encoding"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/fs"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+const (
+	// stopQueueFilename doesn't use the ".json" extension of the metadata
+	// records, so it's not listed by the fileStore
+	stopQueueFilename = ".stop-queue"
+
+	// MaxStopAttempts is the number of failed stops after which a task is
+	// removed from the queue and reported
+	MaxStopAttempts = 8
+
+	stopRetryBaseDelay = time.Minute
+	stopRetryMaxDelay  = time.Hour
+
+	// stopClaimDuration postpones the next attempt of the requests being
+	// retried, so concurrent drains don't retry them twice. If the draining
+	// process dies, the requests are retried once the claim expires
+	stopClaimDuration = 15 * time.Minute
+)
+
+// defaultStopQueueDirectory keeps the queue on the host when the metadata is
+// stored remotely, by the s3 and dynamodb backends
+var defaultStopQueueDirectory = filepath.Join(os.TempDir(), "fargate-driver")
+
+var (
+	// ErrStopQueueNotConfigured is returned when no metadata directory is configured
+	ErrStopQueueNotConfigured = errors.New("stop queue requires the metadata directory")
+
+	// ErrStopAttemptsExhausted is returned when tasks couldn't be stopped after MaxStopAttempts
+	ErrStopAttemptsExhausted = errors.New("tasks couldn't be stopped")
+)
+
+// StopRequest is a task that couldn't be stopped by the "cleanup" stage
+type StopRequest struct {
+	TaskARN string
+	Cluster string
+	Reason  string
+
+	Attempts    int
+	LastError   string `json:",omitempty"`
+	NextAttempt time.Time
+}
+
+func (r StopRequest) sameTask(other StopRequest) bool {
+	return r.TaskARN == other.TaskARN && r.Cluster == other.Cluster
+}
+
+// StopFunc stops the task of the request
+type StopFunc func(request StopRequest) error
+
+// IgnoreTaskNotFound considers the requests of the tasks that don't exist
+// anymore as stopped, so they are removed from the queue
+func IgnoreTaskNotFound(stop StopFunc) StopFunc {
+	return func(request StopRequest) error {
+		err := stop(request)
+		if errors.Is(err, aws.ErrTaskNotFound) {
+			return nil
+		}
+
+		return err
+	}
+}
+
+// DrainResult reports the requests processed by StopQueue.Drain
+type DrainResult struct {
+	Stopped   []StopRequest
+	Failed    []StopRequest
+	Exhausted []StopRequest
+	Waiting   int
+}
+
+// StopQueue persists the tasks that couldn't be stopped, so the stop is
+// retried by a later invocation of the driver
+type StopQueue interface {
+	// Add queues the request. Requests for an already queued task are ignored
+	Add(request StopRequest) error
+
+	// Drain retries the requests whose backoff elapsed. Requests are removed
+	// when stopped, or when they failed MaxStopAttempts times
+	Drain(stop StopFunc) (DrainResult, error)
+}
+
+type fsStopQueue struct {
+	logger    logging.Logger
+	fs        fs.FS
+	encoder   encoding.Encoder
+	directory string
+	now       func() time.Time
+}
+
+// StopQueueDirectory returns the directory of the stop queue: the metadata
+// directory, or a directory of the host when no metadata directory is used
+func StopQueueDirectory(cfg config.TaskMetadata) string {
+	if cfg.Directory != "" {
+		return cfg.Directory
+	}
+
+	return defaultStopQueueDirectory
+}
+
+// NewStopQueue is a constructor for the concrete type of the StopQueue
+// interface. The queue is stored in the directory, created if needed
+func NewStopQueue(logger logging.Logger, directory string) (StopQueue, error) {
+	if directory == "" {
+		return nil, ErrStopQueueNotConfigured
+	}
+
+	fileSystem := fs.NewOS()
+
+	err := fileSystem.MkdirAll(directory, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("creating stop queue directory %q: %w", directory, err)
+	}
+
+	queue := &fsStopQueue{
+		logger:    logger,
+		fs:        fileSystem,
+		encoder:   encoding.NewJSON(),
+		directory: directory,
+		now:       time.Now,
+	}
+
+	return queue, nil
+}
+
+func (q *fsStopQueue) Add(request StopRequest) error {
+	return q.update(func(requests []StopRequest) ([]StopRequest, error) {
+		for _, queued := range requests {
+			if queued.sameTask(request) {
+				q.logger.Debug("[Add] Task is already queued")
+				return requests, nil
+			}
+		}
+
+		if request.NextAttempt.IsZero() {
+			request.NextAttempt = q.now()
+		}
+
+		return append(requests, request), nil
+	})
+}
+
+func (q *fsStopQueue) Drain(stop StopFunc) (DrainResult, error) {
+	result := DrainResult{}
+
+	due, err := q.claim(&result)
+	if err != nil {
+		return result, err
+	}
+
+	errs := make([]error, len(due))
+	for i, request := range due {
+		q.logger.
+			WithField("taskARN", request.TaskARN).
+			WithField("attempt", request.Attempts+1).
+			Debug("[Drain] Retrying to stop the task")
+
+		errs[i] = stop(request)
+	}
+
+	err = q.update(func(requests []StopRequest) ([]StopRequest, error) {
+		for i, request := range due {
+			requests = removeRequest(requests, request)
+
+			if errs[i] == nil {
+				result.Stopped = append(result.Stopped, request)
+				continue
+			}
+
+			request.Attempts++
+			request.LastError = errs[i].Error()
+
+			if request.Attempts >= MaxStopAttempts {
+				result.Exhausted = append(result.Exhausted, request)
+				continue
+			}
+
+			request.NextAttempt = q.now().Add(stopRetryDelay(request.Attempts))
+			result.Failed = append(result.Failed, request)
+			requests = append(requests, request)
+		}
+
+		return requests, nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	q.logResult(result)
+
+	if len(result.Exhausted) > 0 {
+		return result, fmt.Errorf("%w after %d attempts: %d", ErrStopAttemptsExhausted, MaxStopAttempts, len(result.Exhausted))
+	}
+
+	return result, nil
+}
+
+func (q *fsStopQueue) logResult(result DrainResult) {
+	for _, request := range result.Stopped {
+		q.logger.
+			WithField("taskARN", request.TaskARN).
+			Info("Queued Fargate task was stopped")
+	}
+
+	for _, request := range result.Failed {
+		q.logger.
+			WithField("taskARN", request.TaskARN).
+			WithField("attempts", request.Attempts).
+			WithField("nextAttempt", request.NextAttempt).
+			WithField("error", request.LastError).
+			Warning("Queued Fargate task couldn't be stopped. Will retry")
+	}
+
+	for _, request := range result.Exhausted {
+		q.logger.
+			WithField("taskARN", request.TaskARN).
+			WithField("cluster", request.Cluster).
+			WithField("attempts", request.Attempts).
+			WithField("error", request.LastError).
+			Error("Queued Fargate task couldn't be stopped. It must be stopped manually")
+	}
+}
+
+// claim returns the requests due for a retry, and postpones them in the
+// stored queue for the time of the retry
+func (q *fsStopQueue) claim(result *DrainResult) ([]StopRequest, error) {
+	var due []StopRequest
+
+	err := q.update(func(requests []StopRequest) ([]StopRequest, error) {
+		now := q.now()
+
+		for i, request := range requests {
+			if request.NextAttempt.After(now) {
+				result.Waiting++
+				continue
+			}
+
+			due = append(due, request)
+			requests[i].NextAttempt = now.Add(stopClaimDuration)
+		}
+
+		return requests, nil
+	})
+
+	return due, err
+}
+
+func removeRequest(requests []StopRequest, request StopRequest) []StopRequest {
+	kept := requests[:0]
+	for _, queued := range requests {
+		if !queued.sameTask(request) {
+			kept = append(kept, queued)
+		}
+	}
+
+	return kept
+}
+
+// stopRetryDelay doubles the delay after each failed attempt
+func stopRetryDelay(attempts int) time.Duration {
+	delay := stopRetryBaseDelay
+	for i := 1; i < attempts && delay < stopRetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > stopRetryMaxDelay {
+		return stopRetryMaxDelay
+	}
+
+	return delay
+}
+
+// update modifies the stored queue under the lock of the metadata directory
+func (q *fsStopQueue) update(modify func(requests []StopRequest) ([]StopRequest, error)) error {
+	unlock, err := q.fs.Lock(lockFilePath(q.directory))
+	if err != nil {
+		return fmt.Errorf("locking metadata directory %q: %w", q.directory, err)
+	}
+	defer unlock()
+
+	requests, err := q.read()
+	if err != nil {
+		return err
+	}
+
+	requests, err = modify(requests)
+	if err != nil {
+		return err
+	}
+
+	return q.write(requests)
+}
+
+func (q *fsStopQueue) path() string {
+	return filepath.Join(q.directory, stopQueueFilename)
+}
+
+func (q *fsStopQueue) read() ([]StopRequest, error) {
+	var requests []StopRequest
+
+	content, err := q.fs.ReadFile(q.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return requests, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading file %q: %w", q.path(), err)
+	}
+
+	err = q.encoder.Decode(bytes.NewBuffer(content), &requests)
+	if err != nil {
+		return nil, fmt.Errorf("decoding stop queue: %w", err)
+	}
+
+	return requests, nil
+}
+
+func (q *fsStopQueue) write(requests []StopRequest) error {
+	if len(requests) == 0 {
+		err := q.fs.Remove(q.path())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("deleting file %q: %w", q.path(), err)
+		}
+
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	err := q.encoder.Encode(requests, buf)
+	if err != nil {
+		return fmt.Errorf("encoding stop queue: %w", err)
+	}
+
+	err = q.fs.WriteFileAtomic(q.path(), buf.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("writing file %q: %w", q.path(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/aws"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/config"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging/test"
+)
+
+func TestNewStopQueue(t *testing.T) {
+	_, err := NewStopQueue(test.NewNullLogger(), "")
+	assertions.ErrorIs(t, err, ErrStopQueueNotConfigured)
+
+	queue, err := NewStopQueue(test.NewNullLogger(), "/tmp")
+	assert.NoError(t, err)
+	assert.NotNil(t, queue)
+
+	dir, err := ioutil.TempDir("", "stop-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	queueDir := filepath.Join(dir, "fargate-driver")
+	_, err = NewStopQueue(test.NewNullLogger(), queueDir)
+	assert.NoError(t, err)
+	assert.DirExists(t, queueDir)
+}
+
+func TestStopQueueDirectory(t *testing.T) {
+	assert.Equal(t, "/var/lib/metadata", StopQueueDirectory(config.TaskMetadata{Directory: "/var/lib/metadata"}))
+	assert.Equal(
+		t,
+		filepath.Join(os.TempDir(), "fargate-driver"),
+		StopQueueDirectory(config.TaskMetadata{Backend: config.MetadataBackendS3}),
+	)
+}
+
+func TestStopQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stop-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := NewStopQueue(test.NewNullLogger(), dir)
+	require.NoError(t, err)
+
+	queue := q.(*fsStopQueue)
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	queue.now = func() time.Time { return now }
+
+	require.NoError(t, queue.Add(StopRequest{TaskARN: "task-1", Cluster: "cluster", Reason: "Job finished"}))
+	require.NoError(t, queue.Add(StopRequest{TaskARN: "task-2", Cluster: "cluster"}))
+	require.NoError(t, queue.Add(StopRequest{TaskARN: "task-1", Cluster: "cluster"}))
+
+	keys, err := newFileStore(dir).List()
+	require.NoError(t, err)
+	assert.Empty(t, keys, "Queue should not be listed as a metadata record")
+
+	stopError := errors.New("simulated error")
+	var stopped []string
+	stop := func(request StopRequest) error {
+		stopped = append(stopped, request.TaskARN)
+		if request.TaskARN == "task-2" {
+			return stopError
+		}
+
+		return nil
+	}
+
+	result, err := queue.Drain(stop)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"task-1", "task-2"}, stopped)
+	require.Len(t, result.Stopped, 1)
+	assert.Equal(t, "Job finished", result.Stopped[0].Reason)
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, 1, result.Failed[0].Attempts)
+	assert.Equal(t, stopError.Error(), result.Failed[0].LastError)
+	assert.Equal(t, now.Add(time.Minute), result.Failed[0].NextAttempt)
+
+	stopped = nil
+	result, err = queue.Drain(stop)
+	require.NoError(t, err)
+	assert.Empty(t, stopped, "Request should wait for the backoff")
+	assert.Equal(t, 1, result.Waiting)
+
+	for attempt := 2; attempt <= MaxStopAttempts; attempt++ {
+		now = now.Add(stopRetryMaxDelay)
+		result, err = queue.Drain(stop)
+	}
+
+	assertions.ErrorIs(t, err, ErrStopAttemptsExhausted)
+	require.Len(t, result.Exhausted, 1)
+	assert.Equal(t, "task-2", result.Exhausted[0].TaskARN)
+	assert.Equal(t, MaxStopAttempts, result.Exhausted[0].Attempts)
+
+	_, err = os.Stat(filepath.Join(dir, stopQueueFilename))
+	assert.True(t, os.IsNotExist(err), "Empty queue should be removed")
+}
+
+func TestStopQueue_DrainClaimedRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stop-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := NewStopQueue(test.NewNullLogger(), dir)
+	require.NoError(t, err)
+
+	queue := q.(*fsStopQueue)
+	require.NoError(t, queue.Add(StopRequest{TaskARN: "task-1", Cluster: "cluster"}))
+
+	var concurrentResult DrainResult
+	_, err = queue.Drain(func(request StopRequest) error {
+		concurrentResult, err = queue.Drain(func(request StopRequest) error {
+			assert.Fail(t, "Claimed request should not be retried by a concurrent drain")
+			return nil
+		})
+		require.NoError(t, err)
+
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, concurrentResult.Waiting)
+}
+
+func TestStopRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		4: 8 * time.Minute,
+		7: time.Hour,
+		8: time.Hour,
+	}
+
+	for attempts, expectedDelay := range tests {
+		assert.Equal(t, expectedDelay, stopRetryDelay(attempts), "attempts: %d", attempts)
+	}
+}
+
+func TestIgnoreTaskNotFound(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		stopError     error
+		expectedError error
+	}{
+		"Task stopped": {},
+		"Task doesn't exist anymore": {
+			stopError: fmt.Errorf("stopping task: %w", aws.ErrTaskNotFound),
+		},
+		"Error stopping the task": {
+			stopError:     testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			stop := IgnoreTaskNotFound(func(request StopRequest) error {
+				assert.Equal(t, "task-arn", request.TaskARN)
+				return tt.stopError
+			})
+
+			err := stop(StopRequest{TaskARN: "task-arn"})
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
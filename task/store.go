@@ -0,0 +1,118 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encoding"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/logging"
+)
+
+// ErrVersionConflict is returned when the stored metadata was modified since it was read
+var ErrVersionConflict = errors.New("metadata was modified by another process")
+
+// Store is a key/value storage shared between runner managers. Writes are
+// conditional, so two managers can't silently overwrite each other's data
+type Store interface {
+	// Get returns the content stored for the key and its current version.
+	// Returns os.ErrNotExist when nothing is stored for the key
+	Get(key string) ([]byte, string, error)
+
+	// Put stores the content only if the stored version still matches the
+	// given one. An empty version requires that nothing is stored for the key
+	Put(key string, content []byte, version string) (string, error)
+
+	// Delete removes the content stored for the key only if the stored
+	// version still matches the given one. An empty version removes the
+	// content unconditionally.
+	// Returns os.ErrNotExist when nothing is stored for the key
+	Delete(key string, version string) error
+
+	// List returns the keys of all the stored contents
+	List() ([]string, error)
+}
+
+type storeMetadataManager struct {
+	logger  logging.Logger
+	store   Store
+	encoder encoding.Encoder
+	key     string
+
+	// version of the stored data, as seen by the last Get or Persist
+	version string
+}
+
+func newStoreMetadataManager(logger logging.Logger, store Store) *storeMetadataManager {
+	return &storeMetadataManager{
+		logger:  logger,
+		store:   store,
+		encoder: encoding.NewJSON(),
+		key:     generateRunnerFilename(),
+	}
+}
+
+func (s *storeMetadataManager) Persist(data Data) error {
+	s.logger.Debug("[Persist] Will persist metadata")
+
+	data.SchemaVersion = CurrentSchemaVersion
+
+	buf := new(bytes.Buffer)
+	err := s.encoder.Encode(data, buf)
+	if err != nil {
+		return fmt.Errorf("encoding data to JSON: %w", err)
+	}
+
+	version, err := s.store.Put(s.key, buf.Bytes(), s.version)
+	if err != nil {
+		return fmt.Errorf("storing metadata %q: %w", s.key, err)
+	}
+
+	s.version = version
+
+	s.logger.WithField("version", version).Debug("[Persist] Metadata was persisted")
+
+	return nil
+}
+
+func (s *storeMetadataManager) Get() (Data, error) {
+	s.logger.Debug("[Get] Will get existing metadata")
+
+	data := Data{}
+
+	content, version, err := s.store.Get(s.key)
+	if err != nil {
+		return data, fmt.Errorf("fetching metadata %q: %w", s.key, err)
+	}
+
+	err = s.encoder.Decode(bytes.NewBuffer(content), &data)
+	if err != nil {
+		return data, fmt.Errorf("decoding JSON: %w", err)
+	}
+
+	err = migrateData(&data)
+	if err != nil {
+		return data, err
+	}
+
+	s.version = version
+
+	s.logger.WithField("version", version).Debug("[Get] Metadata was fetched")
+
+	return data, nil
+}
+
+func (s *storeMetadataManager) Clear() error {
+	s.logger.Debug("[Clear] Will delete existing metadata")
+
+	err := s.store.Delete(s.key, s.version)
+	if err != nil {
+		return fmt.Errorf("deleting metadata %q: %w", s.key, err)
+	}
+
+	s.version = ""
+
+	s.logger.Debug("[Clear] Metadata was deleted")
+
+	return nil
+}
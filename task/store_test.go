@@ -0,0 +1,212 @@
+package task
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/assertions"
+	"gitlab.com/gitlab-org/ci-cd/custom-executor-drivers/fargate/internal/encoding"
+)
+
+func newTestStoreMetadataManager(t *testing.T, store Store) *storeMetadataManager {
+	initializeAdapterForTesting(t)
+
+	return newStoreMetadataManager(createTestLogger(), store)
+}
+
+func TestStoreMetadataManager_Persist(t *testing.T) {
+	testData := Data{
+		TaskARN:     "task-arn",
+		ContainerIP: "192.168.0.1",
+	}
+
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		currentVersion  string
+		encodingError   error
+		putError        error
+		expectedVersion string
+		expectedError   error
+	}{
+		"Persist new metadata": {
+			currentVersion:  "",
+			expectedVersion: "1",
+		},
+		"Error on encoding JSON": {
+			currentVersion:  "1",
+			encodingError:   testError,
+			expectedVersion: "1",
+			expectedError:   testError,
+		},
+		"Error on storing metadata": {
+			currentVersion:  "1",
+			putError:        testError,
+			expectedVersion: "1",
+			expectedError:   testError,
+		},
+		"Persist over metadata read before": {
+			currentVersion:  "1",
+			expectedVersion: "2",
+		},
+		"Metadata modified concurrently": {
+			currentVersion:  "1",
+			putError:        ErrVersionConflict,
+			expectedVersion: "1",
+			expectedError:   ErrVersionConflict,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockStore := new(MockStore)
+			defer mockStore.AssertExpectations(t)
+
+			mockEncoder := new(encoding.MockEncoder)
+			defer mockEncoder.AssertExpectations(t)
+
+			manager := newTestStoreMetadataManager(t, mockStore)
+			manager.encoder = mockEncoder
+			manager.version = tt.currentVersion
+
+			expectedData := testData
+			expectedData.SchemaVersion = CurrentSchemaVersion
+
+			mockEncoder.On("Encode", expectedData, mock.AnythingOfType("*bytes.Buffer")).
+				Return(tt.encodingError).
+				Once()
+
+			newVersion := ""
+			if tt.putError == nil {
+				newVersion = tt.expectedVersion
+			}
+
+			if tt.encodingError == nil {
+				mockStore.
+					On("Put", manager.key, mock.AnythingOfType("[]uint8"), tt.currentVersion).
+					Return(newVersion, tt.putError).
+					Once()
+			}
+
+			err := manager.Persist(testData)
+			assert.Equal(t, tt.expectedVersion, manager.version)
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStoreMetadataManager_Get(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		content         []byte
+		getError        error
+		expectedData    Data
+		expectedVersion string
+		expectedError   error
+	}{
+		"Get existing metadata": {
+			content:         []byte(`{"TaskARN":"task-arn","ContainerIP":"192.168.0.1"}`),
+			expectedData:    Data{SchemaVersion: CurrentSchemaVersion, TaskARN: "task-arn", ContainerIP: "192.168.0.1", Phase: PhaseReachable},
+			expectedVersion: "3",
+		},
+		"Metadata written by a newer driver": {
+			content:       []byte(`{"SchemaVersion":1000,"TaskARN":"task-arn"}`),
+			expectedError: ErrUnsupportedSchemaVersion,
+		},
+		"Metadata doesn't exist": {
+			getError:      os.ErrNotExist,
+			expectedError: os.ErrNotExist,
+		},
+		"Error when reading metadata": {
+			getError:      testError,
+			expectedError: testError,
+		},
+		"Error when decoding JSON": {
+			content:         []byte(`{"TaskARN":`),
+			expectedVersion: "3",
+			expectedError:   io.ErrUnexpectedEOF,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockStore := new(MockStore)
+			defer mockStore.AssertExpectations(t)
+
+			manager := newTestStoreMetadataManager(t, mockStore)
+
+			mockStore.
+				On("Get", manager.key).
+				Return(tt.content, tt.expectedVersion, tt.getError).
+				Once()
+
+			data, err := manager.Get()
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				assert.Empty(t, manager.version, "version of invalid metadata should not be recorded")
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedData, data)
+			assert.Equal(t, tt.expectedVersion, manager.version)
+		})
+	}
+}
+
+func TestStoreMetadataManager_Clear(t *testing.T) {
+	testError := errors.New("simulated error")
+
+	tests := map[string]struct {
+		deleteError   error
+		expectedError error
+	}{
+		"Clear with success": {},
+		"Metadata doesn't exist": {
+			deleteError:   os.ErrNotExist,
+			expectedError: os.ErrNotExist,
+		},
+		"Error deleting metadata": {
+			deleteError:   testError,
+			expectedError: testError,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			mockStore := new(MockStore)
+			defer mockStore.AssertExpectations(t)
+
+			manager := newTestStoreMetadataManager(t, mockStore)
+			manager.version = "1"
+
+			mockStore.
+				On("Delete", manager.key, "1").
+				Return(tt.deleteError).
+				Once()
+
+			err := manager.Clear()
+
+			if tt.expectedError != nil {
+				assertions.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Empty(t, manager.version)
+		})
+	}
+}